@@ -34,9 +34,9 @@ func (app *application) mount() http.Handler {
 	// use logger and recoverer middleware
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{env.GetString("WEB_URL", "http://localhost:3000")},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "If-Match", "If-None-Match"},
+		ExposedHeaders:   []string{"Link", "ETag"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
@@ -45,19 +45,33 @@ func (app *application) mount() http.Handler {
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 
-	r.Use(middleware.Timeout(60 * time.Second))
-
-	// Defining user routes
 	userHandler := handler.NewUserHandler(app.service.Users, app.service.Auth)
-	userHandler.RegisterRoutes(r)
 
-	// Defining task routes
-	taskHandler := handler.NewTaskHandler(app.service.Tasks, app.service.Auth)
-	taskHandler.RegisterRoutes(r)
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.Timeout(60 * time.Second))
+
+		// Defining user routes
+		userHandler.RegisterRoutes(r)
+
+		// Defining task routes
+		taskHandler := handler.NewTaskHandler(app.service.Tasks, app.service.Comments, app.service.Attachments, app.service.Auth)
+		taskHandler.RegisterRoutes(r)
+
+		// Defining template routes
+		templateHandler := handler.NewTemplateHandler(app.service.Templates, app.service.Auth)
+		templateHandler.RegisterRoutes(r)
+
+		// Defining workflow routes
+		workflowHandler := handler.NewWorkflowHandler(app.service.Workflows, app.service.Auth)
+		workflowHandler.RegisterRoutes(r)
+
+		// Defining auth routes
+		authHandler := handler.NewAuthHandler(app.service.Auth, app.config.cognitoConfig)
+		authHandler.RegisterRoutes(r)
+	})
 
-	// Defining auth routes
-	authHandler := handler.NewAuthHandler(app.service.Auth, app.config.cognitoConfig)
-	authHandler.RegisterRoutes(r)
+	// Defining the notification stream, which outlives the timeout above
+	userHandler.RegisterStreamRoutes(r)
 
 	return r
 }
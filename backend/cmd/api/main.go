@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log"
 
 	"github.com/Ghaby-X/tasork/internal/db"
@@ -18,17 +19,76 @@ func main() {
 		log.Fatal("Error loading .env file")
 	}
 
-	db, err := db.NewDynamoDbClient(env.GetString("AWS_REGION", "us-east-1"))
+	// pick where attachment contents are kept, BLOB_DRIVER=local keeps them on disk
+	var blobs store.BlobStore
+	switch env.GetString("BLOB_DRIVER", "local") {
+	case "s3":
+		region := env.GetString("S3_REGION", env.GetString("AWS_REGION", "us-east-1"))
+		credentials, err := db.NewCredentialsProvider(region)
+		if err != nil {
+			log.Fatalf("Error loading aws credentials: %v", err)
+		}
+
+		blobs = store.NewS3BlobStore(store.S3Config{
+			Endpoint:    env.GetString("S3_ENDPOINT", ""),
+			Bucket:      env.GetString("S3_BUCKET", ""),
+			Region:      region,
+			PathStyle:   env.GetString("S3_PATH_STYLE", "false") == "true",
+			Credentials: credentials,
+		})
+	default:
+		blobs = store.NewLocalBlobStore(env.GetString("BLOB_DIR", "./data/blobs"))
+	}
+
+	// connect database to store, STORE_DRIVER=memory runs without aws
+	var storage *store.Storage
+	switch env.GetString("STORE_DRIVER", "dynamodb") {
+	case "memory":
+		log.Printf("using in-memory store, data will not persist")
+		storage = store.NewStorage(store.NewMemoryDB(), blobs)
+	default:
+		db, err := db.NewDynamoDbClient(env.GetString("AWS_REGION", "us-east-1"))
+		if err != nil {
+			log.Fatalf("Error creating dynamodb client: %v", err)
+		}
+
+		log.Printf("dynamodb client has been loaded successfully")
+		storage = store.NewStorage(db, blobs)
+	}
+
+	// pick how emails are sent, MAIL_DRIVER=file writes them to an mbox file instead
+	mailer, err := services.NewMailerFromEnv()
 	if err != nil {
-		log.Fatalf("Error creating dynamodb client: %v", err)
+		log.Fatalf("Error creating mailer: %v", err)
 	}
 
-	log.Printf("dynamodb client has been loaded successfully")
-	// connect database to store
-	store := store.NewStorage(db)
+	// connect store to server, requests only queue their emails
+	queued := services.NewQueuedMailer(mailer, env.GetInt("MAIL_QUEUE_SIZE", 100))
+	service := services.NewService(storage, services.NewLocalBroker(), queued)
 
-	// connect store to server
-	service := services.NewService(store)
+	// create the tasks of recurring templates in the background, SCHEDULER_ENABLED=false
+	// leaves it to another instance
+	if env.GetString("SCHEDULER_ENABLED", "true") == "true" {
+		go services.NewScheduler(service.Templates, services.SystemClock).Run(context.Background())
+	}
+
+	// remind assignees of deadlines in the background, REMINDERS_ENABLED=false
+	// leaves it to another instance
+	if env.GetString("REMINDERS_ENABLED", "true") == "true" {
+		go services.NewReminderWorker(service.Tasks, services.SystemClock).Run(context.Background())
+	}
+
+	// send the emails held back during quiet hours once they end, OUTBOX_ENABLED=false
+	// leaves it to another instance
+	if env.GetString("OUTBOX_ENABLED", "true") == "true" {
+		go services.NewOutboxWorker(storage.Notifications, mailer, services.SystemClock).Run(context.Background())
+	}
+
+	// email digests in the background. Off unless DIGESTS_ENABLED=true, which
+	// is meant for one instance only, or leave it to cmd/digest
+	if env.GetString("DIGESTS_ENABLED", "false") == "true" {
+		go services.NewDigestWorker(service.Tasks, service.Users, mailer, services.SystemClock).Run(context.Background())
+	}
 
 	// config for app
 	cognitoConfig := &types.CongitoConfig{
@@ -0,0 +1,32 @@
+// backfill-usernames indexes the users saved before @mentions were looked up
+// by username, so they can be mentioned. It scans the whole table once and can
+// be run again safely
+package main
+
+import (
+	"log"
+
+	"github.com/Ghaby-X/tasork/internal/db"
+	"github.com/Ghaby-X/tasork/internal/env"
+	"github.com/Ghaby-X/tasork/internal/store"
+	"github.com/lpernett/godotenv"
+)
+
+func main() {
+	// a .env file is optional here, the environment may already be set
+	if err := godotenv.Load(); err != nil {
+		log.Printf("no .env file loaded: %v", err)
+	}
+
+	client, err := db.NewDynamoDbClient(env.GetString("AWS_REGION", "us-east-1"))
+	if err != nil {
+		log.Fatalf("Error creating dynamodb client: %v", err)
+	}
+	storage := store.NewStorage(client, store.NewLocalBlobStore(env.GetString("BLOB_DIR", "./data/blobs")))
+
+	added, err := storage.Users.BackfillUsernames()
+	if err != nil {
+		log.Fatalf("backfill failed after indexing %d usernames: %v", added, err)
+	}
+	log.Printf("indexed %d usernames", added)
+}
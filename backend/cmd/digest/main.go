@@ -0,0 +1,60 @@
+// digest sends the email digests that are due once and exits, for running
+// the digest job by hand or from cron instead of inside the api
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+
+	"github.com/Ghaby-X/tasork/internal/db"
+	"github.com/Ghaby-X/tasork/internal/env"
+	"github.com/Ghaby-X/tasork/internal/services"
+	"github.com/Ghaby-X/tasork/internal/store"
+	"github.com/lpernett/godotenv"
+)
+
+// a clock stopped at the time given with -at
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+func main() {
+	at := flag.String("at", "", "send the digests due at this RFC 3339 time instead of now")
+	flag.Parse()
+
+	// a .env file is optional here, the environment may already be set
+	if err := godotenv.Load(); err != nil {
+		log.Printf("no .env file loaded: %v", err)
+	}
+
+	var clock services.Clock = services.SystemClock
+	if *at != "" {
+		now, err := time.Parse(time.RFC3339, *at)
+		if err != nil {
+			log.Fatalf("invalid -at time: %v", err)
+		}
+		clock = fixedClock(now)
+	}
+
+	client, err := db.NewDynamoDbClient(env.GetString("AWS_REGION", "us-east-1"))
+	if err != nil {
+		log.Fatalf("Error creating dynamodb client: %v", err)
+	}
+	storage := store.NewStorage(client, store.NewLocalBlobStore(env.GetString("BLOB_DIR", "./data/blobs")))
+
+	// sent straight away rather than queued, as the process exits once done
+	mailer, err := services.NewMailerFromEnv()
+	if err != nil {
+		log.Fatalf("Error creating mailer: %v", err)
+	}
+	service := services.NewService(storage, services.NewLocalBroker(), mailer)
+
+	sent, err := services.NewDigestWorker(service.Tasks, service.Users, mailer, clock).RunOnce()
+	if err != nil {
+		log.Fatalf("digest failed: %v", err)
+	}
+	log.Printf("sent %d digests", sent)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 )
@@ -20,3 +21,13 @@ func NewDynamoDbClient(aws_region string) (*dynamodb.Client, error) {
 
 	return client, nil
 }
+
+// function to load the aws credentials of the default chain, for clients built by hand
+func NewCredentialsProvider(aws_region string) (aws.CredentialsProvider, error) {
+	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(aws_region))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load AWS SDK config: %w", err)
+	}
+
+	return cfg.Credentials, nil
+}
@@ -2,6 +2,7 @@ package env
 import (
 	"os"
 	"strconv"
+	"time"
 ) 
 
 func GetString(key, fallback string) string {
@@ -28,3 +29,18 @@ func GetInt(key string, fallback int) int {
 
 	return valAsInt
 }
+
+func GetDuration(key string, fallback time.Duration) time.Duration {
+	val, ok := os.LookupEnv(key)
+
+	if !ok {
+		return fallback
+	}
+
+	valAsDuration, err := time.ParseDuration(val)
+	if err != nil {
+		return fallback
+	}
+
+	return valAsDuration
+}
@@ -0,0 +1,214 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/Ghaby-X/tasork/internal/services"
+	internal_types "github.com/Ghaby-X/tasork/internal/types"
+	"github.com/Ghaby-X/tasork/internal/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+// room for the multipart headers around the file
+const multipartOverhead = 1 << 20
+
+// maps the errors shared by the attachment endpoints, reporting whether it wrote a response
+func writeAttachmentError(w http.ResponseWriter, err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, services.ErrNotFound):
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("task or attachment not found"))
+	case errors.Is(err, services.ErrForbidden):
+		utils.WriteError(w, http.StatusForbidden, err)
+	case errors.Is(err, services.ErrAttachmentTooLarge), errors.As(err, &maxBytesErr):
+		utils.WriteError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("attachment is too large"))
+	case errors.Is(err, services.ErrUnsupportedMediaType):
+		utils.WriteError(w, http.StatusUnsupportedMediaType, err)
+	case errors.Is(err, services.ErrNotSupported):
+		utils.WriteError(w, http.StatusNotImplemented, err)
+	case errors.Is(err, services.ErrInvalidAttachment):
+		utils.WriteError(w, http.StatusBadRequest, err)
+	default:
+		return false
+	}
+	return true
+}
+
+// list the attachments of a task
+func (h *TaskHandler) handleListAttachments(w http.ResponseWriter, r *http.Request) {
+	taskId := chi.URLParam(r, "taskId")
+	tokenUser := utils.GetUserFromRequest(r)
+
+	page, err := utils.ParsePageQuery(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	output, err := h.attachments.ListAttachments(tokenUser["custom:tenantId"], taskId, page)
+	switch {
+	case errors.Is(err, services.ErrInvalidCursor):
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid cursor"))
+		return
+	case errors.Is(err, services.ErrNotFound):
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("task not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("failed to list attachments: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to list attachments"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, output)
+}
+
+// upload a file as the "file" field of a multipart form
+func (h *TaskHandler) handleUploadAttachment(w http.ResponseWriter, r *http.Request) {
+	taskId := chi.URLParam(r, "taskId")
+	tokenUser := utils.GetUserFromRequest(r)
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.attachments.MaxSize()+multipartOverhead)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("expected a multipart/form-data body"))
+		return
+	}
+
+	// the file is streamed from the first part named "file", other fields are skipped
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("missing file field"))
+			return
+		}
+		if writeAttachmentError(w, err) {
+			return
+		}
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid multipart body"))
+			return
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+
+		output, err := h.attachments.UploadAttachment(tokenUser, taskId, part.FileName(), part)
+		part.Close()
+		if writeAttachmentError(w, err) {
+			return
+		}
+		if err != nil {
+			log.Printf("failed to upload attachment: %v", err)
+			utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to upload attachment"))
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusCreated, output)
+		return
+	}
+}
+
+// start an upload straight to the blob store, finished by the complete endpoint
+func (h *TaskHandler) handlePresignAttachment(w http.ResponseWriter, r *http.Request) {
+	taskId := chi.URLParam(r, "taskId")
+	tokenUser := utils.GetUserFromRequest(r)
+
+	var RequestDTO internal_types.PresignAttachmentDTO
+	err := utils.ParseJSONBody(r, &RequestDTO)
+	if err != nil {
+		log.Printf("could not parse user body: %v", err)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid request body"))
+		return
+	}
+
+	output, err := h.attachments.PresignAttachment(RequestDTO, tokenUser, taskId)
+	if writeAttachmentError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Printf("failed to presign attachment: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to presign attachment"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, output)
+}
+
+// confirm a presigned upload - invoked by its uploader
+func (h *TaskHandler) handleCompleteAttachment(w http.ResponseWriter, r *http.Request) {
+	taskId := chi.URLParam(r, "taskId")
+	attachmentId := chi.URLParam(r, "attachmentId")
+	tokenUser := utils.GetUserFromRequest(r)
+
+	output, err := h.attachments.CompleteAttachment(tokenUser, taskId, attachmentId)
+	if writeAttachmentError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Printf("failed to complete attachment: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to complete attachment"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, output)
+}
+
+// download an attachment, redirecting to the blob store when it can presign
+func (h *TaskHandler) handleDownloadAttachment(w http.ResponseWriter, r *http.Request) {
+	taskId := chi.URLParam(r, "taskId")
+	attachmentId := chi.URLParam(r, "attachmentId")
+	tokenUser := utils.GetUserFromRequest(r)
+
+	download, err := h.attachments.DownloadAttachment(tokenUser["custom:tenantId"], taskId, attachmentId)
+	if writeAttachmentError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Printf("failed to download attachment: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to download attachment"))
+		return
+	}
+
+	if download.URL != "" {
+		http.Redirect(w, r, download.URL, http.StatusFound)
+		return
+	}
+	defer download.Body.Close()
+
+	attachment := download.Attachment
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.FileName}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, download.Body); err != nil {
+		log.Printf("failed to send attachment %s: %v", attachmentId, err)
+	}
+}
+
+// delete an attachment - invoked by its uploader or an admin
+func (h *TaskHandler) handleDeleteAttachment(w http.ResponseWriter, r *http.Request) {
+	taskId := chi.URLParam(r, "taskId")
+	attachmentId := chi.URLParam(r, "attachmentId")
+	tokenUser := utils.GetUserFromRequest(r)
+
+	err := h.attachments.DeleteAttachment(tokenUser, taskId, attachmentId)
+	if writeAttachmentError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Printf("failed to delete attachment: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to delete attachment"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, internal_types.SendJsonResponse{Message: "attachment deleted successfully"})
+}
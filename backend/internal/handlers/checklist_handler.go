@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/Ghaby-X/tasork/internal/services"
+	internal_types "github.com/Ghaby-X/tasork/internal/types"
+	"github.com/Ghaby-X/tasork/internal/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+// maps the errors shared by the checklist writes, reporting whether it wrote a response
+func writeChecklistError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, services.ErrNotFound):
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("task or checklist item not found"))
+	case errors.Is(err, services.ErrForbidden):
+		utils.WriteError(w, http.StatusForbidden, fmt.Errorf("task is not assigned to you"))
+	case errors.Is(err, services.ErrInvalidChecklist):
+		utils.WriteError(w, http.StatusBadRequest, err)
+	case errors.Is(err, services.ErrPreconditionFailed):
+		utils.WriteError(w, http.StatusPreconditionFailed, fmt.Errorf("task has changed since it was read"))
+	case errors.Is(err, services.ErrConflict):
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("task was modified by another request, try again"))
+	default:
+		return false
+	}
+	return true
+}
+
+// replace the checklist of a task - invoked by admins
+func (h *TaskHandler) handleUpdateChecklist(w http.ResponseWriter, r *http.Request) {
+	taskId := chi.URLParam(r, "taskId")
+	tokenUser := utils.GetUserFromRequest(r)
+
+	ifMatch, err := ifMatchVersion(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusPreconditionFailed, err)
+		return
+	}
+
+	var RequestDTO internal_types.UpdateChecklistDTO
+	err = utils.ParseJSONBody(r, &RequestDTO)
+	if err != nil {
+		log.Printf("could not parse user body: %v", err)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid request body"))
+		return
+	}
+
+	output, err := h.service.UpdateChecklist(RequestDTO, tokenUser, taskId, ifMatch)
+	if writeChecklistError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Printf("failed to update checklist: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to update checklist"))
+		return
+	}
+
+	if etag, err := taskETag(output); err == nil {
+		w.Header().Set("ETag", etag)
+	}
+	utils.WriteJSON(w, http.StatusOK, output)
+}
+
+// tick or untick one checklist item
+func (h *TaskHandler) handleCheckItem(w http.ResponseWriter, r *http.Request) {
+	taskId := chi.URLParam(r, "taskId")
+	itemId := chi.URLParam(r, "itemId")
+	tokenUser := utils.GetUserFromRequest(r)
+
+	ifMatch, err := ifMatchVersion(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusPreconditionFailed, err)
+		return
+	}
+
+	var RequestDTO internal_types.CheckItemDTO
+	err = utils.ParseJSONBody(r, &RequestDTO)
+	if err != nil || RequestDTO.Done == nil {
+		log.Printf("could not parse user body: %v", err)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("done is required"))
+		return
+	}
+
+	output, err := h.service.CheckItem(RequestDTO, tokenUser, taskId, itemId, ifMatch)
+	if writeChecklistError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Printf("failed to check item: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to check item"))
+		return
+	}
+
+	if etag, err := taskETag(output); err == nil {
+		w.Header().Set("ETag", etag)
+	}
+	utils.WriteJSON(w, http.StatusOK, output)
+}
@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/Ghaby-X/tasork/internal/services"
+	internal_types "github.com/Ghaby-X/tasork/internal/types"
+	"github.com/Ghaby-X/tasork/internal/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+// maps the errors shared by the comment writes, reporting whether it wrote a response
+func writeCommentError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, services.ErrNotFound):
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("task or comment not found"))
+	case errors.Is(err, services.ErrForbidden):
+		utils.WriteError(w, http.StatusForbidden, err)
+	case errors.Is(err, services.ErrInvalidComment):
+		utils.WriteError(w, http.StatusBadRequest, err)
+	default:
+		return false
+	}
+	return true
+}
+
+// list the comments of a task
+func (h *TaskHandler) handleListComments(w http.ResponseWriter, r *http.Request) {
+	taskId := chi.URLParam(r, "taskId")
+	tokenUser := utils.GetUserFromRequest(r)
+
+	page, err := utils.ParsePageQuery(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	output, err := h.comments.ListComments(tokenUser["custom:tenantId"], taskId, page)
+	switch {
+	case errors.Is(err, services.ErrInvalidCursor):
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid cursor"))
+		return
+	case errors.Is(err, services.ErrNotFound):
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("task not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("failed to list comments: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to list comments"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, output)
+}
+
+// comment on a task, or reply to a comment
+func (h *TaskHandler) handleCreateComment(w http.ResponseWriter, r *http.Request) {
+	taskId := chi.URLParam(r, "taskId")
+	tokenUser := utils.GetUserFromRequest(r)
+
+	var RequestDTO internal_types.CreateCommentDTO
+	err := utils.ParseJSONBody(r, &RequestDTO)
+	if err != nil {
+		log.Printf("could not parse user body: %v", err)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid request body"))
+		return
+	}
+
+	output, err := h.comments.CreateComment(RequestDTO, tokenUser, taskId)
+	if writeCommentError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Printf("failed to create comment: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to create comment"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, output)
+}
+
+// edit a comment - invoked by its author
+func (h *TaskHandler) handleUpdateComment(w http.ResponseWriter, r *http.Request) {
+	taskId := chi.URLParam(r, "taskId")
+	commentId := chi.URLParam(r, "commentId")
+	tokenUser := utils.GetUserFromRequest(r)
+
+	var RequestDTO internal_types.UpdateCommentDTO
+	err := utils.ParseJSONBody(r, &RequestDTO)
+	if err != nil {
+		log.Printf("could not parse user body: %v", err)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid request body"))
+		return
+	}
+
+	output, err := h.comments.UpdateComment(RequestDTO, tokenUser, taskId, commentId)
+	if writeCommentError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Printf("failed to update comment: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to update comment"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, output)
+}
+
+// delete a comment - invoked by its author or an admin
+func (h *TaskHandler) handleDeleteComment(w http.ResponseWriter, r *http.Request) {
+	taskId := chi.URLParam(r, "taskId")
+	commentId := chi.URLParam(r, "commentId")
+	tokenUser := utils.GetUserFromRequest(r)
+
+	err := h.comments.DeleteComment(tokenUser, taskId, commentId)
+	if writeCommentError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Printf("failed to delete comment: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to delete comment"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, internal_types.SendJsonResponse{Message: "comment deleted successfully"})
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/Ghaby-X/tasork/internal/services"
+	internal_types "github.com/Ghaby-X/tasork/internal/types"
+	"github.com/Ghaby-X/tasork/internal/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+// maps the errors shared by the dependency writes, reporting whether it wrote a response
+func writeDependencyError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, services.ErrNotFound):
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("task or dependency not found"))
+	case errors.Is(err, services.ErrInvalidDependency):
+		utils.WriteError(w, http.StatusUnprocessableEntity, err)
+	case errors.Is(err, services.ErrPreconditionFailed):
+		utils.WriteError(w, http.StatusPreconditionFailed, fmt.Errorf("task has changed since it was read"))
+	case errors.Is(err, services.ErrConflict):
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("dependency already exists or the tasks were modified"))
+	default:
+		return false
+	}
+	return true
+}
+
+// list the tasks a task is blocked by and the tasks it blocks
+func (h *TaskHandler) handleGetDependencies(w http.ResponseWriter, r *http.Request) {
+	taskId := chi.URLParam(r, "taskId")
+	tokenUser := utils.GetUserFromRequest(r)
+
+	output, err := h.service.GetDependencies(tokenUser["custom:tenantId"], taskId)
+	if errors.Is(err, services.ErrNotFound) {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("task not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("failed to get dependencies: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to get dependencies"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, output)
+}
+
+// mark a task as blocked by another task
+func (h *TaskHandler) handleAddDependency(w http.ResponseWriter, r *http.Request) {
+	taskId := chi.URLParam(r, "taskId")
+	tokenUser := utils.GetUserFromRequest(r)
+
+	ifMatch, err := ifMatchVersion(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusPreconditionFailed, err)
+		return
+	}
+
+	var RequestDTO internal_types.DependencyDTO
+	err = utils.ParseJSONBody(r, &RequestDTO)
+	if err != nil || RequestDTO.TaskId == "" {
+		log.Printf("could not parse user body: %v", err)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("taskId is required"))
+		return
+	}
+
+	err = h.service.AddDependency(RequestDTO, tokenUser, taskId, ifMatch)
+	if writeDependencyError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Printf("failed to add dependency: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to add dependency"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, internal_types.SendJsonResponse{Message: "dependency added successfully"})
+}
+
+// stop a task from being blocked by another task
+func (h *TaskHandler) handleRemoveDependency(w http.ResponseWriter, r *http.Request) {
+	taskId := chi.URLParam(r, "taskId")
+	blockerId := chi.URLParam(r, "blockerId")
+	tokenUser := utils.GetUserFromRequest(r)
+
+	ifMatch, err := ifMatchVersion(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusPreconditionFailed, err)
+		return
+	}
+
+	err = h.service.RemoveDependency(tokenUser, taskId, blockerId, ifMatch)
+	if writeDependencyError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Printf("failed to remove dependency: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to remove dependency"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, internal_types.SendJsonResponse{Message: "dependency removed successfully"})
+}
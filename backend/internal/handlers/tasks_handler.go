@@ -1,11 +1,16 @@
 package handler
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 
-	"github.com/Ghaby-X/tasork/internal/env"
 	"github.com/Ghaby-X/tasork/internal/services"
 	internal_types "github.com/Ghaby-X/tasork/internal/types"
 	"github.com/Ghaby-X/tasork/internal/utils"
@@ -15,26 +20,50 @@ import (
 
 type TaskHandler struct {
 	service     *services.TasksService
+	comments    *services.CommentsService
+	attachments *services.AttachmentsService
 	AuthService *services.AuthService
 }
 
-func NewTaskHandler(services *services.TasksService, AuthService *services.AuthService) *TaskHandler {
+func NewTaskHandler(services *services.TasksService, comments *services.CommentsService, attachments *services.AttachmentsService, AuthService *services.AuthService) *TaskHandler {
 	return &TaskHandler{
 		services,
+		comments,
+		attachments,
 		AuthService,
 	}
 }
 
 func (h *TaskHandler) RegisterRoutes(r chi.Router) {
 	r.With(h.AuthService.AuthorizeRegistrationMiddleWare).Route("/tasks", func(r chi.Router) {
-		r.Get("/", h.handleGetAllTasks) // handle if a user or an id
-		r.Post("/", h.CreateTask)
-		r.Get("/{taskId}/view", h.handleGetTaskById)
-		r.Get("/user/{userId}", h.handleGetTaskForUser)
-		r.Get("/{taskId}/history", h.handleGetTaskHistoryById)
-		r.Post("/{taskId}/update", h.handleUpdateTask)  // invoke by admins to update task
-		r.Post("/{taskId}/history", h.handleTaskStatus) // update tasks status - normally invoked by non-admins
-		r.Delete("/{taskId}", h.handleDeleteTask)
+		can := h.AuthService.RequirePermission
+
+		r.With(can(services.PermViewTasks)).Get("/", h.handleGetAllTasks) // handle if a user or an id
+		r.With(can(services.PermCreateTask)).Post("/", h.CreateTask)
+		r.With(can(services.PermViewTasks)).Get("/{taskId}/view", h.handleGetTaskById)
+		r.With(can(services.PermViewTasks)).Get("/user/{userId}", h.handleGetTaskForUser)
+		r.With(can(services.PermViewTasks)).Get("/{taskId}/history", h.handleGetTaskHistoryById)
+		r.With(can(services.PermEditTask)).Patch("/{taskId}", h.handlePatchTask)                    // partial update of task fields
+		r.With(can(services.PermEditTask)).Post("/{taskId}/update", h.handleUpdateTask)             // invoke by admins to update task
+		r.With(can(services.PermUpdateOwnTaskStatus)).Post("/{taskId}/history", h.handleTaskStatus) // members only on tasks assigned to them
+		r.With(can(services.PermDeleteTask)).Delete("/{taskId}", h.handleDeleteTask)
+		r.With(can(services.PermEditTask)).Post("/{taskId}/assignees", h.handleAddAssignee)
+		r.With(can(services.PermEditTask)).Delete("/{taskId}/assignees/{userId}", h.handleRemoveAssignee)
+		r.With(can(services.PermEditTask)).Put("/{taskId}/checklist", h.handleUpdateChecklist)
+		r.With(can(services.PermUpdateOwnTaskStatus)).Patch("/{taskId}/checklist/{itemId}", h.handleCheckItem) // members only on tasks assigned to them
+		r.With(can(services.PermViewTasks)).Get("/{taskId}/dependencies", h.handleGetDependencies)
+		r.With(can(services.PermEditTask)).Post("/{taskId}/dependencies", h.handleAddDependency)
+		r.With(can(services.PermEditTask)).Delete("/{taskId}/dependencies/{blockerId}", h.handleRemoveDependency)
+		r.With(can(services.PermViewTasks)).Get("/{taskId}/comments", h.handleListComments)
+		r.With(can(services.PermComment)).Post("/{taskId}/comments", h.handleCreateComment)
+		r.With(can(services.PermComment)).Patch("/{taskId}/comments/{commentId}", h.handleUpdateComment)
+		r.With(can(services.PermComment)).Delete("/{taskId}/comments/{commentId}", h.handleDeleteComment)
+		r.With(can(services.PermViewTasks)).Get("/{taskId}/attachments", h.handleListAttachments)
+		r.With(can(services.PermAttach)).Post("/{taskId}/attachments", h.handleUploadAttachment) // multipart upload through the api
+		r.With(can(services.PermAttach)).Post("/{taskId}/attachments/presign", h.handlePresignAttachment)
+		r.With(can(services.PermAttach)).Post("/{taskId}/attachments/{attachmentId}/complete", h.handleCompleteAttachment)
+		r.With(can(services.PermViewTasks)).Get("/{taskId}/attachments/{attachmentId}", h.handleDownloadAttachment)
+		r.With(can(services.PermAttach)).Delete("/{taskId}/attachments/{attachmentId}", h.handleDeleteAttachment)
 
 	})
 }
@@ -62,6 +91,17 @@ func (h *TaskHandler) CreateTask(w http.ResponseWriter, r *http.Request) {
 
 	// create task
 	err = h.service.CreateTask(&RequestDTO, user, taskUUID)
+	switch {
+	case errors.Is(err, services.ErrIllegalTransition):
+		utils.WriteError(w, http.StatusUnprocessableEntity, err)
+		return
+	case errors.Is(err, services.ErrInvalidChecklist), errors.Is(err, services.ErrInvalidDeadline):
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	case errors.Is(err, services.ErrNotFound):
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("assignee or parent task not found"))
+		return
+	}
 	if err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
@@ -73,11 +113,25 @@ func (h *TaskHandler) CreateTask(w http.ResponseWriter, r *http.Request) {
 
 // function to get all task - either by tenant
 func (h *TaskHandler) handleGetAllTasks(w http.ResponseWriter, r *http.Request) {
-	tableName := env.GetString("DYNAMODB_TABLE_NAME", "tasork")
 	tokenUser := utils.GetUserFromRequest(r)
 	pkey := tokenUser["custom:tenantId"]
 
-	output, err := h.service.GetAllTaskBytenant(pkey, tableName)
+	page, err := utils.ParsePageQuery(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	filter, err := parseTaskListQuery(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	output, err := h.service.GetAllTaskBytenant(pkey, filter, page)
+	if errors.Is(err, services.ErrInvalidCursor) {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid cursor"))
+		return
+	}
 	if err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
 		return
@@ -88,29 +142,56 @@ func (h *TaskHandler) handleGetAllTasks(w http.ResponseWriter, r *http.Request)
 
 // Get one particular task
 func (h *TaskHandler) handleGetTaskById(w http.ResponseWriter, r *http.Request) {
-	tableName := env.GetString("DYNAMODB_TABLE_NAME", "tasork")
 	tokenUser := utils.GetUserFromRequest(r)
 	pkey := tokenUser["custom:tenantId"]
 	taskId := chi.URLParam(r, "taskId")
 
 	log.Printf("%s", taskId)
-	output, err := h.service.GetOneTaskBytenant(pkey, tableName, taskId)
+	output, err := h.service.GetOneTaskBytenant(pkey, taskId)
+	if errors.Is(err, services.ErrNotFound) {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("task not found"))
+		return
+	}
 	if err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
 
+	etag, err := taskETag(output)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
 	utils.WriteJSON(w, http.StatusOK, output)
 }
 
 // Get Tasks For users
 func (h *TaskHandler) handleGetTaskForUser(w http.ResponseWriter, r *http.Request) {
-	tableName := env.GetString("DYNAMODB_TABLE_NAME", "tasork")
 	tokenUser := utils.GetUserFromRequest(r)
 	pkey := tokenUser["custom:tenantId"]
 	userpKey := chi.URLParam(r, "userId")
 
-	output, err := h.service.GetAllTaskByUser(pkey, tableName, userpKey)
+	page, err := utils.ParsePageQuery(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	output, err := h.service.GetAllTaskByUser(pkey, userpKey, page)
+	switch {
+	case errors.Is(err, services.ErrInvalidCursor):
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid cursor"))
+		return
+	case errors.Is(err, services.ErrNotFound):
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("user not found"))
+		return
+	}
 	if err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
 		return
@@ -124,10 +205,17 @@ func (h *TaskHandler) handleDeleteTask(w http.ResponseWriter, r *http.Request) {
 	taskId := chi.URLParam(r, "taskId")
 	tokenUser := utils.GetUserFromRequest(r)
 	tenantId := tokenUser["custom:tenantId"]
-	tableName := env.GetString("DYNAMODB_TABLE_NAME", "tasork")
 
 	// Delete Task
-	err := h.service.DeleteTask(taskId, tableName, tenantId)
+	err := h.service.DeleteTask(taskId, tenantId)
+	if errors.Is(err, services.ErrNotFound) {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("task not found"))
+		return
+	}
+	if errors.Is(err, services.ErrConflict) {
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("a task depending on it was modified, try again"))
+		return
+	}
 	if err != nil {
 		log.Printf("could not delete: %v", err)
 		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("failed to update task"))
@@ -140,22 +228,17 @@ func (h *TaskHandler) handleDeleteTask(w http.ResponseWriter, r *http.Request) {
 // Handle Task update
 func (h *TaskHandler) handleUpdateTask(w http.ResponseWriter, r *http.Request) {
 	taskId := chi.URLParam(r, "taskId")
-	tokenUser := utils.GetUserFromRequest(r)
-	tenantId := tokenUser["custom:tenantId"]
-	tableName := env.GetString("DYNAMODB_TABLE_NAME", "tasork")
-
-	// Delete Task
-	err := h.service.DeleteTask(taskId, tenantId, tableName)
-	if err != nil {
-		log.Printf("could not delete: %v", err)
-		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("failed to update task"))
-		return
-	}
 
 	// get DTO from request body
 	var RequestDTO internal_types.CreateTaskDTO
 	user := utils.GetUserFromRequest(r)
 
+	ifMatch, err := ifMatchVersion(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusPreconditionFailed, err)
+		return
+	}
+
 	err = utils.ParseJSONBody(r, &RequestDTO)
 	if err != nil {
 		log.Printf("could not parse user body: %v", err)
@@ -169,10 +252,27 @@ func (h *TaskHandler) handleUpdateTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// create task
-	err = h.service.CreateTask(&RequestDTO, user, taskId)
-	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, err)
+	// update task in place
+	err = h.service.ReplaceTask(&RequestDTO, user, taskId, ifMatch)
+	switch {
+	case errors.Is(err, services.ErrNotFound):
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("task or user not found"))
+		return
+	case errors.Is(err, services.ErrInvalidDeadline):
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	case errors.Is(err, services.ErrIllegalTransition):
+		utils.WriteError(w, http.StatusUnprocessableEntity, err)
+		return
+	case errors.Is(err, services.ErrPreconditionFailed):
+		utils.WriteError(w, http.StatusPreconditionFailed, fmt.Errorf("task has changed since it was read"))
+		return
+	case errors.Is(err, services.ErrConflict):
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("task was modified by another request, try again"))
+		return
+	case err != nil:
+		log.Printf("could not update: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to update task"))
 		return
 	}
 
@@ -180,25 +280,177 @@ func (h *TaskHandler) handleUpdateTask(w http.ResponseWriter, r *http.Request) {
 	utils.WriteJSON(w, http.StatusOK, internal_types.SendJsonResponse{Message: "task updated successfully"})
 }
 
+// Handle partial task update
+func (h *TaskHandler) handlePatchTask(w http.ResponseWriter, r *http.Request) {
+	taskId := chi.URLParam(r, "taskId")
+	tokenUser := utils.GetUserFromRequest(r)
+
+	ifMatch, err := ifMatchVersion(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusPreconditionFailed, err)
+		return
+	}
+
+	var RequestDTO internal_types.PatchTaskDTO
+	err = utils.ParseJSONBody(r, &RequestDTO)
+	if err != nil {
+		log.Printf("could not parse user body: %v", err)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid request body"))
+		return
+	}
+
+	// verify dto objects
+	if RequestDTO.Tasktitle == nil && RequestDTO.TaskDescription == nil && RequestDTO.Status == nil && RequestDTO.Deadline == nil && RequestDTO.BlockOnSubtasks == nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("no fields to update"))
+		return
+	}
+	if RequestDTO.Tasktitle != nil && len(*RequestDTO.Tasktitle) < 3 {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("task title should be greater than 3"))
+		return
+	}
+
+	output, err := h.service.UpdateTask(RequestDTO, tokenUser, taskId, ifMatch)
+	switch {
+	case errors.Is(err, services.ErrNotFound):
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("task not found"))
+		return
+	case errors.Is(err, services.ErrInvalidDeadline):
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	case errors.Is(err, services.ErrIllegalTransition):
+		utils.WriteError(w, http.StatusUnprocessableEntity, err)
+		return
+	case errors.Is(err, services.ErrPreconditionFailed):
+		utils.WriteError(w, http.StatusPreconditionFailed, fmt.Errorf("task has changed since it was read"))
+		return
+	case errors.Is(err, services.ErrConflict):
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("task was modified by another request, try again"))
+		return
+	case err != nil:
+		log.Printf("failed to update task: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to update task"))
+		return
+	}
+
+	if output != nil {
+		if etag, err := taskETag(output); err == nil {
+			w.Header().Set("ETag", etag)
+		}
+	}
+	utils.WriteJSON(w, http.StatusOK, output)
+}
+
+// assign a user to an existing task
+func (h *TaskHandler) handleAddAssignee(w http.ResponseWriter, r *http.Request) {
+	taskId := chi.URLParam(r, "taskId")
+	tokenUser := utils.GetUserFromRequest(r)
+
+	ifMatch, err := ifMatchVersion(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusPreconditionFailed, err)
+		return
+	}
+
+	var RequestDTO internal_types.Assignee
+	err = utils.ParseJSONBody(r, &RequestDTO)
+	if err != nil || RequestDTO.UserId == "" {
+		log.Printf("could not parse user body: %v", err)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("userId is required"))
+		return
+	}
+
+	err = h.service.AddAssignee(RequestDTO, tokenUser, taskId, ifMatch)
+	switch {
+	case errors.Is(err, services.ErrNotFound):
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("task or user not found"))
+		return
+	case errors.Is(err, services.ErrPreconditionFailed):
+		utils.WriteError(w, http.StatusPreconditionFailed, fmt.Errorf("task has changed since it was read"))
+		return
+	case errors.Is(err, services.ErrConflict):
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("user is already assigned or the task was modified"))
+		return
+	case err != nil:
+		log.Printf("failed to add assignee: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to add assignee"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, internal_types.SendJsonResponse{Message: "assignee added successfully"})
+}
+
+// unassign a user from a task
+func (h *TaskHandler) handleRemoveAssignee(w http.ResponseWriter, r *http.Request) {
+	taskId := chi.URLParam(r, "taskId")
+	userId := chi.URLParam(r, "userId")
+	tokenUser := utils.GetUserFromRequest(r)
+
+	ifMatch, err := ifMatchVersion(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusPreconditionFailed, err)
+		return
+	}
+
+	err = h.service.RemoveAssignee(userId, tokenUser, taskId, ifMatch)
+	switch {
+	case errors.Is(err, services.ErrNotFound):
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("task or assignee not found"))
+		return
+	case errors.Is(err, services.ErrPreconditionFailed):
+		utils.WriteError(w, http.StatusPreconditionFailed, fmt.Errorf("task has changed since it was read"))
+		return
+	case errors.Is(err, services.ErrConflict):
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("task was modified by another request, try again"))
+		return
+	case err != nil:
+		log.Printf("failed to remove assignee: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to remove assignee"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, internal_types.SendJsonResponse{Message: "assignee removed successfully"})
+}
+
 // for tracking purpose we create task history
 func (h *TaskHandler) handleTaskStatus(w http.ResponseWriter, r *http.Request) {
 	// update task status for tenants as well as user (USER -TASK, TENANT - USER)
 	// create task history - tasktitle, historyid, taskid, edited by,
 	taskId := chi.URLParam(r, "taskId")
 	tokenUser := utils.GetUserFromRequest(r)
-	tableName := env.GetString("DYNAMODB_TABLE_NAME", "tasork")
+
+	ifMatch, err := ifMatchVersion(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusPreconditionFailed, err)
+		return
+	}
 
 	var RequestDTO internal_types.CreateTaskHistory
 
-	err := utils.ParseJSONBody(r, &RequestDTO)
+	err = utils.ParseJSONBody(r, &RequestDTO)
 	if err != nil {
 		log.Printf("could not parse user body: %v", err)
 		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("all fields are required"))
 		return
 	}
 
-	err = h.service.UpdateTaskStatus(RequestDTO, tokenUser, tableName, taskId)
-	if err != nil {
+	err = h.service.UpdateTaskStatus(RequestDTO, tokenUser, taskId, ifMatch)
+	switch {
+	case errors.Is(err, services.ErrNotFound):
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("task not found"))
+		return
+	case errors.Is(err, services.ErrForbidden):
+		utils.WriteError(w, http.StatusForbidden, fmt.Errorf("task is not assigned to you"))
+		return
+	case errors.Is(err, services.ErrIllegalTransition):
+		utils.WriteError(w, http.StatusUnprocessableEntity, err)
+		return
+	case errors.Is(err, services.ErrPreconditionFailed):
+		utils.WriteError(w, http.StatusPreconditionFailed, fmt.Errorf("task has changed since it was read"))
+		return
+	case errors.Is(err, services.ErrConflict):
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("task was modified by another request, try again"))
+		return
+	case err != nil:
 		log.Printf("failed to update status, %v", err)
 		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to update status"))
 		return
@@ -210,10 +462,24 @@ func (h *TaskHandler) handleTaskStatus(w http.ResponseWriter, r *http.Request) {
 // function to handle get task history
 func (h *TaskHandler) handleGetTaskHistoryById(w http.ResponseWriter, r *http.Request) {
 	taskId := chi.URLParam(r, "taskId")
-	tableName := env.GetString("DYNAMODB_TABLE_NAME", "tasork")
+	tokenUser := utils.GetUserFromRequest(r)
+
+	page, err := utils.ParsePageQuery(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
 
 	// invoke get tasks service
-	data, err := h.service.GetTaskHistory(taskId, tableName)
+	data, err := h.service.GetTaskHistory(tokenUser["custom:tenantId"], taskId, page)
+	switch {
+	case errors.Is(err, services.ErrInvalidCursor):
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid cursor"))
+		return
+	case errors.Is(err, services.ErrNotFound):
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("task not found"))
+		return
+	}
 	if err != nil {
 		log.Printf("failed to get task history data: %v", err)
 		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to get task history"))
@@ -222,3 +488,73 @@ func (h *TaskHandler) handleGetTaskHistoryById(w http.ResponseWriter, r *http.Re
 
 	utils.WriteJSON(w, http.StatusOK, data)
 }
+
+// read the filter and sort query parameters of GET /tasks
+func parseTaskListQuery(r *http.Request) (internal_types.TaskListQuery, error) {
+	params := r.URL.Query()
+	query := internal_types.TaskListQuery{
+		Status:    params.Get("status"),
+		Assignee:  params.Get("assignee"),
+		CreatedBy: params.Get("createdBy"),
+		Title:     params.Get("title"),
+		SortBy:    params.Get("sort"),
+	}
+
+	switch query.SortBy {
+	case "", "deadline", "createdAt", "status":
+	default:
+		return query, fmt.Errorf("sort must be one of deadline, createdAt or status")
+	}
+
+	switch params.Get("order") {
+	case "", "asc":
+	case "desc":
+		query.Descending = true
+	default:
+		return query, fmt.Errorf("order must be asc or desc")
+	}
+
+	// deadlines are compared in the ISO 8601 form they are saved in
+	for param, field := range map[string]*string{"deadlineAfter": &query.DeadlineAfter, "deadlineBefore": &query.DeadlineBefore} {
+		value := params.Get(param)
+		if value == "" {
+			continue
+		}
+		date, err := utils.ParseDateToISOString(value)
+		if err != nil {
+			return query, fmt.Errorf("%s is not a valid date", param)
+		}
+		*field = date
+	}
+
+	return query, nil
+}
+
+// the ETag of a task view, its version followed by a hash of the rendered
+// view. Completion, subtask counts, blocked and overdue change the view
+// without a new version, so the version alone would answer 304 for a stale view
+func taskETag(output *internal_types.GetTasksOutput) (string, error) {
+	body, err := json.Marshal(output)
+	if err != nil {
+		return "", fmt.Errorf("failed to render task: %w", err)
+	}
+	sum := sha256.Sum256(body)
+	return strconv.Quote(fmt.Sprintf("%d-%s", output.Task.Version, hex.EncodeToString(sum[:8]))), nil
+}
+
+// reads the task version a write is based on from If-Match, nil when the
+// header is absent or "*". Only the version part of a task ETag is compared,
+// a bare version is accepted too. A tag that is not a task ETag can never match
+func ifMatchVersion(r *http.Request) (*int64, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return nil, nil
+	}
+
+	tag, _, _ := strings.Cut(strings.Trim(header, `"`), "-")
+	version, err := strconv.ParseInt(tag, 10, 64)
+	if err != nil || strings.Contains(header, ",") {
+		return nil, fmt.Errorf("If-Match must be a single task ETag")
+	}
+	return &version, nil
+}
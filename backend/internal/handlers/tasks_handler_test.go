@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Ghaby-X/tasork/internal/services"
+	"github.com/Ghaby-X/tasork/internal/store"
+	internal_types "github.com/Ghaby-X/tasork/internal/types"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/go-chi/chi/v5"
+)
+
+// the tasks service on an in-memory table with one admin, ann of TENANT#a
+func newTestTasks(t *testing.T) (*services.TasksService, internal_types.TokenClaims) {
+	t.Helper()
+	st := store.NewStorage(store.NewMemoryDB(), store.NewLocalBlobStore(t.TempDir()))
+	notify := services.NewDispatcher(st.Notifications, services.NewNotificationHub(services.NewLocalBroker()), services.NewMemoryMailer(), services.SystemClock)
+	tasks := services.NewTaskService(st.Tasks, st.Users, services.NewWorkflowService(st.Workflows), notify)
+
+	err := st.Users.CreateItem(&dynamodb.PutItemInput{
+		TableName: aws.String("tasork"),
+		Item: map[string]types.AttributeValue{
+			"PartitionKey": &types.AttributeValueMemberS{Value: "TENANT#a"},
+			"SortKey":      &types.AttributeValueMemberS{Value: store.UserKey("ann")},
+			"userName":     &types.AttributeValueMemberS{Value: "ann"},
+			"email":        &types.AttributeValueMemberS{Value: "ann@example.com"},
+			"role":         &types.AttributeValueMemberS{Value: "admin"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tasks, internal_types.TokenClaims{
+		"sub":             "ann",
+		"custom:tenantId": "TENANT#a",
+		"custom:role":     "admin",
+		"custom:username": "ann",
+	}
+}
+
+// sets the claims the JWT middleware would
+func withClaims(claims internal_types.TokenClaims) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), internal_types.ContextKey("user"), claims)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func TestTaskETag(t *testing.T) {
+	tasks, claims := newTestTasks(t)
+	h := &TaskHandler{service: tasks}
+	r := chi.NewRouter()
+	r.Use(withClaims(claims))
+	r.Get("/tasks/{taskId}/view", h.handleGetTaskById)
+	r.Patch("/tasks/{taskId}", h.handlePatchTask)
+
+	if err := tasks.CreateTask(&internal_types.CreateTaskDTO{Tasktitle: "parent"}, claims, "parent"); err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(method, path, body string, header map[string]string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for name, value := range header {
+			req.Header.Set(name, value)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := serve(http.MethodGet, "/tasks/parent/view", "", nil)
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("view = %d with ETag %q, want 200 with an ETag", first.Code, etag)
+	}
+	if rec := serve(http.MethodGet, "/tasks/parent/view", "", map[string]string{"If-None-Match": etag}); rec.Code != http.StatusNotModified {
+		t.Errorf("view with the current ETag = %d, want 304", rec.Code)
+	}
+
+	// a new subtask changes the view of its parent, not its version
+	if err := tasks.CreateTask(&internal_types.CreateTaskDTO{Tasktitle: "child", ParentId: "parent"}, claims, "child"); err != nil {
+		t.Fatal(err)
+	}
+	changed := serve(http.MethodGet, "/tasks/parent/view", "", map[string]string{"If-None-Match": etag})
+	if changed.Code != http.StatusOK {
+		t.Fatalf("view after a subtask was added = %d, want 200", changed.Code)
+	}
+	newETag := changed.Header().Get("ETag")
+	if newETag == etag {
+		t.Errorf("ETag %s did not change with the view", etag)
+	}
+	if version, _, _ := strings.Cut(newETag, "-"); !strings.HasPrefix(etag, version+"-") {
+		t.Errorf("ETag went from %s to %s, want the same version", etag, newETag)
+	}
+
+	// writes are checked against the version only, so the first ETag still
+	// matches and the write moves the task on to a new version
+	title := `{"taskTitle": "renamed"}`
+	if rec := serve(http.MethodPatch, "/tasks/parent", title, map[string]string{"If-Match": etag}); rec.Code != http.StatusOK {
+		t.Fatalf("patch with the current version = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if rec := serve(http.MethodPatch, "/tasks/parent", title, map[string]string{"If-Match": newETag}); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("patch with a stale ETag = %d, want 412", rec.Code)
+	}
+	if rec := serve(http.MethodPatch, "/tasks/parent", title, map[string]string{"If-Match": `"one"`}); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("patch with an If-Match that is not a task ETag = %d, want 412", rec.Code)
+	}
+}
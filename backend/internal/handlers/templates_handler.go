@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/Ghaby-X/tasork/internal/services"
+	internal_types "github.com/Ghaby-X/tasork/internal/types"
+	"github.com/Ghaby-X/tasork/internal/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+type TemplateHandler struct {
+	service     *services.TemplatesService
+	AuthService *services.AuthService
+}
+
+func NewTemplateHandler(services *services.TemplatesService, AuthService *services.AuthService) *TemplateHandler {
+	return &TemplateHandler{
+		services,
+		AuthService,
+	}
+}
+
+func (h *TemplateHandler) RegisterRoutes(r chi.Router) {
+	r.With(h.AuthService.AuthorizeRegistrationMiddleWare, h.AuthService.RequirePermission(services.PermManageTemplates)).Route("/templates", func(r chi.Router) {
+		r.Get("/", h.handleListTemplates)
+		r.Post("/", h.handleCreateTemplate)
+		r.Get("/{templateId}", h.handleGetTemplate)
+		r.Put("/{templateId}", h.handleUpdateTemplate)
+		r.Delete("/{templateId}", h.handleDeleteTemplate)
+	})
+}
+
+// maps the errors shared by the template endpoints, reporting whether it wrote a response
+func writeTemplateError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, services.ErrInvalidTemplate):
+		utils.WriteError(w, http.StatusBadRequest, err)
+	case errors.Is(err, services.ErrNotFound):
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("template or assignee not found"))
+	case errors.Is(err, services.ErrConflict):
+		utils.WriteError(w, http.StatusConflict, fmt.Errorf("template was modified by another request, try again"))
+	default:
+		return false
+	}
+	return true
+}
+
+// list the recurring task templates of the tenant
+func (h *TemplateHandler) handleListTemplates(w http.ResponseWriter, r *http.Request) {
+	tokenUser := utils.GetUserFromRequest(r)
+
+	page, err := utils.ParsePageQuery(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	output, err := h.service.ListTemplates(tokenUser["custom:tenantId"], page)
+	if errors.Is(err, services.ErrInvalidCursor) {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid cursor"))
+		return
+	}
+	if err != nil {
+		log.Printf("failed to list templates: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to list templates"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, output)
+}
+
+// create a template, its tasks are created by the scheduler
+func (h *TemplateHandler) handleCreateTemplate(w http.ResponseWriter, r *http.Request) {
+	tokenUser := utils.GetUserFromRequest(r)
+
+	var RequestDTO internal_types.TemplateDTO
+	err := utils.ParseJSONBody(r, &RequestDTO)
+	if err != nil {
+		log.Printf("could not parse user body: %v", err)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid request body"))
+		return
+	}
+
+	output, err := h.service.CreateTemplate(RequestDTO, tokenUser)
+	if writeTemplateError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Printf("failed to create template: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to create template"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, output)
+}
+
+func (h *TemplateHandler) handleGetTemplate(w http.ResponseWriter, r *http.Request) {
+	templateId := chi.URLParam(r, "templateId")
+	tokenUser := utils.GetUserFromRequest(r)
+
+	output, err := h.service.GetTemplate(tokenUser["custom:tenantId"], templateId)
+	if writeTemplateError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Printf("failed to get template: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to get template"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, output)
+}
+
+// replace a template, tasks already created from it are kept
+func (h *TemplateHandler) handleUpdateTemplate(w http.ResponseWriter, r *http.Request) {
+	templateId := chi.URLParam(r, "templateId")
+	tokenUser := utils.GetUserFromRequest(r)
+
+	var RequestDTO internal_types.TemplateDTO
+	err := utils.ParseJSONBody(r, &RequestDTO)
+	if err != nil {
+		log.Printf("could not parse user body: %v", err)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid request body"))
+		return
+	}
+
+	output, err := h.service.UpdateTemplate(RequestDTO, tokenUser, templateId)
+	if writeTemplateError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Printf("failed to update template: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to update template"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, output)
+}
+
+// delete a template, tasks already created from it are kept
+func (h *TemplateHandler) handleDeleteTemplate(w http.ResponseWriter, r *http.Request) {
+	templateId := chi.URLParam(r, "templateId")
+	tokenUser := utils.GetUserFromRequest(r)
+
+	err := h.service.DeleteTemplate(tokenUser["custom:tenantId"], templateId)
+	if writeTemplateError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Printf("failed to delete template: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to delete template"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, internal_types.SendJsonResponse{Message: "template deleted successfully"})
+}
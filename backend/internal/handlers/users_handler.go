@@ -1,12 +1,15 @@
 package handler
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"time"
 
-	"github.com/Ghaby-X/tasork/internal/env"
 	"github.com/Ghaby-X/tasork/internal/services"
+	"github.com/Ghaby-X/tasork/internal/store"
 	internal_types "github.com/Ghaby-X/tasork/internal/types"
 	"github.com/Ghaby-X/tasork/internal/utils"
 	"github.com/go-chi/chi/v5"
@@ -26,22 +29,44 @@ func NewUserHandler(services *services.UsersService, AuthService *services.AuthS
 
 func (h *UserHandler) RegisterRoutes(r chi.Router) {
 	r.With(h.AuthService.AuthorizeRegistrationMiddleWare).Route("/users", func(r chi.Router) {
-		r.Get("/", h.GetAllUsers)
-		r.Post("/invite", h.handleInviteUsers)
+		r.With(h.AuthService.RequirePermission(services.PermViewUsers)).Get("/", h.GetAllUsers)
+		r.With(h.AuthService.RequirePermission(services.PermInviteUsers)).Post("/invite", h.handleInviteUsers)
+		r.Get("/notification", h.handleGetNotifications)
 		r.Post("/notification", h.handleGetNotifications)
+		r.Get("/notification/unread-count", h.handleCountUnreadNotifications)
+		r.Post("/notification/read", h.handleMarkAllNotificationsRead)
+		r.Post("/notification/{notificationId}/read", h.handleMarkNotificationRead)
+		r.Post("/notification/{notificationId}/archive", h.handleArchiveNotification)
+		r.Delete("/notification/{notificationId}", h.handleDeleteNotification)
+		r.Get("/preferences", h.handleGetPreferences)
+		r.Patch("/preferences", h.handleUpdatePreferences)
 	})
 }
 
+// routes that stay open, registered apart so the request timeout leaves them be
+func (h *UserHandler) RegisterStreamRoutes(r chi.Router) {
+	r.With(h.AuthService.AuthorizeRegistrationMiddleWare).Get("/users/notifications/stream", h.handleStreamNotifications)
+}
+
 // r.Post("/{userId}/deleteUser", h.handleDeleteUser)
 
 // get users from tenantId
 func (h *UserHandler) GetAllUsers(w http.ResponseWriter, r *http.Request) {
 	tokenUser := utils.GetUserFromRequest(r)
 	tenantId := tokenUser["custom:tenantId"]
-	tableName := env.GetString("DYNAMODB_TABLE_NAME", "")
+
+	page, err := utils.ParsePageQuery(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
 
 	// get users from service
-	users, err := h.service.GetAllUsers(tenantId, tableName)
+	users, err := h.service.GetAllUsers(tenantId, page)
+	if errors.Is(err, services.ErrInvalidCursor) {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid cursor"))
+		return
+	}
 	if err != nil {
 		log.Printf("failed to retrieve users: %v", err)
 		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to retrieve users"))
@@ -77,14 +102,28 @@ func (h *UserHandler) handleInviteUsers(w http.ResponseWriter, r *http.Request)
 	utils.WriteJSON(w, http.StatusOK, internal_types.SendJsonResponse{Message: "Invite sent successfully"})
 }
 
-// get notifications of a user
+// get notifications of a user, newest first, only the unread ones with
+// ?unread=true and the archived ones in place of the others with ?archived=true
 func (h *UserHandler) handleGetNotifications(w http.ResponseWriter, r *http.Request) {
 	user := utils.GetUserFromRequest(r)
 	userId := "USER#" + user["sub"]
-	tableName := env.GetString("DYNAMODB_TABLE_NAME", "")
+
+	page, err := utils.ParsePageQuery(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
 
 	// get notifications from service
-	notifications, err := h.service.GetNotifications(userId, tableName)
+	query := store.NotificationQuery{
+		UnreadOnly: r.URL.Query().Get("unread") == "true",
+		Archived:   r.URL.Query().Get("archived") == "true",
+	}
+	notifications, err := h.service.GetNotifications(userId, query, page)
+	if errors.Is(err, services.ErrInvalidCursor) {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid cursor"))
+		return
+	}
 	if err != nil {
 		log.Printf("failed to retrieve notifications: %v", err)
 		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to retrieve notifications"))
@@ -93,3 +132,209 @@ func (h *UserHandler) handleGetNotifications(w http.ResponseWriter, r *http.Requ
 
 	utils.WriteJSON(w, http.StatusOK, notifications)
 }
+
+func (h *UserHandler) handleCountUnreadNotifications(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromRequest(r)
+
+	count, err := h.service.CountUnreadNotifications("USER#" + user["sub"])
+	if err != nil {
+		log.Printf("failed to count notifications: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to count notifications"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, internal_types.NotificationCountDTO{Count: count})
+}
+
+func (h *UserHandler) handleMarkNotificationRead(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromRequest(r)
+	notificationId := chi.URLParam(r, "notificationId")
+
+	err := h.service.MarkNotificationRead("USER#"+user["sub"], notificationId)
+	if errors.Is(err, services.ErrNotFound) {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("notification not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("failed to mark notification read: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to mark notification read"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, internal_types.SendJsonResponse{Message: "notification marked read"})
+}
+
+// mark every unread notification of the user read, returning how many there were
+func (h *UserHandler) handleMarkAllNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromRequest(r)
+
+	count, err := h.service.MarkAllNotificationsRead("USER#" + user["sub"])
+	if err != nil {
+		log.Printf("failed to mark notifications read: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to mark notifications read"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, internal_types.NotificationCountDTO{Count: count})
+}
+
+func (h *UserHandler) handleArchiveNotification(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromRequest(r)
+	notificationId := chi.URLParam(r, "notificationId")
+
+	err := h.service.ArchiveNotification("USER#"+user["sub"], notificationId)
+	if errors.Is(err, services.ErrNotFound) {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("notification not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("failed to archive notification: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to archive notification"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, internal_types.SendJsonResponse{Message: "notification archived"})
+}
+
+func (h *UserHandler) handleDeleteNotification(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromRequest(r)
+	notificationId := chi.URLParam(r, "notificationId")
+
+	err := h.service.DeleteNotification("USER#"+user["sub"], notificationId)
+	if errors.Is(err, services.ErrNotFound) {
+		utils.WriteError(w, http.StatusNotFound, fmt.Errorf("notification not found"))
+		return
+	}
+	if err != nil {
+		log.Printf("failed to delete notification: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to delete notification"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, internal_types.SendJsonResponse{Message: "notification deleted successfully"})
+}
+
+func (h *UserHandler) handleGetPreferences(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromRequest(r)
+
+	preferences, err := h.service.GetPreferences("USER#" + user["sub"])
+	if err != nil {
+		log.Printf("failed to retrieve preferences: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to retrieve preferences"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, preferences)
+}
+
+// change the given notification preferences, leaving out the rest
+func (h *UserHandler) handleUpdatePreferences(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromRequest(r)
+
+	var RequestDTO internal_types.PatchPreferencesDTO
+	err := utils.ParseJSONBody(r, &RequestDTO)
+	if err != nil {
+		log.Printf("could not parse preferences body: %v", err)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid request body"))
+		return
+	}
+
+	preferences, err := h.service.UpdatePreferences("USER#"+user["sub"], user["custom:tenantId"], RequestDTO)
+	if errors.Is(err, services.ErrInvalidPreferences) {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		log.Printf("failed to update preferences: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to update preferences"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, preferences)
+}
+
+// how often an idle stream sends a comment, keeping proxies from closing it
+const streamHeartbeat = 25 * time.Second
+
+// how long a client waits before reconnecting a dropped stream, in milliseconds
+const streamRetry = 5000
+
+func writeNotificationEvent(w http.ResponseWriter, notification store.Notification) error {
+	data, err := json.Marshal(services.ToNotificationDTO(notification))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: notification\ndata: %s\n\n", notification.NotificationID, data)
+	return err
+}
+
+// stream the user's notifications as server-sent events. A client that
+// reconnects with Last-Event-ID, or ?lastEventId= where it cannot set
+// headers, first gets the notifications it missed
+func (h *UserHandler) handleStreamNotifications(w http.ResponseWriter, r *http.Request) {
+	user := utils.GetUserFromRequest(r)
+
+	lastEventId := r.Header.Get("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = r.URL.Query().Get("lastEventId")
+	}
+
+	subscription, missed, err := h.service.StreamNotifications("USER#"+user["sub"], lastEventId)
+	if err != nil {
+		log.Printf("failed to stream notifications: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to stream notifications"))
+		return
+	}
+	defer subscription.Close()
+
+	// the server's write timeout would otherwise end the stream
+	controller := http.NewResponseController(w)
+	if err := controller.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Printf("failed to clear write deadline: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetry)
+
+	replayed := map[string]bool{}
+	for _, notification := range missed {
+		if err := writeNotificationEvent(w, notification); err != nil {
+			return
+		}
+		replayed[notification.NotificationID] = true
+	}
+	if err := controller.Flush(); err != nil {
+		log.Printf("failed to flush notification stream: %v", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case notification, ok := <-subscription.C:
+			// dropped for falling behind, the client reconnects and replays
+			if !ok {
+				return
+			}
+			if replayed[notification.NotificationID] {
+				continue
+			}
+			if err := writeNotificationEvent(w, notification); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/Ghaby-X/tasork/internal/services"
+	internal_types "github.com/Ghaby-X/tasork/internal/types"
+	"github.com/Ghaby-X/tasork/internal/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+type WorkflowHandler struct {
+	service     *services.WorkflowService
+	AuthService *services.AuthService
+}
+
+func NewWorkflowHandler(services *services.WorkflowService, AuthService *services.AuthService) *WorkflowHandler {
+	return &WorkflowHandler{
+		services,
+		AuthService,
+	}
+}
+
+func (h *WorkflowHandler) RegisterRoutes(r chi.Router) {
+	r.With(h.AuthService.AuthorizeRegistrationMiddleWare).Route("/workflow", func(r chi.Router) {
+		r.With(h.AuthService.RequirePermission(services.PermViewWorkflow)).Get("/", h.handleGetWorkflow)
+		r.With(h.AuthService.RequirePermission(services.PermEditWorkflow)).Put("/", h.handleUpdateWorkflow) // invoke by admins to change task statuses
+	})
+}
+
+// get the task workflow of the tenant
+func (h *WorkflowHandler) handleGetWorkflow(w http.ResponseWriter, r *http.Request) {
+	tokenUser := utils.GetUserFromRequest(r)
+
+	output, err := h.service.GetWorkflow(tokenUser["custom:tenantId"])
+	if err != nil {
+		log.Printf("failed to get workflow: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to get workflow"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, output)
+}
+
+// replace the task workflow of the tenant
+func (h *WorkflowHandler) handleUpdateWorkflow(w http.ResponseWriter, r *http.Request) {
+	tokenUser := utils.GetUserFromRequest(r)
+
+	var RequestDTO internal_types.WorkflowDTO
+	err := utils.ParseJSONBody(r, &RequestDTO)
+	if err != nil {
+		log.Printf("could not parse user body: %v", err)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid request body"))
+		return
+	}
+
+	output, err := h.service.UpdateWorkflow(RequestDTO, tokenUser)
+	if errors.Is(err, services.ErrInvalidWorkflow) {
+		utils.WriteError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	if err != nil {
+		log.Printf("failed to update workflow: %v", err)
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to update workflow"))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, output)
+}
@@ -0,0 +1,397 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Ghaby-X/tasork/internal/env"
+	"github.com/Ghaby-X/tasork/internal/store"
+	internal_types "github.com/Ghaby-X/tasork/internal/types"
+	"github.com/google/uuid"
+)
+
+// how long presigned upload and download urls stay valid
+const presignExpiry = 15 * time.Minute
+
+// attachment types accepted when ATTACHMENT_TYPES is not set
+const defaultAttachmentTypes = "image/jpeg,image/png,image/gif,image/webp,application/pdf,text/plain"
+
+type AttachmentsService struct {
+	store   store.AttachmentsStore
+	blobs   store.BlobStore
+	tasks   store.TasksStore
+	maxSize int64
+	types   []string
+}
+
+// limits come from MAX_ATTACHMENT_BYTES (10MB by default) and ATTACHMENT_TYPES,
+// a comma separated list of media types
+func NewAttachmentsService(attachmentstore store.AttachmentsStore, blobs store.BlobStore, taskstore store.TasksStore) *AttachmentsService {
+	var types []string
+	for _, mediaType := range strings.Split(env.GetString("ATTACHMENT_TYPES", defaultAttachmentTypes), ",") {
+		if mediaType = strings.TrimSpace(strings.ToLower(mediaType)); mediaType != "" {
+			types = append(types, mediaType)
+		}
+	}
+
+	return &AttachmentsService{
+		store:   attachmentstore,
+		blobs:   blobs,
+		tasks:   taskstore,
+		maxSize: int64(env.GetInt("MAX_ATTACHMENT_BYTES", 10<<20)),
+		types:   types,
+	}
+}
+
+// MaxSize is the largest attachment accepted, in bytes
+func (s *AttachmentsService) MaxSize() int64 {
+	return s.maxSize
+}
+
+// reads a task of the caller's tenant, tasks of other tenants are not found
+func (s *AttachmentsService) task(tenantId, taskId string) (*store.Task, error) {
+	task, err := s.tasks.GetTask(tenantId, taskId)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, fmt.Errorf("task %s: %w", taskId, ErrNotFound)
+	}
+	return task, err
+}
+
+// reads a task the user may attach files to
+func (s *AttachmentsService) taskToAttachTo(user internal_types.TokenClaims, taskId string) (*store.Task, error) {
+	task, err := s.task(user["custom:tenantId"], taskId)
+	if err != nil {
+		return nil, err
+	}
+	if !HasPermission(user["custom:role"], PermAttachToAnyTask) && !isAssigned(*task, user["sub"]) {
+		return nil, fmt.Errorf("task %s is not assigned to %s: %w", taskId, user["sub"], ErrForbidden)
+	}
+	return task, nil
+}
+
+func (s *AttachmentsService) attachment(taskId, attachmentId string) (*store.Attachment, error) {
+	attachment, err := s.store.GetAttachment(taskId, attachmentId)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, fmt.Errorf("attachment %s: %w", attachmentId, ErrNotFound)
+	}
+	return attachment, err
+}
+
+// checks the media type, without parameters, is one of the accepted ones
+func (s *AttachmentsService) checkType(contentType string) (string, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || !slices.Contains(s.types, strings.ToLower(mediaType)) {
+		return "", fmt.Errorf("%w: %q", ErrUnsupportedMediaType, contentType)
+	}
+	return strings.ToLower(mediaType), nil
+}
+
+func (s *AttachmentsService) checkSize(size int64) error {
+	if size > s.maxSize {
+		return fmt.Errorf("%w: the limit is %d bytes", ErrAttachmentTooLarge, s.maxSize)
+	}
+	return nil
+}
+
+// whether two content types name the same media type, whatever their parameters
+func sameMediaType(a, b string) bool {
+	a, _, errA := mime.ParseMediaType(a)
+	b, _, errB := mime.ParseMediaType(b)
+	return errA == nil && errB == nil && a == b
+}
+
+// a file name without any directories, safe to send back in a header
+func cleanFileName(name string) (string, error) {
+	name = path.Base(strings.ReplaceAll(strings.TrimSpace(name), `\`, "/"))
+	if name == "." || name == "/" || name == "" {
+		return "", fmt.Errorf("%w: a file name is required", ErrInvalidAttachment)
+	}
+	return name, nil
+}
+
+// the blob of an attachment, grouped by tenant and task
+func attachmentBlobKey(tenantId, taskId, attachmentId string) string {
+	return fmt.Sprintf("tenants/%s/tasks/%s/%s",
+		strings.TrimPrefix(tenantId, "TENANT#"), strings.TrimPrefix(taskId, "TASK#"), attachmentId)
+}
+
+func attachedHistory(taskId string, user internal_types.TokenClaims, task *store.Task, attachment store.Attachment, removed bool) *store.HistoryEntry {
+	if removed {
+		return newHistory(taskId, user, store.HistoryAttached, task.Status, fmt.Sprintf("removed %s", attachment.FileName),
+			[]store.FieldChange{{Field: "attachment", Before: attachment.AttachmentID}})
+	}
+	return newHistory(taskId, user, store.HistoryAttached, task.Status, fmt.Sprintf("attached %s", attachment.FileName),
+		[]store.FieldChange{{Field: "attachment", After: attachment.AttachmentID}})
+}
+
+// stores a file uploaded through the api. Its type is sniffed from its
+// contents rather than trusted from the client
+func (s *AttachmentsService) UploadAttachment(user internal_types.TokenClaims, taskId, fileName string, file io.Reader) (*internal_types.AttachmentOutput, error) {
+	task, err := s.taskToAttachTo(user, taskId)
+	if err != nil {
+		return nil, err
+	}
+	fileName, err = cleanFileName(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	// blob stores need the size up front, and attachments are small enough to hold
+	contents, err := io.ReadAll(io.LimitReader(file, s.maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload: %w", err)
+	}
+	if err := s.checkSize(int64(len(contents))); err != nil {
+		return nil, err
+	}
+	if len(contents) == 0 {
+		return nil, fmt.Errorf("%w: the file is empty", ErrInvalidAttachment)
+	}
+	contentType, err := s.checkType(http.DetectContentType(contents))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	attachment := store.Attachment{
+		TaskID:       taskId,
+		AttachmentID: uuid.NewString(),
+		FileName:     fileName,
+		ContentType:  contentType,
+		Size:         int64(len(contents)),
+		UploadedBy:   user["sub"],
+		UploadedAt:   now.Format(time.RFC3339),
+	}
+	attachment.BlobKey = attachmentBlobKey(task.TenantID, taskId, attachment.AttachmentID)
+
+	if err := s.blobs.Put(attachment.BlobKey, bytes.NewReader(contents), attachment.Size, contentType); err != nil {
+		return nil, fmt.Errorf("failed to store attachment: %w", err)
+	}
+	if err := s.store.PutAttachment(attachment, attachedHistory(taskId, user, task, attachment, false)); err != nil {
+		if err := s.blobs.Delete(attachment.BlobKey); err != nil {
+			log.Printf("failed to delete blob %s of a failed attachment: %v", attachment.BlobKey, err)
+		}
+		return nil, err
+	}
+
+	output := toAttachmentOutput(attachment)
+	return &output, nil
+}
+
+// creates a pending attachment and a url the client uploads its contents to
+// directly, after which it calls CompleteAttachment
+func (s *AttachmentsService) PresignAttachment(data internal_types.PresignAttachmentDTO, user internal_types.TokenClaims, taskId string) (*internal_types.PresignedUploadOutput, error) {
+	task, err := s.taskToAttachTo(user, taskId)
+	if err != nil {
+		return nil, err
+	}
+	fileName, err := cleanFileName(data.FileName)
+	if err != nil {
+		return nil, err
+	}
+	if data.Size <= 0 {
+		return nil, fmt.Errorf("%w: the file size is required", ErrInvalidAttachment)
+	}
+	if err := s.checkSize(data.Size); err != nil {
+		return nil, err
+	}
+	contentType, err := s.checkType(data.ContentType)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	attachment := store.Attachment{
+		TaskID:       taskId,
+		AttachmentID: uuid.NewString(),
+		FileName:     fileName,
+		ContentType:  contentType,
+		Size:         data.Size,
+		UploadedBy:   user["sub"],
+		UploadedAt:   now.Format(time.RFC3339),
+		Pending:      true,
+	}
+	attachment.BlobKey = attachmentBlobKey(task.TenantID, taskId, attachment.AttachmentID)
+
+	uploadURL, err := s.blobs.PresignPut(attachment.BlobKey, contentType, data.Size, presignExpiry)
+	if errors.Is(err, store.ErrPresignNotSupported) {
+		return nil, fmt.Errorf("presigned uploads: %w", ErrNotSupported)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.PutAttachment(attachment, nil); err != nil {
+		return nil, err
+	}
+
+	return &internal_types.PresignedUploadOutput{
+		Attachment: toAttachmentOutput(attachment),
+		UploadURL:  uploadURL,
+		ExpiresAt:  now.Add(presignExpiry).Format(time.RFC3339),
+	}, nil
+}
+
+// confirms a presigned upload arrived as announced, an upload of another size
+// or content type is thrown away
+func (s *AttachmentsService) CompleteAttachment(user internal_types.TokenClaims, taskId, attachmentId string) (*internal_types.AttachmentOutput, error) {
+	task, err := s.task(user["custom:tenantId"], taskId)
+	if err != nil {
+		return nil, err
+	}
+	attachment, err := s.attachment(taskId, attachmentId)
+	if err != nil {
+		return nil, err
+	}
+	if store.UserKey(attachment.UploadedBy) != store.UserKey(user["sub"]) {
+		return nil, fmt.Errorf("attachment %s is not by %s: %w", attachmentId, user["sub"], ErrForbidden)
+	}
+	if !attachment.Pending {
+		output := toAttachmentOutput(*attachment)
+		return &output, nil
+	}
+
+	info, err := s.blobs.Stat(attachment.BlobKey)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, fmt.Errorf("%w: nothing was uploaded", ErrInvalidAttachment)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var mismatch string
+	switch {
+	case info.Size != attachment.Size:
+		mismatch = fmt.Sprintf("uploaded %d bytes, announced %d", info.Size, attachment.Size)
+	// stores that do not keep the content type cannot be checked for it
+	case info.ContentType != "" && !sameMediaType(info.ContentType, attachment.ContentType):
+		mismatch = fmt.Sprintf("uploaded %q, announced %q", info.ContentType, attachment.ContentType)
+	}
+	if mismatch != "" {
+		if err := s.blobs.Delete(attachment.BlobKey); err != nil {
+			log.Printf("failed to delete mismatched blob %s: %v", attachment.BlobKey, err)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrInvalidAttachment, mismatch)
+	}
+
+	attachment.Pending = false
+	attachment.UploadedAt = time.Now().UTC().Format(time.RFC3339)
+	err = s.store.CompleteAttachment(*attachment, *attachedHistory(taskId, user, task, *attachment, false))
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return nil, err
+	}
+
+	output := toAttachmentOutput(*attachment)
+	return &output, nil
+}
+
+// lists one page of a task's attachments to any user of its tenant
+func (s *AttachmentsService) ListAttachments(tenantId, taskId string, page internal_types.PageQuery) (*internal_types.Page[internal_types.AttachmentOutput], error) {
+	if _, err := s.task(tenantId, taskId); err != nil {
+		return nil, err
+	}
+
+	attachments, next, err := s.store.ListAttachments(taskId, store.PageRequest{Limit: page.Limit, Cursor: page.Cursor})
+	if err != nil {
+		return nil, listError(err)
+	}
+
+	output := make([]internal_types.AttachmentOutput, 0, len(attachments))
+	for _, attachment := range attachments {
+		output = append(output, toAttachmentOutput(attachment))
+	}
+	return &internal_types.Page[internal_types.AttachmentOutput]{Items: output, NextCursor: next}, nil
+}
+
+// Download is where to get an attachment from, a short lived url when the blob
+// store can presign one and its contents otherwise
+type Download struct {
+	Attachment internal_types.AttachmentOutput
+	URL        string
+	Body       io.ReadCloser
+}
+
+// opens an attachment for any user of the task's tenant
+func (s *AttachmentsService) DownloadAttachment(tenantId, taskId, attachmentId string) (*Download, error) {
+	if _, err := s.task(tenantId, taskId); err != nil {
+		return nil, err
+	}
+	attachment, err := s.attachment(taskId, attachmentId)
+	if err != nil {
+		return nil, err
+	}
+	if attachment.Pending {
+		return nil, fmt.Errorf("attachment %s is not uploaded yet: %w", attachmentId, ErrNotFound)
+	}
+
+	download := &Download{Attachment: toAttachmentOutput(*attachment)}
+	download.URL, err = s.blobs.PresignGet(attachment.BlobKey, presignExpiry)
+	if err == nil {
+		return download, nil
+	}
+	if !errors.Is(err, store.ErrPresignNotSupported) {
+		return nil, err
+	}
+
+	download.Body, _, err = s.blobs.Get(attachment.BlobKey)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, fmt.Errorf("attachment %s has no contents: %w", attachmentId, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return download, nil
+}
+
+// deletes an attachment, its uploader or a moderator may
+func (s *AttachmentsService) DeleteAttachment(user internal_types.TokenClaims, taskId, attachmentId string) error {
+	task, err := s.task(user["custom:tenantId"], taskId)
+	if err != nil {
+		return err
+	}
+	attachment, err := s.attachment(taskId, attachmentId)
+	if err != nil {
+		return err
+	}
+	if store.UserKey(attachment.UploadedBy) != store.UserKey(user["sub"]) && !HasPermission(user["custom:role"], PermModerateAttachments) {
+		return fmt.Errorf("attachment %s is not by %s: %w", attachmentId, user["sub"], ErrForbidden)
+	}
+
+	var history *store.HistoryEntry
+	if !attachment.Pending {
+		history = attachedHistory(taskId, user, task, *attachment, true)
+	}
+	err = s.store.DeleteAttachment(taskId, attachmentId, history)
+	if errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("attachment %s: %w", attachmentId, ErrNotFound)
+	}
+	if err != nil {
+		return err
+	}
+
+	// the metadata is gone, so a blob left behind is only wasted space
+	if err := s.blobs.Delete(attachment.BlobKey); err != nil {
+		log.Printf("failed to delete blob %s: %v", attachment.BlobKey, err)
+	}
+	return nil
+}
+
+func toAttachmentOutput(attachment store.Attachment) internal_types.AttachmentOutput {
+	return internal_types.AttachmentOutput{
+		AttachmentId: attachment.AttachmentID,
+		TaskId:       store.TaskKey(attachment.TaskID),
+		FileName:     attachment.FileName,
+		ContentType:  attachment.ContentType,
+		Size:         attachment.Size,
+		UploadedBy:   store.UserKey(attachment.UploadedBy),
+		UploadedAt:   attachment.UploadedAt,
+		Pending:      attachment.Pending,
+	}
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ghaby-X/tasork/internal/store"
+	internal_types "github.com/Ghaby-X/tasork/internal/types"
+)
+
+// a blob store in memory that presigns, keeping the content type of each blob
+// as s3 does
+type memoryBlobs struct {
+	blobs map[string]store.BlobInfo
+}
+
+func (m *memoryBlobs) Put(key string, body io.Reader, size int64, contentType string) error {
+	m.blobs[key] = store.BlobInfo{Size: size, ContentType: contentType}
+	return nil
+}
+
+func (m *memoryBlobs) Get(key string) (io.ReadCloser, store.BlobInfo, error) {
+	info, err := m.Stat(key)
+	return io.NopCloser(strings.NewReader("")), info, err
+}
+
+func (m *memoryBlobs) Stat(key string) (store.BlobInfo, error) {
+	info, ok := m.blobs[key]
+	if !ok {
+		return store.BlobInfo{}, store.ErrNotFound
+	}
+	return info, nil
+}
+
+func (m *memoryBlobs) Delete(key string) error {
+	delete(m.blobs, key)
+	return nil
+}
+
+func (m *memoryBlobs) PresignPut(key, contentType string, size int64, expires time.Duration) (string, error) {
+	return "https://blobs.example.com/" + key, nil
+}
+
+func (m *memoryBlobs) PresignGet(key string, expires time.Duration) (string, error) {
+	return "https://blobs.example.com/" + key, nil
+}
+
+func TestCompleteAttachment(t *testing.T) {
+	tasks, st := newTestTasks(store.NewMemoryDB())
+	blobs := &memoryBlobs{blobs: map[string]store.BlobInfo{}}
+	attachments := NewAttachmentsService(st.Attachments, blobs, st.Tasks)
+	ann, bob := testClaims("TENANT#a", "ann"), testClaims("TENANT#a", "bob")
+	if err := tasks.CreateTask(&internal_types.CreateTaskDTO{Tasktitle: "fence"}, ann, "task-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		upload      bool
+		size        int64
+		contentType string
+		wantErr     error
+	}{
+		{"as announced", true, 100, "image/png", nil},
+		{"type with parameters", true, 100, "IMAGE/PNG; name=fence.png", nil},
+		{"nothing uploaded", false, 0, "", ErrInvalidAttachment},
+		{"another size", true, 101, "image/png", ErrInvalidAttachment},
+		{"another type", true, 100, "application/pdf", ErrInvalidAttachment},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			presigned, err := attachments.PresignAttachment(internal_types.PresignAttachmentDTO{FileName: "fence.png", ContentType: "image/png", Size: 100}, ann, "task-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			key := strings.TrimPrefix(presigned.UploadURL, "https://blobs.example.com/")
+			if tt.upload {
+				blobs.Put(key, bytes.NewReader(nil), tt.size, tt.contentType)
+			}
+
+			if _, err := attachments.CompleteAttachment(bob, "task-1", presigned.Attachment.AttachmentId); !errors.Is(err, ErrForbidden) {
+				t.Errorf("completed by another user = %v, want ErrForbidden", err)
+			}
+			completed, err := attachments.CompleteAttachment(ann, "task-1", presigned.Attachment.AttachmentId)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && completed.Pending {
+				t.Error("attachment still pending")
+			}
+			if _, kept := blobs.blobs[key]; tt.upload && kept != (tt.wantErr == nil) {
+				t.Errorf("blob kept = %v, want it kept only when it matches", kept)
+			}
+		})
+	}
+}
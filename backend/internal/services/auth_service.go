@@ -24,8 +24,9 @@ import (
 )
 
 type AuthService struct {
-	store       *store.AuthStore
+	store       store.AuthStore
 	Authkeyfunc keyfunc.Keyfunc
+	notify      *Dispatcher
 }
 
 type TokenClaims struct {
@@ -37,7 +38,7 @@ type TokenClaims struct {
 	Role       string `json:"role"`
 }
 
-func NewAuthService(authStore *store.AuthStore) *AuthService {
+func NewAuthService(authStore store.AuthStore, notify *Dispatcher) *AuthService {
 	jwkUrl := utils.ConstructTokenVerifyURL()
 	AuthKeyfunc, err := keyfunc.NewDefault([]string{jwkUrl})
 	if err != nil {
@@ -47,6 +48,7 @@ func NewAuthService(authStore *store.AuthStore) *AuthService {
 	return &AuthService{
 		authStore,
 		AuthKeyfunc,
+		notify,
 	}
 }
 
@@ -215,21 +217,10 @@ func (s *AuthService) RegisterAdminTenant(cognitoClient *cognitoidentityprovider
 		log.Printf("failed to create tenant in database\nError: %v\n", err)
 		return nil, err
 	}
-
-	// notification item
-	notificationUUID := uuid.NewString()
-	now := time.Now().UTC()
-	isoString := now.Format(time.RFC3339)
-	notificationItem := map[string]types.AttributeValue{
-		"PartitionKey": &types.AttributeValueMemberS{Value: userId},
-		"SortKey":      &types.AttributeValueMemberS{Value: "NOTIFICATION#" + notificationUUID},
-		"message":      &types.AttributeValueMemberS{Value: "Welcome to tasork, a place for efficient task management"},
-		"time":         &types.AttributeValueMemberS{Value: isoString},
-	}
-
-	_, err = s.store.CreateItem(tableName, notificationItem)
+	// lets the other users of the tenant @mention them
+	_, err = s.store.CreateItem(tableName, store.UsernameItem(tenantId, store.Member{UserID: userId, Username: preferred_username, Email: email}))
 	if err != nil {
-		log.Printf("failed to write notification: %s", err)
+		log.Printf("failed to index username in database\nError: %v\n", err)
 		return nil, err
 	}
 
@@ -244,11 +235,14 @@ func (s *AuthService) RegisterAdminTenant(cognitoClient *cognitoidentityprovider
 	Log in to get started!`,
 		tenantName, // replace with your actual variable
 	)
-	err = SendWelcomeMail(email, customMessage)
-	if err != nil {
-		log.Printf("failed to send welcome mail\nError: %v\n", err)
-		return nil, err
-	}
+	s.notify.Dispatch(Message{
+		Type:    NotifyWelcome,
+		UserID:  userId,
+		Email:   email,
+		Text:    "Welcome to tasork, a place for efficient task management",
+		Subject: "Welcome to tasork!",
+		Body:    customMessage,
+	})
 
 	return output, err
 }
@@ -318,15 +312,10 @@ func (s *AuthService) CreateUserFromInvite(cognitoClient *utils.CognitoClient, I
 						},
 					},
 				},
-				// Put notification item
+				// index the username for @mentions
 				{
 					PutRequest: &types.PutRequest{
-						Item: map[string]types.AttributeValue{
-							"PartitionKey": &types.AttributeValueMemberS{Value: "USER#" + userID},
-							"SortKey":      &types.AttributeValueMemberS{Value: "NOTIFICATION#" + uuid.NewString()},
-							"message":      &types.AttributeValueMemberS{Value: "Welcome to the team"},
-							"time":         &types.AttributeValueMemberS{Value: time.Now().Format(time.RFC3339)},
-						},
+						Item: store.UsernameItem(InviteTokenDetails.SortKey, store.Member{UserID: userID, Username: RequestBody.Username, Email: InviteTokenDetails.Email}),
 					},
 				},
 				// Delete invite item
@@ -357,11 +346,14 @@ func (s *AuthService) CreateUserFromInvite(cognitoClient *utils.CognitoClient, I
 
 		Log in to explore your workspace and start contributing!`
 
-	err = SendWelcomeMail(InviteTokenDetails.Email, customMessage)
-	if err != nil {
-		log.Printf("failed to send welcome mail %v", err)
-		return err
-	}
+	s.notify.Dispatch(Message{
+		Type:    NotifyWelcome,
+		UserID:  userID,
+		Email:   InviteTokenDetails.Email,
+		Text:    "Welcome to the team",
+		Subject: "Welcome to tasork!",
+		Body:    customMessage,
+	})
 
 	return nil
 }
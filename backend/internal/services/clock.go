@@ -0,0 +1,18 @@
+package services
+
+import "time"
+
+// Clock tells the current time. The scheduler reads the time through it so
+// tests can run it against a clock they move by hand
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// SystemClock reads the wall clock
+var SystemClock Clock = systemClock{}
@@ -0,0 +1,275 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Ghaby-X/tasork/internal/env"
+	"github.com/Ghaby-X/tasork/internal/store"
+	internal_types "github.com/Ghaby-X/tasork/internal/types"
+)
+
+// longest comment body accepted, in characters
+const maxCommentLength = 4000
+
+// an @mention of a username in a comment body
+var mentionPattern = regexp.MustCompile(`@([\w.-]+)`)
+
+type CommentsService struct {
+	store  store.CommentsStore
+	tasks  store.TasksStore
+	users  store.UsersStore
+	notify *Dispatcher
+}
+
+func NewCommentsService(commentstore store.CommentsStore, taskstore store.TasksStore, userstore store.UsersStore, notify *Dispatcher) *CommentsService {
+	return &CommentsService{commentstore, taskstore, userstore, notify}
+}
+
+// reads a task of the caller's tenant, tasks of other tenants are not found
+func (s *CommentsService) task(tenantId, taskId string) (*store.Task, error) {
+	task, err := s.tasks.GetTask(tenantId, taskId)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, fmt.Errorf("task %s: %w", taskId, ErrNotFound)
+	}
+	return task, err
+}
+
+// reads a comment of a task, a deleted comment is only found when allowDeleted
+func (s *CommentsService) comment(taskId, commentId string, allowDeleted bool) (*store.Comment, error) {
+	comment, err := s.store.GetComment(taskId, commentId)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && comment.Deleted && !allowDeleted) {
+		return nil, fmt.Errorf("comment %s: %w", commentId, ErrNotFound)
+	}
+	return comment, err
+}
+
+func validateCommentBody(body string) (string, error) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return "", fmt.Errorf("%w: comment body is required", ErrInvalidComment)
+	}
+	if utf8.RuneCountInString(body) > maxCommentLength {
+		return "", fmt.Errorf("%w: comment is longer than %d characters", ErrInvalidComment, maxCommentLength)
+	}
+	return body, nil
+}
+
+// the users of the tenant mentioned in body, other than the author, in the
+// order they are first mentioned
+func (s *CommentsService) mentionedUsers(tenantId, authorId, body string) ([]store.Member, error) {
+	var names []string
+	for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		names = append(names, match[1])
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	mentioned, err := s.users.GetUsersByName(tenantId, names)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mentioned users: %w", err)
+	}
+	return slices.DeleteFunc(mentioned, func(user store.Member) bool {
+		return store.UserKey(user.UserID) == store.UserKey(authorId)
+	}), nil
+}
+
+// messages for the mentioned users, and their ids to store on the comment.
+// They are only emailed the comment when MENTION_EMAILS is true
+func mentionMessages(mentioned []store.Member, task *store.Task, body string) ([]Message, []string) {
+	subject := ""
+	if env.GetString("MENTION_EMAILS", "false") == "true" {
+		subject = fmt.Sprintf("You were mentioned on '%s'", task.Title)
+	}
+
+	var messages []Message
+	var ids []string
+	for _, user := range mentioned {
+		messages = append(messages, Message{
+			Type:    NotifyMentioned,
+			UserID:  user.UserID,
+			Email:   user.Email,
+			Text:    fmt.Sprintf("You were mentioned in a comment on '%s'", task.Title),
+			Subject: subject,
+			Body:    body,
+		})
+		ids = append(ids, user.UserID)
+	}
+	return messages, ids
+}
+
+func (s *CommentsService) CreateComment(data internal_types.CreateCommentDTO, user internal_types.TokenClaims, taskId string) (*internal_types.CommentOutput, error) {
+	tenantId := user["custom:tenantId"]
+
+	task, err := s.task(tenantId, taskId)
+	if err != nil {
+		return nil, err
+	}
+	if !HasPermission(user["custom:role"], PermCommentOnAnyTask) && !isAssigned(*task, user["sub"]) {
+		return nil, fmt.Errorf("task %s is not assigned to %s: %w", taskId, user["sub"], ErrForbidden)
+	}
+
+	body, err := validateCommentBody(data.Body)
+	if err != nil {
+		return nil, err
+	}
+	if data.ParentId != "" {
+		_, err := s.comment(taskId, data.ParentId, true)
+		if errors.Is(err, ErrNotFound) {
+			return nil, fmt.Errorf("%w: parent comment %s does not exist", ErrInvalidComment, data.ParentId)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	mentioned, err := s.mentionedUsers(tenantId, user["sub"], body)
+	if err != nil {
+		return nil, err
+	}
+	messages, mentions := mentionMessages(mentioned, task, body)
+
+	now := time.Now().UTC()
+	comment := store.Comment{
+		TaskID:    taskId,
+		CommentID: store.NewCommentID(now),
+		ParentID:  data.ParentId,
+		AuthorID:  user["sub"],
+		Body:      body,
+		Mentions:  mentions,
+		CreatedAt: now.Format(time.RFC3339),
+	}
+
+	description := "commented"
+	if data.ParentId != "" {
+		description = "replied to a comment"
+	}
+	history := newHistory(taskId, user, store.HistoryCommented, task.Status, description,
+		[]store.FieldChange{{Field: "comment", After: comment.CommentID}})
+
+	if err := s.store.PutComment(comment, history); err != nil {
+		return nil, err
+	}
+	s.notify.Dispatch(messages...)
+
+	output := toCommentOutput(comment)
+	return &output, nil
+}
+
+// edits the body of a comment, only its author may. Users mentioned for the
+// first time are notified
+func (s *CommentsService) UpdateComment(data internal_types.UpdateCommentDTO, user internal_types.TokenClaims, taskId, commentId string) (*internal_types.CommentOutput, error) {
+	tenantId := user["custom:tenantId"]
+
+	task, err := s.task(tenantId, taskId)
+	if err != nil {
+		return nil, err
+	}
+	comment, err := s.comment(taskId, commentId, false)
+	if err != nil {
+		return nil, err
+	}
+	if store.UserKey(comment.AuthorID) != store.UserKey(user["sub"]) {
+		return nil, fmt.Errorf("comment %s is not by %s: %w", commentId, user["sub"], ErrForbidden)
+	}
+
+	body, err := validateCommentBody(data.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	mentioned, err := s.mentionedUsers(tenantId, user["sub"], body)
+	if err != nil {
+		return nil, err
+	}
+	var newlyMentioned []store.Member
+	for _, mention := range mentioned {
+		if !slices.Contains(comment.Mentions, mention.UserID) {
+			newlyMentioned = append(newlyMentioned, mention)
+		}
+	}
+	messages, _ := mentionMessages(newlyMentioned, task, body)
+
+	comment.Body = body
+	comment.Mentions = []string{}
+	for _, mention := range mentioned {
+		comment.Mentions = append(comment.Mentions, mention.UserID)
+	}
+	comment.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+
+	err = s.store.UpdateComment(*comment)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, fmt.Errorf("comment %s: %w", commentId, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.notify.Dispatch(messages...)
+
+	output := toCommentOutput(*comment)
+	return &output, nil
+}
+
+// deletes a comment, its author or a moderator may. Replies to it stay
+func (s *CommentsService) DeleteComment(user internal_types.TokenClaims, taskId, commentId string) error {
+	if _, err := s.task(user["custom:tenantId"], taskId); err != nil {
+		return err
+	}
+	comment, err := s.comment(taskId, commentId, false)
+	if err != nil {
+		return err
+	}
+	if store.UserKey(comment.AuthorID) != store.UserKey(user["sub"]) && !HasPermission(user["custom:role"], PermModerateComments) {
+		return fmt.Errorf("comment %s is not by %s: %w", commentId, user["sub"], ErrForbidden)
+	}
+
+	err = s.store.DeleteComment(taskId, commentId)
+	if errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("comment %s: %w", commentId, ErrNotFound)
+	}
+	return err
+}
+
+// lists one page of a task's comments, oldest first. Replies carry the id of
+// the comment they answer for clients to build threads
+func (s *CommentsService) ListComments(tenantId, taskId string, page internal_types.PageQuery) (*internal_types.Page[internal_types.CommentOutput], error) {
+	if _, err := s.task(tenantId, taskId); err != nil {
+		return nil, err
+	}
+
+	comments, next, err := s.store.ListComments(taskId, store.PageRequest{Limit: page.Limit, Cursor: page.Cursor})
+	if err != nil {
+		return nil, listError(err)
+	}
+
+	output := make([]internal_types.CommentOutput, 0, len(comments))
+	for _, comment := range comments {
+		output = append(output, toCommentOutput(comment))
+	}
+	return &internal_types.Page[internal_types.CommentOutput]{Items: output, NextCursor: next}, nil
+}
+
+func toCommentOutput(comment store.Comment) internal_types.CommentOutput {
+	mentions := make([]string, 0, len(comment.Mentions))
+	for _, mention := range comment.Mentions {
+		mentions = append(mentions, store.UserKey(mention))
+	}
+
+	return internal_types.CommentOutput{
+		CommentId: comment.CommentID,
+		ParentId:  comment.ParentID,
+		TaskId:    store.TaskKey(comment.TaskID),
+		AuthorId:  store.UserKey(comment.AuthorID),
+		Body:      comment.Body,
+		Mentions:  mentions,
+		CreatedAt: comment.CreatedAt,
+		UpdatedAt: comment.UpdatedAt,
+		Deleted:   comment.Deleted,
+	}
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Ghaby-X/tasork/internal/store"
+	internal_types "github.com/Ghaby-X/tasork/internal/types"
+)
+
+func memberClaims(tenantId, userId string) internal_types.TokenClaims {
+	claims := testClaims(tenantId, userId)
+	claims["custom:role"] = RoleMember
+	return claims
+}
+
+func TestCommentMentions(t *testing.T) {
+	tasks, st := newTestTasks(store.NewMemoryDB())
+	comments := NewCommentsService(st.Comments, st.Tasks, st.Users, tasks.notify)
+	ann := testClaims("TENANT#a", "ann")
+	for _, userId := range []string{"ann", "bob", "cat"} {
+		addTestUser(t, st, "TENANT#a", userId)
+	}
+	// dan is of another tenant, so mentioning him notifies no one
+	addTestUser(t, st, "TENANT#b", "dan")
+	if err := tasks.CreateTask(&internal_types.CreateTaskDTO{Tasktitle: "fence"}, ann, "task-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	comment, err := comments.CreateComment(internal_types.CreateCommentDTO{Body: "@BOB and @ann, see @dan and @nobody. Thanks @bob"}, ann, "task-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(comment.Mentions, []string{"USER#bob"}) {
+		t.Errorf("mentions = %v, want bob only", comment.Mentions)
+	}
+	if got := notificationsOf(t, st, "bob"); len(got) != 1 {
+		t.Errorf("bob was notified %v, want once", got)
+	}
+	for _, userId := range []string{"ann", "dan"} {
+		if got := notificationsOf(t, st, userId); len(got) != 0 {
+			t.Errorf("%s was notified %v, want nothing", userId, got)
+		}
+	}
+
+	// an edit only notifies users it mentions for the first time
+	_, err = comments.UpdateComment(internal_types.UpdateCommentDTO{Body: "@cat @bob"}, ann, "task-1", comment.CommentId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := notificationsOf(t, st, "bob"); len(got) != 1 {
+		t.Errorf("bob was notified %v after the edit, want once", got)
+	}
+	if got := notificationsOf(t, st, "cat"); len(got) != 1 {
+		t.Errorf("cat was notified %v after the edit, want once", got)
+	}
+}
+
+func TestCommentPermissions(t *testing.T) {
+	tasks, st := newTestTasks(store.NewMemoryDB())
+	comments := NewCommentsService(st.Comments, st.Tasks, st.Users, tasks.notify)
+	admin, bob, cat := testClaims("TENANT#a", "ann"), memberClaims("TENANT#a", "bob"), memberClaims("TENANT#a", "cat")
+	for _, userId := range []string{"ann", "bob", "cat"} {
+		addTestUser(t, st, "TENANT#a", userId)
+	}
+	if err := tasks.CreateTask(&internal_types.CreateTaskDTO{Tasktitle: "fence", Assignees: []internal_types.Assignee{{UserId: "bob"}, {UserId: "cat"}}}, admin, "task-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tasks.CreateTask(&internal_types.CreateTaskDTO{Tasktitle: "gate"}, admin, "task-2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := comments.CreateComment(internal_types.CreateCommentDTO{Body: "mine"}, bob, "task-2"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("member commenting on a task not assigned to them = %v, want ErrForbidden", err)
+	}
+	byBob, err := comments.CreateComment(internal_types.CreateCommentDTO{Body: "done"}, bob, "task-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	byCat, err := comments.CreateComment(internal_types.CreateCommentDTO{Body: "agreed", ParentId: byBob.CommentId}, cat, "task-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edit := internal_types.UpdateCommentDTO{Body: "edited"}
+	for name, claims := range map[string]internal_types.TokenClaims{"another member": cat, "an admin": admin} {
+		if _, err := comments.UpdateComment(edit, claims, "task-1", byBob.CommentId); !errors.Is(err, ErrForbidden) {
+			t.Errorf("%s editing bob's comment = %v, want ErrForbidden", name, err)
+		}
+	}
+	if _, err := comments.UpdateComment(edit, bob, "task-1", byBob.CommentId); err != nil {
+		t.Errorf("bob editing his comment: %v", err)
+	}
+
+	if err := comments.DeleteComment(cat, "task-1", byBob.CommentId); !errors.Is(err, ErrForbidden) {
+		t.Errorf("another member deleting bob's comment = %v, want ErrForbidden", err)
+	}
+	if err := comments.DeleteComment(cat, "task-1", byCat.CommentId); err != nil {
+		t.Errorf("cat deleting her comment: %v", err)
+	}
+	if err := comments.DeleteComment(admin, "task-1", byBob.CommentId); err != nil {
+		t.Errorf("an admin deleting bob's comment: %v", err)
+	}
+	if _, err := comments.UpdateComment(edit, bob, "task-1", byBob.CommentId); !errors.Is(err, ErrNotFound) {
+		t.Errorf("editing a deleted comment = %v, want ErrNotFound", err)
+	}
+
+	// both stay in the listing without their bodies
+	page, err := comments.ListComments("TENANT#a", "task-1", internal_types.PageQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 2 || !page.Items[0].Deleted || page.Items[0].Body != "" || page.Items[1].ParentId != byBob.CommentId {
+		t.Errorf("comments after deleting = %+v, want both deleted, the reply still threaded", page.Items)
+	}
+}
+
+// the length limit counts characters, not the bytes they take
+func TestCommentLength(t *testing.T) {
+	tests := []struct {
+		body    string
+		wantErr bool
+	}{
+		{strings.Repeat("é", maxCommentLength), false},
+		{strings.Repeat("é", maxCommentLength+1), true},
+		{strings.Repeat("a", maxCommentLength+1), true},
+		{"  ", true},
+	}
+	for _, tt := range tests {
+		_, err := validateCommentBody(tt.body)
+		if got := errors.Is(err, ErrInvalidComment); got != tt.wantErr {
+			t.Errorf("validateCommentBody of %d bytes = %v, want an error %v", len(tt.body), err, tt.wantErr)
+		}
+	}
+}
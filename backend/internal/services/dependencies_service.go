@@ -0,0 +1,272 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Ghaby-X/tasork/internal/store"
+	internal_types "github.com/Ghaby-X/tasork/internal/types"
+)
+
+// how many times adding a dependency is tried while other dependencies of
+// the tenant are being added
+const dependencyAttempts = 3
+
+// whether any of the tasks a task depends on is not in a final status.
+// Blockers that are gone no longer block
+func isBlocked(task store.Task, blockers map[string]store.Task, terminal map[string]bool) bool {
+	for _, blockerId := range task.BlockedBy {
+		if blocker, ok := blockers[blockerId]; ok && !terminal[blocker.Status] {
+			return true
+		}
+	}
+	return false
+}
+
+// reads every task the given tasks depend on, by id, in one batch
+func (s *TasksService) blockersOf(tenantId string, tasks []store.Task) (map[string]store.Task, error) {
+	var ids []string
+	for _, task := range tasks {
+		ids = append(ids, task.BlockedBy...)
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	blockers, err := s.store.GetTasks(tenantId, ids)
+	if err != nil {
+		return nil, err
+	}
+	byId := map[string]store.Task{}
+	for _, blocker := range blockers {
+		byId[blocker.TaskID] = blocker
+	}
+	return byId, nil
+}
+
+// converts tasks into the api response shape, flagging the blocked and
+// overdue ones
+func (s *TasksService) taskOutputs(tenantId string, tasks []store.Task) ([]internal_types.GetTasksOutput, error) {
+	results := toTaskOutputs(tasks)
+
+	blockers, err := s.blockersOf(tenantId, tasks)
+	if err != nil {
+		return nil, err
+	}
+	hasDeadline := slices.ContainsFunc(tasks, func(task store.Task) bool { return task.Deadline != "" })
+	if len(blockers) == 0 && !hasDeadline {
+		return results, nil
+	}
+	terminal, err := s.workflows.terminalStatuses(tenantId)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for i, task := range tasks {
+		results[i].Blocked = isBlocked(task, blockers, terminal)
+		results[i].Overdue = isOverdue(task, terminal, now)
+	}
+	return results, nil
+}
+
+// a blocked task cannot leave the initial status of the workflow, whether to
+// start it or to finish it straight away. A status the workflow does not know
+// counts as the initial one, as it does for transitions
+func (s *TasksService) checkBlockers(task store.Task, status string) error {
+	if len(task.BlockedBy) == 0 || status == task.Status {
+		return nil
+	}
+
+	workflow, err := s.workflows.workflow(task.TenantID)
+	if err != nil {
+		return err
+	}
+	if _, ok := findState(workflow, task.Status); ok && task.Status != workflow.InitialState {
+		return nil
+	}
+
+	blockers, err := s.blockersOf(task.TenantID, []store.Task{task})
+	if err != nil {
+		return err
+	}
+	terminal, err := s.workflows.terminalStatuses(task.TenantID)
+	if err != nil {
+		return err
+	}
+	if isBlocked(task, blockers, terminal) {
+		return fmt.Errorf("%w: the task is blocked by tasks that are not complete", ErrIllegalTransition)
+	}
+	return nil
+}
+
+// whether blockerId already depends on taskId, directly or through other
+// tasks, in which case taskId depending on it would close a cycle
+func (s *TasksService) dependsOn(tenantId, blockerId, taskId string) (bool, error) {
+	visited := map[string]bool{blockerId: true}
+	frontier := []string{blockerId}
+	for len(frontier) > 0 {
+		tasks, err := s.store.GetTasks(tenantId, frontier)
+		if err != nil {
+			return false, err
+		}
+
+		frontier = nil
+		for _, task := range tasks {
+			for _, id := range task.BlockedBy {
+				if id == taskId {
+					return true, nil
+				}
+				if !visited[id] {
+					visited[id] = true
+					frontier = append(frontier, id)
+				}
+			}
+		}
+	}
+	return false, nil
+}
+
+// marks a task as blocked by another task of the tenant, refusing
+// dependencies that would close a cycle
+func (s *TasksService) AddDependency(data internal_types.DependencyDTO, user internal_types.TokenClaims, taskUUID string, ifMatch *int64) error {
+	blockerId := strings.TrimPrefix(data.TaskId, "TASK#")
+	if blockerId == "" {
+		return fmt.Errorf("%w: the blocking task is required", ErrInvalidDependency)
+	}
+	if blockerId == strings.TrimPrefix(taskUUID, "TASK#") {
+		return fmt.Errorf("%w: a task cannot block itself", ErrInvalidDependency)
+	}
+
+	task, err := s.taskForWrite(user["custom:tenantId"], taskUUID, ifMatch)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(task.BlockedBy, blockerId) {
+		return fmt.Errorf("task is already blocked by %s: %w", blockerId, ErrConflict)
+	}
+	blocker, err := s.store.GetTask(task.TenantID, blockerId)
+	if errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("blocking task %s: %w", blockerId, ErrNotFound)
+	}
+	if err != nil {
+		return err
+	}
+
+	dependency := store.Dependency{
+		TenantID:  task.TenantID,
+		TaskID:    task.TaskID,
+		BlockerID: blockerId,
+		CreatedBy: user["sub"],
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	history := *newHistory(taskUUID, user, store.HistoryEdited, task.Status, fmt.Sprintf("blocked by '%s'", blocker.Title),
+		[]store.FieldChange{{Field: "blockedBy", After: store.TaskKey(blockerId)}})
+
+	// the check is made again when another dependency of the tenant was added
+	// while it ran, as that one may close a cycle with this one
+	for range dependencyAttempts {
+		var version int64
+		version, err = s.store.DependenciesVersion(task.TenantID)
+		if err != nil {
+			return err
+		}
+		var cycle bool
+		cycle, err = s.dependsOn(task.TenantID, blockerId, task.TaskID)
+		if err != nil {
+			return err
+		}
+		if cycle {
+			return fmt.Errorf("%w: %s already depends on this task", ErrInvalidDependency, blockerId)
+		}
+
+		err = s.store.AddDependency(*task, *blocker, dependency, version, history)
+		if !errors.Is(err, store.ErrDependenciesChanged) {
+			break
+		}
+	}
+	if errors.Is(err, store.ErrAlreadyExists) {
+		return fmt.Errorf("task is already blocked by %s: %w", blockerId, ErrConflict)
+	}
+	if errors.Is(err, store.ErrDependenciesChanged) {
+		return fmt.Errorf("the dependencies of the tenant kept changing: %w", ErrConflict)
+	}
+	return writeError(err, taskUUID, ifMatch)
+}
+
+// drops a dependency of a task on another one
+func (s *TasksService) RemoveDependency(user internal_types.TokenClaims, taskUUID, blockerId string, ifMatch *int64) error {
+	blockerId = strings.TrimPrefix(blockerId, "TASK#")
+
+	task, err := s.taskForWrite(user["custom:tenantId"], taskUUID, ifMatch)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(task.BlockedBy, blockerId) {
+		return fmt.Errorf("dependency on %s: %w", blockerId, ErrNotFound)
+	}
+
+	err = s.store.RemoveDependency(*task, blockerId,
+		*newHistory(taskUUID, user, store.HistoryEdited, task.Status, "removed a dependency",
+			[]store.FieldChange{{Field: "blockedBy", Before: store.TaskKey(blockerId)}}),
+	)
+	if errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("dependency on %s: %w", blockerId, ErrNotFound)
+	}
+	return writeError(err, taskUUID, ifMatch)
+}
+
+// lists the tasks a task waits for and the tasks waiting for it
+func (s *TasksService) GetDependencies(tenantId, taskId string) (*internal_types.DependenciesOutput, error) {
+	task, err := s.store.GetTask(tenantId, taskId)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, fmt.Errorf("task %s: %w", taskId, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	terminal, err := s.workflows.terminalStatuses(tenantId)
+	if err != nil {
+		return nil, err
+	}
+	blockers, err := s.store.GetTasks(tenantId, task.BlockedBy)
+	if err != nil {
+		return nil, err
+	}
+	dependentIds, err := s.store.ListDependents(task.TaskID)
+	if err != nil {
+		return nil, err
+	}
+	dependents, err := s.store.GetTasks(tenantId, dependentIds)
+	if err != nil {
+		return nil, err
+	}
+
+	output := &internal_types.DependenciesOutput{
+		TaskId:    store.TaskKey(task.TaskID),
+		BlockedBy: toTaskSummaries(blockers, terminal),
+		Blocks:    toTaskSummaries(dependents, terminal),
+	}
+	for _, blocker := range output.BlockedBy {
+		if !blocker.Complete {
+			output.Blocked = true
+		}
+	}
+	return output, nil
+}
+
+func toTaskSummaries(tasks []store.Task, terminal map[string]bool) []internal_types.TaskSummary {
+	summaries := make([]internal_types.TaskSummary, 0, len(tasks))
+	for _, task := range tasks {
+		summaries = append(summaries, internal_types.TaskSummary{
+			TaskId:    store.TaskKey(task.TaskID),
+			Tasktitle: task.Title,
+			Status:    task.Status,
+			Complete:  terminal[task.Status],
+		})
+	}
+	return summaries
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Ghaby-X/tasork/internal/store"
+	internal_types "github.com/Ghaby-X/tasork/internal/types"
+)
+
+func TestBlockedTaskCannotLeaveInitialStatus(t *testing.T) {
+	tasks, st := newTestTasks(store.NewMemoryDB())
+	claims := testClaims("TENANT#a", "ann")
+	addTestUser(t, st, "TENANT#a", "ann")
+	for _, id := range []string{"blocker", "blocked"} {
+		if err := tasks.CreateTask(&internal_types.CreateTaskDTO{Tasktitle: id}, claims, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tasks.AddDependency(internal_types.DependencyDTO{TaskId: "blocker"}, claims, "blocked", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	move := func(id, status string) error {
+		_, err := tasks.UpdateTask(internal_types.PatchTaskDTO{Status: &status}, claims, id, nil)
+		return err
+	}
+	for _, status := range []string{"in-progress", "done", "cancelled"} {
+		if err := move("blocked", status); !errors.Is(err, ErrIllegalTransition) {
+			t.Errorf("moving a blocked task to %s = %v, want ErrIllegalTransition", status, err)
+		}
+	}
+
+	if err := move("blocker", "done"); err != nil {
+		t.Fatal(err)
+	}
+	if err := move("blocked", "done"); err != nil {
+		t.Errorf("moving an unblocked task to done = %v, want nil", err)
+	}
+}
+
+// a tasks store that runs race right before writing the first dependency it
+// is asked to add, as a concurrent request could
+type racingDependencies struct {
+	store.TasksStore
+	race func()
+}
+
+func (r *racingDependencies) AddDependency(task store.Task, blocker store.Task, dependency store.Dependency, version int64, history store.HistoryEntry) error {
+	if race := r.race; race != nil {
+		r.race = nil
+		race()
+	}
+	return r.TasksStore.AddDependency(task, blocker, dependency, version, history)
+}
+
+func TestDependencyCycles(t *testing.T) {
+	tasks, st := newTestTasks(store.NewMemoryDB())
+	ann := testClaims("TENANT#a", "ann")
+	addTestUser(t, st, "TENANT#a", "ann")
+	for _, taskId := range []string{"a", "b", "c", "d", "e"} {
+		if err := tasks.CreateTask(&internal_types.CreateTaskDTO{Tasktitle: taskId}, ann, taskId); err != nil {
+			t.Fatal(err)
+		}
+	}
+	block := func(taskId, blockerId string) error {
+		return tasks.AddDependency(internal_types.DependencyDTO{TaskId: blockerId}, ann, taskId, nil)
+	}
+
+	if err := block("a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := block("b", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := block("c", "a"); !errors.Is(err, ErrInvalidDependency) {
+		t.Errorf("c blocked by a = %v, want ErrInvalidDependency", err)
+	}
+
+	// c blocked by d passes the check with d blocked by e, but e is made to
+	// depend on c before it is written. Neither c nor d changes, yet the
+	// write fails and the check made again finds the cycle
+	if err := block("d", "e"); err != nil {
+		t.Fatal(err)
+	}
+	racing := &racingDependencies{TasksStore: st.Tasks}
+	racing.race = func() {
+		if err := block("e", "c"); err != nil {
+			t.Errorf("e blocked by c: %v", err)
+		}
+	}
+	tasks.store = racing
+	if err := block("c", "d"); !errors.Is(err, ErrInvalidDependency) {
+		t.Errorf("c blocked by d = %v, want ErrInvalidDependency", err)
+	}
+	if racing.race != nil {
+		t.Error("the concurrent dependency was not added")
+	}
+}
@@ -0,0 +1,323 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Ghaby-X/tasork/internal/env"
+	"github.com/Ghaby-X/tasork/internal/store"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+)
+
+// most unread notifications listed in a digest, the rest are only counted
+const maxDigestNotifications = 10
+
+// DigestWorker emails users who asked for a digest one summary of their
+// unread notifications, the tasks due before the next digest and their
+// overdue tasks, daily or weekly at an hour of their own time zone
+type DigestWorker struct {
+	tasks    *TasksService
+	users    *UsersService
+	mailer   Mailer
+	clock    Clock
+	interval time.Duration
+	// the hour of the day digests go out at, in the time zone of each user
+	hour int
+	// the day weekly digests go out on
+	weekday time.Weekday
+}
+
+// runs every DIGEST_INTERVAL (15m by default), sending digests at
+// DIGEST_HOUR (8) and weekly ones on DIGEST_WEEKDAY (monday)
+func NewDigestWorker(tasks *TasksService, users *UsersService, mailer Mailer, clock Clock) *DigestWorker {
+	hour := env.GetInt("DIGEST_HOUR", 8)
+	if hour < 0 || hour > 23 {
+		log.Printf("ignoring digest hour %d", hour)
+		hour = 8
+	}
+
+	weekday := time.Monday
+	name := env.GetString("DIGEST_WEEKDAY", "monday")
+	found := false
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.EqualFold(day.String(), name) {
+			weekday, found = day, true
+		}
+	}
+	if !found {
+		log.Printf("ignoring digest weekday %q", name)
+	}
+
+	return &DigestWorker{
+		tasks:    tasks,
+		users:    users,
+		mailer:   mailer,
+		clock:    clock,
+		interval: env.GetDuration("DIGEST_INTERVAL", 15*time.Minute),
+		hour:     hour,
+		weekday:  weekday,
+	}
+}
+
+// the latest time a digest of the given frequency was due by now
+func (w *DigestWorker) lastDue(frequency string, location *time.Location, now time.Time) time.Time {
+	local := now.In(location)
+	due := time.Date(local.Year(), local.Month(), local.Day(), w.hour, 0, 0, 0, location)
+	if due.After(local) {
+		due = due.AddDate(0, 0, -1)
+	}
+	if frequency == "weekly" {
+		for due.Weekday() != w.weekday {
+			due = due.AddDate(0, 0, -1)
+		}
+	}
+	return due
+}
+
+// how far ahead a digest of the given frequency looks for deadlines, up to
+// the next one
+func digestPeriod(frequency string) time.Duration {
+	if frequency == "weekly" {
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// RunOnce sends the digests that are due and have not been sent yet,
+// returning how many it emailed. Running it again sends nothing new until the
+// next digests are due
+func (w *DigestWorker) RunOnce() (int, error) {
+	now := w.clock.Now().UTC()
+	entries, err := w.users.notifications.ListDigests()
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, entry := range entries {
+		location, err := time.LoadLocation(entry.Timezone)
+		if err != nil {
+			location = time.UTC
+		}
+		lastSent, err := time.Parse(time.RFC3339, entry.LastSentAt)
+		if err != nil {
+			log.Printf("ignoring digest of %s sent at %q", entry.UserID, entry.LastSentAt)
+			continue
+		}
+		if !lastSent.Before(w.lastDue(entry.Digest, location, now)) {
+			continue
+		}
+
+		mailed, err := w.digest(entry, location, now)
+		if errors.Is(err, store.ErrVersionConflict) {
+			continue
+		}
+		if err != nil {
+			log.Printf("failed to send digest of %s: %v", entry.UserID, err)
+			continue
+		}
+		if mailed {
+			sent++
+		}
+	}
+	return sent, nil
+}
+
+type digestTask struct {
+	Title    string
+	Status   string
+	Deadline string
+}
+
+type digestNotification struct {
+	Message string
+	Time    string
+}
+
+type digestContent struct {
+	Name          string
+	Frequency     string
+	Unread        []digestNotification
+	UnreadCount   int
+	MoreUnread    int
+	DueSoon       []digestTask
+	Overdue       []digestTask
+	URL           string
+	LocalDeadline func(string) string
+}
+
+func (c digestContent) empty() bool {
+	return c.UnreadCount == 0 && len(c.DueSoon) == 0 && len(c.Overdue) == 0
+}
+
+// gathers and emails the digest of one user. The digest is claimed before it
+// goes out, so a concurrent run does not send it too, and given back when
+// sending fails, so the next run sends it. A digest with nothing in it is
+// recorded without being sent. Returns whether it was emailed
+func (w *DigestWorker) digest(entry store.DigestEntry, location *time.Location, now time.Time) (bool, error) {
+	row, err := w.users.store.GetUser(entry.TenantID, entry.UserID)
+	if err != nil {
+		return false, fmt.Errorf("failed to read user: %w", err)
+	}
+	var user struct {
+		UserName string `dynamodbav:"userName"`
+		Email    string `dynamodbav:"email"`
+	}
+	if err := attributevalue.UnmarshalMap(row, &user); err != nil {
+		return false, fmt.Errorf("failed to unmarshal user: %w", err)
+	}
+
+	content, err := w.content(entry, now)
+	if err != nil {
+		return false, err
+	}
+	content.Name = user.UserName
+	content.LocalDeadline = func(deadline string) string {
+		at, err := time.Parse(time.RFC3339, deadline)
+		if err != nil {
+			return deadline
+		}
+		return at.In(location).Format("Mon 2 Jan 15:04 MST")
+	}
+
+	var body bytes.Buffer
+	if err := digestTemplate.Execute(&body, content); err != nil {
+		return false, fmt.Errorf("failed to render digest: %w", err)
+	}
+
+	sentAt := now.Format(time.RFC3339)
+	if err := w.users.notifications.ClaimDigest(entry, sentAt); err != nil {
+		return false, err
+	}
+	if content.empty() || user.Email == "" {
+		return false, nil
+	}
+
+	subject := "Your daily tasork digest"
+	if entry.Digest == "weekly" {
+		subject = "Your weekly tasork digest"
+	}
+	if err := w.mailer.Send(Mail{To: user.Email, Subject: subject, HTML: body.String()}); err != nil {
+		claimed := entry
+		claimed.LastSentAt = sentAt
+		if err := w.users.notifications.ClaimDigest(claimed, entry.LastSentAt); err != nil {
+			log.Printf("failed to give back the digest of %s: %v", entry.UserID, err)
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// the unread notifications of a user and their tasks in the digest's
+// tenant that are overdue or due before the next digest
+func (w *DigestWorker) content(entry store.DigestEntry, now time.Time) (digestContent, error) {
+	content := digestContent{Frequency: entry.Digest, URL: env.GetString("WEB_URL", "")}
+
+	notifications, _, err := w.users.notifications.ListNotifications(entry.UserID, store.NotificationQuery{UnreadOnly: true}, now.Unix(), store.PageRequest{Limit: maxDigestNotifications})
+	if err != nil {
+		return content, fmt.Errorf("failed to list notifications: %w", err)
+	}
+	count, err := w.users.notifications.CountUnread(entry.UserID)
+	if err != nil {
+		return content, fmt.Errorf("failed to count notifications: %w", err)
+	}
+	for _, notification := range notifications {
+		content.Unread = append(content.Unread, digestNotification{Message: notification.Message, Time: notification.Time})
+	}
+	content.UnreadCount = count
+	content.MoreUnread = max(count-len(notifications), 0)
+
+	terminal, err := w.tasks.workflows.terminalStatuses(entry.TenantID)
+	if err != nil {
+		return content, err
+	}
+	until := now.Add(digestPeriod(entry.Digest))
+	page := store.PageRequest{Limit: 100}
+	for {
+		tasks, next, err := w.tasks.store.ListTasksByAssignee(entry.UserID, page)
+		if err != nil {
+			return content, fmt.Errorf("failed to list tasks: %w", err)
+		}
+		for _, task := range tasks {
+			deadline, ok := deadlineTime(task.Deadline)
+			if task.TenantID != entry.TenantID || !ok || terminal[task.Status] {
+				continue
+			}
+			item := digestTask{Title: task.Title, Status: task.Status, Deadline: deadline.UTC().Format(time.RFC3339)}
+			switch {
+			case now.After(deadline):
+				content.Overdue = append(content.Overdue, item)
+			case !deadline.After(until):
+				content.DueSoon = append(content.DueSoon, item)
+			}
+		}
+		if next == "" {
+			break
+		}
+		page.Cursor = next
+	}
+
+	// soonest first, which for overdue tasks is the longest overdue
+	for _, tasks := range [][]digestTask{content.DueSoon, content.Overdue} {
+		sort.Slice(tasks, func(i, j int) bool { return tasks[i].Deadline < tasks[j].Deadline })
+	}
+	return content, nil
+}
+
+var digestTemplate = template.Must(template.New("digest").Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif; color: #222;">
+<p>Hi {{.Name}},</p>
+<p>Here is your {{.Frequency}} summary from tasork.</p>
+{{if .Overdue}}
+<h3>Overdue</h3>
+<ul>
+{{range .Overdue}}<li><strong>{{.Title}}</strong> ({{.Status}}), was due {{call $.LocalDeadline .Deadline}}</li>
+{{end}}</ul>
+{{end}}
+{{if .DueSoon}}
+<h3>Due soon</h3>
+<ul>
+{{range .DueSoon}}<li><strong>{{.Title}}</strong> ({{.Status}}), due {{call $.LocalDeadline .Deadline}}</li>
+{{end}}</ul>
+{{end}}
+{{if .Unread}}
+<h3>Unread notifications ({{.UnreadCount}})</h3>
+<ul>
+{{range .Unread}}<li>{{.Message}}</li>
+{{end}}</ul>
+{{if .MoreUnread}}<p>and {{.MoreUnread}} more.</p>{{end}}
+{{end}}
+{{if .URL}}<p><a href="{{.URL}}">Open tasork</a></p>{{end}}
+<p style="color: #888; font-size: 12px;">You get this digest because of your notification preferences, which you can change in tasork.</p>
+</body>
+</html>
+`))
+
+// Run calls RunOnce straight away and then every interval until ctx is done
+func (w *DigestWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		sent, err := w.RunOnce()
+		if err != nil {
+			log.Printf("digest worker failed: %v", err)
+		} else if sent > 0 {
+			log.Printf("digest worker sent %d digests", sent)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
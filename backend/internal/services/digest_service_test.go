@@ -0,0 +1,66 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Ghaby-X/tasork/internal/store"
+	internal_types "github.com/Ghaby-X/tasork/internal/types"
+)
+
+func TestDigestIsSentAgainAfterFailedSend(t *testing.T) {
+	tasks, st := newTestTasks(store.NewMemoryDB())
+	clock := &fakeClock{now: time.Date(2030, time.January, 2, 8, 30, 0, 0, time.UTC)}
+	hub := NewNotificationHub(NewLocalBroker())
+	users := NewUserService(st.Users, st.Notifications, hub, tasks.notify)
+	mailer := &flakyMailer{}
+	worker := &DigestWorker{tasks: tasks, users: users, mailer: mailer, clock: clock, hour: 8, weekday: time.Monday}
+
+	addTestUser(t, st, "TENANT#a", "ann")
+	err := st.Notifications.PutPreferences(store.Preferences{
+		UserID: "ann", TenantID: "TENANT#a", InApp: true, Email: true, Digest: "daily",
+		Timezone: "UTC", UpdatedAt: "2030-01-01T00:00:00Z",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tasks.CreateTask(&internal_types.CreateTaskDTO{
+		Tasktitle: "report",
+		Deadline:  "2030-01-02T17:00:00Z",
+		Assignees: []internal_types.Assignee{{UserId: "ann"}},
+	}, testClaims("TENANT#a", "ann"), "task-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		down bool
+		sent int
+	}{
+		{true, 0},
+		// the failed digest was given back, so this run sends it
+		{false, 1},
+		{false, 0},
+	}
+	for i, step := range steps {
+		mailer.down = step.down
+		sent, err := worker.RunOnce()
+		if err != nil {
+			t.Fatalf("run %d: %v", i, err)
+		}
+		if sent != step.sent {
+			t.Errorf("run %d sent %d digests, want %d", i, sent, step.sent)
+		}
+	}
+	if sent := mailer.Sent(); len(sent) != 1 || sent[0].To != "ann@example.com" {
+		t.Errorf("mailed %v, want one digest to ann", sent)
+	}
+
+	entries, err := st.Notifications.ListDigests()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].LastSentAt != "2030-01-02T08:30:00Z" || entries[0].Timezone != "UTC" {
+		t.Errorf("digest entries = %+v, want ann's, sent at 08:30", entries)
+	}
+}
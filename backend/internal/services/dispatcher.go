@@ -0,0 +1,184 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/Ghaby-X/tasork/internal/store"
+	"github.com/Ghaby-X/tasork/internal/utils"
+)
+
+// NotificationType is what a notification is about, the unit users mute by
+type NotificationType string
+
+const (
+	NotifyAssigned   NotificationType = "task.assigned"
+	NotifyUnassigned NotificationType = "task.unassigned"
+	NotifyMentioned  NotificationType = "comment.mentioned"
+	NotifyReminder   NotificationType = "task.reminder"
+	NotifyWelcome    NotificationType = "account.welcome"
+	NotifyInvite     NotificationType = "account.invite"
+)
+
+var notificationTypes = []NotificationType{NotifyAssigned, NotifyUnassigned, NotifyMentioned, NotifyReminder, NotifyWelcome, NotifyInvite}
+
+// Message is something to tell one person. UserID is empty for someone who
+// is not a user yet, such as an invitee, who can only be emailed. Subject is
+// empty for a message that is only shown in the app, and Body is the email,
+// Text when empty
+type Message struct {
+	Type    NotificationType
+	UserID  string
+	Email   string
+	Text    string
+	Subject string
+	Body    string
+}
+
+// the email of a message, its text when it has no body of its own
+func (m Message) body() string {
+	if m.Body == "" {
+		return m.Text
+	}
+	return m.Body
+}
+
+// the preferences of a user who never saved any
+func defaultPreferences(userId string) store.Preferences {
+	return store.Preferences{UserID: userId, InApp: true, Email: true, Digest: "off", Timezone: "UTC"}
+}
+
+// whether at falls within the quiet hours of a user, which may span midnight
+func inQuietHours(preferences store.Preferences, at time.Time) bool {
+	if preferences.QuietStart == "" || preferences.QuietStart == preferences.QuietEnd {
+		return false
+	}
+	location, err := time.LoadLocation(preferences.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	now := at.In(location).Format("15:04")
+
+	if preferences.QuietStart < preferences.QuietEnd {
+		return now >= preferences.QuietStart && now < preferences.QuietEnd
+	}
+	return now >= preferences.QuietStart || now < preferences.QuietEnd
+}
+
+// when the quiet hours of a user that at falls within end
+func quietHoursEnd(preferences store.Preferences, at time.Time) time.Time {
+	location, err := time.LoadLocation(preferences.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	local := at.In(location)
+	end, err := time.Parse("15:04", preferences.QuietEnd)
+	if err != nil {
+		return at
+	}
+
+	ends := time.Date(local.Year(), local.Month(), local.Day(), end.Hour(), end.Minute(), 0, 0, location)
+	if !ends.After(local) {
+		ends = time.Date(local.Year(), local.Month(), local.Day()+1, end.Hour(), end.Minute(), 0, 0, location)
+	}
+	return ends
+}
+
+// Dispatcher is the one way notifications go out. It routes each message by
+// the preferences of its recipient, writing it to their notifications,
+// pushing it to their streams and emailing it. Emails that come in during
+// the quiet hours of their recipient wait in the outbox until they end
+type Dispatcher struct {
+	store  store.NotificationsStore
+	hub    *NotificationHub
+	mailer Mailer
+	clock  Clock
+}
+
+func NewDispatcher(notificationstore store.NotificationsStore, hub *NotificationHub, mailer Mailer, clock Clock) *Dispatcher {
+	return &Dispatcher{notificationstore, hub, mailer, clock}
+}
+
+func (d *Dispatcher) preferences(userId string) (store.Preferences, error) {
+	preferences, err := d.store.GetPreferences(userId)
+	if errors.Is(err, store.ErrNotFound) {
+		return defaultPreferences(userId), nil
+	}
+	if err != nil {
+		return store.Preferences{}, err
+	}
+	return *preferences, nil
+}
+
+// Dispatch sends messages about a change that is already saved, so failures
+// are only logged rather than failing the change
+func (d *Dispatcher) Dispatch(messages ...Message) {
+	now := d.clock.Now().UTC()
+
+	var notifications []store.Notification
+	var mails []Message
+	var deferred []store.DeferredMail
+	for _, message := range messages {
+		// someone who is not a user yet has no preferences, nor notifications
+		if message.UserID == "" {
+			if message.Subject != "" && message.Email != "" {
+				mails = append(mails, message)
+			}
+			continue
+		}
+
+		preferences, err := d.preferences(message.UserID)
+		if err != nil {
+			log.Printf("failed to read notification preferences of %s: %v", message.UserID, err)
+			preferences = defaultPreferences(message.UserID)
+		}
+		if slices.Contains(preferences.Muted, string(message.Type)) {
+			continue
+		}
+
+		if preferences.InApp {
+			notifications = append(notifications, store.Notification{
+				UserID:         message.UserID,
+				NotificationID: utils.NewULID(now),
+				Message:        message.Text,
+				Time:           now.Format(time.RFC3339),
+			})
+		}
+		// users who get a digest read about it there rather than in an email each
+		digest := preferences.Digest != "" && preferences.Digest != "off" && message.Type != NotifyWelcome
+		if !preferences.Email || digest || message.Subject == "" || message.Email == "" {
+			continue
+		}
+		if inQuietHours(preferences, now) {
+			deferred = append(deferred, store.DeferredMail{
+				MailID:  utils.NewULID(now),
+				UserID:  message.UserID,
+				To:      message.Email,
+				Subject: message.Subject,
+				HTML:    message.body(),
+				SendAt:  quietHoursEnd(preferences, now).UTC().Format(time.RFC3339),
+			})
+			continue
+		}
+		mails = append(mails, message)
+	}
+
+	if err := d.store.PutNotifications(notifications); err != nil {
+		log.Printf("failed to write notifications: %v", err)
+	} else {
+		d.hub.Publish(notifications...)
+	}
+
+	for _, mail := range mails {
+		if err := d.mailer.Send(Mail{To: mail.Email, Subject: mail.Subject, HTML: mail.body()}); err != nil {
+			log.Printf("failed to send %s mail to %s: %v", mail.Type, mail.Email, err)
+		}
+	}
+	for _, mail := range deferred {
+		if err := d.store.DeferMail(mail); err != nil {
+			log.Printf("failed to hold back mail to %s until %s: %v", mail.To, mail.SendAt, err)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package services
+
+import "errors"
+
+// errors returned by services that handlers map to http statuses
+var (
+	ErrNotFound = errors.New("not found")
+	ErrConflict = errors.New("conflict")
+	// the caller's If-Match version is no longer current
+	ErrPreconditionFailed = errors.New("precondition failed")
+	// the caller's role does not allow the action
+	ErrForbidden = errors.New("forbidden")
+	// a pagination cursor that was not issued for the listing
+	ErrInvalidCursor = errors.New("invalid cursor")
+	// a status change the tenant's workflow does not allow
+	ErrIllegalTransition = errors.New("illegal status transition")
+	// a workflow definition that does not hold together
+	ErrInvalidWorkflow = errors.New("invalid workflow")
+	// a checklist item that is empty, too long or not on the task
+	ErrInvalidChecklist = errors.New("invalid checklist")
+	// a task deadline that is not a date or a time
+	ErrInvalidDeadline = errors.New("invalid deadline")
+	// a dependency of a task on itself or one that would close a cycle
+	ErrInvalidDependency = errors.New("invalid dependency")
+	// a task template with a missing title, a bad recurrence rule or time zone
+	ErrInvalidTemplate = errors.New("invalid template")
+	// notification preferences with an unknown digest, type, time or time zone
+	ErrInvalidPreferences = errors.New("invalid preferences")
+	// a comment that is empty, too long or replies to a comment that is not there
+	ErrInvalidComment = errors.New("invalid comment")
+	// an attachment that is missing its file or does not match what was announced
+	ErrInvalidAttachment = errors.New("invalid attachment")
+	// an attachment over the size limit
+	ErrAttachmentTooLarge = errors.New("attachment too large")
+	// an attachment of a type that is not accepted
+	ErrUnsupportedMediaType = errors.New("unsupported attachment type")
+	// the configured storage cannot do what was asked, like presigning urls
+	ErrNotSupported = errors.New("not supported")
+)
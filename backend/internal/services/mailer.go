@@ -0,0 +1,125 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/Ghaby-X/tasork/internal/db"
+	"github.com/Ghaby-X/tasork/internal/env"
+)
+
+// Mail is an html email to one address, sent from the address the mailer is
+// configured with
+type Mail struct {
+	To      string
+	Subject string
+	HTML    string
+}
+
+// Mailer sends emails. NewMailerFromEnv picks one by MAIL_DRIVER
+type Mailer interface {
+	Send(mail Mail) error
+}
+
+// NewMailerFromEnv builds the mailer MAIL_DRIVER names: smtp (the default),
+// ses, file or memory
+func NewMailerFromEnv() (Mailer, error) {
+	switch driver := env.GetString("MAIL_DRIVER", "smtp"); driver {
+	case "smtp":
+		// APP_USER and APP_PASSWORD are the names the gmail setup used
+		username := env.GetString("SMTP_USERNAME", env.GetString("APP_USER", ""))
+		return NewSMTPMailer(SMTPConfig{
+			Host:     env.GetString("SMTP_HOST", "smtp.gmail.com"),
+			Port:     env.GetInt("SMTP_PORT", 465),
+			TLS:      env.GetString("SMTP_TLS", ""),
+			Username: username,
+			Password: env.GetString("SMTP_PASSWORD", env.GetString("APP_PASSWORD", "")),
+			From:     env.GetString("MAIL_FROM", username),
+		})
+	case "ses":
+		region := env.GetString("SES_REGION", env.GetString("AWS_REGION", "us-east-1"))
+		credentials, err := db.NewCredentialsProvider(region)
+		if err != nil {
+			return nil, err
+		}
+		return NewSESMailer(SESConfig{
+			Endpoint:    env.GetString("SES_ENDPOINT", ""),
+			Region:      region,
+			From:        env.GetString("MAIL_FROM", ""),
+			Credentials: credentials,
+		})
+	case "file":
+		return NewFileMailer(env.GetString("MAIL_FILE", "./data/mail.mbox"), env.GetString("MAIL_FROM", "tasork@localhost"))
+	case "memory":
+		return NewMemoryMailer(), nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", driver)
+	}
+}
+
+// header values may not carry line breaks, which would start new headers
+func headerSafe(value string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+}
+
+// MemoryMailer keeps the mails it is given instead of sending them, for
+// tests and offline development
+type MemoryMailer struct {
+	mu   sync.Mutex
+	sent []Mail
+}
+
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+func (m *MemoryMailer) Send(mail Mail) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, mail)
+	return nil
+}
+
+// the mails sent so far, oldest first
+func (m *MemoryMailer) Sent() []Mail {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Mail(nil), m.sent...)
+}
+
+var errMailQueueFull = errors.New("mail queue is full")
+
+// QueuedMailer hands mails to another mailer in the background, so requests
+// do not wait on the mail server. Failures are only logged, and mails still
+// queued when the process exits are lost
+type QueuedMailer struct {
+	mailer Mailer
+	queue  chan Mail
+}
+
+// queues up to size mails, refusing more until some are sent
+func NewQueuedMailer(mailer Mailer, size int) *QueuedMailer {
+	q := &QueuedMailer{mailer, make(chan Mail, size)}
+	go q.run()
+	return q
+}
+
+func (q *QueuedMailer) Send(mail Mail) error {
+	select {
+	case q.queue <- mail:
+		return nil
+	default:
+		return errMailQueueFull
+	}
+}
+
+func (q *QueuedMailer) run() {
+	for mail := range q.queue {
+		if err := q.mailer.Send(mail); err != nil {
+			log.Printf("failed to send mail to %s: %v", mail.To, err)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// appends mails to an mbox file instead of sending them, which mail clients
+// can open, for development and staging
+type fileMailer struct {
+	mu   sync.Mutex
+	path string
+	from string
+}
+
+func NewFileMailer(path, from string) (Mailer, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create mail directory: %w", err)
+	}
+	return &fileMailer{path: path, from: from}, nil
+}
+
+func (f *fileMailer) Send(mail Mail) error {
+	now := time.Now()
+	var b strings.Builder
+	fmt.Fprintf(&b, "From %s %s\n", f.from, now.UTC().Format(time.ANSIC))
+	fmt.Fprintf(&b, "From: %s\n", headerSafe(f.from))
+	fmt.Fprintf(&b, "To: %s\n", headerSafe(mail.To))
+	fmt.Fprintf(&b, "Subject: %s\n", mime.QEncoding.Encode("utf-8", headerSafe(mail.Subject)))
+	fmt.Fprintf(&b, "Date: %s\n", now.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\n")
+	b.WriteString("Content-Type: text/html; charset=utf-8\n\n")
+
+	// lines of the body starting with "From " would read as the next mail
+	for _, line := range strings.Split(mail.HTML, "\n") {
+		if strings.HasPrefix(strings.TrimLeft(line, ">"), "From ") {
+			line = ">" + line
+		}
+		b.WriteString(line + "\n")
+	}
+	b.WriteString("\n")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open mail file: %w", err)
+	}
+	defer file.Close()
+	_, err = file.WriteString(b.String())
+	return err
+}
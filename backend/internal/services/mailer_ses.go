@@ -0,0 +1,104 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// SESConfig locates the ses v2 api or a service compatible with it
+type SESConfig struct {
+	// base url of the service, https://email.<region>.amazonaws.com when empty
+	Endpoint    string
+	Region      string
+	From        string
+	Credentials aws.CredentialsProvider
+}
+
+// sends mails through the SendEmail call of the ses v2 api, signing plain
+// http requests with sigv4
+type sesMailer struct {
+	config SESConfig
+	signer *v4.Signer
+	client *http.Client
+}
+
+func NewSESMailer(config SESConfig) (Mailer, error) {
+	if config.From == "" {
+		return nil, fmt.Errorf("ses mailer needs a from address")
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = fmt.Sprintf("https://email.%s.amazonaws.com", config.Region)
+	}
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+	return &sesMailer{config, v4.NewSigner(), &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+type sesContent struct {
+	Data    string `json:"Data"`
+	Charset string `json:"Charset"`
+}
+
+type sesSendEmail struct {
+	FromEmailAddress string `json:"FromEmailAddress"`
+	Destination      struct {
+		ToAddresses []string `json:"ToAddresses"`
+	} `json:"Destination"`
+	Content struct {
+		Simple struct {
+			Subject sesContent `json:"Subject"`
+			Body    struct {
+				Html sesContent `json:"Html"`
+			} `json:"Body"`
+		} `json:"Simple"`
+	} `json:"Content"`
+}
+
+func (s *sesMailer) Send(mail Mail) error {
+	var input sesSendEmail
+	input.FromEmailAddress = s.config.From
+	input.Destination.ToAddresses = []string{mail.To}
+	input.Content.Simple.Subject = sesContent{headerSafe(mail.Subject), "UTF-8"}
+	input.Content.Simple.Body.Html = sesContent{mail.HTML, "UTF-8"}
+	body, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.config.Endpoint+"/v2/email/outbound-emails", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	credentials, err := s.config.Credentials.Retrieve(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to retrieve aws credentials: %w", err)
+	}
+	hash := sha256.Sum256(body)
+	err = s.signer.SignHTTP(context.Background(), credentials, req, hex.EncodeToString(hash[:]), "ses", s.config.Region, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to sign ses request: %w", err)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("ses request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("ses answered %d: %s", resp.StatusCode, detail)
+	}
+	return nil
+}
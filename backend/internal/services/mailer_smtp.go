@@ -0,0 +1,61 @@
+package services
+
+import (
+	"fmt"
+
+	"gopkg.in/gomail.v2"
+)
+
+// SMTPConfig locates an smtp server. TLS is "implicit" to connect over tls,
+// as on port 465, or "starttls" to upgrade a plain connection when the server
+// offers it. It defaults to implicit on port 465 and starttls otherwise
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	TLS      string
+	Username string
+	Password string
+	From     string
+}
+
+// sends mails through an smtp server, logging in when a username is set
+type smtpMailer struct {
+	config SMTPConfig
+}
+
+func NewSMTPMailer(config SMTPConfig) (Mailer, error) {
+	if config.TLS == "" {
+		config.TLS = "starttls"
+		if config.Port == 465 {
+			config.TLS = "implicit"
+		}
+	}
+	if config.TLS != "implicit" && config.TLS != "starttls" {
+		return nil, fmt.Errorf("unknown smtp tls mode %q", config.TLS)
+	}
+	if config.Host == "" {
+		return nil, fmt.Errorf("smtp mailer needs a host")
+	}
+	return &smtpMailer{config}, nil
+}
+
+func (s *smtpMailer) Send(mail Mail) error {
+	// checked here rather than up front, so the api still starts without mail set up
+	if s.config.From == "" {
+		return fmt.Errorf("no from address set, set MAIL_FROM or SMTP_USERNAME")
+	}
+
+	m := gomail.NewMessage()
+	m.SetHeader("From", s.config.From)
+	m.SetHeader("To", mail.To)
+	m.SetHeader("Subject", headerSafe(mail.Subject))
+	m.SetBody("text/html", mail.HTML)
+
+	d := gomail.NewDialer(s.config.Host, s.config.Port, s.config.Username, s.config.Password)
+	d.SSL = s.config.TLS == "implicit"
+
+	if err := d.DialAndSend(m); err != nil {
+		return fmt.Errorf("failed to send msg %v", err)
+	}
+	return nil
+}
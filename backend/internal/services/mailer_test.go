@@ -0,0 +1,151 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestFileMailer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail", "mail.mbox")
+	mailer, err := NewFileMailer(path, "tasork@localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mails := []Mail{
+		{To: "ann@example.com\r\nBcc: eve@example.com", Subject: "Bienvenue à bord", HTML: "<p>hi</p>\nFrom here on\n>From the top"},
+		{To: "bob@example.com", Subject: "second", HTML: "<p>bye</p>"},
+	}
+	for _, mail := range mails {
+		if err := mailer.Send(mail); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// each mail starts with a "From " line, the only ones in the file
+	var starts []string
+	lines := strings.Split(string(contents), "\n")
+	for _, line := range lines {
+		if strings.HasPrefix(line, "From ") {
+			starts = append(starts, line)
+		}
+		if strings.HasPrefix(line, "Bcc:") {
+			t.Errorf("a line break in To started a header: %q", line)
+		}
+	}
+	if len(starts) != 2 || !strings.HasPrefix(starts[0], "From tasork@localhost ") {
+		t.Fatalf("mails start at %q, want two mails from tasork@localhost", starts)
+	}
+	for _, want := range []string{
+		"To: ann@example.com  Bcc: eve@example.com\n",
+		"Subject: =?utf-8?q?Bienvenue_=C3=A0_bord?=\n",
+		"Content-Type: text/html; charset=utf-8\n\n<p>hi</p>\n",
+		"\n>From here on\n>>From the top\n",
+		"To: bob@example.com\n",
+	} {
+		if !strings.Contains(string(contents), want) {
+			t.Errorf("mbox has no %q:\n%s", want, contents)
+		}
+	}
+}
+
+func TestSESMailer(t *testing.T) {
+	var got sesSendEmail
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/email/outbound-emails" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		authorization = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		if got.Destination.ToAddresses[0] == "full@example.com" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"message":"slow down"}`))
+		}
+	}))
+	defer server.Close()
+
+	mailer, err := NewSESMailer(SESConfig{
+		Endpoint: server.URL + "/",
+		Region:   "eu-west-1",
+		From:     "tasork@example.com",
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "key", SecretAccessKey: "secret"}, nil
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mailer.Send(Mail{To: "ann@example.com", Subject: "hi\nthere", HTML: "<p>hi</p>"}); err != nil {
+		t.Fatal(err)
+	}
+	if got.FromEmailAddress != "tasork@example.com" || got.Content.Simple.Subject.Data != "hi there" || got.Content.Simple.Body.Html.Data != "<p>hi</p>" {
+		t.Errorf("sent %+v", got)
+	}
+	if !strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 Credential=key/") || !strings.Contains(authorization, "/eu-west-1/ses/aws4_request") {
+		t.Errorf("signed with %q, want sigv4 for ses in eu-west-1", authorization)
+	}
+
+	if err := mailer.Send(Mail{To: "full@example.com", Subject: "hi"}); err == nil || !strings.Contains(err.Error(), "429") {
+		t.Errorf("send answered 429 = %v, want an error naming it", err)
+	}
+	if _, err := NewSESMailer(SESConfig{Region: "eu-west-1"}); err == nil {
+		t.Error("ses mailer without a from address was built")
+	}
+}
+
+// a mailer that waits for release before sending
+type heldMailer struct {
+	*MemoryMailer
+	release chan struct{}
+}
+
+func (h heldMailer) Send(mail Mail) error {
+	<-h.release
+	return h.MemoryMailer.Send(mail)
+}
+
+func TestQueuedMailer(t *testing.T) {
+	held := heldMailer{NewMemoryMailer(), make(chan struct{})}
+	queued := NewQueuedMailer(held, 2)
+
+	// one is taken off the queue and waits, two more fill it
+	for i := range 3 {
+		if err := queued.Send(Mail{To: "ann@example.com"}); err != nil {
+			t.Fatalf("mail %d: %v", i, err)
+		}
+		if i == 0 {
+			for len(queued.queue) > 0 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+	if err := queued.Send(Mail{To: "ann@example.com"}); !errors.Is(err, errMailQueueFull) {
+		t.Errorf("send to a full queue = %v, want errMailQueueFull", err)
+	}
+
+	close(held.release)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(held.Sent()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if sent := len(held.Sent()); sent != 3 {
+		t.Errorf("sent %d mails, want the 3 queued", sent)
+	}
+}
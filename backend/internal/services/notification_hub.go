@@ -0,0 +1,132 @@
+package services
+
+import (
+	"log"
+	"sync"
+
+	"github.com/Ghaby-X/tasork/internal/store"
+)
+
+// notifications a subscriber may fall behind by before it is dropped
+const subscriberBuffer = 32
+
+// Broker carries published notifications to the hub of every instance of the
+// api. A single instance uses LocalBroker, several need a broker backed by a
+// shared channel such as redis pub/sub or sns, which calls deliver for each
+// notification any of them publishes
+type Broker interface {
+	Publish(notifications []store.Notification) error
+	Subscribe(deliver func(store.Notification))
+}
+
+// LocalBroker hands notifications straight to the hub of this instance
+type LocalBroker struct {
+	mu      sync.RWMutex
+	deliver []func(store.Notification)
+}
+
+func NewLocalBroker() *LocalBroker {
+	return &LocalBroker{}
+}
+
+func (b *LocalBroker) Publish(notifications []store.Notification) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, notification := range notifications {
+		for _, deliver := range b.deliver {
+			deliver(notification)
+		}
+	}
+	return nil
+}
+
+func (b *LocalBroker) Subscribe(deliver func(store.Notification)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.deliver = append(b.deliver, deliver)
+}
+
+// Subscription receives the notifications of one user as they are published.
+// C is closed when the subscription ends, either by Close or because the
+// subscriber fell too far behind, after which it should reconnect and replay
+type Subscription struct {
+	C      <-chan store.Notification
+	c      chan store.Notification
+	userID string
+	hub    *NotificationHub
+}
+
+func (s *Subscription) Close() {
+	s.hub.unsubscribe(s)
+}
+
+// NotificationHub fans notifications out to the streams open on this
+// instance, receiving them from the broker so every instance sees them
+type NotificationHub struct {
+	broker      Broker
+	mu          sync.Mutex
+	subscribers map[string]map[*Subscription]struct{}
+}
+
+func NewNotificationHub(broker Broker) *NotificationHub {
+	hub := &NotificationHub{broker: broker, subscribers: map[string]map[*Subscription]struct{}{}}
+	broker.Subscribe(hub.deliver)
+	return hub
+}
+
+// Publish pushes notifications that were just written to their users. It
+// only logs failures, as the notifications are already saved and are
+// replayed when a stream reconnects
+func (h *NotificationHub) Publish(notifications ...store.Notification) {
+	if len(notifications) == 0 {
+		return
+	}
+	if err := h.broker.Publish(notifications); err != nil {
+		log.Printf("failed to publish notifications: %v", err)
+	}
+}
+
+func (h *NotificationHub) Subscribe(userID string) *Subscription {
+	c := make(chan store.Notification, subscriberBuffer)
+	subscription := &Subscription{C: c, c: c, userID: store.UserKey(userID), hub: h}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subscribers[subscription.userID] == nil {
+		h.subscribers[subscription.userID] = map[*Subscription]struct{}{}
+	}
+	h.subscribers[subscription.userID][subscription] = struct{}{}
+	return subscription
+}
+
+func (h *NotificationHub) unsubscribe(subscription *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(subscription)
+}
+
+// removes and closes a subscription, h.mu must be held
+func (h *NotificationHub) remove(subscription *Subscription) {
+	subscribers := h.subscribers[subscription.userID]
+	if _, ok := subscribers[subscription]; !ok {
+		return
+	}
+	delete(subscribers, subscription)
+	if len(subscribers) == 0 {
+		delete(h.subscribers, subscription.userID)
+	}
+	close(subscription.c)
+}
+
+func (h *NotificationHub) deliver(notification store.Notification) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for subscription := range h.subscribers[store.UserKey(notification.UserID)] {
+		select {
+		case subscription.c <- notification:
+		default:
+			// a stream that cannot keep up is dropped rather than holding up the others
+			h.remove(subscription)
+		}
+	}
+}
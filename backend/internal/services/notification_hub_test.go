@@ -0,0 +1,119 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Ghaby-X/tasork/internal/store"
+	"github.com/Ghaby-X/tasork/internal/utils"
+)
+
+// the ids of the notifications waiting on a subscription, and whether it is still open
+func received(subscription *Subscription) ([]string, bool) {
+	var ids []string
+	for {
+		select {
+		case notification, ok := <-subscription.C:
+			if !ok {
+				return ids, false
+			}
+			ids = append(ids, notification.NotificationID)
+		default:
+			return ids, true
+		}
+	}
+}
+
+func TestHubFansOutByUser(t *testing.T) {
+	broker := NewLocalBroker()
+	// two instances of the api on one broker
+	hub, other := NewNotificationHub(broker), NewNotificationHub(broker)
+
+	phone, laptop := hub.Subscribe("ann"), other.Subscribe("USER#ann")
+	bob := hub.Subscribe("bob")
+	hub.Publish(store.Notification{UserID: "ann", NotificationID: "1"}, store.Notification{UserID: "USER#bob", NotificationID: "2"})
+
+	for name, subscription := range map[string]*Subscription{"phone": phone, "laptop": laptop} {
+		if ids, open := received(subscription); len(ids) != 1 || ids[0] != "1" || !open {
+			t.Errorf("ann's %s got %v, want 1", name, ids)
+		}
+	}
+	if ids, _ := received(bob); len(ids) != 1 || ids[0] != "2" {
+		t.Errorf("bob got %v, want 2", ids)
+	}
+
+	// a closed subscription gets nothing more, the others carry on
+	phone.Close()
+	phone.Close()
+	other.Publish(store.Notification{UserID: "ann", NotificationID: "3"})
+	if ids, open := received(phone); len(ids) != 0 || open {
+		t.Errorf("closed subscription got %v and is open %v, want nothing and closed", ids, open)
+	}
+	if ids, _ := received(laptop); len(ids) != 1 || ids[0] != "3" {
+		t.Errorf("ann's laptop got %v, want 3", ids)
+	}
+	if ids, _ := received(bob); len(ids) != 0 {
+		t.Errorf("bob got ann's %v", ids)
+	}
+}
+
+func TestHubDropsSlowSubscribers(t *testing.T) {
+	hub := NewNotificationHub(NewLocalBroker())
+	slow, fast := hub.Subscribe("ann"), hub.Subscribe("ann")
+
+	var got []string
+	for i := range subscriberBuffer + 1 {
+		hub.Publish(store.Notification{UserID: "ann", NotificationID: utils.NewULID(time.Now())})
+		if i%8 == 0 {
+			ids, _ := received(fast)
+			got = append(got, ids...)
+		}
+	}
+	ids, open := received(fast)
+	if got = append(got, ids...); len(got) != subscriberBuffer+1 || !open {
+		t.Errorf("fast subscriber got %d notifications, want %d", len(got), subscriberBuffer+1)
+	}
+	// the slow one gets what fit in its buffer and is closed so it reconnects
+	if ids, open := received(slow); len(ids) != subscriberBuffer || open {
+		t.Errorf("slow subscriber got %d notifications and is open %v, want %d and closed", len(ids), open, subscriberBuffer)
+	}
+	slow.Close()
+}
+
+// a stream reconnecting with the id of the last notification it saw gets the
+// ones written since, oldest first
+func TestStreamReplaysMissedNotifications(t *testing.T) {
+	tasks, st := newTestTasks(store.NewMemoryDB())
+	users := NewUserService(st.Users, st.Notifications, tasks.notify.hub, tasks.notify)
+
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var written []store.Notification
+	for i := range 5 {
+		written = append(written, store.Notification{UserID: "ann", NotificationID: utils.NewULID(start.Add(time.Duration(i) * time.Second)), Message: "hi"})
+	}
+	if err := st.Notifications.PutNotifications(written); err != nil {
+		t.Fatal(err)
+	}
+
+	subscription, missed, err := users.StreamNotifications("USER#ann", "NOTIFICATION#"+written[1].NotificationID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer subscription.Close()
+	if len(missed) != 3 || missed[0].NotificationID != written[2].NotificationID || missed[2].NotificationID != written[4].NotificationID {
+		t.Errorf("replayed %+v, want the last 3 in order", missed)
+	}
+
+	// new ones arrive on the subscription
+	tasks.notify.hub.Publish(store.Notification{UserID: "ann", NotificationID: "new"})
+	if ids, _ := received(subscription); len(ids) != 1 || ids[0] != "new" {
+		t.Errorf("stream got %v, want the new notification", ids)
+	}
+
+	// ids from before ulids cannot be replayed from
+	old, missed, err := users.StreamNotifications("USER#ann", "6f1c1e9e-0000-4000-8000-000000000000")
+	if err != nil || len(missed) != 0 {
+		t.Errorf("replay from a random id gave %v with %v, want nothing", missed, err)
+	}
+	old.Close()
+}
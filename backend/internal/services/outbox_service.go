@@ -0,0 +1,86 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/Ghaby-X/tasork/internal/env"
+	"github.com/Ghaby-X/tasork/internal/store"
+)
+
+// OutboxWorker sends the emails that were held back, such as the ones that
+// came in during the quiet hours of their recipient, once they are due
+type OutboxWorker struct {
+	store    store.NotificationsStore
+	mailer   Mailer
+	clock    Clock
+	interval time.Duration
+}
+
+// runs every OUTBOX_INTERVAL (1m by default)
+func NewOutboxWorker(notificationstore store.NotificationsStore, mailer Mailer, clock Clock) *OutboxWorker {
+	return &OutboxWorker{
+		store:    notificationstore,
+		mailer:   mailer,
+		clock:    clock,
+		interval: env.GetDuration("OUTBOX_INTERVAL", time.Minute),
+	}
+}
+
+// RunOnce sends the held back emails that are due, returning how many it
+// sent. Each is taken out of the outbox before it is sent, so several
+// instances never send one twice, and put back to be retried if sending fails
+func (w *OutboxWorker) RunOnce() (int, error) {
+	now := w.clock.Now().UTC()
+	mails, err := w.store.ListDeferredMail(now.Add(time.Second).Format(time.RFC3339))
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, mail := range mails {
+		err := w.store.TakeDeferredMail(mail)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			log.Printf("failed to take mail %s out of the outbox: %v", mail.MailID, err)
+			continue
+		}
+
+		if err := w.mailer.Send(Mail{To: mail.To, Subject: mail.Subject, HTML: mail.HTML}); err != nil {
+			log.Printf("failed to send held back mail to %s: %v", mail.To, err)
+			retry := mail
+			retry.SendAt = now.Add(w.interval).Format(time.RFC3339)
+			if err := w.store.DeferMail(retry); err != nil {
+				log.Printf("failed to put mail %s back in the outbox: %v", mail.MailID, err)
+			}
+			continue
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// Run calls RunOnce straight away and then every interval until ctx is done
+func (w *OutboxWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		sent, err := w.RunOnce()
+		if err != nil {
+			log.Printf("outbox worker failed: %v", err)
+		} else if sent > 0 {
+			log.Printf("outbox worker sent %d held back mails", sent)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Ghaby-X/tasork/internal/store"
+)
+
+// a mailer whose sends fail while down is set
+type flakyMailer struct {
+	MemoryMailer
+	down bool
+}
+
+func (m *flakyMailer) Send(mail Mail) error {
+	if m.down {
+		return errors.New("mail server is down")
+	}
+	return m.MemoryMailer.Send(mail)
+}
+
+func TestQuietHourMailWaitsUntilTheyEnd(t *testing.T) {
+	st := store.NewMemoryStorage()
+	mailer := &flakyMailer{}
+	clock := &fakeClock{now: time.Date(2030, time.January, 1, 23, 0, 0, 0, time.UTC)}
+	notify := NewDispatcher(st.Notifications, NewNotificationHub(NewLocalBroker()), mailer, clock)
+	outbox := &OutboxWorker{store: st.Notifications, mailer: mailer, clock: clock, interval: time.Minute}
+
+	// 22:00 to 07:00 in Berlin is 21:00 to 06:00 UTC in winter
+	err := st.Notifications.PutPreferences(store.Preferences{
+		UserID: "ann", TenantID: "TENANT#a", InApp: true, Email: true, Digest: "off",
+		QuietStart: "22:00", QuietEnd: "07:00", Timezone: "Europe/Berlin",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	notify.Dispatch(Message{Type: NotifyAssigned, UserID: "ann", Email: "ann@example.com", Text: "assigned", Subject: "Assigned"})
+	if sent := mailer.Sent(); len(sent) != 0 {
+		t.Fatalf("mailed %v during quiet hours, want nothing", sent)
+	}
+
+	steps := []struct {
+		at   time.Time
+		down bool
+		sent int
+	}{
+		{time.Date(2030, time.January, 2, 5, 59, 0, 0, time.UTC), false, 0},
+		// sending fails, the mail is put back and retried
+		{time.Date(2030, time.January, 2, 6, 0, 0, 0, time.UTC), true, 0},
+		{time.Date(2030, time.January, 2, 6, 1, 0, 0, time.UTC), false, 1},
+		{time.Date(2030, time.January, 2, 6, 2, 0, 0, time.UTC), false, 0},
+	}
+	for _, step := range steps {
+		clock.now, mailer.down = step.at, step.down
+		sent, err := outbox.RunOnce()
+		if err != nil {
+			t.Fatalf("at %s: %v", step.at, err)
+		}
+		if sent != step.sent {
+			t.Errorf("at %s sent %d held back mails, want %d", step.at, sent, step.sent)
+		}
+	}
+	if sent := mailer.Sent(); len(sent) != 1 || sent[0].To != "ann@example.com" || sent[0].HTML != "assigned" {
+		t.Errorf("mailed %v, want the one held back mail", sent)
+	}
+}
+
+func TestQuietHoursEnd(t *testing.T) {
+	tests := []struct {
+		start, end string
+		at         time.Time
+		want       time.Time
+	}{
+		{"22:00", "07:00", time.Date(2030, time.January, 1, 23, 0, 0, 0, time.UTC), time.Date(2030, time.January, 2, 7, 0, 0, 0, time.UTC)},
+		{"22:00", "07:00", time.Date(2030, time.January, 2, 3, 0, 0, 0, time.UTC), time.Date(2030, time.January, 2, 7, 0, 0, 0, time.UTC)},
+		{"12:00", "13:30", time.Date(2030, time.January, 2, 12, 15, 0, 0, time.UTC), time.Date(2030, time.January, 2, 13, 30, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		preferences := store.Preferences{QuietStart: tt.start, QuietEnd: tt.end, Timezone: "UTC"}
+		if !inQuietHours(preferences, tt.at) {
+			t.Fatalf("%s is not within %s-%s", tt.at, tt.start, tt.end)
+		}
+		if got := quietHoursEnd(preferences, tt.at); !got.Equal(tt.want) {
+			t.Errorf("quiet hours %s-%s at %s end at %s, want %s", tt.start, tt.end, tt.at, got, tt.want)
+		}
+	}
+}
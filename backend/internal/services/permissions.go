@@ -0,0 +1,107 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+
+	internal_types "github.com/Ghaby-X/tasork/internal/types"
+	"github.com/Ghaby-X/tasork/internal/utils"
+)
+
+// roles carried in the custom:role claim
+const (
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+// Permission is an action a role may take
+type Permission string
+
+const (
+	PermViewTasks  Permission = "tasks:view"
+	PermCreateTask Permission = "tasks:create"
+	// edit the fields and assignees of a task
+	PermEditTask   Permission = "tasks:edit"
+	PermDeleteTask Permission = "tasks:delete"
+	// change the status of tasks assigned to the caller
+	PermUpdateOwnTaskStatus Permission = "tasks:status:own"
+	// change the status of any task of the tenant
+	PermUpdateTaskStatus Permission = "tasks:status"
+	// comment on tasks assigned to the caller
+	PermComment Permission = "comments:create"
+	// comment on any task of the tenant
+	PermCommentOnAnyTask Permission = "comments:create:any"
+	// delete the comments of other users
+	PermModerateComments Permission = "comments:moderate"
+	// attach files to tasks assigned to the caller
+	PermAttach Permission = "attachments:create"
+	// attach files to any task of the tenant
+	PermAttachToAnyTask Permission = "attachments:create:any"
+	// delete the attachments of other users
+	PermModerateAttachments Permission = "attachments:moderate"
+	PermViewUsers           Permission = "users:view"
+	PermInviteUsers         Permission = "users:invite"
+	PermViewWorkflow        Permission = "workflow:view"
+	PermEditWorkflow        Permission = "workflow:edit"
+	// create, change and delete recurring task templates
+	PermManageTemplates Permission = "templates:manage"
+)
+
+// what each role may do, roles not listed may do nothing
+var rolePermissions = map[string][]Permission{
+	RoleAdmin: {
+		PermViewTasks, PermCreateTask, PermEditTask, PermDeleteTask,
+		PermUpdateOwnTaskStatus, PermUpdateTaskStatus,
+		PermComment, PermCommentOnAnyTask, PermModerateComments,
+		PermAttach, PermAttachToAnyTask, PermModerateAttachments,
+		PermViewUsers, PermInviteUsers,
+		PermViewWorkflow, PermEditWorkflow,
+		PermManageTemplates,
+	},
+	RoleMember: {
+		PermViewTasks, PermUpdateOwnTaskStatus,
+		PermComment, PermAttach,
+		PermViewUsers,
+		PermViewWorkflow,
+	},
+}
+
+// HasPermission reports whether role may take the action
+func HasPermission(role string, permission Permission) bool {
+	return slices.Contains(rolePermissions[role], permission)
+}
+
+// RequireRole only lets through users with one of roles, it must run after
+// AuthorizeRegistrationMiddleWare
+func (s *AuthService) RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return s.require(func(role string) bool {
+		return slices.Contains(roles, role)
+	})
+}
+
+// RequirePermission only lets through users whose role has the permission, it
+// must run after AuthorizeRegistrationMiddleWare
+func (s *AuthService) RequirePermission(permission Permission) func(http.Handler) http.Handler {
+	return s.require(func(role string) bool {
+		return HasPermission(role, permission)
+	})
+}
+
+func (s *AuthService) require(allowed func(role string) bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := r.Context().Value(internal_types.ContextKey("user")).(internal_types.TokenClaims)
+			if !ok {
+				utils.WriteError(w, http.StatusUnauthorized, fmt.Errorf("user not authenticated"))
+				return
+			}
+
+			if !allowed(user["custom:role"]) {
+				utils.WriteError(w, http.StatusForbidden, fmt.Errorf("not allowed for role %q", user["custom:role"]))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	internal_types "github.com/Ghaby-X/tasork/internal/types"
+)
+
+func TestHasPermission(t *testing.T) {
+	// the roles allowed each permission, any other role is denied
+	tests := []struct {
+		permission Permission
+		admin      bool
+		member     bool
+	}{
+		{PermViewTasks, true, true},
+		{PermCreateTask, true, false},
+		{PermEditTask, true, false},
+		{PermDeleteTask, true, false},
+		{PermUpdateOwnTaskStatus, true, true},
+		{PermUpdateTaskStatus, true, false},
+		{PermComment, true, true},
+		{PermCommentOnAnyTask, true, false},
+		{PermModerateComments, true, false},
+		{PermAttach, true, true},
+		{PermAttachToAnyTask, true, false},
+		{PermModerateAttachments, true, false},
+		{PermViewUsers, true, true},
+		{PermInviteUsers, true, false},
+		{PermViewWorkflow, true, true},
+		{PermEditWorkflow, true, false},
+		{PermManageTemplates, true, false},
+		{Permission("tasks:unknown"), false, false},
+	}
+	for _, tt := range tests {
+		for role, want := range map[string]bool{RoleAdmin: tt.admin, RoleMember: tt.member, "": false, "owner": false} {
+			if got := HasPermission(role, tt.permission); got != want {
+				t.Errorf("HasPermission(%q, %s) = %v, want %v", role, tt.permission, got, want)
+			}
+		}
+	}
+
+	// every permission a role has is in the table above
+	listed := map[Permission]bool{}
+	for _, tt := range tests {
+		listed[tt.permission] = true
+	}
+	for role, permissions := range rolePermissions {
+		for _, permission := range permissions {
+			if !listed[permission] {
+				t.Errorf("%s has %s, which the test does not cover", role, permission)
+			}
+		}
+	}
+}
+
+func TestRequireMiddleware(t *testing.T) {
+	auth := &AuthService{}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	claims := func(role string) internal_types.TokenClaims {
+		return internal_types.TokenClaims{"sub": "ann", "custom:tenantId": "TENANT#a", "custom:role": role}
+	}
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+		claims  internal_types.TokenClaims
+		want    int
+	}{
+		{"admin on an admin route", auth.RequirePermission(PermDeleteTask)(ok), claims(RoleAdmin), http.StatusNoContent},
+		{"member on an admin route", auth.RequirePermission(PermDeleteTask)(ok), claims(RoleMember), http.StatusForbidden},
+		{"member on a member route", auth.RequirePermission(PermViewTasks)(ok), claims(RoleMember), http.StatusNoContent},
+		{"no role", auth.RequirePermission(PermViewTasks)(ok), claims(""), http.StatusForbidden},
+		{"no claims", auth.RequirePermission(PermViewTasks)(ok), nil, http.StatusUnauthorized},
+		{"admin role", auth.RequireRole(RoleAdmin)(ok), claims(RoleAdmin), http.StatusNoContent},
+		{"member on an admin role route", auth.RequireRole(RoleAdmin)(ok), claims(RoleMember), http.StatusForbidden},
+		{"either role", auth.RequireRole(RoleAdmin, RoleMember)(ok), claims(RoleMember), http.StatusNoContent},
+		{"no claims on a role route", auth.RequireRole(RoleAdmin)(ok), nil, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.claims != nil {
+				req = req.WithContext(context.WithValue(req.Context(), internal_types.ContextKey("user"), tt.claims))
+			}
+			rec := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("got %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
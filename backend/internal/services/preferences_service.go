@@ -0,0 +1,118 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/Ghaby-X/tasork/internal/store"
+	internal_types "github.com/Ghaby-X/tasork/internal/types"
+)
+
+var digestFrequencies = []string{"off", "daily", "weekly"}
+
+func toPreferencesOutput(preferences store.Preferences) internal_types.PreferencesOutput {
+	muted := preferences.Muted
+	if muted == nil {
+		muted = []string{}
+	}
+	return internal_types.PreferencesOutput{
+		InApp:      preferences.InApp,
+		Email:      preferences.Email,
+		Digest:     preferences.Digest,
+		Muted:      muted,
+		QuietHours: internal_types.QuietHoursDTO{Start: preferences.QuietStart, End: preferences.QuietEnd},
+		Timezone:   preferences.Timezone,
+		UpdatedAt:  preferences.UpdatedAt,
+	}
+}
+
+// a "HH:MM" time of day, written back in that form
+func parseTimeOfDay(value string) (string, error) {
+	at, err := time.Parse("15:04", value)
+	if err != nil {
+		return "", fmt.Errorf("%w: %q is not a HH:MM time", ErrInvalidPreferences, value)
+	}
+	return at.Format("15:04"), nil
+}
+
+// the saved preferences of a user, or the defaults when they never saved any
+func (s *UsersService) preferences(userId string) (store.Preferences, error) {
+	preferences, err := s.notifications.GetPreferences(userId)
+	if errors.Is(err, store.ErrNotFound) {
+		return defaultPreferences(userId), nil
+	}
+	if err != nil {
+		return store.Preferences{}, err
+	}
+	return *preferences, nil
+}
+
+func (s *UsersService) GetPreferences(userId string) (*internal_types.PreferencesOutput, error) {
+	preferences, err := s.preferences(userId)
+	if err != nil {
+		return nil, err
+	}
+	output := toPreferencesOutput(preferences)
+	return &output, nil
+}
+
+// changes the given notification preferences, starting from the defaults
+// for a user who never saved any. The digest is about the tasks of tenantId
+func (s *UsersService) UpdatePreferences(userId, tenantId string, data internal_types.PatchPreferencesDTO) (*internal_types.PreferencesOutput, error) {
+	preferences, err := s.preferences(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if data.InApp != nil {
+		preferences.InApp = *data.InApp
+	}
+	if data.Email != nil {
+		preferences.Email = *data.Email
+	}
+	if data.Digest != nil {
+		if !slices.Contains(digestFrequencies, *data.Digest) {
+			return nil, fmt.Errorf("%w: digest must be one of %v", ErrInvalidPreferences, digestFrequencies)
+		}
+		preferences.Digest = *data.Digest
+	}
+	if data.Muted != nil {
+		muted := []string{}
+		for _, kind := range *data.Muted {
+			if !slices.Contains(notificationTypes, NotificationType(kind)) {
+				return nil, fmt.Errorf("%w: unknown notification type %q", ErrInvalidPreferences, kind)
+			}
+			if !slices.Contains(muted, kind) {
+				muted = append(muted, kind)
+			}
+		}
+		preferences.Muted = muted
+	}
+	if data.QuietHours != nil {
+		preferences.QuietStart, preferences.QuietEnd = "", ""
+		if data.QuietHours.Start != "" || data.QuietHours.End != "" {
+			if preferences.QuietStart, err = parseTimeOfDay(data.QuietHours.Start); err != nil {
+				return nil, err
+			}
+			if preferences.QuietEnd, err = parseTimeOfDay(data.QuietHours.End); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if data.Timezone != nil {
+		if _, err := time.LoadLocation(*data.Timezone); err != nil || *data.Timezone == "" {
+			return nil, fmt.Errorf("%w: unknown time zone %q", ErrInvalidPreferences, *data.Timezone)
+		}
+		preferences.Timezone = *data.Timezone
+	}
+
+	preferences.TenantID = tenantId
+	preferences.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	if err := s.notifications.PutPreferences(preferences); err != nil {
+		return nil, err
+	}
+	output := toPreferencesOutput(preferences)
+	return &output, nil
+}
@@ -0,0 +1,277 @@
+package services
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// frequencies a recurrence rule can repeat at
+const (
+	freqDaily   = "DAILY"
+	freqWeekly  = "WEEKLY"
+	freqMonthly = "MONTHLY"
+)
+
+// stops rules that can never match, like the 31st of every other February,
+// from being walked forever
+const maxRecurrencePeriods = 100000
+
+var weekdays = map[string]time.Weekday{
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+	"SU": time.Sunday,
+}
+
+// recurrence is the subset of an RFC 5545 RRULE that templates support:
+// FREQ, INTERVAL, BYDAY without ordinals, BYMONTHDAY, UNTIL and COUNT
+type recurrence struct {
+	freq       string
+	interval   int
+	byDay      []time.Weekday
+	byMonthDay []int
+	until      time.Time
+	count      int
+}
+
+// parses a rule such as "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH;COUNT=10". A
+// leading "RRULE:" is allowed
+func parseRecurrence(rule string) (recurrence, error) {
+	r := recurrence{interval: 1}
+	seen := map[string]bool{}
+	for _, part := range strings.Split(strings.TrimPrefix(strings.TrimSpace(rule), "RRULE:"), ";") {
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		name = strings.ToUpper(strings.TrimSpace(name))
+		value = strings.ToUpper(strings.TrimSpace(value))
+		if !ok || value == "" {
+			return r, fmt.Errorf("%w: %q is not NAME=VALUE", ErrInvalidTemplate, part)
+		}
+		if seen[name] {
+			return r, fmt.Errorf("%w: %s is given twice", ErrInvalidTemplate, name)
+		}
+		seen[name] = true
+
+		switch name {
+		case "FREQ":
+			if value != freqDaily && value != freqWeekly && value != freqMonthly {
+				return r, fmt.Errorf("%w: FREQ must be DAILY, WEEKLY or MONTHLY", ErrInvalidTemplate)
+			}
+			r.freq = value
+		case "INTERVAL":
+			interval, err := strconv.Atoi(value)
+			if err != nil || interval < 1 {
+				return r, fmt.Errorf("%w: INTERVAL must be a positive number", ErrInvalidTemplate)
+			}
+			r.interval = interval
+		case "COUNT":
+			count, err := strconv.Atoi(value)
+			if err != nil || count < 1 {
+				return r, fmt.Errorf("%w: COUNT must be a positive number", ErrInvalidTemplate)
+			}
+			r.count = count
+		case "UNTIL":
+			until, err := parseUntil(value)
+			if err != nil {
+				return r, err
+			}
+			r.until = until
+		case "BYDAY":
+			for _, day := range strings.Split(value, ",") {
+				weekday, ok := weekdays[day]
+				if !ok {
+					return r, fmt.Errorf("%w: %q is not a weekday of BYDAY", ErrInvalidTemplate, day)
+				}
+				r.byDay = append(r.byDay, weekday)
+			}
+		case "BYMONTHDAY":
+			for _, day := range strings.Split(value, ",") {
+				monthDay, err := strconv.Atoi(day)
+				if err != nil || monthDay == 0 || monthDay < -31 || monthDay > 31 {
+					return r, fmt.Errorf("%w: %q is not a day of BYMONTHDAY", ErrInvalidTemplate, day)
+				}
+				r.byMonthDay = append(r.byMonthDay, monthDay)
+			}
+		default:
+			return r, fmt.Errorf("%w: %s is not supported", ErrInvalidTemplate, name)
+		}
+	}
+
+	if r.freq == "" {
+		return r, fmt.Errorf("%w: FREQ is required", ErrInvalidTemplate)
+	}
+	if r.count > 0 && !r.until.IsZero() {
+		return r, fmt.Errorf("%w: COUNT and UNTIL cannot both be given", ErrInvalidTemplate)
+	}
+	if len(r.byMonthDay) > 0 && r.freq != freqMonthly {
+		return r, fmt.Errorf("%w: BYMONTHDAY only applies to MONTHLY rules", ErrInvalidTemplate)
+	}
+	return r, nil
+}
+
+// UNTIL is a date, or a date and time that is read as UTC
+func parseUntil(value string) (time.Time, error) {
+	for _, layout := range []string{"20060102T150405Z", "20060102T150405", "20060102", time.RFC3339, "2006-01-02"} {
+		if until, err := time.Parse(layout, value); err == nil {
+			if len(value) == len("20060102") || len(value) == len("2006-01-02") {
+				// a date alone keeps the whole day
+				until = until.Add(24*time.Hour - time.Second)
+			}
+			return until, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w: UNTIL %q is not a date", ErrInvalidTemplate, value)
+}
+
+// calls visit with each occurrence of the rule at or after from, in order,
+// until it returns false or the rule ends. seen is how many occurrences came
+// between start and from, as COUNT counts from start on, and visit is told how
+// many came before each occurrence. Every occurrence keeps the wall clock time
+// of start in its location
+func (r recurrence) walk(start, from time.Time, seen int, visit func(at time.Time, seen int) bool) {
+	if from.Before(start) {
+		from, seen = start, 0
+	}
+	first := r.periodOf(start, from)
+	for period := first; period < first+maxRecurrencePeriods; period++ {
+		for _, at := range r.period(start, period) {
+			if at.Before(from) {
+				continue
+			}
+			if (!r.until.IsZero() && at.After(r.until)) || (r.count > 0 && seen == r.count) {
+				return
+			}
+			if !visit(at, seen) {
+				return
+			}
+			seen++
+		}
+	}
+}
+
+// the period of the rule that from falls in, so a walk can pick up at from
+// rather than replay every period since start
+func (r recurrence) periodOf(start, from time.Time) int {
+	from = from.In(start.Location())
+	startYear, startMonth, startDay := start.Date()
+	fromYear, fromMonth, fromDay := from.Date()
+	days := int(time.Date(fromYear, fromMonth, fromDay, 0, 0, 0, 0, time.UTC).
+		Sub(time.Date(startYear, startMonth, startDay, 0, 0, 0, 0, time.UTC)).Hours() / 24)
+
+	switch r.freq {
+	case freqDaily:
+		return days / r.interval
+	case freqWeekly:
+		// weeks start on monday when BYDAY picks the days of a week
+		if len(r.byDay) > 0 {
+			days += (int(start.Weekday()) + 6) % 7
+		}
+		return days / (7 * r.interval)
+	default:
+		months := (fromYear-startYear)*12 + int(fromMonth-startMonth)
+		return months / r.interval
+	}
+}
+
+// the occurrences of the rule that fall in [from, to), walking from resume
+// with seen occurrences before it
+func (r recurrence) between(start, resume time.Time, seen int, from, to time.Time) []time.Time {
+	var occurrences []time.Time
+	r.walk(start, resume, seen, func(at time.Time, _ int) bool {
+		if !at.Before(to) {
+			return false
+		}
+		if !at.Before(from) {
+			occurrences = append(occurrences, at)
+		}
+		return true
+	})
+	return occurrences
+}
+
+// the first occurrence at or after from and how many came before it, walking
+// from resume with seen occurrences before it. False once the rule has ended
+func (r recurrence) next(start, resume time.Time, seen int, from time.Time) (time.Time, int, bool) {
+	var next time.Time
+	var before int
+	r.walk(start, resume, seen, func(at time.Time, seen int) bool {
+		if at.Before(from) {
+			return true
+		}
+		next, before = at, seen
+		return false
+	})
+	return next, before, !next.IsZero()
+}
+
+// the candidate times of one period of the rule, in order
+func (r recurrence) period(start time.Time, period int) []time.Time {
+	year, month, day := start.Date()
+	hour, minute, second := start.Clock()
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, hour, minute, second, 0, start.Location())
+	}
+
+	switch r.freq {
+	case freqDaily:
+		date := at(year, month, day+period*r.interval)
+		if len(r.byDay) > 0 && !slices.Contains(r.byDay, date.Weekday()) {
+			return nil
+		}
+		return []time.Time{date}
+
+	case freqWeekly:
+		if len(r.byDay) == 0 {
+			return []time.Time{at(year, month, day+period*r.interval*7)}
+		}
+		// weeks start on monday
+		monday := day - (int(start.Weekday())+6)%7 + period*r.interval*7
+		var dates []time.Time
+		for offset := range 7 {
+			date := at(year, month, monday+offset)
+			if slices.Contains(r.byDay, date.Weekday()) {
+				dates = append(dates, date)
+			}
+		}
+		return dates
+
+	default:
+		first := at(year, month+time.Month(period*r.interval), 1)
+		days := daysIn(first.Year(), first.Month())
+		var dates []time.Time
+		for monthDay := 1; monthDay <= days; monthDay++ {
+			date := at(first.Year(), first.Month(), monthDay)
+			if r.onMonthDay(monthDay, days, day) && (len(r.byDay) == 0 || slices.Contains(r.byDay, date.Weekday())) {
+				dates = append(dates, date)
+			}
+		}
+		return dates
+	}
+}
+
+// whether a day of a monthly rule matches BYMONTHDAY, where negative days
+// count back from the end of the month. Without BYMONTHDAY or BYDAY the rule
+// repeats on the day of the month it started, skipping months too short for it
+func (r recurrence) onMonthDay(monthDay, days, startDay int) bool {
+	if len(r.byMonthDay) == 0 {
+		return len(r.byDay) > 0 || monthDay == startDay
+	}
+	for _, day := range r.byMonthDay {
+		if day == monthDay || days+day+1 == monthDay {
+			return true
+		}
+	}
+	return false
+}
+
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseRecurrenceErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"INTERVAL=2",
+		"FREQ=YEARLY",
+		"FREQ=DAILY;FREQ=WEEKLY",
+		"FREQ",
+		"FREQ=",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;INTERVAL=two",
+		"FREQ=DAILY;COUNT=0",
+		"FREQ=DAILY;COUNT=-1",
+		"FREQ=DAILY;UNTIL=tomorrow",
+		"FREQ=DAILY;COUNT=3;UNTIL=20300101",
+		"FREQ=WEEKLY;BYDAY=MO,XX",
+		"FREQ=WEEKLY;BYDAY=1MO",
+		"FREQ=MONTHLY;BYMONTHDAY=0",
+		"FREQ=MONTHLY;BYMONTHDAY=32",
+		"FREQ=MONTHLY;BYMONTHDAY=-32",
+		"FREQ=WEEKLY;BYMONTHDAY=1",
+		"FREQ=DAILY;BYSETPOS=1",
+	}
+	for _, rule := range tests {
+		if _, err := parseRecurrence(rule); !errors.Is(err, ErrInvalidTemplate) {
+			t.Errorf("parseRecurrence(%q) = %v, want ErrInvalidTemplate", rule, err)
+		}
+	}
+
+	for _, rule := range []string{"FREQ=DAILY", "RRULE:FREQ=weekly;byday=mo,fr", "FREQ=MONTHLY;BYMONTHDAY=1,-1;UNTIL=2030-12-31"} {
+		if _, err := parseRecurrence(rule); err != nil {
+			t.Errorf("parseRecurrence(%q) = %v, want nil", rule, err)
+		}
+	}
+}
+
+// the occurrences of rule from start, at most limit of them
+func occurrences(t *testing.T, rule string, start time.Time, limit int) []string {
+	t.Helper()
+	r, err := parseRecurrence(rule)
+	if err != nil {
+		t.Fatalf("parseRecurrence(%q): %v", rule, err)
+	}
+	var dates []string
+	r.walk(start, start, 0, func(at time.Time, _ int) bool {
+		dates = append(dates, at.Format("2006-01-02 15:04"))
+		return len(dates) < limit
+	})
+	return dates
+}
+
+func TestRecurrenceWalk(t *testing.T) {
+	// a wednesday
+	start := time.Date(2030, time.January, 30, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		rule string
+		want []string
+	}{
+		{"daily count", "FREQ=DAILY;COUNT=3", []string{"2030-01-30 09:00", "2030-01-31 09:00", "2030-02-01 09:00"}},
+		{"daily interval until", "FREQ=DAILY;INTERVAL=2;UNTIL=20300205", []string{"2030-01-30 09:00", "2030-02-01 09:00", "2030-02-03 09:00", "2030-02-05 09:00"}},
+		{"until before the time of day", "FREQ=DAILY;UNTIL=20300201T080000Z", []string{"2030-01-30 09:00", "2030-01-31 09:00"}},
+		{"weekdays only", "FREQ=DAILY;BYDAY=MO,TU,WE,TH,FR;COUNT=4", []string{"2030-01-30 09:00", "2030-01-31 09:00", "2030-02-01 09:00", "2030-02-04 09:00"}},
+		{"weekly", "FREQ=WEEKLY;COUNT=3", []string{"2030-01-30 09:00", "2030-02-06 09:00", "2030-02-13 09:00"}},
+		{"weekly byday skips days before start", "FREQ=WEEKLY;BYDAY=MO,FR;COUNT=4", []string{"2030-02-01 09:00", "2030-02-04 09:00", "2030-02-08 09:00", "2030-02-11 09:00"}},
+		{"every other week byday", "FREQ=WEEKLY;INTERVAL=2;BYDAY=TH;COUNT=3", []string{"2030-01-31 09:00", "2030-02-14 09:00", "2030-02-28 09:00"}},
+		{"last day of the month", "FREQ=MONTHLY;BYMONTHDAY=-1;COUNT=4", []string{"2030-01-31 09:00", "2030-02-28 09:00", "2030-03-31 09:00", "2030-04-30 09:00"}},
+		{"first and second to last day", "FREQ=MONTHLY;BYMONTHDAY=1,-2;COUNT=4", []string{"2030-01-30 09:00", "2030-02-01 09:00", "2030-02-27 09:00", "2030-03-01 09:00"}},
+		{"mondays of the month", "FREQ=MONTHLY;BYDAY=MO;COUNT=5", []string{"2030-02-04 09:00", "2030-02-11 09:00", "2030-02-18 09:00", "2030-02-25 09:00", "2030-03-04 09:00"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := occurrences(t, tt.rule, start, 20); !slices.Equal(got, tt.want) {
+				t.Errorf("%s from %s = %v, want %v", tt.rule, start, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecurrenceSkipsShortMonths(t *testing.T) {
+	start := time.Date(2031, time.January, 31, 9, 0, 0, 0, time.UTC)
+	want := []string{"2031-01-31 09:00", "2031-03-31 09:00", "2031-05-31 09:00", "2031-07-31 09:00", "2031-08-31 09:00"}
+	if got := occurrences(t, "FREQ=MONTHLY;COUNT=5", start, 20); !slices.Equal(got, want) {
+		t.Errorf("monthly on the 31st = %v, want %v", got, want)
+	}
+
+	// the 30th has no february, leap year or not
+	start = time.Date(2032, time.January, 30, 9, 0, 0, 0, time.UTC)
+	want = []string{"2032-01-30 09:00", "2032-03-30 09:00"}
+	if got := occurrences(t, "FREQ=MONTHLY;BYMONTHDAY=30;COUNT=2", start, 20); !slices.Equal(got, want) {
+		t.Errorf("monthly on the 30th = %v, want %v", got, want)
+	}
+}
+
+func TestRecurrenceKeepsWallClockTime(t *testing.T) {
+	location, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+	// daylight saving time starts on the 30th
+	start := time.Date(2031, time.March, 29, 9, 0, 0, 0, location)
+	want := []string{"2031-03-29 09:00", "2031-03-30 09:00", "2031-03-31 09:00"}
+	if got := occurrences(t, "FREQ=DAILY;COUNT=3", start, 20); !slices.Equal(got, want) {
+		t.Errorf("daily across daylight saving = %v, want %v", got, want)
+	}
+}
+
+// walking on from any occurrence, told how many came before it, finds the
+// same occurrences as walking from the start
+func TestRecurrenceResumesWalk(t *testing.T) {
+	start := time.Date(2030, time.January, 30, 9, 0, 0, 0, time.UTC)
+	rules := []string{
+		"FREQ=DAILY;COUNT=40",
+		"FREQ=DAILY;INTERVAL=3;BYDAY=MO,WE",
+		"FREQ=WEEKLY;INTERVAL=2",
+		"FREQ=WEEKLY;BYDAY=MO,SU;COUNT=25",
+		"FREQ=MONTHLY;BYMONTHDAY=31;UNTIL=20330101",
+		"FREQ=MONTHLY;INTERVAL=5;BYMONTHDAY=1,-1",
+		"FREQ=MONTHLY;BYDAY=FR",
+	}
+	for _, rule := range rules {
+		r, err := parseRecurrence(rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var all []time.Time
+		r.walk(start, start, 0, func(at time.Time, _ int) bool {
+			all = append(all, at)
+			return len(all) < 60
+		})
+
+		for i, from := range all {
+			var resumed []time.Time
+			r.walk(start, from, i, func(at time.Time, seen int) bool {
+				if seen != i+len(resumed) {
+					t.Fatalf("%s from %s: occurrence %s is told %d came before it, want %d", rule, from, at, seen, i+len(resumed))
+				}
+				resumed = append(resumed, at)
+				return i+len(resumed) < len(all)
+			})
+			if !slices.Equal(resumed, all[i:]) {
+				t.Fatalf("%s resumed from %s = %v, want %v", rule, from, resumed, all[i:])
+			}
+
+			// from a time between occurrences too
+			if i > 0 {
+				between := all[i-1].Add(time.Minute)
+				next, before, ok := r.next(start, between, i, between)
+				if !ok || !next.Equal(from) || before != i {
+					t.Fatalf("%s next after %s = %s, %d, %v, want %s, %d", rule, between, next, before, ok, from, i)
+				}
+			}
+		}
+	}
+}
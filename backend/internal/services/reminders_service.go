@@ -0,0 +1,268 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Ghaby-X/tasork/internal/env"
+	"github.com/Ghaby-X/tasork/internal/store"
+	"github.com/Ghaby-X/tasork/internal/utils"
+)
+
+// the point in time a deadline stands for, false for one that is not a date
+func deadlineTime(deadline string) (time.Time, bool) {
+	if deadline == "" {
+		return time.Time{}, false
+	}
+	iso, err := utils.ParseDateToISOString(deadline)
+	if err != nil {
+		return time.Time{}, false
+	}
+	at, err := time.Parse(time.RFC3339, iso)
+	return at, err == nil
+}
+
+// whether a task is past its deadline without being in a final status
+func isOverdue(task store.Task, terminal map[string]bool, now time.Time) bool {
+	deadline, ok := deadlineTime(task.Deadline)
+	return ok && now.After(deadline) && !terminal[task.Status]
+}
+
+// ReminderWorker notifies the assignees of a task as its deadline comes
+// closer, when it is reached and again every so often while it is overdue
+type ReminderWorker struct {
+	tasks    *TasksService
+	clock    Clock
+	interval time.Duration
+	// how long before the deadline each reminder is sent, 0 at the deadline
+	offsets []time.Duration
+	// how often an overdue task is reminded of, never when 0
+	overdueEvery time.Duration
+	// how many overdue reminders a task gets at most
+	overdueLimit int
+	emails       bool
+}
+
+// runs every REMINDER_INTERVAL (5m by default), sending reminders
+// REMINDER_OFFSETS before deadlines (24h and 0s by default) and every
+// REMINDER_OVERDUE_INTERVAL (24h) after them, up to REMINDER_OVERDUE_LIMIT (7)
+// times. REMINDER_EMAILS=false only leaves notifications
+func NewReminderWorker(tasks *TasksService, clock Clock) *ReminderWorker {
+	var offsets []time.Duration
+	for _, value := range strings.Split(env.GetString("REMINDER_OFFSETS", "24h,0s"), ",") {
+		offset, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || offset < 0 {
+			log.Printf("ignoring reminder offset %q", value)
+			continue
+		}
+		offsets = append(offsets, offset)
+	}
+
+	return &ReminderWorker{
+		tasks:        tasks,
+		clock:        clock,
+		interval:     env.GetDuration("REMINDER_INTERVAL", 5*time.Minute),
+		offsets:      offsets,
+		overdueEvery: env.GetDuration("REMINDER_OVERDUE_INTERVAL", 24*time.Hour),
+		overdueLimit: env.GetInt("REMINDER_OVERDUE_LIMIT", 7),
+		emails:       env.GetString("REMINDER_EMAILS", "true") == "true",
+	}
+}
+
+// the latest reminder of a deadline that is due by now, false when none is.
+// Earlier reminders that were missed, say while the worker was down, are
+// not sent late
+func (w *ReminderWorker) dueReminder(deadline, now time.Time) (time.Time, bool) {
+	var latest time.Time
+	found := false
+	for _, offset := range w.offsets {
+		if at := deadline.Add(-offset); !at.After(now) && (!found || at.After(latest)) {
+			latest, found = at, true
+		}
+	}
+	if w.overdueEvery > 0 && w.overdueLimit > 0 {
+		if periods := min(now.Sub(deadline)/w.overdueEvery, time.Duration(w.overdueLimit)); periods >= 1 {
+			if at := deadline.Add(periods * w.overdueEvery); !found || at.After(latest) {
+				latest, found = at, true
+			}
+		}
+	}
+	return latest, found
+}
+
+// the last reminder a deadline gets, after which it is taken off the index
+func (w *ReminderWorker) finalReminder(deadline time.Time) time.Time {
+	if w.overdueEvery > 0 && w.overdueLimit > 0 {
+		return deadline.Add(time.Duration(w.overdueLimit) * w.overdueEvery)
+	}
+	final := deadline
+	for i, offset := range w.offsets {
+		if at := deadline.Add(-offset); i == 0 || at.After(final) {
+			final = at
+		}
+	}
+	return final
+}
+
+// RunOnce sends the reminders that are due and have not been sent yet,
+// returning how many it sent
+func (w *ReminderWorker) RunOnce() (int, error) {
+	now := w.clock.Now().UTC()
+	lead := time.Duration(0)
+	for _, offset := range w.offsets {
+		lead = max(lead, offset)
+	}
+
+	entries, err := w.tasks.store.ListDeadlinesBefore(now.Add(lead + time.Second).Format(time.RFC3339))
+	if err != nil {
+		return 0, err
+	}
+	byTenant := map[string][]store.DeadlineEntry{}
+	for _, entry := range entries {
+		byTenant[entry.TenantID] = append(byTenant[entry.TenantID], entry)
+	}
+
+	sent := 0
+	for tenantId, entries := range byTenant {
+		count, err := w.remindTenant(tenantId, entries, now)
+		sent += count
+		if err != nil {
+			log.Printf("failed to send reminders of %s: %v", tenantId, err)
+		}
+	}
+	return sent, nil
+}
+
+func (w *ReminderWorker) remindTenant(tenantId string, entries []store.DeadlineEntry, now time.Time) (int, error) {
+	terminal, err := w.tasks.workflows.terminalStatuses(tenantId)
+	if err != nil {
+		return 0, err
+	}
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		ids = append(ids, entry.TaskID)
+	}
+	tasks, err := w.tasks.store.GetTasks(tenantId, ids)
+	if err != nil {
+		return 0, err
+	}
+	byId := map[string]store.Task{}
+	for _, task := range tasks {
+		byId[task.TaskID] = task
+	}
+
+	sent := 0
+	for _, entry := range entries {
+		task, ok := byId[entry.TaskID]
+		if !ok || terminal[task.Status] {
+			// finished tasks need no more reminders, and finished is final
+			w.dropDeadline(entry)
+			continue
+		}
+
+		deadline, ok := deadlineTime(task.Deadline)
+		if !ok || deadline.UTC().Format(time.RFC3339) != entry.Deadline {
+			continue
+		}
+		// once the last reminder is out, or there is none to send, the entry
+		// would only be read again on every run
+		final := w.finalReminder(deadline)
+		at, ok := w.dueReminder(deadline, now)
+		if !ok {
+			if !now.Before(final) {
+				w.dropDeadline(entry)
+			}
+			continue
+		}
+
+		err := w.remind(task, deadline, at, now)
+		if err != nil && !errors.Is(err, store.ErrAlreadyExists) {
+			log.Printf("failed to remind of task %s: %v", task.TaskID, err)
+			continue
+		}
+		if err == nil {
+			sent++
+		}
+		if !at.Before(final) {
+			w.dropDeadline(entry)
+		}
+	}
+	return sent, nil
+}
+
+func (w *ReminderWorker) dropDeadline(entry store.DeadlineEntry) {
+	if err := w.tasks.store.DropDeadline(entry); err != nil {
+		log.Printf("failed to drop deadline of task %s: %v", entry.TaskID, err)
+	}
+}
+
+// notifies the assignees of a task, or its author when it has none, and
+// emails the assignees. Each reminder is recorded once, and only sent after
+// it was recorded, so it is never sent twice
+func (w *ReminderWorker) remind(task store.Task, deadline, at, now time.Time) error {
+	var message string
+	switch {
+	case at.Before(deadline):
+		message = fmt.Sprintf("'%s' is due at %s", task.Title, deadline.UTC().Format(time.RFC3339))
+	case at.Equal(deadline):
+		message = fmt.Sprintf("'%s' is due now", task.Title)
+	default:
+		message = fmt.Sprintf("'%s' is overdue, it was due at %s", task.Title, deadline.UTC().Format(time.RFC3339))
+	}
+
+	recipients := task.Assignees
+	if len(recipients) == 0 {
+		recipients = []store.Assignee{{UserID: task.CreatedBy}}
+	}
+	subject := ""
+	if w.emails {
+		subject = fmt.Sprintf("Reminder: %s", task.Title)
+	}
+	var messages []Message
+	for _, recipient := range recipients {
+		messages = append(messages, Message{
+			Type:    NotifyReminder,
+			UserID:  recipient.UserID,
+			Email:   recipient.Email,
+			Text:    message,
+			Subject: subject,
+		})
+	}
+
+	err := w.tasks.store.PutReminder(store.Reminder{
+		TaskID:   task.TaskID,
+		Deadline: deadline.UTC().Format(time.RFC3339),
+		At:       at.UTC().Format(time.RFC3339),
+		SentAt:   now.Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+	w.tasks.notify.Dispatch(messages...)
+	return nil
+}
+
+// Run calls RunOnce straight away and then every interval until ctx is done
+func (w *ReminderWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		sent, err := w.RunOnce()
+		if err != nil {
+			log.Printf("reminder worker failed: %v", err)
+		} else if sent > 0 {
+			log.Printf("reminder worker sent %d reminders", sent)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
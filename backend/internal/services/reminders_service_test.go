@@ -0,0 +1,91 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Ghaby-X/tasork/internal/store"
+	internal_types "github.com/Ghaby-X/tasork/internal/types"
+)
+
+func TestReminderWorkerDropsDeadlineAfterFinalReminder(t *testing.T) {
+	tasks, st := newTestTasks(store.NewMemoryDB())
+	claims := testClaims("TENANT#a", "ann")
+	addTestUser(t, st, "TENANT#a", "ann")
+	err := tasks.CreateTask(&internal_types.CreateTaskDTO{
+		Tasktitle: "report",
+		Deadline:  "2030-01-10T09:00:00Z",
+		Assignees: []internal_types.Assignee{{UserId: "ann"}},
+	}, claims, "task-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := &fakeClock{}
+	worker := &ReminderWorker{
+		tasks:        tasks,
+		clock:        clock,
+		offsets:      []time.Duration{24 * time.Hour, 0},
+		overdueEvery: 24 * time.Hour,
+		overdueLimit: 2,
+	}
+	indexed := func() bool {
+		t.Helper()
+		entries, err := st.Tasks.ListDeadlinesBefore("2031")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(entries) > 0
+	}
+
+	steps := []struct {
+		at      time.Time
+		sent    int
+		indexed bool
+	}{
+		{time.Date(2030, time.January, 9, 9, 0, 0, 0, time.UTC), 1, true},
+		{time.Date(2030, time.January, 9, 10, 0, 0, 0, time.UTC), 0, true},
+		{time.Date(2030, time.January, 10, 9, 0, 0, 0, time.UTC), 1, true},
+		{time.Date(2030, time.January, 11, 9, 0, 0, 0, time.UTC), 1, true},
+		// the second overdue reminder is the last, after it the task is no longer read
+		{time.Date(2030, time.January, 12, 9, 0, 0, 0, time.UTC), 1, false},
+		{time.Date(2030, time.January, 20, 9, 0, 0, 0, time.UTC), 0, false},
+	}
+	for _, step := range steps {
+		clock.now = step.at
+		sent, err := worker.RunOnce()
+		if err != nil {
+			t.Fatalf("at %s: %v", step.at, err)
+		}
+		if sent != step.sent {
+			t.Errorf("at %s sent %d reminders, want %d", step.at, sent, step.sent)
+		}
+		if got := indexed(); got != step.indexed {
+			t.Errorf("at %s the deadline is indexed = %v, want %v", step.at, got, step.indexed)
+		}
+	}
+}
+
+// without overdue reminders the entry goes once the deadline reminder is out,
+// even when the worker was down and that reminder was sent before
+func TestReminderWorkerDropsDeadlineWithoutOverdueReminders(t *testing.T) {
+	tasks, st := newTestTasks(store.NewMemoryDB())
+	addTestUser(t, st, "TENANT#a", "ann")
+	err := tasks.CreateTask(&internal_types.CreateTaskDTO{Tasktitle: "report", Deadline: "2030-01-10T09:00:00Z"}, testClaims("TENANT#a", "ann"), "task-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := &fakeClock{now: time.Date(2030, time.January, 15, 0, 0, 0, 0, time.UTC)}
+	worker := &ReminderWorker{tasks: tasks, clock: clock, offsets: []time.Duration{time.Hour}}
+	if sent, err := worker.RunOnce(); err != nil || sent != 1 {
+		t.Fatalf("sent %d, %v, want the missed reminder once", sent, err)
+	}
+	entries, err := st.Tasks.ListDeadlinesBefore("2031")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("entries after the final reminder = %v, want none", entries)
+	}
+}
@@ -0,0 +1,55 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Ghaby-X/tasork/internal/env"
+)
+
+// Scheduler creates the tasks of recurring templates ahead of time
+type Scheduler struct {
+	templates *TemplatesService
+	clock     Clock
+	interval  time.Duration
+	lookahead time.Duration
+}
+
+// runs every SCHEDULER_INTERVAL (1m by default), creating the tasks that are
+// due within SCHEDULER_LOOKAHEAD (24h by default)
+func NewScheduler(templates *TemplatesService, clock Clock) *Scheduler {
+	return &Scheduler{
+		templates: templates,
+		clock:     clock,
+		interval:  env.GetDuration("SCHEDULER_INTERVAL", time.Minute),
+		lookahead: env.GetDuration("SCHEDULER_LOOKAHEAD", 24*time.Hour),
+	}
+}
+
+// RunOnce creates the tasks of every occurrence due before the lookahead
+// from now, returning how many it created
+func (s *Scheduler) RunOnce() (int, error) {
+	return s.templates.CreateDueTasks(s.clock.Now().Add(s.lookahead))
+}
+
+// Run calls RunOnce straight away and then every interval until ctx is done
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		created, err := s.RunOnce()
+		if err != nil {
+			log.Printf("scheduler failed to create tasks: %v", err)
+		} else if created > 0 {
+			log.Printf("scheduler created %d tasks", created)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -5,15 +5,30 @@ import (
 )
 
 type Services struct {
-	Users *UsersService
-	Tasks *TasksService
-	Auth  *AuthService
+	Users       *UsersService
+	Tasks       *TasksService
+	Auth        *AuthService
+	Workflows   *WorkflowService
+	Comments    *CommentsService
+	Attachments *AttachmentsService
+	Templates   *TemplatesService
 }
 
-func NewService(servicestore *store.Storage) *Services {
+// broker carries notifications to the streams open on every instance,
+// NewLocalBroker() when there is only one. Notifications are emailed through
+// mailer, best a QueuedMailer so requests do not wait on it
+func NewService(servicestore *store.Storage, broker Broker, mailer Mailer) *Services {
+	hub := NewNotificationHub(broker)
+	notify := NewDispatcher(servicestore.Notifications, hub, mailer, SystemClock)
+	workflows := NewWorkflowService(servicestore.Workflows)
+	tasks := NewTaskService(servicestore.Tasks, servicestore.Users, workflows, notify)
 	return &Services{
-		NewUserService(servicestore.Users),
-		NewTaskService(servicestore.Tasks),
-		NewAuthService(servicestore.Auth),
+		NewUserService(servicestore.Users, servicestore.Notifications, hub, notify),
+		tasks,
+		NewAuthService(servicestore.Auth, notify),
+		workflows,
+		NewCommentsService(servicestore.Comments, servicestore.Tasks, servicestore.Users, notify),
+		NewAttachmentsService(servicestore.Attachments, servicestore.Blobs, servicestore.Tasks),
+		NewTemplatesService(servicestore.Templates, tasks, SystemClock),
 	}
 }
@@ -0,0 +1,229 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Ghaby-X/tasork/internal/store"
+	internal_types "github.com/Ghaby-X/tasork/internal/types"
+	"github.com/google/uuid"
+)
+
+// limits of a task's checklist
+const (
+	maxChecklistItems  = 100
+	maxChecklistLength = 500
+)
+
+// builds a checklist from the items sent, in their order. Items sent with an
+// id keep who ticked them, unless they are unticked
+func buildChecklist(items []internal_types.ChecklistItemDTO, current []store.ChecklistItem, user internal_types.TokenClaims) ([]store.ChecklistItem, error) {
+	if len(items) > maxChecklistItems {
+		return nil, fmt.Errorf("%w: at most %d items are allowed", ErrInvalidChecklist, maxChecklistItems)
+	}
+
+	existing := map[string]store.ChecklistItem{}
+	for _, item := range current {
+		existing[item.ID] = item
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	seen := map[string]bool{}
+	checklist := make([]store.ChecklistItem, 0, len(items))
+	for _, data := range items {
+		text := strings.TrimSpace(data.Text)
+		if text == "" {
+			return nil, fmt.Errorf("%w: item text is required", ErrInvalidChecklist)
+		}
+		if len(text) > maxChecklistLength {
+			return nil, fmt.Errorf("%w: item is longer than %d characters", ErrInvalidChecklist, maxChecklistLength)
+		}
+
+		item := store.ChecklistItem{ID: data.Id, Text: text}
+		if data.Id == "" {
+			item.ID = uuid.NewString()
+		} else {
+			previous, ok := existing[data.Id]
+			if !ok {
+				return nil, fmt.Errorf("%w: item %s is not on the task", ErrInvalidChecklist, data.Id)
+			}
+			if seen[data.Id] {
+				return nil, fmt.Errorf("%w: item %s is listed twice", ErrInvalidChecklist, data.Id)
+			}
+			seen[data.Id] = true
+			item.DoneBy, item.DoneAt = previous.DoneBy, previous.DoneAt
+		}
+
+		item.Done = data.Done
+		switch {
+		case !item.Done:
+			item.DoneBy, item.DoneAt = "", ""
+		case item.DoneAt == "":
+			item.DoneBy, item.DoneAt = user["sub"], now
+		}
+		checklist = append(checklist, item)
+	}
+	return checklist, nil
+}
+
+// how much of a checklist is done, as recorded in the history
+func checklistProgress(checklist []store.ChecklistItem) string {
+	done := 0
+	for _, item := range checklist {
+		if item.Done {
+			done++
+		}
+	}
+	return fmt.Sprintf("%d/%d done", done, len(checklist))
+}
+
+// the share of a task that is done, in percent. Each checklist item and each
+// subtask in a final status counts as one part, and a task with neither is
+// done once it is in a final status itself
+func completion(task store.Task, subtasks []store.Task, terminal map[string]bool) int {
+	parts, done := len(task.Checklist)+len(subtasks), 0
+	if parts == 0 {
+		if terminal[task.Status] {
+			return 100
+		}
+		return 0
+	}
+
+	for _, item := range task.Checklist {
+		if item.Done {
+			done++
+		}
+	}
+	for _, subtask := range subtasks {
+		if terminal[subtask.Status] {
+			done++
+		}
+	}
+	return done * 100 / parts
+}
+
+// fills in the subtasks and completion of a single task
+func (s *TasksService) withSubtasks(output *internal_types.GetTasksOutput, task store.Task) error {
+	terminal, err := s.workflows.terminalStatuses(task.TenantID)
+	if err != nil {
+		return err
+	}
+	subtasks, err := s.store.ListSubtasks(task.TenantID, task.TaskID)
+	if err != nil {
+		return err
+	}
+
+	if len(subtasks) > 0 {
+		output.Subtasks = toTaskSummaries(subtasks, terminal)
+	}
+	percent := completion(task, subtasks, terminal)
+	output.Completion = &percent
+	return nil
+}
+
+// a task that blocks on its subtasks may only move to a final status once
+// every subtask is in one
+func (s *TasksService) checkSubtasks(task store.Task, status string) error {
+	if !task.BlockOnSubtasks || status == task.Status {
+		return nil
+	}
+
+	terminal, err := s.workflows.terminalStatuses(task.TenantID)
+	if err != nil {
+		return err
+	}
+	if !terminal[status] {
+		return nil
+	}
+
+	subtasks, err := s.store.ListSubtasks(task.TenantID, task.TaskID)
+	if err != nil {
+		return err
+	}
+	open := 0
+	for _, subtask := range subtasks {
+		if !terminal[subtask.Status] {
+			open++
+		}
+	}
+	if open > 0 {
+		return fmt.Errorf("%w: %d subtasks are not complete yet", ErrIllegalTransition, open)
+	}
+	return nil
+}
+
+// replaces the checklist of a task with the items sent, in their order
+func (s *TasksService) UpdateChecklist(data internal_types.UpdateChecklistDTO, user internal_types.TokenClaims, taskUUID string, ifMatch *int64) (*internal_types.GetTasksOutput, error) {
+	task, err := s.taskForWrite(user["custom:tenantId"], taskUUID, ifMatch)
+	if err != nil {
+		return nil, err
+	}
+
+	checklist, err := buildChecklist(data.Items, task.Checklist, user)
+	if err != nil {
+		return nil, err
+	}
+
+	history := newHistory(taskUUID, user, store.HistoryEdited, task.Status, "updated checklist",
+		[]store.FieldChange{{Field: "checklist", Before: checklistProgress(task.Checklist), After: checklistProgress(checklist)}})
+	if err := s.store.UpdateChecklist(*task, checklist, history); err != nil {
+		return nil, writeError(err, taskUUID, ifMatch)
+	}
+
+	return s.GetOneTaskBytenant(task.TenantID, taskUUID)
+}
+
+// ticks or unticks one checklist item, members only on tasks assigned to them
+func (s *TasksService) CheckItem(data internal_types.CheckItemDTO, user internal_types.TokenClaims, taskUUID, itemId string, ifMatch *int64) (*internal_types.GetTasksOutput, error) {
+	task, err := s.taskForWrite(user["custom:tenantId"], taskUUID, ifMatch)
+	if err != nil {
+		return nil, err
+	}
+	if !HasPermission(user["custom:role"], PermUpdateTaskStatus) && !isAssigned(*task, user["sub"]) {
+		return nil, fmt.Errorf("task %s is not assigned to %s: %w", taskUUID, user["sub"], ErrForbidden)
+	}
+
+	items := make([]internal_types.ChecklistItemDTO, 0, len(task.Checklist))
+	var checked *store.ChecklistItem
+	for i, item := range task.Checklist {
+		done := item.Done
+		if item.ID == itemId {
+			checked, done = &task.Checklist[i], *data.Done
+		}
+		items = append(items, internal_types.ChecklistItemDTO{Id: item.ID, Text: item.Text, Done: done})
+	}
+	if checked == nil {
+		return nil, fmt.Errorf("checklist item %s: %w", itemId, ErrNotFound)
+	}
+	if checked.Done == *data.Done {
+		return s.GetOneTaskBytenant(task.TenantID, taskUUID)
+	}
+
+	checklist, err := buildChecklist(items, task.Checklist, user)
+	if err != nil {
+		return nil, err
+	}
+
+	description := fmt.Sprintf("checked '%s'", checked.Text)
+	if !*data.Done {
+		description = fmt.Sprintf("unchecked '%s'", checked.Text)
+	}
+	history := newHistory(taskUUID, user, store.HistoryEdited, task.Status, description,
+		[]store.FieldChange{{Field: "checklist", Before: checklistProgress(task.Checklist), After: checklistProgress(checklist)}})
+	if err := s.store.UpdateChecklist(*task, checklist, history); err != nil {
+		return nil, writeError(err, taskUUID, ifMatch)
+	}
+
+	return s.GetOneTaskBytenant(task.TenantID, taskUUID)
+}
+
+// checks the parent of a new subtask is a task of the tenant
+func (s *TasksService) checkParent(tenantId, parentId string) error {
+	err := s.taskInTenant(tenantId, parentId)
+	if errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("parent task %s: %w", parentId, ErrNotFound)
+	}
+	return err
+}
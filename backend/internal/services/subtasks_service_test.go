@@ -0,0 +1,81 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Ghaby-X/tasork/internal/store"
+	internal_types "github.com/Ghaby-X/tasork/internal/types"
+)
+
+func TestCompletionAndBlockingOnSubtasks(t *testing.T) {
+	tasks, st := newTestTasks(store.NewMemoryDB())
+	ann := testClaims("TENANT#a", "ann")
+	addTestUser(t, st, "TENANT#a", "ann")
+	parent := &internal_types.CreateTaskDTO{
+		Tasktitle:       "fence",
+		BlockOnSubtasks: true,
+		Checklist:       []internal_types.ChecklistItemDTO{{Text: "posts"}, {Text: "rails"}},
+	}
+	if err := tasks.CreateTask(parent, ann, "parent"); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"north", "south"} {
+		if err := tasks.CreateTask(&internal_types.CreateTaskDTO{Tasktitle: id, ParentId: "parent"}, ann, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	completion := func(want int) *internal_types.GetTasksOutput {
+		t.Helper()
+		task, err := tasks.GetOneTaskBytenant("TENANT#a", "parent")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if task.Completion == nil || *task.Completion != want {
+			t.Errorf("completion = %v, want %d", task.Completion, want)
+		}
+		return task
+	}
+	move := func(id, status string) error {
+		_, err := tasks.UpdateTask(internal_types.PatchTaskDTO{Status: &status}, ann, id, nil)
+		return err
+	}
+	done := true
+
+	// two checklist items and two subtasks, a quarter each
+	task := completion(0)
+	if len(task.Subtasks) != 2 || len(task.Task.Checklist) != 2 {
+		t.Fatalf("parent has %d subtasks and %d checklist items, want 2 of each", len(task.Subtasks), len(task.Task.Checklist))
+	}
+	if _, err := tasks.CheckItem(internal_types.CheckItemDTO{Done: &done}, ann, "parent", task.Task.Checklist[0].Id, nil); err != nil {
+		t.Fatal(err)
+	}
+	completion(25)
+	if err := move("north", "done"); err != nil {
+		t.Fatal(err)
+	}
+	completion(50)
+
+	// south is still open, so the parent cannot be finished either way
+	for _, status := range []string{"done", "cancelled"} {
+		if err := move("parent", status); !errors.Is(err, ErrIllegalTransition) {
+			t.Errorf("moving the parent to %s with a subtask open = %v, want ErrIllegalTransition", status, err)
+		}
+	}
+	if err := move("parent", "in-progress"); err != nil {
+		t.Errorf("moving the parent on to a status that is not final = %v", err)
+	}
+
+	if err := move("south", "cancelled"); err != nil {
+		t.Fatal(err)
+	}
+	task = completion(75)
+	if _, err := tasks.CheckItem(internal_types.CheckItemDTO{Done: &done}, ann, "parent", task.Task.Checklist[1].Id, nil); err != nil {
+		t.Fatal(err)
+	}
+	completion(100)
+	if err := move("parent", "done"); err != nil {
+		t.Errorf("moving the parent to done with every subtask final = %v", err)
+	}
+}
@@ -1,481 +1,662 @@
 package services
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/Ghaby-X/tasork/internal/env"
 	"github.com/Ghaby-X/tasork/internal/store"
 	internal_types "github.com/Ghaby-X/tasork/internal/types"
-	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/Ghaby-X/tasork/internal/utils"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
-	"github.com/google/uuid"
 )
 
 type TasksService struct {
-	store *store.TasksStore
+	store     store.TasksStore
+	users     store.UsersStore
+	workflows *WorkflowService
+	notify    *Dispatcher
 }
 
-func NewTaskService(taskstore *store.TasksStore) *TasksService {
-	return &TasksService{taskstore}
+func NewTaskService(taskstore store.TasksStore, userstore store.UsersStore, workflows *WorkflowService, notify *Dispatcher) *TasksService {
+	return &TasksService{taskstore, userstore, workflows, notify}
 }
 
-func (s *TasksService) CreateTask(data *internal_types.CreateTaskDTO, user internal_types.TokenClaims, taskUUID string, customMessage ...string) error {
-	taskId := "TASK#" + taskUUID
-	createdBy := "USER#" + user["sub"]
-	tenantId := user["custom:tenantId"]
-
-	// creation of task
-	inputItem := map[string]types.AttributeValue{
-		"PartitionKey": &types.AttributeValueMemberS{Value: tenantId},
-		"SortKey":      &types.AttributeValueMemberS{Value: taskId},
-		"tasktitle":    &types.AttributeValueMemberS{Value: data.Tasktitle},
-		"description":  &types.AttributeValueMemberS{Value: data.TaskDescription},
-		"status":       &types.AttributeValueMemberS{Value: data.Status},
-		"deadline":     &types.AttributeValueMemberS{Value: data.Deadline},
-		"createdAt":    &types.AttributeValueMemberS{Value: data.CreatedAt},
-		"createdby":    &types.AttributeValueMemberS{Value: createdBy},
-	}
-
-	// write request for batch writes
-	writeRequests := []types.WriteRequest{
-		{
-			PutRequest: &types.PutRequest{
-				Item: inputItem,
-			},
-		},
+// checks a user belongs to the tenant, users of other tenants are not found
+func (s *TasksService) userInTenant(tenantId, userId string) error {
+	_, err := s.users.GetUser(tenantId, userId)
+	if errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("user %s: %w", userId, ErrNotFound)
 	}
+	return err
+}
 
-	for _, userStruct := range data.Assignees {
-		writeRequests = append(writeRequests,
-			// write tasks users
-			types.WriteRequest{
-				PutRequest: &types.PutRequest{
-					Item: map[string]types.AttributeValue{
-						"PartitionKey": &types.AttributeValueMemberS{Value: taskId},
-						"SortKey":      &types.AttributeValueMemberS{Value: userStruct.UserId},
-						"tasktitle":    &types.AttributeValueMemberS{Value: data.Tasktitle},
-						"description":  &types.AttributeValueMemberS{Value: data.TaskDescription},
-						"status":       &types.AttributeValueMemberS{Value: data.Status},
-						"deadline":     &types.AttributeValueMemberS{Value: data.Deadline},
-						"createdAt":    &types.AttributeValueMemberS{Value: data.CreatedAt},
-						"createdby":    &types.AttributeValueMemberS{Value: createdBy},
-						"userName":     &types.AttributeValueMemberS{Value: userStruct.Username},
-						"email":        &types.AttributeValueMemberS{Value: userStruct.Email},
-					},
-				},
-			},
-
-			types.WriteRequest{
-				PutRequest: &types.PutRequest{
-					Item: map[string]types.AttributeValue{
-						"PartitionKey": &types.AttributeValueMemberS{Value: userStruct.UserId},
-						"SortKey":      &types.AttributeValueMemberS{Value: taskId},
-						"tasktitle":    &types.AttributeValueMemberS{Value: data.Tasktitle},
-						"description":  &types.AttributeValueMemberS{Value: data.TaskDescription},
-						"status":       &types.AttributeValueMemberS{Value: data.Status},
-						"deadline":     &types.AttributeValueMemberS{Value: data.Deadline},
-						"createdAt":    &types.AttributeValueMemberS{Value: data.CreatedAt},
-						"createdby":    &types.AttributeValueMemberS{Value: createdBy},
-						"email":        &types.AttributeValueMemberS{Value: userStruct.Email},
-						"userName":     &types.AttributeValueMemberS{Value: userStruct.Username},
-					},
-				},
-			},
-			// Notification Item
-			types.WriteRequest{
-				PutRequest: &types.PutRequest{
-					Item: map[string]types.AttributeValue{
-						"PartitionKey": &types.AttributeValueMemberS{Value: userStruct.UserId},
-						"SortKey":      &types.AttributeValueMemberS{Value: "NOTIFICATION#" + uuid.NewString()},
-						"message":      &types.AttributeValueMemberS{Value: fmt.Sprintf("'%s' has been assigned to you", data.Tasktitle)},
-						"time":         &types.AttributeValueMemberS{Value: time.Now().Format(time.RFC3339)},
-					},
-				},
-			},
-		)
-	}
-
-	tableName := env.GetString("DYNAMODB_TABLE_NAME", "tasork")
-	WriteItemInput := &dynamodb.BatchWriteItemInput{
-		RequestItems: map[string][]types.WriteRequest{
-			tableName: writeRequests,
-		},
+// a user of the tenant as an assignee, with the name and email the users
+// store has for them rather than whatever a request claims. Users of other
+// tenants are not found
+func (s *TasksService) tenantAssignee(tenantId, userId string) (store.Assignee, error) {
+	row, err := s.users.GetUser(tenantId, userId)
+	if errors.Is(err, store.ErrNotFound) {
+		return store.Assignee{}, fmt.Errorf("user %s: %w", userId, ErrNotFound)
 	}
-	_, err := s.store.BatchWriteData(WriteItemInput)
 	if err != nil {
-		log.Printf("failed to create tenant in database\nError: %v\n", err)
-		return err
+		return store.Assignee{}, err
 	}
-	return nil
+
+	var member struct {
+		UserName string `dynamodbav:"userName"`
+		Email    string `dynamodbav:"email"`
+	}
+	if err := attributevalue.UnmarshalMap(row, &member); err != nil {
+		return store.Assignee{}, fmt.Errorf("failed to unmarshal user: %w", err)
+	}
+	return store.Assignee{UserID: userId, Username: member.UserName, Email: member.Email}, nil
 }
 
-func (s *TasksService) GetAllTaskBytenant(tenantId string, tableName string) ([]internal_types.GetTasksOutput, error) {
-	taskQuery := dynamodb.QueryInput{
-		TableName:              &tableName,
-		KeyConditionExpression: aws.String("PartitionKey = :pkey AND begins_with(SortKey, :skprefix)"),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":pkey":     &types.AttributeValueMemberS{Value: tenantId},
-			":skprefix": &types.AttributeValueMemberS{Value: "TASK#"},
-		},
+// checks a task belongs to the tenant, tasks of other tenants are not found
+func (s *TasksService) taskInTenant(tenantId, taskId string) error {
+	_, err := s.store.GetTask(tenantId, taskId)
+	if errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("task %s: %w", taskId, ErrNotFound)
 	}
+	return err
+}
 
-	taskQueryOutput, err := s.store.QueryTask(taskQuery)
-	if err != nil {
-		return nil, err
+// converts a stored task and its assignees into the api response shape
+func toTaskOutput(task store.Task) internal_types.GetTasksOutput {
+	output := internal_types.GetTasksOutput{
+		Task: internal_types.QueryTasksOutput{
+			PartitionKey:    task.TenantID,
+			SortKey:         store.TaskKey(task.TaskID),
+			CreatedAt:       task.CreatedAt,
+			Createdby:       store.UserKey(task.CreatedBy),
+			Deadline:        task.Deadline,
+			Description:     task.Description,
+			Status:          task.Status,
+			Tasktitle:       task.Title,
+			Version:         task.Version,
+			Checklist:       make([]internal_types.ChecklistItemOutput, 0, len(task.Checklist)),
+			BlockOnSubtasks: task.BlockOnSubtasks,
+		},
+		Assignee: make([]internal_types.TaskAssignee, 0, len(task.Assignees)),
+	}
+	if task.ParentID != "" {
+		output.Task.ParentId = store.TaskKey(task.ParentID)
+	}
+	for _, blockerId := range task.BlockedBy {
+		output.Task.BlockedBy = append(output.Task.BlockedBy, store.TaskKey(blockerId))
+	}
+	for _, item := range task.Checklist {
+		output.Task.Checklist = append(output.Task.Checklist, internal_types.ChecklistItemOutput{
+			Id:     item.ID,
+			Text:   item.Text,
+			Done:   item.Done,
+			DoneBy: item.DoneBy,
+			DoneAt: item.DoneAt,
+		})
 	}
 
-	// converts task to go struct
-	var tasks []internal_types.QueryTasksOutput
-	if err := attributevalue.UnmarshalListOfMaps(taskQueryOutput.Items, &tasks); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal tasks: %w", err)
+	for _, assignee := range task.Assignees {
+		output.Assignee = append(output.Assignee, internal_types.TaskAssignee{
+			Username: assignee.Username,
+			Email:    assignee.Email,
+			SortKey:  store.UserKey(assignee.UserID),
+		})
 	}
 
-	var results []internal_types.GetTasksOutput // defining results
+	return output
+}
 
-	// append tasks and their assignees
-	for _, task := range tasks {
-		userPK := task.SortKey // gets task id
-		userInput := dynamodb.QueryInput{
-			TableName:              &tableName,
-			KeyConditionExpression: aws.String("PartitionKey = :pkey AND begins_with(SortKey, :skprefix)"),
-			ExpressionAttributeValues: map[string]types.AttributeValue{
-				":pkey":     &types.AttributeValueMemberS{Value: userPK},
-				":skprefix": &types.AttributeValueMemberS{Value: "USER#"},
-			},
+func (s *TasksService) CreateTask(data *internal_types.CreateTaskDTO, user internal_types.TokenClaims, taskUUID string, customMessage ...string) error {
+	deadline, err := normalizeDeadline(data.Deadline)
+	if err != nil {
+		return err
+	}
+	status, err := s.workflows.createStatus(user["custom:tenantId"], data.Status)
+	if err != nil {
+		return err
+	}
+
+	if data.ParentId != "" {
+		if err := s.checkParent(user["custom:tenantId"], data.ParentId); err != nil {
+			return err
 		}
+	}
+	checklist, err := buildChecklist(data.Checklist, nil, user)
+	if err != nil {
+		return err
+	}
 
-		userOutput, err := s.store.QueryTask(userInput)
-		if err != nil {
-			return nil, err
+	task := store.Task{
+		TenantID:        user["custom:tenantId"],
+		TaskID:          taskUUID,
+		ParentID:        strings.TrimPrefix(data.ParentId, "TASK#"),
+		Title:           data.Tasktitle,
+		Description:     data.TaskDescription,
+		Status:          status,
+		Deadline:        deadline,
+		CreatedAt:       time.Now().UTC().Format(time.RFC3339),
+		CreatedBy:       user["sub"],
+		Checklist:       checklist,
+		BlockOnSubtasks: data.BlockOnSubtasks,
+	}
+
+	// the created task is its first change, from nothing to its initial values
+	var changed []store.FieldChange
+	for _, field := range [][2]string{{"title", task.Title}, {"description", task.Description}, {"status", task.Status}, {"deadline", task.Deadline}} {
+		if field[1] != "" {
+			changed = append(changed, store.FieldChange{Field: field[0], After: field[1]})
 		}
+	}
+	if task.ParentID != "" {
+		changed = append(changed, store.FieldChange{Field: "parent", After: store.TaskKey(task.ParentID)})
+	}
+	if len(checklist) > 0 {
+		changed = append(changed, store.FieldChange{Field: "checklist", After: checklistProgress(checklist)})
+	}
 
-		var TaskAssignee []internal_types.TaskAssignee
-		if err := attributevalue.UnmarshalListOfMaps(userOutput.Items, &TaskAssignee); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal users for task %s: %w", task.SortKey, err)
+	var assignees []store.Assignee
+	var messages []Message
+	for _, userStruct := range data.Assignees {
+		assignee, err := s.tenantAssignee(task.TenantID, userStruct.UserId)
+		if err != nil {
+			return err
 		}
+		assignees = append(assignees, assignee)
 
-		results = append(results, internal_types.GetTasksOutput{
-			Task:     task,
-			Assignee: TaskAssignee,
+		messages = append(messages, Message{
+			Type:   NotifyAssigned,
+			UserID: assignee.UserID,
+			Email:  assignee.Email,
+			Text:   fmt.Sprintf("'%s' has been assigned to you", data.Tasktitle),
 		})
+		changed = append(changed, store.FieldChange{Field: "assignee", After: store.UserKey(assignee.UserID)})
+	}
+
+	err = s.store.PutTask(store.TaskWrite{
+		Task:      task,
+		Assignees: assignees,
+		History:   newHistory(taskUUID, user, store.HistoryCreated, task.Status, "created task", changed),
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("parent task %s: %w", data.ParentId, ErrNotFound)
+	}
+	if err != nil {
+		log.Printf("failed to create tenant in database\nError: %v\n", err)
+		return err
+	}
+	s.notify.Dispatch(messages...)
+	return nil
+}
+
+// deadlines are saved in UTC RFC3339, so they sort and filter as strings. A
+// date without a time is due at its start, and an empty deadline is none
+func normalizeDeadline(deadline string) (string, error) {
+	if deadline == "" {
+		return "", nil
+	}
+	iso, err := utils.ParseDateToISOString(deadline)
+	if err != nil {
+		return "", fmt.Errorf("%q is not a date: %w", deadline, ErrInvalidDeadline)
 	}
+	return iso, nil
+}
 
-	return results, nil
+// maps a store listing error, a bad cursor is the caller's fault
+func listError(err error) error {
+	if errors.Is(err, store.ErrInvalidCursor) {
+		return ErrInvalidCursor
+	}
+	return err
 }
 
-func (s *TasksService) GetOneTaskBytenant(tenantId string, tableName string, taskId string) (*internal_types.GetTasksOutput, error) {
-	taskIdRefactor := "TASK#" + taskId
-	taskQuery := dynamodb.QueryInput{
-		TableName:              &tableName,
-		KeyConditionExpression: aws.String("PartitionKey = :pkey AND begins_with(SortKey, :skprefix)"),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":pkey":     &types.AttributeValueMemberS{Value: tenantId},
-			":skprefix": &types.AttributeValueMemberS{Value: taskIdRefactor},
-		},
+func (s *TasksService) GetAllTaskBytenant(tenantId string, filter internal_types.TaskListQuery, page internal_types.PageQuery) (*internal_types.Page[internal_types.GetTasksOutput], error) {
+	query := store.TaskQuery{
+		Status:         filter.Status,
+		AssigneeID:     filter.Assignee,
+		CreatedBy:      filter.CreatedBy,
+		DeadlineAfter:  filter.DeadlineAfter,
+		DeadlineBefore: filter.DeadlineBefore,
+		TitleContains:  filter.Title,
+		SortBy:         filter.SortBy,
+		Descending:     filter.Descending,
 	}
 
-	// query for tasks
-	taskQueryOutput, err := s.store.QueryTask(taskQuery)
+	tasks, next, err := s.store.ListTasksByTenant(tenantId, query, store.PageRequest{Limit: page.Limit, Cursor: page.Cursor})
 	if err != nil {
-		return nil, err
+		return nil, listError(err)
 	}
 
-	// converts task to go struct
-	var tasks []internal_types.QueryTasksOutput
-	if err := attributevalue.UnmarshalListOfMaps(taskQueryOutput.Items, &tasks); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal tasks: %w", err)
+	results, err := s.taskOutputs(tenantId, tasks)
+	if err != nil {
+		return nil, err
 	}
+	return &internal_types.Page[internal_types.GetTasksOutput]{Items: results, NextCursor: next}, nil
+}
 
-	if len(tasks) == 0 {
-		return nil, nil
+func (s *TasksService) GetOneTaskBytenant(tenantId string, taskId string) (*internal_types.GetTasksOutput, error) {
+	task, err := s.store.GetTask(tenantId, taskId)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, fmt.Errorf("task %s: %w", taskId, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	resultTask := tasks[0]
-	var results internal_types.GetTasksOutput // defining results
+	results, err := s.taskOutputs(tenantId, []store.Task{*task})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.withSubtasks(&results[0], *task); err != nil {
+		return nil, err
+	}
+	return &results[0], nil
+}
 
-	results.Task = resultTask
+func (s *TasksService) GetAllTaskByUser(tenantId string, userpKey string, page internal_types.PageQuery) (*internal_types.Page[internal_types.GetTasksOutput], error) {
+	if err := s.userInTenant(tenantId, userpKey); err != nil {
+		return nil, err
+	}
 
-	// append tasks and their assignees
-	taskPK := resultTask.SortKey // gets task id
-	userInput := dynamodb.QueryInput{
-		TableName:              &tableName,
-		KeyConditionExpression: aws.String("PartitionKey = :pkey AND begins_with(SortKey, :skprefix)"),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":pkey":     &types.AttributeValueMemberS{Value: taskPK},
-			":skprefix": &types.AttributeValueMemberS{Value: "USER#"},
-		},
+	tasks, next, err := s.store.ListTasksByAssignee(userpKey, store.PageRequest{Limit: page.Limit, Cursor: page.Cursor})
+	if err != nil {
+		return nil, listError(err)
 	}
 
-	// get assignees
-	userOutput, err := s.store.QueryTask(userInput)
+	results, err := s.taskOutputs(tenantId, tasks)
 	if err != nil {
 		return nil, err
 	}
+	return &internal_types.Page[internal_types.GetTasksOutput]{Items: results, NextCursor: next}, nil
+}
 
-	var TaskAssignee []internal_types.TaskAssignee
-	if err := attributevalue.UnmarshalListOfMaps(userOutput.Items, &TaskAssignee); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal users for task %s: %w", resultTask.SortKey, err)
+// listed tasks already carry their assignees, so no further reads are needed
+func toTaskOutputs(tasks []store.Task) []internal_types.GetTasksOutput {
+	results := make([]internal_types.GetTasksOutput, 0, len(tasks)) // defining results
+	for _, task := range tasks {
+		results = append(results, toTaskOutput(task))
 	}
+	return results
+}
 
-	log.Printf("%v", TaskAssignee)
+// reads a task for a write based on the caller's If-Match version, if any
+func (s *TasksService) taskForWrite(tenantId, taskUUID string, ifMatch *int64) (*store.Task, error) {
+	task, err := s.store.GetTask(tenantId, taskUUID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, fmt.Errorf("task %s: %w", taskUUID, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ifMatch != nil && *ifMatch != task.Version {
+		return nil, fmt.Errorf("task %s is at version %d: %w", taskUUID, task.Version, ErrPreconditionFailed)
+	}
+	return task, nil
+}
 
-	results = internal_types.GetTasksOutput{
-		Task:     resultTask,
-		Assignee: TaskAssignee,
+// whether the user is one of the task's assignees
+func isAssigned(task store.Task, userId string) bool {
+	for _, assignee := range task.Assignees {
+		if store.UserKey(assignee.UserID) == store.UserKey(userId) {
+			return true
+		}
 	}
+	return false
+}
 
-	return &results, nil
+// a history entry of an action the user takes on a task now
+func newHistory(taskUUID string, user internal_types.TokenClaims, action, status, description string, changes []store.FieldChange) *store.HistoryEntry {
+	now := time.Now().UTC()
+	return &store.HistoryEntry{
+		TaskID:            taskUUID,
+		HistoryID:         store.NewHistoryID(now),
+		Action:            action,
+		Status:            status,
+		UpdatedBy:         user["sub"],
+		UpdatedAt:         now.Format(time.RFC3339),
+		UpdateDescription: description,
+		Changes:           changes,
+	}
 }
 
-func (s *TasksService) GetAllTaskByUser(tenantId string, tableName string, userpKey string) ([]internal_types.GetTasksOutput, error) {
-	userpKeyRefactored := "USER#" + userpKey
+// maps a store error of a versioned write, a lost race is only a failed
+// precondition when the caller asked for one
+func writeError(err error, taskUUID string, ifMatch *int64) error {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return fmt.Errorf("task %s: %w", taskUUID, ErrNotFound)
+	case errors.Is(err, store.ErrVersionConflict) && ifMatch != nil:
+		return fmt.Errorf("task %s: %w", taskUUID, ErrPreconditionFailed)
+	case errors.Is(err, store.ErrVersionConflict):
+		return fmt.Errorf("task %s was modified concurrently: %w", taskUUID, ErrConflict)
+	}
+	return err
+}
 
-	// define query input
-	taskQuery := dynamodb.QueryInput{
-		TableName:              &tableName,
-		KeyConditionExpression: aws.String("PartitionKey = :pkey AND begins_with(SortKey, :skprefix)"),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":pkey":     &types.AttributeValueMemberS{Value: userpKeyRefactored},
-			":skprefix": &types.AttributeValueMemberS{Value: "TASK#"},
-		},
+func (s *TasksService) DeleteTask(taskId, tenantId string) error {
+	err := s.store.DeleteTask(tenantId, taskId)
+	if errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("task %s: %w", taskId, ErrNotFound)
+	}
+	if errors.Is(err, store.ErrVersionConflict) {
+		return fmt.Errorf("a task blocked by %s was modified concurrently: %w", taskId, ErrConflict)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete task: %w", err)
 	}
 
-	taskQueryOutput, err := s.store.QueryTask(taskQuery) // make query
+	return nil
+}
+
+// applies a partial update to a task and all of its copies. A non nil ifMatch
+// is the version the caller last saw
+func (s *TasksService) UpdateTask(data internal_types.PatchTaskDTO, user internal_types.TokenClaims, taskUUID string, ifMatch *int64) (*internal_types.GetTasksOutput, error) {
+	task, err := s.taskForWrite(user["custom:tenantId"], taskUUID, ifMatch)
 	if err != nil {
 		return nil, err
 	}
-
-	// converts task to go struct
-	var tasks []internal_types.QueryTasksOutput
-	if err := attributevalue.UnmarshalListOfMaps(taskQueryOutput.Items, &tasks); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal tasks: %w", err)
+	changes, history, err := s.taskChanges(*task, data, user)
+	if err != nil {
+		return nil, err
 	}
 
-	var results []internal_types.GetTasksOutput // defining results
+	err = s.store.UpdateTask(*task, changes, history)
+	if err != nil {
+		return nil, writeError(err, taskUUID, ifMatch)
+	}
 
-	// append tasks and their assignees
-	for _, task := range tasks {
-		userPK := task.SortKey // gets task id
-		userInput := dynamodb.QueryInput{
-			TableName:              &tableName,
-			KeyConditionExpression: aws.String("PartitionKey = :pkey AND begins_with(SortKey, :skprefix)"),
-			ExpressionAttributeValues: map[string]types.AttributeValue{
-				":pkey":     &types.AttributeValueMemberS{Value: userPK},
-				":skprefix": &types.AttributeValueMemberS{Value: "USER#"},
-			},
-		}
+	return s.GetOneTaskBytenant(task.TenantID, taskUUID)
+}
 
-		userOutput, err := s.store.QueryTask(userInput)
+// the store changes of a partial update of task and its history entry, nil
+// when no field changes. The update is checked against the workflow, the
+// subtasks and the blockers of the task
+func (s *TasksService) taskChanges(task store.Task, data internal_types.PatchTaskDTO, user internal_types.TokenClaims) (store.TaskChanges, *store.HistoryEntry, error) {
+	if data.Deadline != nil {
+		deadline, err := normalizeDeadline(*data.Deadline)
 		if err != nil {
-			return nil, err
+			return store.TaskChanges{}, nil, err
 		}
+		data.Deadline = &deadline
+	}
 
-		var TaskAssignee []internal_types.TaskAssignee
-		if err := attributevalue.UnmarshalListOfMaps(userOutput.Items, &TaskAssignee); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal users for task %s: %w", task.SortKey, err)
-		}
+	changes := store.TaskChanges{
+		Title:           data.Tasktitle,
+		Description:     data.TaskDescription,
+		Status:          data.Status,
+		Deadline:        data.Deadline,
+		BlockOnSubtasks: data.BlockOnSubtasks,
+	}
 
-		results = append(results, internal_types.GetTasksOutput{
-			Task:     task,
-			Assignee: TaskAssignee,
+	status := task.Status
+	if data.Status != nil {
+		if err := s.workflows.checkTransition(task.TenantID, user["custom:role"], task.Status, *data.Status); err != nil {
+			return store.TaskChanges{}, nil, err
+		}
+		status = *data.Status
+	}
+	// checked against the flag as it will be after the update
+	blocking := task
+	if data.BlockOnSubtasks != nil {
+		blocking.BlockOnSubtasks = *data.BlockOnSubtasks
+	}
+	if err := s.checkSubtasks(blocking, status); err != nil {
+		return store.TaskChanges{}, nil, err
+	}
+	if err := s.checkBlockers(task, status); err != nil {
+		return store.TaskChanges{}, nil, err
+	}
+
+	// record the fields whose value actually changes
+	var changed []store.FieldChange
+	var names []string
+	for _, field := range []struct {
+		name   string
+		before string
+		after  *string
+	}{
+		{"title", task.Title, data.Tasktitle},
+		{"description", task.Description, data.TaskDescription},
+		{"status", task.Status, data.Status},
+		{"deadline", task.Deadline, data.Deadline},
+	} {
+		if field.after != nil && *field.after != field.before {
+			changed = append(changed, store.FieldChange{Field: field.name, Before: field.before, After: *field.after})
+			names = append(names, field.name)
+		}
+	}
+	if data.BlockOnSubtasks != nil && *data.BlockOnSubtasks != task.BlockOnSubtasks {
+		changed = append(changed, store.FieldChange{
+			Field:  "blockOnSubtasks",
+			Before: strconv.FormatBool(task.BlockOnSubtasks),
+			After:  strconv.FormatBool(*data.BlockOnSubtasks),
 		})
+		names = append(names, "blockOnSubtasks")
 	}
 
-	return results, nil
+	var history *store.HistoryEntry
+	switch {
+	case len(changed) == 1 && changed[0].Field == "status":
+		history = newHistory(task.TaskID, user, store.HistoryStatusChanged, status, fmt.Sprintf("changed status to %s", status), changed)
+	case len(changed) > 0:
+		history = newHistory(task.TaskID, user, store.HistoryEdited, status, "updated "+strings.Join(names, ", "), changed)
+	}
+	return changes, history, nil
 }
 
-func (s *TasksService) DeleteTask(taskId, tenantId, tableName string) error {
-	tenantKey := tenantId
-	taskKey := fmt.Sprintf("TASK#%s", taskId)
-
-	// Delete the task item
-	_, err := s.store.DeleteItem(&dynamodb.DeleteItemInput{
-		TableName: aws.String(tableName),
-		Key: map[string]types.AttributeValue{
-			"PartitionKey": &types.AttributeValueMemberS{Value: tenantKey},
-			"SortKey":      &types.AttributeValueMemberS{Value: taskKey},
-		},
-	})
+// changes only the status attribute of a task and its copies
+func (s *TasksService) UpdateTaskStatus(data internal_types.CreateTaskHistory, user internal_types.TokenClaims, taskUUID string, ifMatch *int64) error {
+	task, err := s.taskForWrite(user["custom:tenantId"], taskUUID, ifMatch)
 	if err != nil {
-		return fmt.Errorf("failed to delete task: %w", err)
+		return err
 	}
-
-	// Get All users assigned to task
-	queryInput := dynamodb.QueryInput{
-		TableName:              aws.String(tableName),
-		KeyConditionExpression: aws.String("PartitionKey = :pk AND begins_with(SortKey, :skprefix)"),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":pk":       &types.AttributeValueMemberS{Value: taskKey},
-			":skprefix": &types.AttributeValueMemberS{Value: "USER#"},
-		},
+	if !HasPermission(user["custom:role"], PermUpdateTaskStatus) && !isAssigned(*task, user["sub"]) {
+		return fmt.Errorf("task %s is not assigned to %s: %w", taskUUID, user["sub"], ErrForbidden)
 	}
-	queryOutput, err := s.store.QueryTask(queryInput)
-	if err != nil {
-		return fmt.Errorf("failed to query user assignments: %w", err)
+	if err := s.workflows.checkTransition(task.TenantID, user["custom:role"], task.Status, data.Status); err != nil {
+		return err
+	}
+	if err := s.checkSubtasks(*task, data.Status); err != nil {
+		return err
+	}
+	if err := s.checkBlockers(*task, data.Status); err != nil {
+		return err
 	}
 
-	// Delete all User#... entries from Task#<taskId> and Task#<taskId> entries from User#...
-	for _, item := range queryOutput.Items {
-		var userId string
-		if skAttr, ok := item["SortKey"].(*types.AttributeValueMemberS); ok {
-			log.Printf("userId: %v", skAttr.Value)
-			userId = skAttr.Value
-		}
+	// a note on a task staying in its status is still recorded, without a change
+	var changed []store.FieldChange
+	if data.Status != task.Status {
+		changed = append(changed, store.FieldChange{Field: "status", Before: task.Status, After: data.Status})
+	}
 
-		// Delete User# from Task#
-		_, err := s.store.DeleteItem(&dynamodb.DeleteItemInput{
-			TableName: aws.String(tableName),
-			Key: map[string]types.AttributeValue{
-				"PartitionKey": &types.AttributeValueMemberS{Value: taskKey},
-				"SortKey":      &types.AttributeValueMemberS{Value: userId},
-			},
-		})
-		if err != nil {
-			log.Printf("warning: failed to delete user-task assignment: %v", err)
-		}
+	history := newHistory(taskUUID, user, store.HistoryStatusChanged, data.Status, data.UpdateDescription, changed)
+	err = s.store.UpdateTask(*task, store.TaskChanges{Status: &data.Status}, history)
+	return writeError(err, taskUUID, ifMatch)
+}
 
-		// Delete Task# from User# (reverse mapping)
-		_, err = s.store.DeleteItem(&dynamodb.DeleteItemInput{
-			TableName: aws.String(tableName),
-			Key: map[string]types.AttributeValue{
-				"PartitionKey": &types.AttributeValueMemberS{Value: userId},
-				"SortKey":      &types.AttributeValueMemberS{Value: taskKey},
-			},
-		})
-		if err != nil {
-			log.Printf("warning: failed to delete reverse user-task mapping: %v", err)
-		}
+// assigns one more user to a task and notifies only them. Only the user id
+// of data is used, their name and email come from the users store
+func (s *TasksService) AddAssignee(data internal_types.Assignee, user internal_types.TokenClaims, taskUUID string, ifMatch *int64) error {
+	task, err := s.taskForWrite(user["custom:tenantId"], taskUUID, ifMatch)
+	if err != nil {
+		return err
+	}
+	assignee, err := s.tenantAssignee(task.TenantID, data.UserId)
+	if err != nil {
+		return err
 	}
 
+	err = s.store.AddAssignee(*task, assignee,
+		*newHistory(taskUUID, user, store.HistoryReassigned, task.Status, fmt.Sprintf("assigned %s", assignee.Username),
+			[]store.FieldChange{{Field: "assignee", After: store.UserKey(assignee.UserID)}}),
+	)
+	if errors.Is(err, store.ErrAlreadyExists) {
+		return fmt.Errorf("user already assigned: %w", ErrConflict)
+	}
+	if err != nil {
+		return writeError(err, taskUUID, ifMatch)
+	}
+	s.notify.Dispatch(Message{
+		Type:   NotifyAssigned,
+		UserID: assignee.UserID,
+		Email:  assignee.Email,
+		Text:   fmt.Sprintf("'%s' has been assigned to you", task.Title),
+	})
 	return nil
 }
 
-func (s *TasksService) UpdateTaskStatus(data internal_types.CreateTaskHistory, user internal_types.TokenClaims, tableName, taskUUID string) error {
-	taskId := "TASK#" + taskUUID
-	createdBy := "USER#" + user["sub"]
-	tenantId := user["custom:tenantId"]
-
-	historyUUID := uuid.NewString()
-	historyId := "HISTORY#" + historyUUID
+// unassigns a user from a task and notifies only them
+func (s *TasksService) RemoveAssignee(userId string, user internal_types.TokenClaims, taskUUID string, ifMatch *int64) error {
+	task, err := s.taskForWrite(user["custom:tenantId"], taskUUID, ifMatch)
+	if err != nil {
+		return err
+	}
 
-	// update status of particular task
-	inputItem := map[string]types.AttributeValue{
-		"PartitionKey": &types.AttributeValueMemberS{Value: tenantId},
-		"SortKey":      &types.AttributeValueMemberS{Value: taskId},
-		"status":       &types.AttributeValueMemberS{Value: data.Status},
+	// name the removed user in the history entry
+	username, email, assigned := "", "", false
+	for _, assignee := range task.Assignees {
+		if store.UserKey(assignee.UserID) == store.UserKey(userId) {
+			username, email, assigned = assignee.Username, assignee.Email, true
+		}
+	}
+	if !assigned {
+		return fmt.Errorf("assignee %s: %w", userId, ErrNotFound)
 	}
 
-	HistoryInputItem := map[string]types.AttributeValue{
-		"PartitionKey":      &types.AttributeValueMemberS{Value: taskId},
-		"SortKey":           &types.AttributeValueMemberS{Value: historyId},
-		"status":            &types.AttributeValueMemberS{Value: data.Status},
-		"updatedby":         &types.AttributeValueMemberS{Value: createdBy},
-		"updatedAt":         &types.AttributeValueMemberS{Value: data.UpdatedAt},
-		"updateDescription": &types.AttributeValueMemberS{Value: data.UpdateDescription},
+	err = s.store.RemoveAssignee(*task, userId,
+		*newHistory(taskUUID, user, store.HistoryReassigned, task.Status, fmt.Sprintf("unassigned %s", username),
+			[]store.FieldChange{{Field: "assignee", Before: store.UserKey(userId)}}),
+	)
+	if errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("assignee %s: %w", userId, ErrNotFound)
 	}
+	if err != nil {
+		return writeError(err, taskUUID, ifMatch)
+	}
+	s.notify.Dispatch(Message{
+		Type:   NotifyUnassigned,
+		UserID: userId,
+		Email:  email,
+		Text:   fmt.Sprintf("You have been removed from '%s'", task.Title),
+	})
+	return nil
+}
 
-	// write request for batch writes
-	writeRequests := []types.WriteRequest{
-		{
-			PutRequest: &types.PutRequest{
-				Item: inputItem,
-			},
-		},
-		{
-			PutRequest: &types.PutRequest{
-				Item: HistoryInputItem,
-			},
-		},
+// brings a task in line with a full task body in one write, patching fields
+// and only touching the assignees that were added or removed. Each change is
+// recorded as if made on its own
+func (s *TasksService) ReplaceTask(data *internal_types.CreateTaskDTO, user internal_types.TokenClaims, taskUUID string, ifMatch *int64) error {
+	patch := internal_types.PatchTaskDTO{
+		Tasktitle:       &data.Tasktitle,
+		TaskDescription: &data.TaskDescription,
+		Deadline:        &data.Deadline,
+		BlockOnSubtasks: &data.BlockOnSubtasks,
+	}
+	if data.Status != "" {
+		patch.Status = &data.Status
 	}
 
-	// update status of User-task
-	// Get All users assigned to task
-	queryInput := dynamodb.QueryInput{
-		TableName:              aws.String(tableName),
-		KeyConditionExpression: aws.String("PartitionKey = :pk AND begins_with(SortKey, :skprefix)"),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":pk":       &types.AttributeValueMemberS{Value: taskId},
-			":skprefix": &types.AttributeValueMemberS{Value: "USER#"},
-		},
+	task, err := s.taskForWrite(user["custom:tenantId"], taskUUID, ifMatch)
+	if err != nil {
+		return err
 	}
-	queryOutput, err := s.store.QueryTask(queryInput)
+	changes, edited, err := s.taskChanges(*task, patch, user)
 	if err != nil {
-		return fmt.Errorf("failed to query user assignments: %w", err)
+		return err
 	}
 
-	status := data.Status
+	var history []store.HistoryEntry
+	if edited != nil {
+		history = append(history, *edited)
+	}
+	status := task.Status
+	if changes.Status != nil {
+		status = *changes.Status
+	}
 
-	// update status for all users
-	for _, item := range queryOutput.Items {
-		var userId string
-		if skAttr, ok := item["SortKey"].(*types.AttributeValueMemberS); ok {
-			log.Printf("userId: %v", skAttr.Value)
-			userId = skAttr.Value
+	var assignees []store.Assignee
+	var messages []Message
+	wanted := map[string]bool{}
+	for _, requested := range data.Assignees {
+		userKey := store.UserKey(requested.UserId)
+		if wanted[userKey] {
+			continue
 		}
+		wanted[userKey] = true
 
-		// append write requests
-		writeRequests = append(writeRequests,
-			types.WriteRequest{
-				PutRequest: &types.PutRequest{
-					Item: map[string]types.AttributeValue{
-						"PartitionKey": &types.AttributeValueMemberS{Value: taskId},
-						"SortKey":      &types.AttributeValueMemberS{Value: userId},
-						"status":       &types.AttributeValueMemberS{Value: status},
-					},
-				},
-			},
-			types.WriteRequest{
-				PutRequest: &types.PutRequest{
-					Item: map[string]types.AttributeValue{
-						"PartitionKey": &types.AttributeValueMemberS{Value: userId},
-						"SortKey":      &types.AttributeValueMemberS{Value: taskId},
-						"status":       &types.AttributeValueMemberS{Value: status},
-					},
-				},
-			},
-		)
-	}
-
-	WriteItemInput := &dynamodb.BatchWriteItemInput{
-		RequestItems: map[string][]types.WriteRequest{
-			tableName: writeRequests,
-		},
+		assignee, err := s.tenantAssignee(task.TenantID, requested.UserId)
+		if err != nil {
+			return err
+		}
+		assignees = append(assignees, assignee)
+		if isAssigned(*task, assignee.UserID) {
+			continue
+		}
+
+		history = append(history, *newHistory(taskUUID, user, store.HistoryReassigned, status, fmt.Sprintf("assigned %s", assignee.Username),
+			[]store.FieldChange{{Field: "assignee", After: userKey}}))
+		messages = append(messages, Message{
+			Type:   NotifyAssigned,
+			UserID: assignee.UserID,
+			Email:  assignee.Email,
+			Text:   fmt.Sprintf("'%s' has been assigned to you", data.Tasktitle),
+		})
 	}
+	for _, assignee := range task.Assignees {
+		userKey := store.UserKey(assignee.UserID)
+		if wanted[userKey] {
+			continue
+		}
 
-	_, err = s.store.BatchWriteData(WriteItemInput)
-	if err != nil {
-		return err
+		history = append(history, *newHistory(taskUUID, user, store.HistoryReassigned, status, fmt.Sprintf("unassigned %s", assignee.Username),
+			[]store.FieldChange{{Field: "assignee", Before: userKey}}))
+		messages = append(messages, Message{
+			Type:   NotifyUnassigned,
+			UserID: assignee.UserID,
+			Email:  assignee.Email,
+			Text:   fmt.Sprintf("You have been removed from '%s'", task.Title),
+		})
 	}
 
+	err = s.store.ReplaceTask(*task, changes, assignees, history)
+	if err != nil {
+		return writeError(err, taskUUID, ifMatch)
+	}
+	s.notify.Dispatch(messages...)
 	return nil
 }
 
-func (s *TasksService) GetTaskHistory(taskId, tableName string) ([]internal_types.GetTaskHistory, error) {
-	taskKeyRefactored := "TASK#" + taskId
-
-	inputItem := dynamodb.QueryInput{
-		TableName:              &tableName,
-		KeyConditionExpression: aws.String("PartitionKey = :pkey AND begins_with(SortKey, :skprefix)"),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":pkey":     &types.AttributeValueMemberS{Value: taskKeyRefactored},
-			":skprefix": &types.AttributeValueMemberS{Value: "HISTORY#"},
-		},
+// lists one page of a task's history, newest first
+func (s *TasksService) GetTaskHistory(tenantId, taskId string, page internal_types.PageQuery) (*internal_types.Page[internal_types.GetTaskHistory], error) {
+	if err := s.taskInTenant(tenantId, taskId); err != nil {
+		return nil, err
 	}
 
-	taskQueryOutput, err := s.store.QueryTask(inputItem)
+	entries, next, err := s.store.ListHistory(taskId, store.PageRequest{Limit: page.Limit, Cursor: page.Cursor})
 	if err != nil {
-		return nil, err
+		return nil, listError(err)
 	}
 
-	// converts task to go struct
-	var taskHistory []internal_types.GetTaskHistory
-	if err := attributevalue.UnmarshalListOfMaps(taskQueryOutput.Items, &taskHistory); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal tasks: %w", err)
+	taskHistory := make([]internal_types.GetTaskHistory, 0, len(entries))
+	for _, entry := range entries {
+		changes := make([]internal_types.HistoryChange, 0, len(entry.Changes))
+		for _, change := range entry.Changes {
+			changes = append(changes, internal_types.HistoryChange{Field: change.Field, Before: change.Before, After: change.After})
+		}
+
+		taskHistory = append(taskHistory, internal_types.GetTaskHistory{
+			HistoryId:         entry.HistoryID,
+			Action:            entry.Action,
+			Status:            entry.Status,
+			UpdatedBy:         store.UserKey(entry.UpdatedBy),
+			UpdatedAt:         entry.UpdatedAt,
+			UpdateDescription: entry.UpdateDescription,
+			Changes:           changes,
+		})
 	}
 
-	return taskHistory, nil
+	return &internal_types.Page[internal_types.GetTaskHistory]{Items: taskHistory, NextCursor: next}, nil
 }
@@ -0,0 +1,525 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Ghaby-X/tasork/internal/store"
+	internal_types "github.com/Ghaby-X/tasork/internal/types"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// a MemoryDB that counts the Query and TransactWriteItems calls made through it
+type countingDB struct {
+	*store.MemoryDB
+	queries      int
+	transactions int
+}
+
+func (db *countingDB) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	db.queries++
+	return db.MemoryDB.Query(ctx, params, optFns...)
+}
+
+func (db *countingDB) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	db.transactions++
+	return db.MemoryDB.TransactWriteItems(ctx, params, optFns...)
+}
+
+// the tasks service on top of db, without the network bound services
+func newTestTasks(db store.DynamoDBAPI) (*TasksService, *store.Storage) {
+	st := store.NewStorage(db, store.NewLocalBlobStore(""))
+	notify := NewDispatcher(st.Notifications, NewNotificationHub(NewLocalBroker()), NewMemoryMailer(), SystemClock)
+	return NewTaskService(st.Tasks, st.Users, NewWorkflowService(st.Workflows), notify), st
+}
+
+func addTestUser(tb testing.TB, st *store.Storage, tenantId, userId string) {
+	tb.Helper()
+	err := st.Users.CreateItem(&dynamodb.PutItemInput{
+		TableName: aws.String("tasork"),
+		Item: map[string]types.AttributeValue{
+			"PartitionKey": &types.AttributeValueMemberS{Value: tenantId},
+			"SortKey":      &types.AttributeValueMemberS{Value: store.UserKey(userId)},
+			"userName":     &types.AttributeValueMemberS{Value: userId},
+			"email":        &types.AttributeValueMemberS{Value: userId + "@example.com"},
+			"role":         &types.AttributeValueMemberS{Value: "admin"},
+		},
+	})
+	if err != nil {
+		tb.Fatalf("failed to add user %s: %v", userId, err)
+	}
+	err = st.Users.CreateItem(&dynamodb.PutItemInput{
+		TableName: aws.String("tasork"),
+		Item:      store.UsernameItem(tenantId, store.Member{UserID: userId, Username: userId, Email: userId + "@example.com"}),
+	})
+	if err != nil {
+		tb.Fatalf("failed to index user %s: %v", userId, err)
+	}
+}
+
+func testClaims(tenantId, userId string) internal_types.TokenClaims {
+	return internal_types.TokenClaims{
+		"sub":             userId,
+		"custom:tenantId": tenantId,
+		"custom:role":     "admin",
+		"custom:username": userId,
+	}
+}
+
+// creates count tasks assigned to the user, every other one with a deadline
+// and every third one blocked by the task before it
+func addTestTasks(tb testing.TB, tasks *TasksService, claims internal_types.TokenClaims, count int) {
+	tb.Helper()
+	for i := range count {
+		data := &internal_types.CreateTaskDTO{
+			Tasktitle: fmt.Sprintf("task %d", i),
+			Assignees: []internal_types.Assignee{{UserId: claims["sub"]}},
+		}
+		if i%2 == 0 {
+			data.Deadline = fmt.Sprintf("2030-01-%02dT09:00:00Z", i%28+1)
+		}
+		id := fmt.Sprintf("%s-%04d", claims["sub"], i)
+		if err := tasks.CreateTask(data, claims, id); err != nil {
+			tb.Fatalf("failed to create %s: %v", id, err)
+		}
+		if i%3 == 2 {
+			blocker := internal_types.DependencyDTO{TaskId: fmt.Sprintf("%s-%04d", claims["sub"], i-1)}
+			if err := tasks.AddDependency(blocker, claims, id, nil); err != nil {
+				tb.Fatalf("failed to block %s: %v", id, err)
+			}
+		}
+	}
+}
+
+// the Query calls made for each page of a full listing of the tenant's tasks
+func queriesPerPage(tb testing.TB, db *countingDB, tasks *TasksService, tenantId string, filter internal_types.TaskListQuery) []int {
+	tb.Helper()
+	var counts []int
+	page := internal_types.PageQuery{Limit: store.MaxPageLimit}
+	for {
+		db.queries = 0
+		output, err := tasks.GetAllTaskBytenant(tenantId, filter, page)
+		if err != nil {
+			tb.Fatalf("failed to list tasks: %v", err)
+		}
+		counts = append(counts, db.queries)
+		if output.NextCursor == "" {
+			return counts
+		}
+		page.Cursor = output.NextCursor
+	}
+}
+
+// listing pages of a tenant with 500 tasks makes as many Query calls per page
+// as listing a tenant with a handful, that is assignees, blockers and
+// statuses are not read task by task
+func BenchmarkListTasks(b *testing.B) {
+	db := &countingDB{MemoryDB: store.NewMemoryDB()}
+	tasks, st := newTestTasks(db)
+
+	small, large := testClaims("TENANT#small", "ann"), testClaims("TENANT#large", "bob")
+	addTestUser(b, st, "TENANT#small", "ann")
+	addTestUser(b, st, "TENANT#large", "bob")
+	addTestTasks(b, tasks, small, 5)
+	addTestTasks(b, tasks, large, 500)
+
+	for _, filter := range []internal_types.TaskListQuery{{}, {SortBy: "deadline"}, {Assignee: "bob"}} {
+		smallFilter := filter
+		if filter.Assignee != "" {
+			smallFilter.Assignee = "ann"
+		}
+		want := queriesPerPage(b, db, tasks, "TENANT#small", smallFilter)[0]
+		for i, got := range queriesPerPage(b, db, tasks, "TENANT#large", filter) {
+			if got != want {
+				b.Fatalf("listing %+v made %d queries for page %d, want %d", filter, got, i, want)
+			}
+		}
+	}
+
+	for b.Loop() {
+		queriesPerPage(b, db, tasks, "TENANT#large", internal_types.TaskListQuery{})
+	}
+}
+
+func TestDeadlinesAreSavedInUTC(t *testing.T) {
+	tasks, st := newTestTasks(store.NewMemoryDB())
+	claims := testClaims("TENANT#a", "ann")
+	addTestUser(t, st, "TENANT#a", "ann")
+
+	tests := []struct {
+		deadline string
+		want     string
+	}{
+		{"", ""},
+		{"2030-01-02", "2030-01-02T00:00:00Z"},
+		{"2030-01-02T09:30:00+02:00", "2030-01-02T07:30:00Z"},
+		{"2030-01-02T09:30:00Z", "2030-01-02T09:30:00Z"},
+	}
+	for i, tt := range tests {
+		id := fmt.Sprintf("task-%d", i)
+		if err := tasks.CreateTask(&internal_types.CreateTaskDTO{Tasktitle: "deadline", Deadline: tt.deadline}, claims, id); err != nil {
+			t.Fatalf("CreateTask(%q): %v", tt.deadline, err)
+		}
+		output, err := tasks.GetOneTaskBytenant("TENANT#a", id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if output.Task.Deadline != tt.want {
+			t.Errorf("created with %q, deadline = %q, want %q", tt.deadline, output.Task.Deadline, tt.want)
+		}
+
+		patched, err := tasks.UpdateTask(internal_types.PatchTaskDTO{Deadline: &tt.deadline}, claims, id, nil)
+		if err != nil {
+			t.Fatalf("UpdateTask(%q): %v", tt.deadline, err)
+		}
+		if patched.Task.Deadline != tt.want {
+			t.Errorf("updated to %q, deadline = %q, want %q", tt.deadline, patched.Task.Deadline, tt.want)
+		}
+	}
+
+	err := tasks.CreateTask(&internal_types.CreateTaskDTO{Tasktitle: "deadline", Deadline: "soon"}, claims, "task-bad")
+	if !errors.Is(err, ErrInvalidDeadline) {
+		t.Errorf("CreateTask(%q) = %v, want ErrInvalidDeadline", "soon", err)
+	}
+	bad := "soon"
+	if _, err := tasks.UpdateTask(internal_types.PatchTaskDTO{Deadline: &bad}, claims, "task-0", nil); !errors.Is(err, ErrInvalidDeadline) {
+		t.Errorf("UpdateTask(%q) = %v, want ErrInvalidDeadline", bad, err)
+	}
+}
+
+// tasks and users of one tenant are not found from another, the same as ones
+// that do not exist
+func TestOtherTenantIsNotFound(t *testing.T) {
+	tasks, st := newTestTasks(store.NewMemoryDB())
+	ann, bob := testClaims("TENANT#a", "ann"), testClaims("TENANT#b", "bob")
+	addTestUser(t, st, "TENANT#a", "ann")
+	addTestUser(t, st, "TENANT#b", "bob")
+	if err := tasks.CreateTask(&internal_types.CreateTaskDTO{Tasktitle: "ann's task", Assignees: []internal_types.Assignee{{UserId: "ann"}}}, ann, "task-a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tasks.CreateTask(&internal_types.CreateTaskDTO{Tasktitle: "bob's task"}, bob, "task-b"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{"history of another tenant's task", func() error {
+			_, err := tasks.GetTaskHistory(bob["custom:tenantId"], "task-a", internal_types.PageQuery{})
+			return err
+		}},
+		{"history of a missing task", func() error {
+			_, err := tasks.GetTaskHistory(bob["custom:tenantId"], "task-c", internal_types.PageQuery{})
+			return err
+		}},
+		{"delete another tenant's task", func() error {
+			return tasks.DeleteTask("task-a", bob["custom:tenantId"])
+		}},
+		{"tasks of another tenant's user", func() error {
+			_, err := tasks.GetAllTaskByUser(bob["custom:tenantId"], "ann", internal_types.PageQuery{})
+			return err
+		}},
+		{"tasks of a missing user", func() error {
+			_, err := tasks.GetAllTaskByUser(bob["custom:tenantId"], "carl", internal_types.PageQuery{})
+			return err
+		}},
+		{"assign another tenant's user", func() error {
+			return tasks.AddAssignee(internal_types.Assignee{UserId: "ann"}, bob, "task-b", nil)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call(); !errors.Is(err, ErrNotFound) {
+				t.Errorf("got %v, want ErrNotFound", err)
+			}
+		})
+	}
+
+	// the other tenant's calls left ann's task alone
+	if _, err := tasks.GetOneTaskBytenant(ann["custom:tenantId"], "task-a"); err != nil {
+		t.Errorf("ann's task after bob's calls: %v", err)
+	}
+	history, err := tasks.GetTaskHistory(ann["custom:tenantId"], "task-a", internal_types.PageQuery{})
+	if err != nil || len(history.Items) == 0 {
+		t.Errorf("ann's history = %v, %v, want the created entry", history, err)
+	}
+	own, err := tasks.GetAllTaskByUser(ann["custom:tenantId"], "ann", internal_types.PageQuery{})
+	if err != nil || len(own.Items) != 1 {
+		t.Errorf("ann's tasks = %v, %v, want task-a", own, err)
+	}
+}
+
+// the messages of a user's notifications, newest first
+func notificationsOf(tb testing.TB, st *store.Storage, userId string) []string {
+	tb.Helper()
+	notifications, _, err := st.Notifications.ListNotifications(userId, store.NotificationQuery{}, 0, store.PageRequest{Limit: store.MaxPageLimit})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var messages []string
+	for _, notification := range notifications {
+		messages = append(messages, notification.Message)
+	}
+	return messages
+}
+
+// the actions of a task's history, oldest first
+func historyActions(tb testing.TB, tasks *TasksService, tenantId, taskId string) []string {
+	tb.Helper()
+	history, err := tasks.GetTaskHistory(tenantId, taskId, internal_types.PageQuery{Limit: store.MaxPageLimit})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var actions []string
+	for _, entry := range slices.Backward(history.Items) {
+		actions = append(actions, entry.Action)
+	}
+	return actions
+}
+
+// a full replace is a single write, its fields, assignee changes and history
+// apply together or not at all
+func TestReplaceTaskIsOneWrite(t *testing.T) {
+	db := &countingDB{MemoryDB: store.NewMemoryDB()}
+	tasks, st := newTestTasks(db)
+	claims := testClaims("TENANT#a", "ann")
+	for _, userId := range []string{"ann", "bob", "cat"} {
+		addTestUser(t, st, "TENANT#a", userId)
+	}
+	err := tasks.CreateTask(&internal_types.CreateTaskDTO{
+		Tasktitle: "fence",
+		Assignees: []internal_types.Assignee{{UserId: "ann"}, {UserId: "bob"}},
+	}, claims, "task-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replace := &internal_types.CreateTaskDTO{
+		Tasktitle: "fence posts",
+		Assignees: []internal_types.Assignee{{UserId: "bob"}, {UserId: "cat"}, {UserId: "bob"}},
+	}
+	version := int64(1)
+	db.transactions = 0
+	if err := tasks.ReplaceTask(replace, claims, "task-1", &version); err != nil {
+		t.Fatal(err)
+	}
+	// one for the task and one for the notifications of cat and ann
+	if db.transactions != 2 {
+		t.Errorf("replace made %d transactions, want 2", db.transactions)
+	}
+
+	output, err := tasks.GetOneTaskBytenant("TENANT#a", "task-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var assignees []string
+	for _, assignee := range output.Assignee {
+		assignees = append(assignees, assignee.SortKey)
+	}
+	if output.Task.Tasktitle != "fence posts" || output.Task.Version != 2 || !slices.Equal(assignees, []string{"USER#bob", "USER#cat"}) {
+		t.Errorf("replaced task = %q at version %d with %v, want %q at version 2 with bob and cat", output.Task.Tasktitle, output.Task.Version, assignees, "fence posts")
+	}
+	for userId, want := range map[string]int{"ann": 0, "bob": 1, "cat": 1} {
+		own, err := tasks.GetAllTaskByUser("TENANT#a", userId, internal_types.PageQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(own.Items) != want {
+			t.Errorf("%s has %d tasks, want %d", userId, len(own.Items), want)
+		}
+		for _, task := range own.Items {
+			if task.Task.Tasktitle != "fence posts" {
+				t.Errorf("%s's copy is titled %q, want %q", userId, task.Task.Tasktitle, "fence posts")
+			}
+		}
+	}
+	want := []string{store.HistoryCreated, store.HistoryEdited, store.HistoryReassigned, store.HistoryReassigned}
+	if got := historyActions(t, tasks, "TENANT#a", "task-1"); !slices.Equal(got, want) {
+		t.Errorf("history = %v, want %v", got, want)
+	}
+	if got := notificationsOf(t, st, "cat"); len(got) != 1 {
+		t.Errorf("cat was notified %v, want once of the assignment", got)
+	}
+	if got := notificationsOf(t, st, "bob"); len(got) != 1 {
+		t.Errorf("bob was notified %v, want only of the first assignment", got)
+	}
+
+	// a replace based on the old version changes nothing
+	replace.Assignees = []internal_types.Assignee{{UserId: "ann"}}
+	if err := tasks.ReplaceTask(replace, claims, "task-1", &version); !errors.Is(err, ErrPreconditionFailed) {
+		t.Fatalf("stale replace = %v, want ErrPreconditionFailed", err)
+	}
+	if got := historyActions(t, tasks, "TENANT#a", "task-1"); len(got) != len(want) {
+		t.Errorf("history after a stale replace = %v, want %v", got, want)
+	}
+	if own, err := tasks.GetAllTaskByUser("TENANT#a", "ann", internal_types.PageQuery{}); err != nil || len(own.Items) != 0 {
+		t.Errorf("ann's tasks after a stale replace = %v, %v, want none", own, err)
+	}
+}
+
+// adding or removing an assignee writes only their pair and notifies only them
+func TestAssigneeChangesNotifyOnlyThatUser(t *testing.T) {
+	tasks, st := newTestTasks(store.NewMemoryDB())
+	claims := testClaims("TENANT#a", "ann")
+	for _, userId := range []string{"ann", "bob"} {
+		addTestUser(t, st, "TENANT#a", userId)
+	}
+	if err := tasks.CreateTask(&internal_types.CreateTaskDTO{Tasktitle: "fence", Assignees: []internal_types.Assignee{{UserId: "ann"}}}, claims, "task-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tasks.AddAssignee(internal_types.Assignee{UserId: "bob"}, claims, "task-1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := tasks.AddAssignee(internal_types.Assignee{UserId: "bob"}, claims, "task-1", nil); !errors.Is(err, ErrConflict) {
+		t.Errorf("assigning bob twice = %v, want ErrConflict", err)
+	}
+	if got := notificationsOf(t, st, "bob"); len(got) != 1 || got[0] != "'fence' has been assigned to you" {
+		t.Errorf("bob was notified %v, want once of the assignment", got)
+	}
+
+	if err := tasks.RemoveAssignee("bob", claims, "task-1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := tasks.RemoveAssignee("bob", claims, "task-1", nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("removing bob twice = %v, want ErrNotFound", err)
+	}
+	if got := notificationsOf(t, st, "bob"); len(got) != 2 || got[0] != "You have been removed from 'fence'" {
+		t.Errorf("bob was notified %v, want of the removal too", got)
+	}
+	if got := notificationsOf(t, st, "ann"); len(got) != 1 {
+		t.Errorf("ann was notified %v, want only of her own assignment", got)
+	}
+
+	want := []string{store.HistoryCreated, store.HistoryReassigned, store.HistoryReassigned}
+	if got := historyActions(t, tasks, "TENANT#a", "task-1"); !slices.Equal(got, want) {
+		t.Errorf("history = %v, want %v", got, want)
+	}
+	if own, err := tasks.GetAllTaskByUser("TENANT#a", "bob", internal_types.PageQuery{}); err != nil || len(own.Items) != 0 {
+		t.Errorf("bob's tasks after the removal = %v, %v, want none", own, err)
+	}
+}
+
+// a patch changes only the fields it carries, on the tenant row and on every
+// assignee's copy, and keeps when and by whom the task was created
+func TestPatchTaskKeepsOtherFields(t *testing.T) {
+	tasks, st := newTestTasks(store.NewMemoryDB())
+	claims := testClaims("TENANT#a", "ann")
+	addTestUser(t, st, "TENANT#a", "ann")
+
+	before := time.Now().UTC().Truncate(time.Second)
+	err := tasks.CreateTask(&internal_types.CreateTaskDTO{
+		Tasktitle:       "fence",
+		TaskDescription: "north side",
+		Deadline:        "2030-01-02T09:00:00Z",
+		Assignees:       []internal_types.Assignee{{UserId: "ann"}},
+	}, claims, "task-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, err := tasks.GetOneTaskBytenant("TENANT#a", "task-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	createdAt, err := time.Parse(time.RFC3339, created.Task.CreatedAt)
+	if err != nil || createdAt.Before(before) || createdAt.After(time.Now()) {
+		t.Errorf("created at %q, want the time it was created", created.Task.CreatedAt)
+	}
+
+	title := "fence posts"
+	if _, err := tasks.UpdateTask(internal_types.PatchTaskDTO{Tasktitle: &title}, claims, "task-1", nil); err != nil {
+		t.Fatal(err)
+	}
+	err = tasks.UpdateTaskStatus(internal_types.CreateTaskHistory{Status: "in-progress", UpdateDescription: "started"}, claims, "task-1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	own, err := tasks.GetAllTaskByUser("TENANT#a", "ann", internal_types.PageQuery{})
+	if err != nil || len(own.Items) != 1 {
+		t.Fatalf("ann's tasks = %v, %v, want task-1", own, err)
+	}
+	patched, err := tasks.GetOneTaskBytenant("TENANT#a", "task-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, task := range map[string]internal_types.QueryTasksOutput{"tenant row": patched.Task, "ann's copy": own.Items[0].Task} {
+		if task.Tasktitle != title || task.Status != "in-progress" {
+			t.Errorf("%s is %q in %q, want %q in %q", name, task.Tasktitle, task.Status, title, "in-progress")
+		}
+		if task.Description != "north side" || task.Deadline != "2030-01-02T09:00:00Z" || task.CreatedAt != created.Task.CreatedAt || task.Createdby != "USER#ann" {
+			t.Errorf("%s lost fields it was not patched on: %+v", name, task)
+		}
+	}
+	if patched.Task.Version != 3 {
+		t.Errorf("version after two writes = %d, want 3", patched.Task.Version)
+	}
+}
+
+// every change to a task is in its history, newest first, with the fields it changed
+func TestTaskHistory(t *testing.T) {
+	tasks, st := newTestTasks(store.NewMemoryDB())
+	comments := NewCommentsService(st.Comments, st.Tasks, st.Users, tasks.notify)
+	ann := testClaims("TENANT#a", "ann")
+	addTestUser(t, st, "TENANT#a", "ann")
+	addTestUser(t, st, "TENANT#a", "bob")
+
+	if err := tasks.CreateTask(&internal_types.CreateTaskDTO{Tasktitle: "fence"}, ann, "task-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tasks.UpdateTaskStatus(internal_types.CreateTaskHistory{Status: "in-progress", UpdateDescription: "started"}, ann, "task-1", nil); err != nil {
+		t.Fatal(err)
+	}
+	title := "north fence"
+	if _, err := tasks.UpdateTask(internal_types.PatchTaskDTO{Tasktitle: &title}, ann, "task-1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := tasks.AddAssignee(internal_types.Assignee{UserId: "bob"}, ann, "task-1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := comments.CreateComment(internal_types.CreateCommentDTO{Body: "posts are in"}, ann, "task-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := tasks.GetTaskHistory("TENANT#a", "task-1", internal_types.PageQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		action  string
+		status  string
+		changes []internal_types.HistoryChange
+	}{
+		{store.HistoryCommented, "in-progress", nil},
+		{store.HistoryReassigned, "in-progress", []internal_types.HistoryChange{{Field: "assignee", After: "USER#bob"}}},
+		{store.HistoryEdited, "in-progress", []internal_types.HistoryChange{{Field: "title", Before: "fence", After: "north fence"}}},
+		{store.HistoryStatusChanged, "in-progress", []internal_types.HistoryChange{{Field: "status", Before: "todo", After: "in-progress"}}},
+		{store.HistoryCreated, "todo", nil},
+	}
+	if len(history.Items) != len(want) {
+		t.Fatalf("history has %d entries, want %d", len(history.Items), len(want))
+	}
+	for i, entry := range history.Items {
+		if entry.Action != want[i].action || entry.Status != want[i].status || entry.UpdatedBy != "USER#ann" || entry.UpdatedAt == "" {
+			t.Errorf("entry %d = %+v, want a %s by ann in %s", i, entry, want[i].action, want[i].status)
+		}
+		if want[i].changes != nil && !slices.Equal(entry.Changes, want[i].changes) {
+			t.Errorf("%s changed %+v, want %+v", entry.Action, entry.Changes, want[i].changes)
+		}
+	}
+	if history.Items[3].UpdateDescription != "started" {
+		t.Errorf("status change described as %q, want the note it was made with", history.Items[3].UpdateDescription)
+	}
+
+	// another tenant cannot read it
+	if _, err := tasks.GetTaskHistory("TENANT#b", "task-1", internal_types.PageQuery{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("history from another tenant = %v, want ErrNotFound", err)
+	}
+}
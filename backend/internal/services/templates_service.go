@@ -0,0 +1,365 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Ghaby-X/tasork/internal/store"
+	internal_types "github.com/Ghaby-X/tasork/internal/types"
+	"github.com/google/uuid"
+)
+
+// namespace of the task ids derived from a template and an occurrence
+var templateTaskNamespace = uuid.MustParse("0c2f4d4e-3b5a-4a57-9a57-6c1f0e7b9d21")
+
+type TemplatesService struct {
+	store store.TemplatesStore
+	tasks *TasksService
+	clock Clock
+}
+
+func NewTemplatesService(templatestore store.TemplatesStore, tasks *TasksService, clock Clock) *TemplatesService {
+	return &TemplatesService{templatestore, tasks, clock}
+}
+
+// the recurrence of a template, read in its time zone
+type schedule struct {
+	rule     recurrence
+	start    time.Time
+	dueAfter time.Duration
+}
+
+// start is a time with an offset, or a local time or date in timezone
+func parseSchedule(start, rule, timezone, dueAfter string) (schedule, error) {
+	var sched schedule
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return sched, fmt.Errorf("%w: unknown time zone %q", ErrInvalidTemplate, timezone)
+	}
+
+	sched.start, err = time.Parse(time.RFC3339, start)
+	if err == nil {
+		sched.start = sched.start.In(location)
+	} else {
+		for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+			if sched.start, err = time.ParseInLocation(layout, start, location); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return sched, fmt.Errorf("%w: start %q is not a date", ErrInvalidTemplate, start)
+		}
+	}
+
+	if sched.rule, err = parseRecurrence(rule); err != nil {
+		return sched, err
+	}
+	if dueAfter != "" {
+		sched.dueAfter, err = time.ParseDuration(dueAfter)
+		if err != nil || sched.dueAfter < 0 {
+			return sched, fmt.Errorf("%w: dueAfter %q is not a duration like \"48h\"", ErrInvalidTemplate, dueAfter)
+		}
+	}
+	return sched, nil
+}
+
+// the first occurrence at or after from that has no task yet and how many
+// occurrences came before it, walking the rule on from resume with seen
+// occurrences before it. Empty for a paused template or one whose rule has ended
+func nextRun(sched schedule, paused bool, resume time.Time, seen int, from time.Time) (string, int) {
+	if paused {
+		return "", 0
+	}
+	next, before, ok := sched.rule.next(sched.start, resume, seen, from)
+	if !ok {
+		return "", 0
+	}
+	return next.UTC().Format(time.RFC3339), before
+}
+
+// checks and converts a template sent by a user, without its id or next run
+func (s *TemplatesService) buildTemplate(data internal_types.TemplateDTO, user internal_types.TokenClaims) (store.Template, schedule, error) {
+	template := store.Template{
+		TenantID:    user["custom:tenantId"],
+		Title:       strings.TrimSpace(data.Tasktitle),
+		Description: data.TaskDescription,
+		Rule:        strings.TrimSpace(data.Rrule),
+		Timezone:    data.Timezone,
+		DueAfter:    data.DueAfter,
+		Paused:      data.Paused,
+	}
+	if template.Timezone == "" {
+		template.Timezone = "UTC"
+	}
+	if len(template.Title) < 3 {
+		return template, schedule{}, fmt.Errorf("%w: task title should be greater than 3", ErrInvalidTemplate)
+	}
+
+	sched, err := parseSchedule(data.Start, template.Rule, template.Timezone, template.DueAfter)
+	if err != nil {
+		return template, sched, err
+	}
+	template.Start = sched.start.Format(time.RFC3339)
+
+	if len(data.Checklist) > maxChecklistItems {
+		return template, sched, fmt.Errorf("%w: at most %d checklist items are allowed", ErrInvalidTemplate, maxChecklistItems)
+	}
+	for _, text := range data.Checklist {
+		text = strings.TrimSpace(text)
+		if text == "" || len(text) > maxChecklistLength {
+			return template, sched, fmt.Errorf("%w: checklist items must have between 1 and %d characters", ErrInvalidTemplate, maxChecklistLength)
+		}
+		template.Checklist = append(template.Checklist, text)
+	}
+
+	for _, data := range data.Assignees {
+		assignee, err := s.tasks.tenantAssignee(template.TenantID, data.UserId)
+		if err != nil {
+			return template, sched, err
+		}
+		template.Assignees = append(template.Assignees, assignee)
+	}
+	return template, sched, nil
+}
+
+func (s *TemplatesService) CreateTemplate(data internal_types.TemplateDTO, user internal_types.TokenClaims) (*internal_types.TemplateOutput, error) {
+	template, sched, err := s.buildTemplate(data, user)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.clock.Now().UTC()
+	template.TemplateID = uuid.NewString()
+	template.CreatedBy = user["sub"]
+	template.CreatedAt = now.Format(time.RFC3339)
+	template.NextRun, template.RunCount = nextRun(sched, template.Paused, sched.start, 0, now)
+
+	if err := s.store.PutTemplate(template); err != nil {
+		return nil, err
+	}
+	template.Version = 1
+	output := toTemplateOutput(template)
+	return &output, nil
+}
+
+// reads a template of the tenant, templates of other tenants are not found
+func (s *TemplatesService) template(tenantId, templateId string) (*store.Template, error) {
+	template, err := s.store.GetTemplate(tenantId, templateId)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, fmt.Errorf("template %s: %w", templateId, ErrNotFound)
+	}
+	return template, err
+}
+
+func (s *TemplatesService) GetTemplate(tenantId, templateId string) (*internal_types.TemplateOutput, error) {
+	template, err := s.template(tenantId, templateId)
+	if err != nil {
+		return nil, err
+	}
+	output := toTemplateOutput(*template)
+	return &output, nil
+}
+
+func (s *TemplatesService) ListTemplates(tenantId string, page internal_types.PageQuery) (*internal_types.Page[internal_types.TemplateOutput], error) {
+	templates, next, err := s.store.ListTemplates(tenantId, store.PageRequest{Limit: page.Limit, Cursor: page.Cursor})
+	if err != nil {
+		return nil, listError(err)
+	}
+
+	output := make([]internal_types.TemplateOutput, 0, len(templates))
+	for _, template := range templates {
+		output = append(output, toTemplateOutput(template))
+	}
+	return &internal_types.Page[internal_types.TemplateOutput]{Items: output, NextCursor: next}, nil
+}
+
+// replaces a template. Tasks already created from it are kept, and the
+// template carries on from the later of now and its next run, so neither
+// past occurrences nor ones that already have a task are created again
+func (s *TemplatesService) UpdateTemplate(data internal_types.TemplateDTO, user internal_types.TokenClaims, templateId string) (*internal_types.TemplateOutput, error) {
+	current, err := s.template(user["custom:tenantId"], templateId)
+	if err != nil {
+		return nil, err
+	}
+	updated, sched, err := s.buildTemplate(data, user)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.clock.Now().UTC()
+	from := now
+	if next, err := time.Parse(time.RFC3339, current.NextRun); err == nil && next.After(now) {
+		from = next
+	}
+	updated.TemplateID = current.TemplateID
+	updated.CreatedBy = current.CreatedBy
+	updated.CreatedAt = current.CreatedAt
+	updated.UpdatedAt = now.Format(time.RFC3339)
+	// the rule may have changed, so it is walked from its start once
+	updated.NextRun, updated.RunCount = nextRun(sched, updated.Paused, sched.start, 0, from)
+
+	err = s.store.UpdateTemplate(*current, updated)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return nil, fmt.Errorf("template %s: %w", templateId, ErrNotFound)
+	case errors.Is(err, store.ErrVersionConflict):
+		return nil, fmt.Errorf("template %s was modified concurrently: %w", templateId, ErrConflict)
+	case err != nil:
+		return nil, err
+	}
+
+	updated.Version = current.Version + 1
+	output := toTemplateOutput(updated)
+	return &output, nil
+}
+
+// deletes a template, the tasks created from it are kept
+func (s *TemplatesService) DeleteTemplate(tenantId, templateId string) error {
+	template, err := s.template(tenantId, templateId)
+	if err != nil {
+		return err
+	}
+
+	err = s.store.DeleteTemplate(*template)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return fmt.Errorf("template %s: %w", templateId, ErrNotFound)
+	case errors.Is(err, store.ErrVersionConflict):
+		return fmt.Errorf("template %s was modified concurrently: %w", templateId, ErrConflict)
+	}
+	return err
+}
+
+// creates the tasks of every occurrence before horizon that has none yet,
+// returning how many were created. Each task id is derived from its template
+// and occurrence, so running this again or on several instances at once
+// never creates a task twice
+func (s *TemplatesService) CreateDueTasks(horizon time.Time) (int, error) {
+	templates, err := s.store.ListDueTemplates(horizon.UTC().Format(time.RFC3339))
+	if err != nil {
+		return 0, err
+	}
+
+	created := 0
+	for _, template := range templates {
+		count, err := s.createTasks(template, horizon)
+		created += count
+		if err != nil {
+			log.Printf("failed to create tasks of template %s: %v", template.TemplateID, err)
+		}
+	}
+	return created, nil
+}
+
+// creates the tasks of one template up to horizon and moves its next run past them
+func (s *TemplatesService) createTasks(template store.Template, horizon time.Time) (int, error) {
+	sched, err := parseSchedule(template.Start, template.Rule, template.Timezone, template.DueAfter)
+	if err != nil {
+		return 0, err
+	}
+	from, err := time.Parse(time.RFC3339, template.NextRun)
+	if err != nil {
+		return 0, fmt.Errorf("invalid next run %q: %w", template.NextRun, err)
+	}
+	// the rule is walked on from the next run rather than from its start. A
+	// count of 0 is a template yet to run, or one saved before counts were kept,
+	// whose walk from the start is short or has to count what came before
+	resume, seen := from, template.RunCount
+	if seen == 0 {
+		resume = sched.start
+	}
+
+	// the tasks are created by the template's author
+	user := internal_types.TokenClaims{
+		"sub":             template.CreatedBy,
+		"custom:tenantId": template.TenantID,
+		"custom:role":     RoleAdmin,
+	}
+
+	created := 0
+	next := horizon
+	var failed error
+	for _, at := range sched.rule.between(sched.start, resume, seen, from, horizon) {
+		err := s.tasks.CreateTask(s.occurrenceTask(template, sched, at), user, occurrenceTaskID(template, at))
+		if err == nil {
+			created++
+			continue
+		}
+		if errors.Is(err, store.ErrAlreadyExists) {
+			continue
+		}
+		if errors.Is(err, ErrNotFound) {
+			// an assignee left the tenant, the occurrence is skipped rather than retried forever
+			log.Printf("skipped %s of template %s: %v", at.UTC().Format(time.RFC3339), template.TemplateID, err)
+			continue
+		}
+		// the template picks up from the failed occurrence on the next run
+		next, failed = at, err
+		break
+	}
+
+	updated := template
+	updated.NextRun, updated.RunCount = nextRun(sched, template.Paused, resume, seen, next)
+	err = s.store.UpdateTemplate(template, updated)
+	if err != nil && !errors.Is(err, store.ErrVersionConflict) && !errors.Is(err, store.ErrNotFound) {
+		return created, err
+	}
+	return created, failed
+}
+
+func (s *TemplatesService) occurrenceTask(template store.Template, sched schedule, at time.Time) *internal_types.CreateTaskDTO {
+	task := &internal_types.CreateTaskDTO{
+		Tasktitle:       template.Title,
+		TaskDescription: template.Description,
+		CreatedBy:       template.CreatedBy,
+	}
+	if sched.dueAfter > 0 {
+		task.Deadline = at.Add(sched.dueAfter).UTC().Format(time.RFC3339)
+	}
+	for _, assignee := range template.Assignees {
+		task.Assignees = append(task.Assignees, internal_types.Assignee{UserId: assignee.UserID, Username: assignee.Username, Email: assignee.Email})
+	}
+	for _, text := range template.Checklist {
+		task.Checklist = append(task.Checklist, internal_types.ChecklistItemDTO{Text: text})
+	}
+	return task
+}
+
+// the id of the task of a template's occurrence, the same on every run
+func occurrenceTaskID(template store.Template, at time.Time) string {
+	name := template.TenantID + "/" + template.TemplateID + "/" + at.UTC().Format(time.RFC3339)
+	return uuid.NewSHA1(templateTaskNamespace, []byte(name)).String()
+}
+
+func toTemplateOutput(template store.Template) internal_types.TemplateOutput {
+	output := internal_types.TemplateOutput{
+		TemplateId:      template.TemplateID,
+		Tasktitle:       template.Title,
+		TaskDescription: template.Description,
+		Assignee:        make([]internal_types.TaskAssignee, 0, len(template.Assignees)),
+		Checklist:       template.Checklist,
+		Start:           template.Start,
+		Rrule:           template.Rule,
+		Timezone:        template.Timezone,
+		DueAfter:        template.DueAfter,
+		Paused:          template.Paused,
+		NextRun:         template.NextRun,
+		CreatedBy:       store.UserKey(template.CreatedBy),
+		CreatedAt:       template.CreatedAt,
+		UpdatedAt:       template.UpdatedAt,
+	}
+	if output.Checklist == nil {
+		output.Checklist = []string{}
+	}
+	for _, assignee := range template.Assignees {
+		output.Assignee = append(output.Assignee, internal_types.TaskAssignee{
+			Username: assignee.Username,
+			Email:    assignee.Email,
+			SortKey:  store.UserKey(assignee.UserID),
+		})
+	}
+	return output
+}
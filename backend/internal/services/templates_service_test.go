@@ -0,0 +1,122 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Ghaby-X/tasork/internal/store"
+	internal_types "github.com/Ghaby-X/tasork/internal/types"
+)
+
+// a clock the test moves by hand
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func TestSchedulerCreatesEachOccurrenceOnce(t *testing.T) {
+	tasks, st := newTestTasks(store.NewMemoryDB())
+	clock := &fakeClock{now: time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)}
+	templates := NewTemplatesService(st.Templates, tasks, clock)
+	scheduler := &Scheduler{templates: templates, clock: clock, lookahead: 24 * time.Hour}
+
+	claims := testClaims("TENANT#a", "ann")
+	addTestUser(t, st, "TENANT#a", "ann")
+	template, err := templates.CreateTemplate(internal_types.TemplateDTO{
+		Tasktitle: "stand-up",
+		Assignees: []internal_types.Assignee{{UserId: "ann"}},
+		Start:     "2030-01-01T09:00:00Z",
+		Rrule:     "FREQ=DAILY;COUNT=6",
+		DueAfter:  "1h",
+	}, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listed := func() int {
+		t.Helper()
+		page, err := tasks.GetAllTaskBytenant("TENANT#a", internal_types.TaskListQuery{}, internal_types.PageQuery{Limit: store.MaxPageLimit})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(page.Items)
+	}
+
+	steps := []struct {
+		at      time.Time
+		created int
+		total   int
+		nextRun string
+	}{
+		{time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC), 1, 1, "2030-01-02T09:00:00Z"},
+		// the same tick again creates nothing
+		{time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC), 0, 1, "2030-01-02T09:00:00Z"},
+		{time.Date(2030, time.January, 1, 12, 0, 0, 0, time.UTC), 1, 2, "2030-01-03T09:00:00Z"},
+		// after downtime the missed occurrences are caught up once
+		{time.Date(2030, time.January, 4, 12, 0, 0, 0, time.UTC), 3, 5, "2030-01-06T09:00:00Z"},
+		{time.Date(2030, time.January, 4, 12, 0, 0, 0, time.UTC), 0, 5, "2030-01-06T09:00:00Z"},
+		// COUNT ends the rule after the sixth
+		{time.Date(2030, time.January, 10, 0, 0, 0, 0, time.UTC), 1, 6, ""},
+		{time.Date(2030, time.January, 11, 0, 0, 0, 0, time.UTC), 0, 6, ""},
+	}
+	for _, step := range steps {
+		clock.now = step.at
+		created, err := scheduler.RunOnce()
+		if err != nil {
+			t.Fatalf("at %s: %v", step.at, err)
+		}
+		if created != step.created {
+			t.Errorf("at %s created %d tasks, want %d", step.at, created, step.created)
+		}
+		if total := listed(); total != step.total {
+			t.Errorf("at %s the tenant has %d tasks, want %d", step.at, total, step.total)
+		}
+		saved, err := templates.GetTemplate("TENANT#a", template.TemplateId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if saved.NextRun != step.nextRun {
+			t.Errorf("at %s the next run is %q, want %q", step.at, saved.NextRun, step.nextRun)
+		}
+	}
+}
+
+// occurrences before a template was created count towards COUNT, also once
+// the scheduler walks the rule on from the next run
+func TestSchedulerKeepsCountOfPastOccurrences(t *testing.T) {
+	tasks, st := newTestTasks(store.NewMemoryDB())
+	clock := &fakeClock{now: time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)}
+	templates := NewTemplatesService(st.Templates, tasks, clock)
+
+	addTestUser(t, st, "TENANT#a", "ann")
+	template, err := templates.CreateTemplate(internal_types.TemplateDTO{
+		Tasktitle: "stand-up",
+		Start:     "2029-12-25T09:00:00Z",
+		Rrule:     "FREQ=DAILY;COUNT=10",
+	}, testClaims("TENANT#a", "ann"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	total := 0
+	for day := range 7 {
+		created, err := templates.CreateDueTasks(clock.now.AddDate(0, 0, day+1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += created
+	}
+	if total != 3 {
+		t.Errorf("created %d tasks, want the 3 left of 10 after the 7 before the template", total)
+	}
+	saved, err := templates.GetTemplate("TENANT#a", template.TemplateId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.NextRun != "" {
+		t.Errorf("next run after the last occurrence = %q, want none", saved.NextRun)
+	}
+}
@@ -1,9 +1,11 @@
 package services
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/Ghaby-X/tasork/internal/env"
 	"github.com/Ghaby-X/tasork/internal/store"
@@ -16,11 +18,18 @@ import (
 )
 
 type UsersService struct {
-	store *store.UsersStore
+	store         store.UsersStore
+	notifications store.NotificationsStore
+	hub           *NotificationHub
+	notify        *Dispatcher
+	// how long a read notification is kept, forever when 0
+	readTTL time.Duration
 }
 
-func NewUserService(userstore *store.UsersStore) *UsersService {
-	return &UsersService{userstore}
+// read notifications expire NOTIFICATION_READ_TTL (720h by default) after
+// they are read
+func NewUserService(userstore store.UsersStore, notificationstore store.NotificationsStore, hub *NotificationHub, notify *Dispatcher) *UsersService {
+	return &UsersService{userstore, notificationstore, hub, notify, env.GetDuration("NOTIFICATION_READ_TTL", 30*24*time.Hour)}
 }
 
 type User struct {
@@ -29,33 +38,22 @@ type User struct {
 	Age  int64  `json:"user_age"`
 }
 
-// service to get all users
-func (s *UsersService) GetAllUsers(tenantId, tableName string) ([]internal_types.CreateUser, error) {
-	queryInput := dynamodb.QueryInput{
-		TableName:              aws.String(tableName),
-		KeyConditionExpression: aws.String("PartitionKey = :pk AND begins_with(SortKey, :skprefix)"),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":pk":       &types.AttributeValueMemberS{Value: tenantId},
-			":skprefix": &types.AttributeValueMemberS{Value: "USER#"},
-		},
-	}
-
+// service to get a page of users
+func (s *UsersService) GetAllUsers(tenantId string, page internal_types.PageQuery) (*internal_types.Page[internal_types.CreateUser], error) {
 	// get users with query input
-	retrievedUsers, err := s.store.QueryDB(queryInput)
+	retrievedUsers, next, err := s.store.QueryPage(tenantId, "USER#", store.PageRequest{Limit: page.Limit, Cursor: page.Cursor})
 	if err != nil {
-		return nil, err
+		return nil, listError(err)
 	}
 
 	// marshal users
-	var UserStruct []internal_types.CreateUser
-	err = attributevalue.UnmarshalListOfMaps(retrievedUsers.Items, &UserStruct)
+	UserStruct := make([]internal_types.CreateUser, 0, len(retrievedUsers))
+	err = attributevalue.UnmarshalListOfMaps(retrievedUsers, &UserStruct)
 	if err != nil {
 		return nil, err
 	}
-	log.Printf("%v", retrievedUsers.Items)
-	log.Printf("%v", UserStruct)
 
-	return UserStruct, nil
+	return &internal_types.Page[internal_types.CreateUser]{Items: UserStruct, NextCursor: next}, nil
 }
 
 // creating user invite
@@ -92,11 +90,14 @@ func (s *UsersService) CreateInviteUser(userDto internal_types.UserInvite, tenan
 		log.Printf("error storing user in database %v", err)
 		return err
 	}
-	err = SendInvitationMail(userDto.Email, tenantName, inviteURL)
-	if err != nil {
-		log.Printf("failed to send user invite mail %v", err)
-		return err
-	}
+
+	// the invitee is not a user yet, so the invite can only be emailed
+	s.notify.Dispatch(Message{
+		Type:    NotifyInvite,
+		Email:   userDto.Email,
+		Subject: fmt.Sprintf("Invitation to join %s", tenantName),
+		Body:    fmt.Sprintf("You have been invited to join %s on tasork! click on the link below to accept invite. \n\n\n%s", tenantName, inviteURL),
+	})
 	return nil
 }
 
@@ -115,31 +116,101 @@ func getUUIDfromString(IdStr string) (string, error) {
 	return res[1], nil
 }
 
-func (s *UsersService) GetNotifications(userId string, tableName string) ([]internal_types.NotificationDTO, error) {
-	// need user id - primarykey from token claims
-	queryInput := dynamodb.QueryInput{
-		TableName:              aws.String(tableName),
-		KeyConditionExpression: aws.String("PartitionKey = :pk AND begins_with(SortKey, :skprefix)"),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":pk":       &types.AttributeValueMemberS{Value: userId},
-			":skprefix": &types.AttributeValueMemberS{Value: "NOTIFICATION#"},
-		},
+// lists a page of a user's notifications, newest first
+func (s *UsersService) GetNotifications(userId string, query store.NotificationQuery, page internal_types.PageQuery) (*internal_types.Page[internal_types.NotificationDTO], error) {
+	notifications, next, err := s.notifications.ListNotifications(userId, query, time.Now().Unix(), store.PageRequest{Limit: page.Limit, Cursor: page.Cursor})
+	if err != nil {
+		return nil, listError(err)
 	}
 
-	// get users with query input
-	retrievedNotifications, err := s.store.QueryDB(queryInput)
-	if err != nil {
-		return nil, err
+	results := make([]internal_types.NotificationDTO, 0, len(notifications))
+	for _, notification := range notifications {
+		results = append(results, ToNotificationDTO(notification))
 	}
+	return &internal_types.Page[internal_types.NotificationDTO]{Items: results, NextCursor: next}, nil
+}
 
-	// marshal users
-	var notificationStruct []internal_types.NotificationDTO
-	err = attributevalue.UnmarshalListOfMaps(retrievedNotifications.Items, &notificationStruct)
+func ToNotificationDTO(notification store.Notification) internal_types.NotificationDTO {
+	return internal_types.NotificationDTO{
+		PartitionKey: store.UserKey(notification.UserID),
+		SortKey:      "NOTIFICATION#" + notification.NotificationID,
+		Message:      notification.Message,
+		Time:         notification.Time,
+		Read:         notification.ReadAt != "",
+		ReadAt:       notification.ReadAt,
+		Archived:     notification.ArchivedAt != "",
+	}
+}
+
+// most notifications replayed to a stream that reconnects
+const maxReplayedNotifications = 100
+
+// subscribes to a user's notifications as they are written, returning along
+// with it the ones written after lastEventId, which a reconnecting stream
+// missed. The subscription starts first so nothing falls in between, and
+// may repeat some of the replayed notifications
+func (s *UsersService) StreamNotifications(userId, lastEventId string) (*Subscription, []store.Notification, error) {
+	subscription := s.hub.Subscribe(userId)
+
+	// ids from before notification ids were ulids do not sort by time, so they cannot be replayed from
+	lastEventId = strings.TrimPrefix(lastEventId, "NOTIFICATION#")
+	if len(lastEventId) != 26 {
+		return subscription, nil, nil
+	}
+	missed, err := s.notifications.ListNotificationsAfter(userId, lastEventId, maxReplayedNotifications)
 	if err != nil {
-		return nil, err
+		subscription.Close()
+		return nil, nil, err
 	}
-	log.Printf("%v", retrievedNotifications.Items)
-	log.Printf("%v", notificationStruct)
+	return subscription, missed, nil
+}
+
+func (s *UsersService) CountUnreadNotifications(userId string) (int, error) {
+	return s.notifications.CountUnread(userId)
+}
 
-	return notificationStruct, nil
+// the read time of a notification read now, and the unix time it expires at
+func (s *UsersService) readNow() (string, int64) {
+	now := time.Now().UTC()
+	var expiresAt int64
+	if s.readTTL > 0 {
+		expiresAt = now.Add(s.readTTL).Unix()
+	}
+	return now.Format(time.RFC3339), expiresAt
+}
+
+// accepts the id with or without its NOTIFICATION# prefix, as listings return it
+func (s *UsersService) MarkNotificationRead(userId, notificationId string) error {
+	notificationId = strings.TrimPrefix(notificationId, "NOTIFICATION#")
+	readAt, expiresAt := s.readNow()
+	err := s.notifications.MarkRead(userId, notificationId, readAt, expiresAt)
+	if errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("notification %s: %w", notificationId, ErrNotFound)
+	}
+	return err
+}
+
+// marks every unread notification of a user read, returning how many there were
+func (s *UsersService) MarkAllNotificationsRead(userId string) (int, error) {
+	readAt, expiresAt := s.readNow()
+	return s.notifications.MarkAllRead(userId, readAt, expiresAt)
+}
+
+// keeps a notification out of listings for good, marking it read if it was not
+func (s *UsersService) ArchiveNotification(userId, notificationId string) error {
+	notificationId = strings.TrimPrefix(notificationId, "NOTIFICATION#")
+	err := s.notifications.ArchiveNotification(userId, notificationId, time.Now().UTC().Format(time.RFC3339))
+	if errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("notification %s: %w", notificationId, ErrNotFound)
+	}
+	return err
+}
+
+func (s *UsersService) DeleteNotification(userId, notificationId string) error {
+	notificationId = strings.TrimPrefix(notificationId, "NOTIFICATION#")
+	err := s.notifications.DeleteNotification(userId, notificationId)
+	if errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("notification %s: %w", notificationId, ErrNotFound)
+	}
+	return err
 }
@@ -0,0 +1,251 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/Ghaby-X/tasork/internal/store"
+	internal_types "github.com/Ghaby-X/tasork/internal/types"
+)
+
+// the workflow of tenants that have not saved their own
+func defaultWorkflow(tenantId string) store.Workflow {
+	anyone := []string{}
+	admins := []string{"admin"}
+
+	return store.Workflow{
+		TenantID:     tenantId,
+		InitialState: "todo",
+		States: []store.WorkflowState{
+			{Name: "todo"},
+			{Name: "in-progress"},
+			{Name: "done", Terminal: true},
+			{Name: "cancelled", Terminal: true},
+		},
+		Transitions: []store.Transition{
+			{From: "todo", To: "in-progress", Roles: anyone},
+			{From: "in-progress", To: "todo", Roles: anyone},
+			{From: "in-progress", To: "done", Roles: anyone},
+			{From: "todo", To: "done", Roles: admins},
+			{From: "todo", To: "cancelled", Roles: admins},
+			{From: "in-progress", To: "cancelled", Roles: admins},
+		},
+	}
+}
+
+type WorkflowService struct {
+	store store.WorkflowStore
+}
+
+func NewWorkflowService(workflowstore store.WorkflowStore) *WorkflowService {
+	return &WorkflowService{workflowstore}
+}
+
+// the tenant's saved workflow, or the default one
+func (s *WorkflowService) workflow(tenantId string) (store.Workflow, error) {
+	workflow, err := s.store.GetWorkflow(tenantId)
+	if errors.Is(err, store.ErrNotFound) {
+		return defaultWorkflow(tenantId), nil
+	}
+	if err != nil {
+		return store.Workflow{}, fmt.Errorf("failed to get workflow: %w", err)
+	}
+	return *workflow, nil
+}
+
+func (s *WorkflowService) GetWorkflow(tenantId string) (*internal_types.WorkflowDTO, error) {
+	workflow, err := s.workflow(tenantId)
+	if err != nil {
+		return nil, err
+	}
+
+	output := toWorkflowDTO(workflow)
+	return &output, nil
+}
+
+// replaces the tenant's workflow. Tasks left in a state the new workflow no
+// longer has move on as if they were in its initial state
+func (s *WorkflowService) UpdateWorkflow(data internal_types.WorkflowDTO, user internal_types.TokenClaims) (*internal_types.WorkflowDTO, error) {
+	workflow := store.Workflow{
+		TenantID:     user["custom:tenantId"],
+		InitialState: data.InitialState,
+		UpdatedBy:    user["sub"],
+		UpdatedAt:    time.Now().UTC().Format(time.RFC3339),
+	}
+	for _, state := range data.States {
+		workflow.States = append(workflow.States, store.WorkflowState{Name: state.Name, Terminal: state.Terminal})
+	}
+	for _, transition := range data.Transitions {
+		roles := transition.Roles
+		if roles == nil {
+			roles = []string{}
+		}
+		workflow.Transitions = append(workflow.Transitions, store.Transition{From: transition.From, To: transition.To, Roles: roles})
+	}
+
+	if err := validateWorkflow(workflow); err != nil {
+		return nil, err
+	}
+	if err := s.store.PutWorkflow(workflow); err != nil {
+		return nil, fmt.Errorf("failed to save workflow: %w", err)
+	}
+
+	output := toWorkflowDTO(workflow)
+	return &output, nil
+}
+
+// the status a new task starts in, the initial state when none is given
+func (s *WorkflowService) createStatus(tenantId, status string) (string, error) {
+	workflow, err := s.workflow(tenantId)
+	if err != nil {
+		return "", err
+	}
+
+	if status == "" {
+		return workflow.InitialState, nil
+	}
+	state, ok := findState(workflow, status)
+	if !ok {
+		return "", fmt.Errorf("%w: %q is not a status of the workflow", ErrIllegalTransition, status)
+	}
+	if state.Terminal {
+		return "", fmt.Errorf("%w: a task cannot be created as %q", ErrIllegalTransition, status)
+	}
+	return status, nil
+}
+
+// checks that role may move a task from one status to another. Staying put is
+// always allowed, and a task in a status the workflow does not know, left over
+// from before it or from an older workflow, moves as if it were in the
+// initial state, with the same transitions and roles
+func (s *WorkflowService) checkTransition(tenantId, role, from, to string) error {
+	if from == to {
+		return nil
+	}
+
+	workflow, err := s.workflow(tenantId)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := findState(workflow, to); !ok {
+		return fmt.Errorf("%w: %q is not a status of the workflow", ErrIllegalTransition, to)
+	}
+	fromState, ok := findState(workflow, from)
+	if !ok {
+		from = workflow.InitialState
+		if from == to {
+			return nil
+		}
+		fromState, _ = findState(workflow, from)
+	}
+	if fromState.Terminal {
+		return fmt.Errorf("%w: %q is a final status", ErrIllegalTransition, from)
+	}
+
+	for _, transition := range workflow.Transitions {
+		if transition.From != from || transition.To != to {
+			continue
+		}
+		if len(transition.Roles) > 0 && !slices.Contains(transition.Roles, role) {
+			return fmt.Errorf("%w: role %q may not move a task from %q to %q", ErrIllegalTransition, role, from, to)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: a task cannot move from %q to %q", ErrIllegalTransition, from, to)
+}
+
+// the final statuses of the tenant's workflow
+func (s *WorkflowService) terminalStatuses(tenantId string) (map[string]bool, error) {
+	workflow, err := s.workflow(tenantId)
+	if err != nil {
+		return nil, err
+	}
+
+	terminal := map[string]bool{}
+	for _, state := range workflow.States {
+		if state.Terminal {
+			terminal[state.Name] = true
+		}
+	}
+	return terminal, nil
+}
+
+func findState(workflow store.Workflow, name string) (store.WorkflowState, bool) {
+	for _, state := range workflow.States {
+		if state.Name == name {
+			return state, true
+		}
+	}
+	return store.WorkflowState{}, false
+}
+
+func validateWorkflow(workflow store.Workflow) error {
+	if len(workflow.States) == 0 {
+		return fmt.Errorf("%w: at least one state is required", ErrInvalidWorkflow)
+	}
+
+	seen := map[string]bool{}
+	for _, state := range workflow.States {
+		if state.Name == "" {
+			return fmt.Errorf("%w: states must have a name", ErrInvalidWorkflow)
+		}
+		if seen[state.Name] {
+			return fmt.Errorf("%w: state %q is listed twice", ErrInvalidWorkflow, state.Name)
+		}
+		seen[state.Name] = true
+	}
+
+	initial, ok := findState(workflow, workflow.InitialState)
+	if !ok {
+		return fmt.Errorf("%w: initial state %q is not one of the states", ErrInvalidWorkflow, workflow.InitialState)
+	}
+	if initial.Terminal {
+		return fmt.Errorf("%w: the initial state cannot be terminal", ErrInvalidWorkflow)
+	}
+
+	moves := map[[2]string]bool{}
+	for _, transition := range workflow.Transitions {
+		from, ok := findState(workflow, transition.From)
+		if !ok {
+			return fmt.Errorf("%w: transition from unknown state %q", ErrInvalidWorkflow, transition.From)
+		}
+		if _, ok := findState(workflow, transition.To); !ok {
+			return fmt.Errorf("%w: transition to unknown state %q", ErrInvalidWorkflow, transition.To)
+		}
+		if from.Terminal {
+			return fmt.Errorf("%w: terminal state %q cannot have transitions out of it", ErrInvalidWorkflow, from.Name)
+		}
+		if transition.From == transition.To {
+			return fmt.Errorf("%w: transition from %q to itself", ErrInvalidWorkflow, transition.From)
+		}
+		if moves[[2]string{transition.From, transition.To}] {
+			return fmt.Errorf("%w: transition from %q to %q is listed twice", ErrInvalidWorkflow, transition.From, transition.To)
+		}
+		moves[[2]string{transition.From, transition.To}] = true
+
+		if slices.Contains(transition.Roles, "") {
+			return fmt.Errorf("%w: transition roles must not be empty", ErrInvalidWorkflow)
+		}
+	}
+	return nil
+}
+
+func toWorkflowDTO(workflow store.Workflow) internal_types.WorkflowDTO {
+	output := internal_types.WorkflowDTO{
+		InitialState: workflow.InitialState,
+		States:       make([]internal_types.WorkflowStateDTO, 0, len(workflow.States)),
+		Transitions:  make([]internal_types.WorkflowTransitionDTO, 0, len(workflow.Transitions)),
+		UpdatedBy:    workflow.UpdatedBy,
+		UpdatedAt:    workflow.UpdatedAt,
+	}
+	for _, state := range workflow.States {
+		output.States = append(output.States, internal_types.WorkflowStateDTO{Name: state.Name, Terminal: state.Terminal})
+	}
+	for _, transition := range workflow.Transitions {
+		output.Transitions = append(output.Transitions, internal_types.WorkflowTransitionDTO{From: transition.From, To: transition.To, Roles: transition.Roles})
+	}
+	return output
+}
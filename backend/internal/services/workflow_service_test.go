@@ -0,0 +1,42 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Ghaby-X/tasork/internal/store"
+)
+
+func TestCheckTransition(t *testing.T) {
+	workflows := NewWorkflowService(store.NewMemoryStorage().Workflows)
+
+	tests := []struct {
+		name     string
+		role     string
+		from, to string
+		allowed  bool
+	}{
+		{"staying put", "member", "todo", "todo", true},
+		{"allowed transition", "member", "todo", "in-progress", true},
+		{"admin only transition", "admin", "todo", "cancelled", true},
+		{"admin only transition as member", "member", "todo", "cancelled", false},
+		{"unknown target", "admin", "in-progress", "archived", false},
+		{"out of a final status", "admin", "done", "todo", false},
+		{"unknown from moves as initial", "member", "archived", "in-progress", true},
+		{"unknown from checks roles", "member", "archived", "done", false},
+		{"unknown from as admin", "admin", "archived", "done", true},
+		{"unknown from into initial", "member", "archived", "todo", true},
+		{"unknown from and target", "admin", "archived", "shelved", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := workflows.checkTransition("TENANT#a", tt.role, tt.from, tt.to)
+			if tt.allowed && err != nil {
+				t.Errorf("checkTransition(%q, %q, %q) = %v, want nil", tt.role, tt.from, tt.to, err)
+			}
+			if !tt.allowed && !errors.Is(err, ErrIllegalTransition) {
+				t.Errorf("checkTransition(%q, %q, %q) = %v, want ErrIllegalTransition", tt.role, tt.from, tt.to, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/Ghaby-X/tasork/internal/env"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const attachmentPrefix = "ATTACHMENT#"
+
+// Attachment is the metadata of a file attached to a task, its contents are
+// kept in a BlobStore under BlobKey. A pending attachment was handed a
+// presigned upload url and has not been confirmed uploaded yet
+type Attachment struct {
+	TaskID       string
+	AttachmentID string
+	FileName     string
+	ContentType  string
+	Size         int64
+	BlobKey      string
+	UploadedBy   string
+	UploadedAt   string
+	Pending      bool
+}
+
+type AttachmentsStore interface {
+	// writes the metadata of a new attachment, with its history entry if any
+	PutAttachment(attachment Attachment, history *HistoryEntry) error
+	// returns ErrNotFound when the task has no such attachment
+	GetAttachment(taskID, attachmentID string) (*Attachment, error)
+	// marks a pending attachment uploaded, ErrNotFound if it is not pending
+	CompleteAttachment(attachment Attachment, history HistoryEntry) error
+	DeleteAttachment(taskID, attachmentID string, history *HistoryEntry) error
+	// lists one page of a task's attachments, oldest first
+	ListAttachments(taskID string, page PageRequest) ([]Attachment, string, error)
+}
+
+type attachmentItem struct {
+	PartitionKey string `dynamodbav:"PartitionKey"`
+	SortKey      string `dynamodbav:"SortKey"`
+	FileName     string `dynamodbav:"fileName"`
+	ContentType  string `dynamodbav:"contentType"`
+	Size         int64  `dynamodbav:"size"`
+	BlobKey      string `dynamodbav:"blobKey"`
+	UploadedBy   string `dynamodbav:"uploadedBy"`
+	UploadedAt   string `dynamodbav:"uploadedAt"`
+	Pending      bool   `dynamodbav:"pending,omitempty"`
+}
+
+func newAttachmentItem(attachment Attachment) attachmentItem {
+	return attachmentItem{
+		PartitionKey: TaskKey(attachment.TaskID),
+		SortKey:      attachmentPrefix + attachment.AttachmentID,
+		FileName:     attachment.FileName,
+		ContentType:  attachment.ContentType,
+		Size:         attachment.Size,
+		BlobKey:      attachment.BlobKey,
+		UploadedBy:   UserKey(attachment.UploadedBy),
+		UploadedAt:   attachment.UploadedAt,
+		Pending:      attachment.Pending,
+	}
+}
+
+func (item attachmentItem) attachment() Attachment {
+	return Attachment{
+		TaskID:       strings.TrimPrefix(item.PartitionKey, taskPrefix),
+		AttachmentID: strings.TrimPrefix(item.SortKey, attachmentPrefix),
+		FileName:     item.FileName,
+		ContentType:  item.ContentType,
+		Size:         item.Size,
+		BlobKey:      item.BlobKey,
+		UploadedBy:   strings.TrimPrefix(item.UploadedBy, userPrefix),
+		UploadedAt:   item.UploadedAt,
+		Pending:      item.Pending,
+	}
+}
+
+type attachmentsStore struct {
+	db        DynamoDBAPI
+	tableName string
+	cursors   *cursorCodec
+}
+
+func newAttachmentsStore(db DynamoDBAPI, cursors *cursorCodec) *attachmentsStore {
+	return &attachmentsStore{db, env.GetString("DYNAMODB_TABLE_NAME", "tasork"), cursors}
+}
+
+// writes actions in one transaction, the first of which carries the condition
+func (s *attachmentsStore) write(actions []types.TransactWriteItem, history *HistoryEntry, failed error) error {
+	if history != nil {
+		action, err := putAction(s.tableName, newHistoryItem(*history))
+		if err != nil {
+			return err
+		}
+		actions = append(actions, action)
+	}
+
+	err := transactWrite(s.db, actions)
+	if isConditionFailure(err) {
+		return failed
+	}
+	if err != nil {
+		log.Printf("failed to write attachment, %v", err)
+		return errors.New("could not write attachment")
+	}
+	return nil
+}
+
+func (s *attachmentsStore) PutAttachment(attachment Attachment, history *HistoryEntry) error {
+	action, err := putAction(s.tableName, newAttachmentItem(attachment))
+	if err != nil {
+		return err
+	}
+	action.Put.ConditionExpression = aws.String("attribute_not_exists(PartitionKey)")
+
+	return s.write([]types.TransactWriteItem{action}, history, ErrAlreadyExists)
+}
+
+func (s *attachmentsStore) GetAttachment(taskID, attachmentID string) (*Attachment, error) {
+	output, err := s.db.GetItem(context.Background(), &dynamodb.GetItemInput{
+		TableName: aws.String(s.tableName),
+		Key:       keyAttributes(TaskKey(taskID), attachmentPrefix+attachmentID),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if output.Item == nil {
+		return nil, ErrNotFound
+	}
+
+	var item attachmentItem
+	if err := attributevalue.UnmarshalMap(output.Item, &item); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal attachment: %w", err)
+	}
+	attachment := item.attachment()
+	return &attachment, nil
+}
+
+func (s *attachmentsStore) CompleteAttachment(attachment Attachment, history HistoryEntry) error {
+	return s.write([]types.TransactWriteItem{{Update: &types.Update{
+		TableName:           aws.String(s.tableName),
+		Key:                 keyAttributes(TaskKey(attachment.TaskID), attachmentPrefix+attachment.AttachmentID),
+		UpdateExpression:    aws.String("SET #uploadedAt = :uploadedAt REMOVE #pending"),
+		ConditionExpression: aws.String("#pending = :pending"),
+		ExpressionAttributeNames: map[string]string{
+			"#uploadedAt": "uploadedAt",
+			"#pending":    "pending",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":uploadedAt": &types.AttributeValueMemberS{Value: attachment.UploadedAt},
+			":pending":    &types.AttributeValueMemberBOOL{Value: true},
+		},
+	}}}, &history, ErrNotFound)
+}
+
+func (s *attachmentsStore) DeleteAttachment(taskID, attachmentID string, history *HistoryEntry) error {
+	return s.write([]types.TransactWriteItem{{Delete: &types.Delete{
+		TableName:           aws.String(s.tableName),
+		Key:                 keyAttributes(TaskKey(taskID), attachmentPrefix+attachmentID),
+		ConditionExpression: aws.String("attribute_exists(PartitionKey)"),
+	}}}, history, ErrNotFound)
+}
+
+func (s *attachmentsStore) ListAttachments(taskID string, page PageRequest) ([]Attachment, string, error) {
+	rows, next, err := queryPage(s.db, s.cursors, s.tableName, TaskKey(taskID), attachmentPrefix, nil, page)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var items []attachmentItem
+	if err := attributevalue.UnmarshalListOfMaps(rows, &items); err != nil {
+		return nil, "", fmt.Errorf("failed to unmarshal attachments: %w", err)
+	}
+
+	attachments := make([]Attachment, 0, len(items))
+	for _, item := range items {
+		attachments = append(attachments, item.attachment())
+	}
+	return attachments, next, nil
+}
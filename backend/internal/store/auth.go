@@ -8,11 +8,18 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
-type AuthStore struct {
-	db *dynamodb.Client
+type AuthStore interface {
+	RegisterAdminTenant(tableName string, Item map[string]types.AttributeValue) (*dynamodb.PutItemOutput, error)
+	GetItem(input dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error)
+	BatchWriteItem(BatchInput *dynamodb.BatchWriteItemInput) error
+	CreateItem(tableName string, Item map[string]types.AttributeValue) (*dynamodb.PutItemOutput, error)
 }
 
-func (s *AuthStore) RegisterAdminTenant(tableName string, Item map[string]types.AttributeValue) (*dynamodb.PutItemOutput, error) {
+type authStore struct {
+	db DynamoDBAPI
+}
+
+func (s *authStore) RegisterAdminTenant(tableName string, Item map[string]types.AttributeValue) (*dynamodb.PutItemOutput, error) {
 	output, err := utils.InsertIntoDB(s.db, tableName, Item)
 	if err != nil {
 		return nil, err
@@ -21,7 +28,7 @@ func (s *AuthStore) RegisterAdminTenant(tableName string, Item map[string]types.
 }
 
 // get item from database
-func (s *AuthStore) GetItem(input dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+func (s *authStore) GetItem(input dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
 	result, err := s.db.GetItem(context.Background(), &input)
 	if err != nil {
 		return nil, err
@@ -30,13 +37,12 @@ func (s *AuthStore) GetItem(input dynamodb.GetItemInput) (*dynamodb.GetItemOutpu
 	return result, nil
 }
 
-// batch write item
-func (s *AuthStore) BatchWriteItem(BatchInput *dynamodb.BatchWriteItemInput) error {
-	_, err := s.db.BatchWriteItem(context.Background(), BatchInput)
-	return err
+// batch write item, chunked and retried until every item is written
+func (s *authStore) BatchWriteItem(BatchInput *dynamodb.BatchWriteItemInput) error {
+	return batchWrite(s.db, BatchInput.RequestItems)
 }
 
-func (s *AuthStore) CreateItem(tableName string, Item map[string]types.AttributeValue) (*dynamodb.PutItemOutput, error) {
+func (s *authStore) CreateItem(tableName string, Item map[string]types.AttributeValue) (*dynamodb.PutItemOutput, error) {
 	output, err := utils.InsertIntoDB(s.db, tableName, Item)
 	if err != nil {
 		return nil, err
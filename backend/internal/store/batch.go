@@ -0,0 +1,162 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// retry policy for items dynamodb hands back as unprocessed
+const (
+	batchMaxAttempts = 5
+	batchBaseDelay   = 50 * time.Millisecond
+	batchMaxDelay    = 2 * time.Second
+)
+
+// FailedWrite identifies an item that could not be written
+type FailedWrite struct {
+	TableName    string
+	PartitionKey string
+	SortKey      string
+}
+
+// BatchWriteError lists the items still unwritten after every retry
+type BatchWriteError struct {
+	Failed []FailedWrite
+	Err    error
+}
+
+func (e *BatchWriteError) Error() string {
+	keys := make([]string, 0, len(e.Failed))
+	for _, f := range e.Failed {
+		keys = append(keys, f.PartitionKey+"/"+f.SortKey)
+	}
+	msg := fmt.Sprintf("failed to write %d items: %s", len(e.Failed), strings.Join(keys, ", "))
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *BatchWriteError) Unwrap() error {
+	return e.Err
+}
+
+type tableWrite struct {
+	tableName string
+	request   types.WriteRequest
+}
+
+// full jitter exponential backoff for the given retry attempt
+func backoff(attempt int) time.Duration {
+	delay := min(batchBaseDelay<<attempt, batchMaxDelay)
+	return rand.N(delay) + time.Millisecond
+}
+
+// writes any number of requests in chunks of 25, retrying unprocessed items
+// with backoff. Returns a *BatchWriteError naming every item left unwritten
+func batchWrite(db DynamoDBAPI, requestItems map[string][]types.WriteRequest) error {
+	var writes []tableWrite
+	for tableName, requests := range requestItems {
+		for _, request := range requests {
+			writes = append(writes, tableWrite{tableName, request})
+		}
+	}
+
+	batchErr := &BatchWriteError{}
+	for start := 0; start < len(writes); start += maxBatchWriteItems {
+		end := min(start+maxBatchWriteItems, len(writes))
+		pending := groupByTable(writes[start:end])
+
+		for attempt := 0; len(pending) > 0; attempt++ {
+			if attempt > 0 {
+				if attempt >= batchMaxAttempts {
+					break
+				}
+				time.Sleep(backoff(attempt))
+			}
+
+			output, err := db.BatchWriteItem(context.Background(), &dynamodb.BatchWriteItemInput{RequestItems: pending})
+			if err != nil {
+				// the sdk already retries throttling, anything left is not worth retrying here
+				batchErr.Err = errors.Join(batchErr.Err, err)
+				break
+			}
+			pending = output.UnprocessedItems
+		}
+
+		for tableName, requests := range pending {
+			for _, request := range requests {
+				batchErr.Failed = append(batchErr.Failed, failedWrite(tableName, request))
+			}
+		}
+	}
+
+	if len(batchErr.Failed) > 0 {
+		log.Printf("batch write incomplete: %v", batchErr)
+		return batchErr
+	}
+	return nil
+}
+
+// reads the items at keys of one table in chunks of 100, retrying unprocessed
+// keys with backoff. Items come back in no particular order and missing items
+// are left out, keys must not repeat
+func batchGet(db DynamoDBAPI, tableName string, keys []map[string]types.AttributeValue) ([]map[string]types.AttributeValue, error) {
+	var items []map[string]types.AttributeValue
+	for start := 0; start < len(keys); start += maxBatchGetKeys {
+		end := min(start+maxBatchGetKeys, len(keys))
+		pending := map[string]types.KeysAndAttributes{tableName: {Keys: keys[start:end]}}
+
+		for attempt := 0; len(pending) > 0; attempt++ {
+			if attempt > 0 {
+				if attempt >= batchMaxAttempts {
+					return nil, fmt.Errorf("failed to read %d items after %d attempts", len(pending[tableName].Keys), attempt)
+				}
+				time.Sleep(backoff(attempt))
+			}
+
+			output, err := db.BatchGetItem(context.Background(), &dynamodb.BatchGetItemInput{RequestItems: pending})
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, output.Responses[tableName]...)
+			pending = output.UnprocessedKeys
+		}
+	}
+	return items, nil
+}
+
+func groupByTable(writes []tableWrite) map[string][]types.WriteRequest {
+	grouped := map[string][]types.WriteRequest{}
+	for _, w := range writes {
+		grouped[w.tableName] = append(grouped[w.tableName], w.request)
+	}
+	return grouped
+}
+
+func failedWrite(tableName string, request types.WriteRequest) FailedWrite {
+	key := map[string]types.AttributeValue{}
+	switch {
+	case request.PutRequest != nil:
+		key = request.PutRequest.Item
+	case request.DeleteRequest != nil:
+		key = request.DeleteRequest.Key
+	}
+
+	failed := FailedWrite{TableName: tableName}
+	if pk, ok := key[partitionKeyAttr].(*types.AttributeValueMemberS); ok {
+		failed.PartitionKey = pk.Value
+	}
+	if sk, ok := key[sortKeyAttr].(*types.AttributeValueMemberS); ok {
+		failed.SortKey = sk.Value
+	}
+	return failed
+}
@@ -0,0 +1,210 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// a MemoryDB that hands back part of every batch call as unprocessed: the
+// last held requests of each of the first rounds calls, and the stuck sort
+// keys every time
+type unprocessedDB struct {
+	*MemoryDB
+	rounds int
+	held   int
+	stuck  map[string]bool
+	writes int
+	gets   int
+}
+
+// splits keys into the ones this call processes and the ones it hands back
+func (db *unprocessedDB) split(keys []map[string]types.AttributeValue, call int) (done, back []int) {
+	for i, key := range keys {
+		_, sk, _ := keyOf(key)
+		if db.stuck[sk] {
+			back = append(back, i)
+		} else {
+			done = append(done, i)
+		}
+	}
+	if call <= db.rounds {
+		cut := max(len(done)-db.held, 0)
+		back = append(back, done[cut:]...)
+		done = done[:cut]
+	}
+	return done, back
+}
+
+func (db *unprocessedDB) BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	db.writes++
+	requests := params.RequestItems[testTable]
+	keys := make([]map[string]types.AttributeValue, 0, len(requests))
+	for _, request := range requests {
+		keys = append(keys, request.PutRequest.Item)
+	}
+
+	done, back := db.split(keys, db.writes)
+	output := &dynamodb.BatchWriteItemOutput{UnprocessedItems: map[string][]types.WriteRequest{}}
+	for _, i := range back {
+		output.UnprocessedItems[testTable] = append(output.UnprocessedItems[testTable], requests[i])
+	}
+	if len(done) > 0 {
+		var processed []types.WriteRequest
+		for _, i := range done {
+			processed = append(processed, requests[i])
+		}
+		if _, err := db.MemoryDB.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{RequestItems: map[string][]types.WriteRequest{testTable: processed}}); err != nil {
+			return nil, err
+		}
+	}
+	return output, nil
+}
+
+func (db *unprocessedDB) BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
+	db.gets++
+	keys := params.RequestItems[testTable].Keys
+
+	done, back := db.split(keys, db.gets)
+	output := &dynamodb.BatchGetItemOutput{
+		Responses:       map[string][]map[string]types.AttributeValue{},
+		UnprocessedKeys: map[string]types.KeysAndAttributes{},
+	}
+	if len(back) > 0 {
+		var unprocessed []map[string]types.AttributeValue
+		for _, i := range back {
+			unprocessed = append(unprocessed, keys[i])
+		}
+		output.UnprocessedKeys[testTable] = types.KeysAndAttributes{Keys: unprocessed}
+	}
+	if len(done) > 0 {
+		var processed []map[string]types.AttributeValue
+		for _, i := range done {
+			processed = append(processed, keys[i])
+		}
+		read, err := db.MemoryDB.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{RequestItems: map[string]types.KeysAndAttributes{testTable: {Keys: processed}}})
+		if err != nil {
+			return nil, err
+		}
+		output.Responses = read.Responses
+	}
+	return output, nil
+}
+
+func testWrites(n int) map[string][]types.WriteRequest {
+	var writes []types.WriteRequest
+	for i := range n {
+		writes = append(writes, types.WriteRequest{PutRequest: &types.PutRequest{Item: testItem("P", fmt.Sprintf("S#%02d", i))}})
+	}
+	return map[string][]types.WriteRequest{testTable: writes}
+}
+
+func testKeys(n int) []map[string]types.AttributeValue {
+	var keys []map[string]types.AttributeValue
+	for i := range n {
+		keys = append(keys, keyAttributes("P", fmt.Sprintf("S#%02d", i)))
+	}
+	return keys
+}
+
+// the sort keys in partition P, in order
+func writtenKeys(t *testing.T, db *MemoryDB) []string {
+	t.Helper()
+	output, err := db.Query(context.Background(), &dynamodb.QueryInput{
+		TableName:                 aws.String(testTable),
+		KeyConditionExpression:    aws.String("PartitionKey = :pk"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":pk": str("P")},
+	})
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	return sortKeys(output.Items)
+}
+
+func TestBatchWriteRetriesUnprocessedItems(t *testing.T) {
+	db := &unprocessedDB{MemoryDB: NewMemoryDB(), rounds: 2, held: 3}
+
+	// 60 items are chunks of 25, 25 and 10. The first takes three calls, as
+	// the first two hand back three items each, the others take one
+	if err := batchWrite(db, testWrites(60)); err != nil {
+		t.Fatalf("batch write: %v", err)
+	}
+	if db.writes != 5 {
+		t.Errorf("made %d BatchWriteItem calls, want 5", db.writes)
+	}
+	if got := writtenKeys(t, db.MemoryDB); len(got) != 60 {
+		t.Errorf("wrote %d items, want 60", len(got))
+	}
+}
+
+func TestBatchWriteReportsItemsNeverWritten(t *testing.T) {
+	db := &unprocessedDB{MemoryDB: NewMemoryDB(), stuck: map[string]bool{"S#03": true, "S#27": true}}
+
+	err := batchWrite(db, testWrites(30))
+	var batchErr *BatchWriteError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("got %v, want a *BatchWriteError", err)
+	}
+	var failed []string
+	for _, f := range batchErr.Failed {
+		if f.TableName != testTable || f.PartitionKey != "P" {
+			t.Errorf("failed write %+v, want one in %s/P", f, testTable)
+		}
+		failed = append(failed, f.SortKey)
+	}
+	slices.Sort(failed)
+	if want := []string{"S#03", "S#27"}; !slices.Equal(failed, want) {
+		t.Errorf("failed keys = %v, want %v", failed, want)
+	}
+	// each chunk gives up after the last attempt
+	if db.writes != 2*batchMaxAttempts {
+		t.Errorf("made %d BatchWriteItem calls, want %d", db.writes, 2*batchMaxAttempts)
+	}
+	if got := writtenKeys(t, db.MemoryDB); len(got) != 28 || slices.Contains(got, "S#03") || slices.Contains(got, "S#27") {
+		t.Errorf("wrote %v, want every item but S#03 and S#27", got)
+	}
+}
+
+func TestBatchGetRetriesUnprocessedKeys(t *testing.T) {
+	db := &unprocessedDB{MemoryDB: NewMemoryDB(), rounds: 2, held: 10}
+	for i := range 150 {
+		putTestItems(t, db.MemoryDB, testItem("P", fmt.Sprintf("S#%03d", i)))
+	}
+	var keys []map[string]types.AttributeValue
+	for i := range 160 {
+		keys = append(keys, keyAttributes("P", fmt.Sprintf("S#%03d", i)))
+	}
+
+	// chunks of 100 and 60, the first taking three calls. The keys past 149
+	// are missing and left out
+	items, err := batchGet(db, testTable, keys)
+	if err != nil {
+		t.Fatalf("batch get: %v", err)
+	}
+	if db.gets != 4 {
+		t.Errorf("made %d BatchGetItem calls, want 4", db.gets)
+	}
+	got := sortKeys(items)
+	slices.Sort(got)
+	if len(got) != 150 || got[0] != "S#000" || got[149] != "S#149" {
+		t.Errorf("read %d items, want S#000 to S#149", len(got))
+	}
+}
+
+func TestBatchGetGivesUp(t *testing.T) {
+	db := &unprocessedDB{MemoryDB: NewMemoryDB(), stuck: map[string]bool{"S#01": true}}
+	putTestItems(t, db.MemoryDB, testItem("P", "S#00"), testItem("P", "S#01"))
+
+	if _, err := batchGet(db, testTable, testKeys(2)); err == nil {
+		t.Fatal("batch get of a key that is never processed succeeded")
+	}
+	if db.gets != batchMaxAttempts {
+		t.Errorf("made %d BatchGetItem calls, want %d", db.gets, batchMaxAttempts)
+	}
+}
@@ -0,0 +1,143 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// returned by blob stores that cannot hand out urls for direct transfers
+var ErrPresignNotSupported = errors.New("presigned urls are not supported")
+
+// BlobInfo describes a stored blob, ContentType is empty when the store does not keep it
+type BlobInfo struct {
+	Size        int64
+	ContentType string
+}
+
+// BlobStore keeps the contents of files, their metadata lives in the table.
+// Keys are slash separated paths chosen by the caller, missing blobs return
+// ErrNotFound
+type BlobStore interface {
+	Put(key string, body io.Reader, size int64, contentType string) error
+	Get(key string) (io.ReadCloser, BlobInfo, error)
+	Stat(key string) (BlobInfo, error)
+	// deleting a missing blob is not an error
+	Delete(key string) error
+	// a url the client can PUT exactly size bytes of contentType to, until expires
+	PresignPut(key, contentType string, size int64, expires time.Duration) (string, error)
+	// a url the client can GET the blob from, until expires
+	PresignGet(key string, expires time.Duration) (string, error)
+}
+
+// keeps blobs as files under a directory, for development and tests. It cannot
+// presign, so every transfer goes through the api
+type localBlobStore struct {
+	dir string
+}
+
+// NewLocalBlobStore keeps blobs under dir, which is created on the first write
+func NewLocalBlobStore(dir string) BlobStore {
+	return &localBlobStore{dir}
+}
+
+// the file of a key, refusing keys that would leave the directory
+func (s *localBlobStore) path(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "..") || strings.Contains(key, `\`) {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+// writes to a temporary file first, so a failed write leaves no partial blob
+func (s *localBlobStore) Put(key string, body io.Reader, size int64, contentType string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create blob directory: %w", err)
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create blob: %w", err)
+	}
+	defer os.Remove(file.Name())
+
+	written, err := io.Copy(file, body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write blob: %w", err)
+	}
+	if written != size {
+		return fmt.Errorf("blob is %d bytes, expected %d", written, size)
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+func (s *localBlobStore) Get(key string) (io.ReadCloser, BlobInfo, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, BlobInfo{}, err
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, BlobInfo{}, ErrNotFound
+	}
+	if err != nil {
+		return nil, BlobInfo{}, err
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, BlobInfo{}, err
+	}
+	return file, BlobInfo{Size: stat.Size()}, nil
+}
+
+func (s *localBlobStore) Stat(key string) (BlobInfo, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+
+	stat, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return BlobInfo{}, ErrNotFound
+	}
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	return BlobInfo{Size: stat.Size()}, nil
+}
+
+func (s *localBlobStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *localBlobStore) PresignPut(key, contentType string, size int64, expires time.Duration) (string, error) {
+	return "", ErrPresignNotSupported
+}
+
+func (s *localBlobStore) PresignGet(key string, expires time.Duration) (string, error) {
+	return "", ErrPresignNotSupported
+}
@@ -0,0 +1,135 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// S3Config locates a bucket of s3 or of an s3 compatible service
+type S3Config struct {
+	// base url of the service, the one of the region when empty
+	Endpoint string
+	Bucket   string
+	Region   string
+	// address the bucket in the path rather than the host name, which most
+	// s3 compatible services need
+	PathStyle   bool
+	Credentials aws.CredentialsProvider
+}
+
+// keeps blobs in an s3 bucket
+type s3BlobStore struct {
+	bucket    string
+	client    *s3.Client
+	presigner *s3.PresignClient
+}
+
+func NewS3BlobStore(config S3Config) BlobStore {
+	client := s3.New(s3.Options{
+		Region:       config.Region,
+		Credentials:  config.Credentials,
+		UsePathStyle: config.PathStyle,
+		HTTPClient:   &http.Client{Timeout: 5 * time.Minute},
+	}, func(options *s3.Options) {
+		if config.Endpoint != "" {
+			options.BaseEndpoint = aws.String(config.Endpoint)
+		}
+	})
+	return &s3BlobStore{config.Bucket, client, s3.NewPresignClient(client)}
+}
+
+// a missing object is ErrNotFound, which the sdk reports as a 404
+func s3Error(action, key string, err error) error {
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotFound {
+		return ErrNotFound
+	}
+	return fmt.Errorf("s3 %s of %s failed: %w", action, key, err)
+}
+
+func (s *s3BlobStore) Put(key string, body io.Reader, size int64, contentType string) error {
+	_, err := s.client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(key),
+		Body:          body,
+		ContentLength: aws.Int64(size),
+		ContentType:   aws.String(contentType),
+	}, s3.WithAPIOptions(
+		// the body may not be seekable, so it is sent without hashing it first
+		v4.SwapComputePayloadSHA256ForUnsignedPayloadMiddleware,
+	))
+	if err != nil {
+		return s3Error("put", key, err)
+	}
+	return nil
+}
+
+func (s *s3BlobStore) Get(key string) (io.ReadCloser, BlobInfo, error) {
+	output, err := s.client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, BlobInfo{}, s3Error("get", key, err)
+	}
+	return output.Body, BlobInfo{Size: aws.ToInt64(output.ContentLength), ContentType: aws.ToString(output.ContentType)}, nil
+}
+
+func (s *s3BlobStore) Stat(key string) (BlobInfo, error) {
+	output, err := s.client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return BlobInfo{}, s3Error("head", key, err)
+	}
+	return BlobInfo{Size: aws.ToInt64(output.ContentLength), ContentType: aws.ToString(output.ContentType)}, nil
+}
+
+func (s *s3BlobStore) Delete(key string) error {
+	_, err := s.client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err == nil {
+		return nil
+	}
+	if err = s3Error("delete", key, err); errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// the signed url carries the content type and length, so the upload must match them
+func (s *s3BlobStore) PresignPut(key, contentType string, size int64, expires time.Duration) (string, error) {
+	req, err := s.presigner.PresignPutObject(context.Background(), &s3.PutObjectInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(key),
+		ContentLength: aws.Int64(size),
+		ContentType:   aws.String(contentType),
+	}, s3.WithPresignExpires(expires))
+	if err != nil {
+		return "", fmt.Errorf("failed to presign s3 upload of %s: %w", key, err)
+	}
+	return req.URL, nil
+}
+
+func (s *s3BlobStore) PresignGet(key string, expires time.Duration) (string, error) {
+	req, err := s.presigner.PresignGetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(expires))
+	if err != nil {
+		return "", fmt.Errorf("failed to presign s3 download of %s: %w", key, err)
+	}
+	return req.URL, nil
+}
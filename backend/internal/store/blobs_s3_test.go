@@ -0,0 +1,45 @@
+package store
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestS3PresignPut(t *testing.T) {
+	blobs := NewS3BlobStore(S3Config{
+		Endpoint:  "http://localhost:9000",
+		Bucket:    "tasork",
+		Region:    "us-east-1",
+		PathStyle: true,
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "key", SecretAccessKey: "secret"}, nil
+		}),
+	})
+
+	signed, err := blobs.PresignPut("tenants/a/tasks/1/file one", "image/png", 100, 15*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Host != "localhost:9000" || u.EscapedPath() != "/tasork/tenants/a/tasks/1/file%20one" {
+		t.Errorf("presigned %s, want the object in the path of the endpoint", signed)
+	}
+	query := u.Query()
+	if query.Get("X-Amz-Expires") != "900" || query.Get("X-Amz-Signature") == "" {
+		t.Errorf("presigned %s, want it signed for 15 minutes", signed)
+	}
+	// the upload has to send the type and length it was signed for
+	for _, header := range []string{"content-length", "content-type"} {
+		if !strings.Contains(query.Get("X-Amz-SignedHeaders"), header) {
+			t.Errorf("signed headers %q do not include %s", query.Get("X-Amz-SignedHeaders"), header)
+		}
+	}
+}
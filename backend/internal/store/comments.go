@@ -0,0 +1,221 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Ghaby-X/tasork/internal/env"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Comment is a comment on a task, ids are kept without prefixes. ParentID is
+// the comment it replies to, empty for a comment that starts a thread. A
+// deleted comment keeps its place in the thread but loses its body
+type Comment struct {
+	TaskID    string
+	CommentID string
+	ParentID  string
+	AuthorID  string
+	Body      string
+	Mentions  []string
+	CreatedAt string
+	UpdatedAt string
+	Deleted   bool
+}
+
+// NewCommentID returns a comment id that sorts after the ids made before at
+func NewCommentID(at time.Time) string {
+	return timeOrderedID(at)
+}
+
+type CommentsStore interface {
+	// writes a comment along with its history entry
+	PutComment(comment Comment, history *HistoryEntry) error
+	// returns ErrNotFound when the task has no such comment
+	GetComment(taskID, commentID string) (*Comment, error)
+	// changes the body and mentions of a comment that is not deleted
+	UpdateComment(comment Comment) error
+	// marks a comment deleted, keeping it for the replies to it
+	DeleteComment(taskID, commentID string) error
+	// lists one page of a task's comments, oldest first
+	ListComments(taskID string, page PageRequest) ([]Comment, string, error)
+}
+
+type commentItem struct {
+	PartitionKey string   `dynamodbav:"PartitionKey"`
+	SortKey      string   `dynamodbav:"SortKey"`
+	ParentID     string   `dynamodbav:"parentId,omitempty"`
+	AuthorID     string   `dynamodbav:"authorId"`
+	Body         string   `dynamodbav:"body"`
+	Mentions     []string `dynamodbav:"mentions,omitempty"`
+	CreatedAt    string   `dynamodbav:"createdAt"`
+	UpdatedAt    string   `dynamodbav:"updatedAt,omitempty"`
+	Deleted      bool     `dynamodbav:"deleted,omitempty"`
+}
+
+func newCommentItem(comment Comment) commentItem {
+	return commentItem{
+		PartitionKey: TaskKey(comment.TaskID),
+		SortKey:      commentPrefix + comment.CommentID,
+		ParentID:     comment.ParentID,
+		AuthorID:     UserKey(comment.AuthorID),
+		Body:         comment.Body,
+		Mentions:     comment.Mentions,
+		CreatedAt:    comment.CreatedAt,
+		UpdatedAt:    comment.UpdatedAt,
+		Deleted:      comment.Deleted,
+	}
+}
+
+func (item commentItem) comment() Comment {
+	return Comment{
+		TaskID:    strings.TrimPrefix(item.PartitionKey, taskPrefix),
+		CommentID: strings.TrimPrefix(item.SortKey, commentPrefix),
+		ParentID:  item.ParentID,
+		AuthorID:  strings.TrimPrefix(item.AuthorID, userPrefix),
+		Body:      item.Body,
+		Mentions:  item.Mentions,
+		CreatedAt: item.CreatedAt,
+		UpdatedAt: item.UpdatedAt,
+		Deleted:   item.Deleted,
+	}
+}
+
+type commentsStore struct {
+	db        DynamoDBAPI
+	tableName string
+	cursors   *cursorCodec
+}
+
+func newCommentsStore(db DynamoDBAPI, cursors *cursorCodec) *commentsStore {
+	return &commentsStore{db, env.GetString("DYNAMODB_TABLE_NAME", "tasork"), cursors}
+}
+
+func (s *commentsStore) PutComment(comment Comment, history *HistoryEntry) error {
+	items := []any{newCommentItem(comment)}
+	if history != nil {
+		items = append(items, newHistoryItem(*history))
+	}
+
+	actions := make([]types.TransactWriteItem, 0, len(items))
+	for _, item := range items {
+		action, err := putAction(s.tableName, item)
+		if err != nil {
+			return err
+		}
+		actions = append(actions, action)
+	}
+	actions[0].Put.ConditionExpression = aws.String("attribute_not_exists(PartitionKey)")
+
+	err := transactWrite(s.db, actions)
+	if isConditionFailure(err) {
+		return ErrAlreadyExists
+	}
+	if err != nil {
+		log.Printf("failed to put comment, %v", err)
+		return errors.New("could not create comment")
+	}
+	return nil
+}
+
+func (s *commentsStore) GetComment(taskID, commentID string) (*Comment, error) {
+	output, err := s.db.GetItem(context.Background(), &dynamodb.GetItemInput{
+		TableName: aws.String(s.tableName),
+		Key:       keyAttributes(TaskKey(taskID), commentPrefix+commentID),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if output.Item == nil {
+		return nil, ErrNotFound
+	}
+
+	var item commentItem
+	if err := attributevalue.UnmarshalMap(output.Item, &item); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal comment: %w", err)
+	}
+	comment := item.comment()
+	return &comment, nil
+}
+
+func (s *commentsStore) UpdateComment(comment Comment) error {
+	mentions, err := attributevalue.Marshal(comment.Mentions)
+	if err != nil {
+		return fmt.Errorf("failed to marshal mentions: %w", err)
+	}
+
+	actions := []types.TransactWriteItem{{Update: &types.Update{
+		TableName:           aws.String(s.tableName),
+		Key:                 keyAttributes(TaskKey(comment.TaskID), commentPrefix+comment.CommentID),
+		UpdateExpression:    aws.String("SET #body = :body, #mentions = :mentions, #updatedAt = :updatedAt"),
+		ConditionExpression: aws.String("attribute_exists(PartitionKey) AND attribute_not_exists(#deleted)"),
+		ExpressionAttributeNames: map[string]string{
+			"#body":      "body",
+			"#mentions":  "mentions",
+			"#updatedAt": "updatedAt",
+			"#deleted":   "deleted",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":body":      &types.AttributeValueMemberS{Value: comment.Body},
+			":mentions":  mentions,
+			":updatedAt": &types.AttributeValueMemberS{Value: comment.UpdatedAt},
+		},
+	}}}
+
+	err = transactWrite(s.db, actions)
+	if isConditionFailure(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		log.Printf("failed to update comment, %v", err)
+		return errors.New("could not update comment")
+	}
+	return nil
+}
+
+func (s *commentsStore) DeleteComment(taskID, commentID string) error {
+	_, err := s.db.UpdateItem(context.Background(), &dynamodb.UpdateItemInput{
+		TableName:           aws.String(s.tableName),
+		Key:                 keyAttributes(TaskKey(taskID), commentPrefix+commentID),
+		UpdateExpression:    aws.String("SET #deleted = :deleted, #body = :empty REMOVE #mentions"),
+		ConditionExpression: aws.String("attribute_exists(PartitionKey)"),
+		ExpressionAttributeNames: map[string]string{
+			"#deleted":  "deleted",
+			"#body":     "body",
+			"#mentions": "mentions",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":deleted": &types.AttributeValueMemberBOOL{Value: true},
+			":empty":   &types.AttributeValueMemberS{Value: ""},
+		},
+	})
+	if isConditionFailure(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *commentsStore) ListComments(taskID string, page PageRequest) ([]Comment, string, error) {
+	rows, next, err := queryPage(s.db, s.cursors, s.tableName, TaskKey(taskID), commentPrefix, nil, page)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var items []commentItem
+	if err := attributevalue.UnmarshalListOfMaps(rows, &items); err != nil {
+		return nil, "", fmt.Errorf("failed to unmarshal comments: %w", err)
+	}
+
+	comments := make([]Comment, 0, len(items))
+	for _, item := range items {
+		comments = append(comments, item.comment())
+	}
+	return comments, next, nil
+}
@@ -0,0 +1,242 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Dependency marks TaskID as blocked by BlockerID, both tasks of one tenant.
+// It is kept as an edge each way, TASK#<task>/BLOCKEDBY#<blocker> and
+// TASK#<blocker>/BLOCKS#<task>, and in the blockedBy list of the task's
+// tenant row so listings need no query per task
+type Dependency struct {
+	TenantID  string
+	TaskID    string
+	BlockerID string
+	CreatedBy string
+	CreatedAt string
+}
+
+type dependencyItem struct {
+	PartitionKey string `dynamodbav:"PartitionKey"`
+	SortKey      string `dynamodbav:"SortKey"`
+	TenantID     string `dynamodbav:"tenantId"`
+	CreatedBy    string `dynamodbav:"createdby"`
+	CreatedAt    string `dynamodbav:"createdAt"`
+}
+
+// the two edges of a dependency
+func newDependencyItems(dependency Dependency) (dependencyItem, dependencyItem) {
+	blockedBy := dependencyItem{
+		PartitionKey: TaskKey(dependency.TaskID),
+		SortKey:      blockedByPrefix + strings.TrimPrefix(dependency.BlockerID, taskPrefix),
+		TenantID:     dependency.TenantID,
+		CreatedBy:    UserKey(dependency.CreatedBy),
+		CreatedAt:    dependency.CreatedAt,
+	}
+	blocks := blockedBy
+	blocks.PartitionKey = TaskKey(dependency.BlockerID)
+	blocks.SortKey = blocksPrefix + strings.TrimPrefix(dependency.TaskID, taskPrefix)
+	return blockedBy, blocks
+}
+
+// versioned update of the tenant row replacing its blockedBy list
+func (s *tasksStore) blockersUpdate(task Task, blockers []string) (types.TransactWriteItem, error) {
+	keys := make([]string, 0, len(blockers))
+	for _, blocker := range blockers {
+		keys = append(keys, strings.TrimPrefix(blocker, taskPrefix))
+	}
+	av, err := attributevalue.Marshal(keys)
+	if err != nil {
+		return types.TransactWriteItem{}, fmt.Errorf("failed to marshal blockers: %w", err)
+	}
+
+	return s.versionedUpdate(task, "SET #blockedBy = :blockedBy",
+		map[string]string{"#blockedBy": "blockedBy"},
+		map[string]types.AttributeValue{":blockedBy": av},
+	), nil
+}
+
+func (s *tasksStore) DependenciesVersion(tenantID string) (int64, error) {
+	output, err := s.db.GetItem(context.Background(), &dynamodb.GetItemInput{
+		TableName: aws.String(s.tableName),
+		Key:       keyAttributes(tenantID, dependenciesKey),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var item struct {
+		Version int64 `dynamodbav:"version"`
+	}
+	if err := attributevalue.UnmarshalMap(output.Item, &item); err != nil {
+		return 0, fmt.Errorf("failed to unmarshal dependencies version: %w", err)
+	}
+	return item.Version, nil
+}
+
+// moves the tenant's dependencies on from version, failing once another
+// dependency was added since
+func (s *tasksStore) dependenciesUpdate(tenantID string, version int64) types.TransactWriteItem {
+	cond := "attribute_not_exists(#version)"
+	values := map[string]types.AttributeValue{
+		":next": &types.AttributeValueMemberN{Value: strconv.FormatInt(version+1, 10)},
+	}
+	if version > 0 {
+		cond = "#version = :seen"
+		values[":seen"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(version, 10)}
+	}
+	return types.TransactWriteItem{Update: &types.Update{
+		TableName:                 aws.String(s.tableName),
+		Key:                       keyAttributes(tenantID, dependenciesKey),
+		UpdateExpression:          aws.String("SET #version = :next"),
+		ConditionExpression:       aws.String(cond),
+		ExpressionAttributeNames:  map[string]string{"#version": "version"},
+		ExpressionAttributeValues: values,
+	}}
+}
+
+// writes both edges of a dependency along with the task's history. The
+// blocker must still be at blocker.Version and the tenant's dependencies at
+// dependenciesVersion, so no dependency added since the caller checked for
+// cycles, between any of the tenant's tasks, can close one with this one
+func (s *tasksStore) AddDependency(task Task, blocker Task, dependency Dependency, dependenciesVersion int64, history HistoryEntry) error {
+	summary, err := s.blockersUpdate(task, append(slices.Clone(task.BlockedBy), blocker.TaskID))
+	if err != nil {
+		return err
+	}
+
+	cond, names, values := versionCondition(blocker.Version)
+	actions := []types.TransactWriteItem{summary, {ConditionCheck: &types.ConditionCheck{
+		TableName:                 aws.String(s.tableName),
+		Key:                       keyAttributes(blocker.TenantID, TaskKey(blocker.TaskID)),
+		ConditionExpression:       aws.String(cond),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	}}, s.dependenciesUpdate(task.TenantID, dependenciesVersion)}
+
+	blockedBy, blocks := newDependencyItems(dependency)
+	for _, item := range []any{blockedBy, blocks, newHistoryItem(history)} {
+		action, err := s.putAction(item)
+		if err != nil {
+			return err
+		}
+		actions = append(actions, action)
+	}
+	actions[3].Put.ConditionExpression = aws.String("attribute_not_exists(PartitionKey)")
+
+	err = transactWrite(s.db, actions)
+	switch {
+	case conditionFailedAt(err, 0):
+		return s.staleOrMissing(task)
+	case conditionFailedAt(err, 1):
+		return s.staleOrMissing(blocker)
+	case conditionFailedAt(err, 2):
+		return ErrDependenciesChanged
+	case isConditionFailure(err):
+		return ErrAlreadyExists
+	case err != nil:
+		log.Printf("failed to add dependency, %v", err)
+		return errors.New("could not add dependency")
+	}
+	return nil
+}
+
+// deletes both edges of a dependency along with writing the task's history
+func (s *tasksStore) RemoveDependency(task Task, blockerID string, history HistoryEntry) error {
+	blockerID = strings.TrimPrefix(blockerID, taskPrefix)
+	remaining := slices.DeleteFunc(slices.Clone(task.BlockedBy), func(id string) bool { return id == blockerID })
+	summary, err := s.blockersUpdate(task, remaining)
+	if err != nil {
+		return err
+	}
+
+	blockedBy, blocks := newDependencyItems(Dependency{TaskID: task.TaskID, BlockerID: blockerID})
+	actions := []types.TransactWriteItem{
+		summary,
+		s.deleteAction(blockedBy.PartitionKey, blockedBy.SortKey),
+		s.deleteAction(blocks.PartitionKey, blocks.SortKey),
+	}
+	actions[1].Delete.ConditionExpression = aws.String("attribute_exists(PartitionKey)")
+
+	action, err := s.putAction(newHistoryItem(history))
+	if err != nil {
+		return err
+	}
+	actions = append(actions, action)
+
+	err = transactWrite(s.db, actions)
+	if conditionFailedAt(err, 0) {
+		return s.staleOrMissing(task)
+	}
+	if isConditionFailure(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		log.Printf("failed to remove dependency, %v", err)
+		return errors.New("could not remove dependency")
+	}
+	return nil
+}
+
+// lists the ids of the tasks a task blocks
+func (s *tasksStore) ListDependents(taskID string) ([]string, error) {
+	var edges []dependencyItem
+	if err := s.queryPrefix(TaskKey(taskID), blocksPrefix, &edges); err != nil {
+		return nil, fmt.Errorf("failed to query dependents: %w", err)
+	}
+
+	ids := make([]string, 0, len(edges))
+	for _, edge := range edges {
+		ids = append(ids, strings.TrimPrefix(edge.SortKey, blocksPrefix))
+	}
+	return ids, nil
+}
+
+// actions dropping every dependency of a deleted task, both the ones
+// blocking it and the ones it blocks. Tasks it blocked must still be at the
+// version read here
+func (s *tasksStore) dropDependencies(task Task) ([]types.TransactWriteItem, error) {
+	var actions []types.TransactWriteItem
+	for _, blockerID := range task.BlockedBy {
+		blockedBy, blocks := newDependencyItems(Dependency{TaskID: task.TaskID, BlockerID: blockerID})
+		actions = append(actions,
+			s.deleteAction(blockedBy.PartitionKey, blockedBy.SortKey),
+			s.deleteAction(blocks.PartitionKey, blocks.SortKey),
+		)
+	}
+
+	dependentIDs, err := s.ListDependents(task.TaskID)
+	if err != nil {
+		return nil, err
+	}
+	dependents, err := s.GetTasks(task.TenantID, dependentIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, dependent := range dependents {
+		remaining := slices.DeleteFunc(slices.Clone(dependent.BlockedBy), func(id string) bool { return id == task.TaskID })
+		summary, err := s.blockersUpdate(dependent, remaining)
+		if err != nil {
+			return nil, err
+		}
+
+		blockedBy, blocks := newDependencyItems(Dependency{TaskID: dependent.TaskID, BlockerID: task.TaskID})
+		actions = append(actions,
+			summary,
+			s.deleteAction(blockedBy.PartitionKey, blockedBy.SortKey),
+			s.deleteAction(blocks.PartitionKey, blocks.SortKey),
+		)
+	}
+	return actions, nil
+}
@@ -0,0 +1,102 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// partition indexing the users who get a digest, only read by the digest job
+const digestPartition = "DIGEST"
+
+// DigestEntry points at a user who gets a digest. LastSentAt is when their
+// last digest went out, or when they asked for one before any did, in UTC
+type DigestEntry struct {
+	UserID     string
+	TenantID   string
+	Digest     string
+	Timezone   string
+	LastSentAt string
+}
+
+// DIGEST/USER#<id> follows the digest preferences of a user
+type digestItem struct {
+	PartitionKey string `dynamodbav:"PartitionKey"`
+	SortKey      string `dynamodbav:"SortKey"`
+	TenantID     string `dynamodbav:"tenantId"`
+	Digest       string `dynamodbav:"digest"`
+	Timezone     string `dynamodbav:"timezone"`
+	LastSentAt   string `dynamodbav:"lastSentAt"`
+}
+
+// the action keeping the digest index in step with preferences, dropping the
+// user when their digest is off. A user who had a digest keeps the time
+// their last one went out, one who did not starts counting from now
+func (s *notificationsStore) digestAction(preferences Preferences) types.TransactWriteItem {
+	key := keyAttributes(digestPartition, UserKey(preferences.UserID))
+	if preferences.Digest == "" || preferences.Digest == "off" || preferences.TenantID == "" {
+		return types.TransactWriteItem{Delete: &types.Delete{TableName: aws.String(s.tableName), Key: key}}
+	}
+
+	return types.TransactWriteItem{Update: &types.Update{
+		TableName:        aws.String(s.tableName),
+		Key:              key,
+		UpdateExpression: aws.String("SET tenantId = :tenantId, #digest = :digest, #timezone = :timezone, lastSentAt = if_not_exists(lastSentAt, :now)"),
+		ExpressionAttributeNames: map[string]string{
+			"#digest":   "digest",
+			"#timezone": "timezone",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":tenantId": &types.AttributeValueMemberS{Value: preferences.TenantID},
+			":digest":   &types.AttributeValueMemberS{Value: preferences.Digest},
+			":timezone": &types.AttributeValueMemberS{Value: preferences.Timezone},
+			":now":      &types.AttributeValueMemberS{Value: preferences.UpdatedAt},
+		},
+	}}
+}
+
+func (s *notificationsStore) ListDigests() ([]DigestEntry, error) {
+	rows, err := queryAll(s.db, s.tableName, digestPartition, userPrefix, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []digestItem
+	if err := attributevalue.UnmarshalListOfMaps(rows, &items); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal digests: %w", err)
+	}
+
+	entries := make([]DigestEntry, 0, len(items))
+	for _, item := range items {
+		entries = append(entries, DigestEntry{
+			UserID:     strings.TrimPrefix(item.SortKey, userPrefix),
+			TenantID:   item.TenantID,
+			Digest:     item.Digest,
+			Timezone:   item.Timezone,
+			LastSentAt: item.LastSentAt,
+		})
+	}
+	return entries, nil
+}
+
+func (s *notificationsStore) ClaimDigest(entry DigestEntry, sentAt string) error {
+	_, err := s.db.UpdateItem(context.Background(), &dynamodb.UpdateItemInput{
+		TableName:           aws.String(s.tableName),
+		Key:                 keyAttributes(digestPartition, UserKey(entry.UserID)),
+		UpdateExpression:    aws.String("SET lastSentAt = :sentAt"),
+		ConditionExpression: aws.String("lastSentAt = :lastSentAt"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":sentAt":     &types.AttributeValueMemberS{Value: sentAt},
+			":lastSentAt": &types.AttributeValueMemberS{Value: entry.LastSentAt},
+		},
+	})
+	if isConditionFailure(err) {
+		return ErrVersionConflict
+	}
+	return err
+}
@@ -0,0 +1,705 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+)
+
+// key schema shared by every table in the application
+const (
+	partitionKeyAttr = "PartitionKey"
+	sortKeyAttr      = "SortKey"
+)
+
+// dynamodb caps a single BatchWriteItem call at 25 requests and BatchGetItem at 100 keys
+const (
+	maxBatchWriteItems = 25
+	maxBatchGetKeys    = 100
+)
+
+// MemoryDB is an in-memory, single-table stand-in for dynamodb. Tables are
+// created on first use and keyed on PartitionKey/SortKey like the real one
+type MemoryDB struct {
+	mu     sync.RWMutex
+	tables map[string]*memoryTable
+}
+
+type memoryTable struct {
+	partitions map[string]map[string]map[string]types.AttributeValue
+}
+
+func NewMemoryDB() *MemoryDB {
+	return &MemoryDB{tables: map[string]*memoryTable{}}
+}
+
+func validationError(format string, args ...any) error {
+	return &smithy.GenericAPIError{Code: "ValidationException", Message: fmt.Sprintf(format, args...)}
+}
+
+func conditionFailedError() error {
+	return &types.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")}
+}
+
+// returns the named table, creating it if needed. caller must hold the write lock
+func (m *MemoryDB) table(name *string) (*memoryTable, error) {
+	t, err := m.readTable(name)
+	if err != nil {
+		return nil, err
+	}
+	m.tables[*name] = t
+	return t, nil
+}
+
+// returns the named table, or an empty one without registering it
+func (m *MemoryDB) readTable(name *string) (*memoryTable, error) {
+	if name == nil || *name == "" {
+		return nil, validationError("table name is required")
+	}
+	if t, ok := m.tables[*name]; ok {
+		return t, nil
+	}
+	return &memoryTable{partitions: map[string]map[string]map[string]types.AttributeValue{}}, nil
+}
+
+// extracts partition and sort key from a key or a full item
+func keyOf(item map[string]types.AttributeValue) (string, string, error) {
+	pk, ok := item[partitionKeyAttr].(*types.AttributeValueMemberS)
+	if !ok || pk.Value == "" {
+		return "", "", validationError("missing or invalid %s", partitionKeyAttr)
+	}
+	sk, ok := item[sortKeyAttr].(*types.AttributeValueMemberS)
+	if !ok || sk.Value == "" {
+		return "", "", validationError("missing or invalid %s", sortKeyAttr)
+	}
+	return pk.Value, sk.Value, nil
+}
+
+func keyAttributes(pk, sk string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		partitionKeyAttr: &types.AttributeValueMemberS{Value: pk},
+		sortKeyAttr:      &types.AttributeValueMemberS{Value: sk},
+	}
+}
+
+func (t *memoryTable) get(pk, sk string) map[string]types.AttributeValue {
+	return t.partitions[pk][sk]
+}
+
+func (t *memoryTable) put(item map[string]types.AttributeValue) error {
+	pk, sk, err := keyOf(item)
+	if err != nil {
+		return err
+	}
+	partition, ok := t.partitions[pk]
+	if !ok {
+		partition = map[string]map[string]types.AttributeValue{}
+		t.partitions[pk] = partition
+	}
+	partition[sk] = cloneItem(item)
+	return nil
+}
+
+func (t *memoryTable) delete(pk, sk string) {
+	partition, ok := t.partitions[pk]
+	if !ok {
+		return
+	}
+	delete(partition, sk)
+	if len(partition) == 0 {
+		delete(t.partitions, pk)
+	}
+}
+
+// checks an optional condition expression against the current item
+func checkCondition(expr *string, names map[string]string, values map[string]types.AttributeValue, current map[string]types.AttributeValue) error {
+	if expr == nil || *expr == "" {
+		return nil
+	}
+	cond, err := parseCondition(*expr, names, values)
+	if err != nil {
+		return validationError("invalid ConditionExpression: %v", err)
+	}
+	if current == nil {
+		current = map[string]types.AttributeValue{}
+	}
+	ok, err := cond.eval(current)
+	if err != nil {
+		return validationError("invalid ConditionExpression: %v", err)
+	}
+	if !ok {
+		return conditionFailedError()
+	}
+	return nil
+}
+
+func (m *MemoryDB) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	t, err := m.readTable(params.TableName)
+	if err != nil {
+		return nil, err
+	}
+	pk, sk, err := keyOf(params.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dynamodb.GetItemOutput{Item: cloneItem(t.get(pk, sk))}, nil
+}
+
+func (m *MemoryDB) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, err := m.table(params.TableName)
+	if err != nil {
+		return nil, err
+	}
+	pk, sk, err := keyOf(params.Item)
+	if err != nil {
+		return nil, err
+	}
+
+	old := t.get(pk, sk)
+	if err := checkCondition(params.ConditionExpression, params.ExpressionAttributeNames, params.ExpressionAttributeValues, old); err != nil {
+		return nil, err
+	}
+	if err := t.put(params.Item); err != nil {
+		return nil, err
+	}
+
+	output := &dynamodb.PutItemOutput{}
+	if params.ReturnValues == types.ReturnValueAllOld {
+		output.Attributes = cloneItem(old)
+	}
+	return output, nil
+}
+
+func (m *MemoryDB) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, err := m.table(params.TableName)
+	if err != nil {
+		return nil, err
+	}
+	pk, sk, err := keyOf(params.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	old := t.get(pk, sk)
+	if err := checkCondition(params.ConditionExpression, params.ExpressionAttributeNames, params.ExpressionAttributeValues, old); err != nil {
+		return nil, err
+	}
+	t.delete(pk, sk)
+
+	output := &dynamodb.DeleteItemOutput{}
+	if params.ReturnValues == types.ReturnValueAllOld {
+		output.Attributes = cloneItem(old)
+	}
+	return output, nil
+}
+
+// finds the partition key equality required at the top of a key condition
+func partitionFromKeyCondition(cond condition) (string, bool) {
+	switch c := cond.(type) {
+	case andCond:
+		if pk, ok := partitionFromKeyCondition(c.left); ok {
+			return pk, true
+		}
+		return partitionFromKeyCondition(c.right)
+	case compareCond:
+		path, ok := c.left.(pathOperand)
+		if !ok || c.op != "=" || path.path.String() != partitionKeyAttr {
+			return "", false
+		}
+		value, ok := c.right.(valueOperand)
+		if !ok {
+			return "", false
+		}
+		s, ok := value.av.(*types.AttributeValueMemberS)
+		if !ok {
+			return "", false
+		}
+		return s.Value, true
+	}
+	return "", false
+}
+
+func (m *MemoryDB) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	t, err := m.readTable(params.TableName)
+	if err != nil {
+		return nil, err
+	}
+	if params.KeyConditionExpression == nil {
+		return nil, validationError("KeyConditionExpression is required")
+	}
+
+	keyCond, err := parseCondition(*params.KeyConditionExpression, params.ExpressionAttributeNames, params.ExpressionAttributeValues)
+	if err != nil {
+		return nil, validationError("invalid KeyConditionExpression: %v", err)
+	}
+	pk, ok := partitionFromKeyCondition(keyCond)
+	if !ok {
+		return nil, validationError("KeyConditionExpression must test %s for equality", partitionKeyAttr)
+	}
+
+	var filter condition
+	if params.FilterExpression != nil && *params.FilterExpression != "" {
+		filter, err = parseCondition(*params.FilterExpression, params.ExpressionAttributeNames, params.ExpressionAttributeValues)
+		if err != nil {
+			return nil, validationError("invalid FilterExpression: %v", err)
+		}
+	}
+
+	partition := t.partitions[pk]
+	sortKeys := make([]string, 0, len(partition))
+	for sk := range partition {
+		sortKeys = append(sortKeys, sk)
+	}
+	forward := params.ScanIndexForward == nil || *params.ScanIndexForward
+	if forward {
+		sort.Strings(sortKeys)
+	} else {
+		sort.Sort(sort.Reverse(sort.StringSlice(sortKeys)))
+	}
+
+	// resume after the exclusive start key
+	if params.ExclusiveStartKey != nil {
+		_, startSK, err := keyOf(params.ExclusiveStartKey)
+		if err != nil {
+			return nil, err
+		}
+		start := sort.Search(len(sortKeys), func(i int) bool {
+			if forward {
+				return sortKeys[i] > startSK
+			}
+			return sortKeys[i] < startSK
+		})
+		sortKeys = sortKeys[start:]
+	}
+
+	output := &dynamodb.QueryOutput{}
+	for i, sk := range sortKeys {
+		item := partition[sk]
+		match, err := keyCond.eval(item)
+		if err != nil {
+			return nil, validationError("invalid KeyConditionExpression: %v", err)
+		}
+		if !match {
+			continue
+		}
+
+		// limit applies to items read, before the filter
+		output.ScannedCount++
+		if filter != nil {
+			match, err = filter.eval(item)
+			if err != nil {
+				return nil, validationError("invalid FilterExpression: %v", err)
+			}
+		}
+		if match {
+			output.Items = append(output.Items, cloneItem(item))
+			output.Count++
+		}
+
+		if params.Limit != nil && output.ScannedCount >= *params.Limit && i < len(sortKeys)-1 {
+			output.LastEvaluatedKey = keyAttributes(pk, sk)
+			break
+		}
+	}
+
+	return output, nil
+}
+
+// reads every partition in key order, partitions sorted on their key too
+func (m *MemoryDB) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	t, err := m.readTable(params.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	var filter condition
+	if params.FilterExpression != nil && *params.FilterExpression != "" {
+		filter, err = parseCondition(*params.FilterExpression, params.ExpressionAttributeNames, params.ExpressionAttributeValues)
+		if err != nil {
+			return nil, validationError("invalid FilterExpression: %v", err)
+		}
+	}
+
+	var keys [][2]string
+	for pk, partition := range t.partitions {
+		for sk := range partition {
+			keys = append(keys, [2]string{pk, sk})
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+
+	// resume after the exclusive start key
+	if params.ExclusiveStartKey != nil {
+		startPK, startSK, err := keyOf(params.ExclusiveStartKey)
+		if err != nil {
+			return nil, err
+		}
+		start := sort.Search(len(keys), func(i int) bool {
+			return keys[i][0] > startPK || (keys[i][0] == startPK && keys[i][1] > startSK)
+		})
+		keys = keys[start:]
+	}
+
+	output := &dynamodb.ScanOutput{}
+	for i, key := range keys {
+		item := t.partitions[key[0]][key[1]]
+
+		// limit applies to items read, before the filter
+		output.ScannedCount++
+		match := true
+		if filter != nil {
+			match, err = filter.eval(item)
+			if err != nil {
+				return nil, validationError("invalid FilterExpression: %v", err)
+			}
+		}
+		if match {
+			output.Items = append(output.Items, cloneItem(item))
+			output.Count++
+		}
+
+		if params.Limit != nil && output.ScannedCount >= *params.Limit && i < len(keys)-1 {
+			output.LastEvaluatedKey = keyAttributes(key[0], key[1])
+			break
+		}
+	}
+
+	return output, nil
+}
+
+func (m *MemoryDB) BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	total := 0
+	for _, keys := range params.RequestItems {
+		total += len(keys.Keys)
+	}
+	if total == 0 || total > maxBatchGetKeys {
+		return nil, validationError("BatchGetItem requires between 1 and %d keys, got %d", maxBatchGetKeys, total)
+	}
+
+	output := &dynamodb.BatchGetItemOutput{
+		Responses:       map[string][]map[string]types.AttributeValue{},
+		UnprocessedKeys: map[string]types.KeysAndAttributes{},
+	}
+	for tableName, keys := range params.RequestItems {
+		t, err := m.readTable(aws.String(tableName))
+		if err != nil {
+			return nil, err
+		}
+
+		seen := map[[2]string]bool{}
+		for _, key := range keys.Keys {
+			pk, sk, err := keyOf(key)
+			if err != nil {
+				return nil, err
+			}
+			if seen[[2]string{pk, sk}] {
+				return nil, validationError("provided list of item keys contains duplicates in table %s", tableName)
+			}
+			seen[[2]string{pk, sk}] = true
+
+			// missing items are simply left out of the response
+			if item := t.get(pk, sk); item != nil {
+				output.Responses[tableName] = append(output.Responses[tableName], cloneItem(item))
+			}
+		}
+	}
+
+	return output, nil
+}
+
+func (m *MemoryDB) BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	total := 0
+	for _, requests := range params.RequestItems {
+		total += len(requests)
+	}
+	if total == 0 || total > maxBatchWriteItems {
+		return nil, validationError("BatchWriteItem requires between 1 and %d requests, got %d", maxBatchWriteItems, total)
+	}
+
+	// validate the whole batch before applying any of it
+	for tableName, requests := range params.RequestItems {
+		seen := map[[2]string]bool{}
+		for _, request := range requests {
+			var key map[string]types.AttributeValue
+			switch {
+			case request.PutRequest != nil:
+				key = request.PutRequest.Item
+			case request.DeleteRequest != nil:
+				key = request.DeleteRequest.Key
+			default:
+				return nil, validationError("write request must contain a PutRequest or DeleteRequest")
+			}
+			pk, sk, err := keyOf(key)
+			if err != nil {
+				return nil, err
+			}
+			if seen[[2]string{pk, sk}] {
+				return nil, validationError("provided list of item keys contains duplicates in table %s", tableName)
+			}
+			seen[[2]string{pk, sk}] = true
+		}
+	}
+
+	for tableName, requests := range params.RequestItems {
+		t, err := m.table(aws.String(tableName))
+		if err != nil {
+			return nil, err
+		}
+		for _, request := range requests {
+			if request.PutRequest != nil {
+				if err := t.put(request.PutRequest.Item); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			pk, sk, _ := keyOf(request.DeleteRequest.Key)
+			t.delete(pk, sk)
+		}
+	}
+
+	return &dynamodb.BatchWriteItemOutput{UnprocessedItems: map[string][]types.WriteRequest{}}, nil
+}
+
+// applies an update expression to the item at key, creating it if missing
+func updatedItem(current, key map[string]types.AttributeValue, expr *string, names map[string]string, values map[string]types.AttributeValue) (map[string]types.AttributeValue, error) {
+	if expr == nil || *expr == "" {
+		return nil, validationError("UpdateExpression is required")
+	}
+	actions, err := parseUpdate(*expr, names, values)
+	if err != nil {
+		return nil, validationError("invalid UpdateExpression: %v", err)
+	}
+
+	if current == nil {
+		current = cloneItem(key)
+	}
+	updated, err := applyUpdate(current, actions)
+	if err != nil {
+		return nil, validationError("invalid UpdateExpression: %v", err)
+	}
+	return updated, nil
+}
+
+func (m *MemoryDB) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, err := m.table(params.TableName)
+	if err != nil {
+		return nil, err
+	}
+	pk, sk, err := keyOf(params.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	old := t.get(pk, sk)
+	if err := checkCondition(params.ConditionExpression, params.ExpressionAttributeNames, params.ExpressionAttributeValues, old); err != nil {
+		return nil, err
+	}
+	updated, err := updatedItem(old, keyAttributes(pk, sk), params.UpdateExpression, params.ExpressionAttributeNames, params.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.put(updated); err != nil {
+		return nil, err
+	}
+
+	output := &dynamodb.UpdateItemOutput{}
+	switch params.ReturnValues {
+	case types.ReturnValueAllOld:
+		output.Attributes = cloneItem(old)
+	case types.ReturnValueAllNew, types.ReturnValueUpdatedNew:
+		output.Attributes = cloneItem(updated)
+	}
+	return output, nil
+}
+
+// a single write inside a transaction, resolved against its table
+type transactAction struct {
+	table *memoryTable
+	pk    string
+	sk    string
+	put   map[string]types.AttributeValue
+	del   bool
+}
+
+func (m *MemoryDB) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(params.TransactItems) == 0 || len(params.TransactItems) > maxTransactItems {
+		return nil, validationError("TransactWriteItems requires between 1 and %d items, got %d", maxTransactItems, len(params.TransactItems))
+	}
+
+	// check every condition before applying anything
+	actions := make([]transactAction, 0, len(params.TransactItems))
+	reasons := make([]types.CancellationReason, len(params.TransactItems))
+	seen := map[[3]string]bool{}
+	cancelled := false
+
+	for i, item := range params.TransactItems {
+		var (
+			tableName *string
+			key       map[string]types.AttributeValue
+			expr      *string
+			names     map[string]string
+			values    map[string]types.AttributeValue
+			action    transactAction
+		)
+
+		switch {
+		case item.Put != nil:
+			tableName, key = item.Put.TableName, item.Put.Item
+			expr, names, values = item.Put.ConditionExpression, item.Put.ExpressionAttributeNames, item.Put.ExpressionAttributeValues
+			action.put = item.Put.Item
+		case item.Delete != nil:
+			tableName, key = item.Delete.TableName, item.Delete.Key
+			expr, names, values = item.Delete.ConditionExpression, item.Delete.ExpressionAttributeNames, item.Delete.ExpressionAttributeValues
+			action.del = true
+		case item.Update != nil:
+			tableName, key = item.Update.TableName, item.Update.Key
+			expr, names, values = item.Update.ConditionExpression, item.Update.ExpressionAttributeNames, item.Update.ExpressionAttributeValues
+		case item.ConditionCheck != nil:
+			tableName, key = item.ConditionCheck.TableName, item.ConditionCheck.Key
+			expr, names, values = item.ConditionCheck.ConditionExpression, item.ConditionCheck.ExpressionAttributeNames, item.ConditionCheck.ExpressionAttributeValues
+		default:
+			return nil, validationError("unsupported transaction action at index %d", i)
+		}
+
+		t, err := m.table(tableName)
+		if err != nil {
+			return nil, err
+		}
+		pk, sk, err := keyOf(key)
+		if err != nil {
+			return nil, err
+		}
+		if seen[[3]string{*tableName, pk, sk}] {
+			return nil, validationError("transaction request cannot include multiple operations on one item")
+		}
+		seen[[3]string{*tableName, pk, sk}] = true
+
+		reasons[i] = types.CancellationReason{Code: aws.String("None")}
+		err = checkCondition(expr, names, values, t.get(pk, sk))
+		var conditionFailed *types.ConditionalCheckFailedException
+		switch {
+		case errors.As(err, &conditionFailed):
+			reasons[i] = types.CancellationReason{Code: aws.String("ConditionalCheckFailed"), Message: conditionFailed.Message}
+			cancelled = true
+		case err != nil:
+			return nil, err
+		}
+
+		// updates are resolved up front so a bad expression fails the whole transaction
+		if item.Update != nil && err == nil {
+			u := item.Update
+			action.put, err = updatedItem(t.get(pk, sk), keyAttributes(pk, sk), u.UpdateExpression, u.ExpressionAttributeNames, u.ExpressionAttributeValues)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		action.table, action.pk, action.sk = t, pk, sk
+		actions = append(actions, action)
+	}
+
+	if cancelled {
+		return nil, &types.TransactionCanceledException{
+			Message:             aws.String("Transaction cancelled, please refer cancellation reasons for specific reasons"),
+			CancellationReasons: reasons,
+		}
+	}
+
+	for _, action := range actions {
+		switch {
+		case action.put != nil:
+			if err := action.table.put(action.put); err != nil {
+				return nil, err
+			}
+		case action.del:
+			action.table.delete(action.pk, action.sk)
+		}
+	}
+
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+func cloneItem(item map[string]types.AttributeValue) map[string]types.AttributeValue {
+	if item == nil {
+		return nil
+	}
+	clone := make(map[string]types.AttributeValue, len(item))
+	for k, v := range item {
+		clone[k] = cloneAV(v)
+	}
+	return clone
+}
+
+func cloneAV(av types.AttributeValue) types.AttributeValue {
+	switch v := av.(type) {
+	case *types.AttributeValueMemberS:
+		return &types.AttributeValueMemberS{Value: v.Value}
+	case *types.AttributeValueMemberN:
+		return &types.AttributeValueMemberN{Value: v.Value}
+	case *types.AttributeValueMemberB:
+		return &types.AttributeValueMemberB{Value: append([]byte(nil), v.Value...)}
+	case *types.AttributeValueMemberBOOL:
+		return &types.AttributeValueMemberBOOL{Value: v.Value}
+	case *types.AttributeValueMemberNULL:
+		return &types.AttributeValueMemberNULL{Value: v.Value}
+	case *types.AttributeValueMemberSS:
+		return &types.AttributeValueMemberSS{Value: append([]string(nil), v.Value...)}
+	case *types.AttributeValueMemberNS:
+		return &types.AttributeValueMemberNS{Value: append([]string(nil), v.Value...)}
+	case *types.AttributeValueMemberBS:
+		bs := make([][]byte, len(v.Value))
+		for i, b := range v.Value {
+			bs[i] = append([]byte(nil), b...)
+		}
+		return &types.AttributeValueMemberBS{Value: bs}
+	case *types.AttributeValueMemberL:
+		l := make([]types.AttributeValue, len(v.Value))
+		for i, e := range v.Value {
+			l[i] = cloneAV(e)
+		}
+		return &types.AttributeValueMemberL{Value: l}
+	case *types.AttributeValueMemberM:
+		return &types.AttributeValueMemberM{Value: cloneItem(v.Value)}
+	}
+	return av
+}
@@ -0,0 +1,1060 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// a small parser and evaluator for the subset of the dynamodb expression
+// language used by the stores, so MemoryDB behaves like the real table
+
+type tokenKind int
+
+const (
+	tokIdent tokenKind = iota
+	tokName            // #alias
+	tokValue           // :placeholder
+	tokNumber
+	tokPunct
+	tokEOF
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func tokenize(expr string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '#' || c == ':':
+			j := i + 1
+			for j < len(expr) && isIdentChar(expr[j]) {
+				j++
+			}
+			if j == i+1 {
+				return nil, fmt.Errorf("invalid token at %d in %q", i, expr)
+			}
+			kind := tokName
+			if c == ':' {
+				kind = tokValue
+			}
+			tokens = append(tokens, token{kind, expr[i:j]})
+			i = j
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(expr) && expr[j] >= '0' && expr[j] <= '9' {
+				j++
+			}
+			tokens = append(tokens, token{tokNumber, expr[i:j]})
+			i = j
+		case isIdentChar(c):
+			j := i
+			for j < len(expr) && isIdentChar(expr[j]) {
+				j++
+			}
+			tokens = append(tokens, token{tokIdent, expr[i:j]})
+			i = j
+		case c == '<' || c == '>':
+			if i+1 < len(expr) && (expr[i+1] == '=' || (c == '<' && expr[i+1] == '>')) {
+				tokens = append(tokens, token{tokPunct, expr[i : i+2]})
+				i += 2
+			} else {
+				tokens = append(tokens, token{tokPunct, expr[i : i+1]})
+				i++
+			}
+		case strings.IndexByte("()=,.[]+-", c) >= 0:
+			tokens = append(tokens, token{tokPunct, expr[i : i+1]})
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected character %q in %q", c, expr)
+		}
+	}
+
+	return append(tokens, token{kind: tokEOF}), nil
+}
+
+// path element, either a map key or a list index
+type pathElem struct {
+	name    string
+	index   int
+	isIndex bool
+}
+
+type attrPath []pathElem
+
+func (p attrPath) String() string {
+	var sb strings.Builder
+	for i, e := range p {
+		if e.isIndex {
+			fmt.Fprintf(&sb, "[%d]", e.index)
+			continue
+		}
+		if i > 0 {
+			sb.WriteByte('.')
+		}
+		sb.WriteString(e.name)
+	}
+	return sb.String()
+}
+
+// operands evaluate to an attribute value, nil when the attribute is missing
+type operand interface {
+	value(item map[string]types.AttributeValue) (types.AttributeValue, error)
+}
+
+type pathOperand struct{ path attrPath }
+
+type valueOperand struct{ av types.AttributeValue }
+
+type sizeOperand struct{ path attrPath }
+
+func (o pathOperand) value(item map[string]types.AttributeValue) (types.AttributeValue, error) {
+	return resolvePath(item, o.path), nil
+}
+
+func (o valueOperand) value(map[string]types.AttributeValue) (types.AttributeValue, error) {
+	return o.av, nil
+}
+
+func (o sizeOperand) value(item map[string]types.AttributeValue) (types.AttributeValue, error) {
+	var n int
+	switch v := resolvePath(item, o.path).(type) {
+	case nil:
+		return nil, nil
+	case *types.AttributeValueMemberS:
+		n = len(v.Value)
+	case *types.AttributeValueMemberB:
+		n = len(v.Value)
+	case *types.AttributeValueMemberL:
+		n = len(v.Value)
+	case *types.AttributeValueMemberM:
+		n = len(v.Value)
+	case *types.AttributeValueMemberSS:
+		n = len(v.Value)
+	case *types.AttributeValueMemberNS:
+		n = len(v.Value)
+	case *types.AttributeValueMemberBS:
+		n = len(v.Value)
+	default:
+		return nil, fmt.Errorf("size() is not supported for %s", o.path)
+	}
+	return &types.AttributeValueMemberN{Value: strconv.Itoa(n)}, nil
+}
+
+// conditions evaluate to a boolean against an item
+type condition interface {
+	eval(item map[string]types.AttributeValue) (bool, error)
+}
+
+type andCond struct{ left, right condition }
+
+type orCond struct{ left, right condition }
+
+type notCond struct{ inner condition }
+
+type compareCond struct {
+	op          string
+	left, right operand
+}
+
+type betweenCond struct{ target, low, high operand }
+
+type inCond struct {
+	target  operand
+	choices []operand
+}
+
+type funcCond struct {
+	name string
+	path attrPath
+	arg  operand
+}
+
+func (c andCond) eval(item map[string]types.AttributeValue) (bool, error) {
+	ok, err := c.left.eval(item)
+	if err != nil || !ok {
+		return false, err
+	}
+	return c.right.eval(item)
+}
+
+func (c orCond) eval(item map[string]types.AttributeValue) (bool, error) {
+	ok, err := c.left.eval(item)
+	if err != nil || ok {
+		return ok, err
+	}
+	return c.right.eval(item)
+}
+
+func (c notCond) eval(item map[string]types.AttributeValue) (bool, error) {
+	ok, err := c.inner.eval(item)
+	return !ok, err
+}
+
+func (c compareCond) eval(item map[string]types.AttributeValue) (bool, error) {
+	left, err := c.left.value(item)
+	if err != nil {
+		return false, err
+	}
+	right, err := c.right.value(item)
+	if err != nil {
+		return false, err
+	}
+	if left == nil || right == nil {
+		return false, nil
+	}
+
+	switch c.op {
+	case "=":
+		return equalAV(left, right), nil
+	case "<>":
+		return !equalAV(left, right), nil
+	}
+
+	cmp, ok := compareAV(left, right)
+	if !ok {
+		return false, nil
+	}
+	switch c.op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	case ">=":
+		return cmp >= 0, nil
+	}
+	return false, fmt.Errorf("unknown comparator %q", c.op)
+}
+
+func (c betweenCond) eval(item map[string]types.AttributeValue) (bool, error) {
+	target, err := c.target.value(item)
+	if err != nil {
+		return false, err
+	}
+	low, err := c.low.value(item)
+	if err != nil {
+		return false, err
+	}
+	high, err := c.high.value(item)
+	if err != nil {
+		return false, err
+	}
+	if target == nil || low == nil || high == nil {
+		return false, nil
+	}
+
+	lowCmp, ok := compareAV(target, low)
+	if !ok {
+		return false, nil
+	}
+	highCmp, ok := compareAV(target, high)
+	if !ok {
+		return false, nil
+	}
+	return lowCmp >= 0 && highCmp <= 0, nil
+}
+
+func (c inCond) eval(item map[string]types.AttributeValue) (bool, error) {
+	target, err := c.target.value(item)
+	if err != nil || target == nil {
+		return false, err
+	}
+	for _, choice := range c.choices {
+		v, err := choice.value(item)
+		if err != nil {
+			return false, err
+		}
+		if v != nil && equalAV(target, v) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (c funcCond) eval(item map[string]types.AttributeValue) (bool, error) {
+	attr := resolvePath(item, c.path)
+	switch c.name {
+	case "attribute_exists":
+		return attr != nil, nil
+	case "attribute_not_exists":
+		return attr == nil, nil
+	}
+
+	arg, err := c.arg.value(item)
+	if err != nil || attr == nil || arg == nil {
+		return false, err
+	}
+
+	switch c.name {
+	case "begins_with":
+		switch a := attr.(type) {
+		case *types.AttributeValueMemberS:
+			prefix, ok := arg.(*types.AttributeValueMemberS)
+			return ok && strings.HasPrefix(a.Value, prefix.Value), nil
+		case *types.AttributeValueMemberB:
+			prefix, ok := arg.(*types.AttributeValueMemberB)
+			return ok && bytes.HasPrefix(a.Value, prefix.Value), nil
+		}
+		return false, nil
+	case "contains":
+		switch a := attr.(type) {
+		case *types.AttributeValueMemberS:
+			sub, ok := arg.(*types.AttributeValueMemberS)
+			return ok && strings.Contains(a.Value, sub.Value), nil
+		case *types.AttributeValueMemberSS:
+			member, ok := arg.(*types.AttributeValueMemberS)
+			for _, v := range a.Value {
+				if ok && v == member.Value {
+					return true, nil
+				}
+			}
+		case *types.AttributeValueMemberNS:
+			for _, v := range a.Value {
+				if equalAV(&types.AttributeValueMemberN{Value: v}, arg) {
+					return true, nil
+				}
+			}
+		case *types.AttributeValueMemberL:
+			for _, v := range a.Value {
+				if equalAV(v, arg) {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	}
+
+	return false, fmt.Errorf("unknown function %q", c.name)
+}
+
+// resolves a document path within an item, nil when missing
+func resolvePath(item map[string]types.AttributeValue, path attrPath) types.AttributeValue {
+	var current types.AttributeValue = &types.AttributeValueMemberM{Value: item}
+	for _, elem := range path {
+		switch v := current.(type) {
+		case *types.AttributeValueMemberM:
+			if elem.isIndex {
+				return nil
+			}
+			next, ok := v.Value[elem.name]
+			if !ok {
+				return nil
+			}
+			current = next
+		case *types.AttributeValueMemberL:
+			if !elem.isIndex || elem.index >= len(v.Value) {
+				return nil
+			}
+			current = v.Value[elem.index]
+		default:
+			return nil
+		}
+	}
+	return current
+}
+
+func parseNumber(s string) (*big.Float, bool) {
+	f, _, err := big.ParseFloat(s, 10, 128, big.ToNearestEven)
+	return f, err == nil
+}
+
+// orders two scalar values of the same type
+func compareAV(a, b types.AttributeValue) (int, bool) {
+	switch x := a.(type) {
+	case *types.AttributeValueMemberS:
+		y, ok := b.(*types.AttributeValueMemberS)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(x.Value, y.Value), true
+	case *types.AttributeValueMemberN:
+		y, ok := b.(*types.AttributeValueMemberN)
+		if !ok {
+			return 0, false
+		}
+		xf, okx := parseNumber(x.Value)
+		yf, oky := parseNumber(y.Value)
+		if !okx || !oky {
+			return 0, false
+		}
+		return xf.Cmp(yf), true
+	case *types.AttributeValueMemberB:
+		y, ok := b.(*types.AttributeValueMemberB)
+		if !ok {
+			return 0, false
+		}
+		return bytes.Compare(x.Value, y.Value), true
+	}
+	return 0, false
+}
+
+func equalAV(a, b types.AttributeValue) bool {
+	if cmp, ok := compareAV(a, b); ok {
+		return cmp == 0
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+type exprParser struct {
+	tokens []token
+	pos    int
+	names  map[string]string
+	values map[string]types.AttributeValue
+}
+
+func newExprParser(expr string, names map[string]string, values map[string]types.AttributeValue) (*exprParser, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &exprParser{tokens: tokens, names: names, values: values}, nil
+}
+
+// parses a complete condition expression
+func parseCondition(expr string, names map[string]string, values map[string]types.AttributeValue) (condition, error) {
+	p, err := newExprParser(expr, names, values)
+	if err != nil {
+		return nil, err
+	}
+	cond, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q in %q", p.peek().text, expr)
+	}
+	return cond, nil
+}
+
+func (p *exprParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *exprParser) acceptKeyword(keyword string) bool {
+	if t := p.peek(); t.kind == tokIdent && strings.EqualFold(t.text, keyword) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) acceptPunct(punct string) bool {
+	if t := p.peek(); t.kind == tokPunct && t.text == punct {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) expectPunct(punct string) error {
+	if !p.acceptPunct(punct) {
+		return fmt.Errorf("expected %q, found %q", punct, p.peek().text)
+	}
+	return nil
+}
+
+func (p *exprParser) parseOr() (condition, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orCond{left, right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (condition, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andCond{left, right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseNot() (condition, error) {
+	if p.acceptKeyword("NOT") {
+		inner, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notCond{inner}, nil
+	}
+	return p.parsePrimary()
+}
+
+var conditionFuncs = map[string]bool{
+	"attribute_exists":     true,
+	"attribute_not_exists": true,
+	"begins_with":          true,
+	"contains":             true,
+}
+
+func (p *exprParser) parsePrimary() (condition, error) {
+	if p.acceptPunct("(") {
+		cond, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return cond, p.expectPunct(")")
+	}
+
+	if t := p.peek(); t.kind == tokIdent && conditionFuncs[t.text] && p.tokens[p.pos+1].text == "(" {
+		return p.parseFuncCond()
+	}
+
+	target, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.acceptKeyword("BETWEEN") {
+		low, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if !p.acceptKeyword("AND") {
+			return nil, fmt.Errorf("expected AND in BETWEEN")
+		}
+		high, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return betweenCond{target, low, high}, nil
+	}
+
+	if p.acceptKeyword("IN") {
+		if err := p.expectPunct("("); err != nil {
+			return nil, err
+		}
+		var choices []operand
+		for {
+			choice, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			choices = append(choices, choice)
+			if !p.acceptPunct(",") {
+				break
+			}
+		}
+		return inCond{target, choices}, p.expectPunct(")")
+	}
+
+	op := p.next()
+	switch op.text {
+	case "=", "<>", "<", "<=", ">", ">=":
+	default:
+		return nil, fmt.Errorf("expected comparator, found %q", op.text)
+	}
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return compareCond{op.text, target, right}, nil
+}
+
+func (p *exprParser) parseFuncCond() (condition, error) {
+	name := p.next().text
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+	path, err := p.parsePath()
+	if err != nil {
+		return nil, err
+	}
+
+	cond := funcCond{name: name, path: path}
+	if name == "begins_with" || name == "contains" {
+		if err := p.expectPunct(","); err != nil {
+			return nil, err
+		}
+		if cond.arg, err = p.parseOperand(); err != nil {
+			return nil, err
+		}
+	}
+	return cond, p.expectPunct(")")
+}
+
+func (p *exprParser) parseOperand() (operand, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokValue:
+		p.next()
+		av, ok := p.values[t.text]
+		if !ok {
+			return nil, fmt.Errorf("value %s is not defined", t.text)
+		}
+		return valueOperand{av}, nil
+	case t.kind == tokIdent && t.text == "size" && p.tokens[p.pos+1].text == "(":
+		p.next()
+		p.next()
+		path, err := p.parsePath()
+		if err != nil {
+			return nil, err
+		}
+		return sizeOperand{path}, p.expectPunct(")")
+	}
+
+	path, err := p.parsePath()
+	if err != nil {
+		return nil, err
+	}
+	return pathOperand{path}, nil
+}
+
+// words dynamodb rejects as attribute names in expressions, which have to be
+// given through ExpressionAttributeNames instead
+var reservedWords = func() map[string]bool {
+	words := map[string]bool{}
+	for _, word := range strings.Fields(reservedWordList) {
+		words[word] = true
+	}
+	return words
+}()
+
+const reservedWordList = "ABORT ABSOLUTE ACTION ADD AFTER AGENT AGGREGATE ALL ALLOCATE ALTER ANALYZE AND ANY ARCHIVE ARE ARRAY " +
+	"AS ASC ASCII ASENSITIVE ASSERTION ASYMMETRIC AT ATOMIC ATTACH ATTRIBUTE AUTH AUTHORIZATION AUTHORIZE " +
+	"AUTO AVG BACK BACKUP BASE BATCH BEFORE BEGIN BETWEEN BIGINT BINARY BIT BLOB BLOCK BOOLEAN BOTH " +
+	"BREADTH BUCKET BULK BY BYTE CALL CALLED CALLING CAPACITY CASCADE CASCADED CASE CAST CATALOG CHAR " +
+	"CHARACTER CHECK CLASS CLOB CLOSE CLUSTER CLUSTERED CLUSTERING CLUSTERS COALESCE COLLATE COLLATION " +
+	"COLLECTION COLUMN COLUMNS COMBINE COMMENT COMMIT COMPACT COMPILE COMPRESS CONDITION CONFLICT CONNECT " +
+	"CONNECTION CONSISTENCY CONSISTENT CONSTRAINT CONSTRAINTS CONSTRUCTOR CONSUMED CONTINUE CONVERT COPY " +
+	"CORRESPONDING COUNT COUNTER CREATE CROSS CUBE CURRENT CURSOR CYCLE DATA DATABASE DATE DATETIME DAY " +
+	"DEALLOCATE DEC DECIMAL DECLARE DEFAULT DEFERRABLE DEFERRED DEFINE DEFINED DEFINITION DELETE " +
+	"DELIMITED DEPTH DEREF DESC DESCRIBE DESCRIPTOR DETACH DETERMINISTIC DIAGNOSTICS DIRECTORIES DISABLE " +
+	"DISCONNECT DISTINCT DISTRIBUTE DO DOMAIN DOUBLE DROP DUMP DURATION DYNAMIC EACH ELEMENT ELSE ELSEIF " +
+	"EMPTY ENABLE END EQUAL EQUALS ERROR ESCAPE ESCAPED EVAL EVALUATE EXCEEDED EXCEPT EXCEPTION " +
+	"EXCEPTIONS EXCLUSIVE EXEC EXECUTE EXISTS EXIT EXPLAIN EXPLODE EXPORT EXPRESSION EXTENDED EXTERNAL " +
+	"EXTRACT FAIL FALSE FAMILY FETCH FIELDS FILE FILTER FILTERING FINAL FINISH FIRST FIXED FLATTERN FLOAT " +
+	"FOR FORCE FOREIGN FORMAT FORWARD FOUND FREE FROM FULL FUNCTION FUNCTIONS GENERAL GENERATE GET GLOB " +
+	"GLOBAL GO GOTO GRANT GREATER GROUP GROUPING HANDLER HASH HAVE HAVING HEAP HIDDEN HOLD HOUR " +
+	"IDENTIFIED IDENTITY IF IGNORE IMMEDIATE IMPORT IN INCLUDING INCLUSIVE INCREMENT INCREMENTAL INDEX " +
+	"INDEXED INDEXES INDICATOR INFINITE INITIALLY INLINE INNER INNTER INOUT INPUT INSENSITIVE INSERT " +
+	"INSTEAD INT INTEGER INTERSECT INTERVAL INTO INVALIDATE IS ISOLATION ITEM ITEMS ITERATE JOIN KEY KEYS " +
+	"LAG LANGUAGE LARGE LAST LATERAL LEAD LEADING LEAVE LEFT LENGTH LESS LEVEL LIKE LIMIT LIMITED LINES " +
+	"LIST LOAD LOCAL LOCALTIME LOCALTIMESTAMP LOCATION LOCATOR LOCK LOCKS LOG LOGED LONG LOOP LOWER MAP " +
+	"MATCH MATERIALIZED MAX MAXLEN MEMBER MERGE METHOD METRICS MIN MINUS MINUTE MISSING MOD MODE MODIFIES " +
+	"MODIFY MODULE MONTH MULTI MULTISET NAME NAMES NATIONAL NATURAL NCHAR NCLOB NEW NEXT NO NONE NOT NULL " +
+	"NULLIF NUMBER NUMERIC OBJECT OF OFFLINE OFFSET OLD ON ONLINE ONLY OPAQUE OPEN OPERATOR OPTION OR " +
+	"ORDER ORDINALITY OTHER OTHERS OUT OUTER OUTPUT OVER OVERLAPS OVERRIDE OWNER PAD PARALLEL PARAMETER " +
+	"PARAMETERS PARTIAL PARTITION PARTITIONED PARTITIONS PATH PERCENT PERCENTILE PERMISSION PERMISSIONS " +
+	"PIPE PIPELINED PLAN POOL POSITION PRECISION PREPARE PRESERVE PRIMARY PRIOR PRIVATE PRIVILEGES " +
+	"PROCEDURE PROCESSED PROJECT PROJECTION PROPERTY PROVISIONING PUBLIC PUT QUERY QUIT QUORUM RAISE " +
+	"RANDOM RANGE RANK RAW READ READS REAL REBUILD RECORD RECURSIVE REDUCE REF REFERENCE REFERENCES " +
+	"REFERENCING REGEXP REGION REINDEX RELATIVE RELEASE REMAINDER RENAME REPEAT REPLACE REQUEST RESET " +
+	"RESIGNAL RESOURCE RESPONSE RESTORE RESTRICT RESULT RETURN RETURNING RETURNS REVERSE REVOKE RIGHT " +
+	"ROLE ROLES ROLLBACK ROLLUP ROUTINE ROW ROWS RULE RULES SAMPLE SATISFIES SAVE SAVEPOINT SCAN SCHEMA " +
+	"SCOPE SCROLL SEARCH SECOND SECTION SEGMENT SEGMENTS SELECT SELF SEMI SENSITIVE SEPARATE SEQUENCE " +
+	"SERIALIZABLE SESSION SET SETS SHARD SHARE SHARED SHORT SHOW SIGNAL SIMILAR SIZE SKEWED SMALLINT " +
+	"SNAPSHOT SOME SOURCE SPACE SPACES SPARSE SPECIFIC SPECIFICTYPE SPLIT SQL SQLCODE SQLERROR " +
+	"SQLEXCEPTION SQLSTATE SQLWARNING START STATE STATIC STATUS STORAGE STORE STORED STREAM STRING STRUCT " +
+	"STYLE SUB SUBMULTISET SUBPARTITION SUBSTRING SUBTYPE SUM SUPER SYMMETRIC SYNONYM SYSTEM TABLE " +
+	"TABLESAMPLE TEMP TEMPORARY TERMINATED TEXT THAN THEN THROUGHPUT TIME TIMESTAMP TIMEZONE TINYINT TO " +
+	"TOKEN TOTAL TOUCH TRAILING TRANSACTION TRANSFORM TRANSLATE TRANSLATION TREAT TRIGGER TRIM TRUE " +
+	"TRUNCATE TTL TUPLE TYPE UNDER UNDO UNION UNIQUE UNIT UNKNOWN UNLOGGED UNNEST UNPROCESSED UNSIGNED " +
+	"UNTIL UPDATE UPPER URL USAGE USE USER USERS USING UUID VACUUM VALUE VALUED VALUES VARCHAR VARIABLE " +
+	"VARIANCE VARINT VARYING VIEW VIEWS VIRTUAL VOID WAIT WHEN WHENEVER WHERE WHILE WINDOW WITH WITHIN " +
+	"WITHOUT WORK WRAPPED WRITE YEAR ZONE"
+
+func (p *exprParser) parsePathName() (string, error) {
+	t := p.next()
+	switch t.kind {
+	case tokIdent:
+		if reservedWords[strings.ToUpper(t.text)] {
+			return "", fmt.Errorf("attribute name is a reserved keyword; reserved keyword: %s", t.text)
+		}
+		return t.text, nil
+	case tokName:
+		name, ok := p.names[t.text]
+		if !ok {
+			return "", fmt.Errorf("name %s is not defined", t.text)
+		}
+		return name, nil
+	}
+	return "", fmt.Errorf("expected attribute name, found %q", t.text)
+}
+
+func (p *exprParser) parsePath() (attrPath, error) {
+	name, err := p.parsePathName()
+	if err != nil {
+		return nil, err
+	}
+	path := attrPath{{name: name}}
+
+	for {
+		switch {
+		case p.acceptPunct("."):
+			name, err := p.parsePathName()
+			if err != nil {
+				return nil, err
+			}
+			path = append(path, pathElem{name: name})
+		case p.acceptPunct("["):
+			t := p.next()
+			if t.kind != tokNumber {
+				return nil, fmt.Errorf("expected list index, found %q", t.text)
+			}
+			index, _ := strconv.Atoi(t.text)
+			path = append(path, pathElem{index: index, isIndex: true})
+			if err := p.expectPunct("]"); err != nil {
+				return nil, err
+			}
+		default:
+			return path, nil
+		}
+	}
+}
+
+// operands only valid on the right hand side of a SET action
+type ifNotExistsOperand struct {
+	path     attrPath
+	fallback operand
+}
+
+type listAppendOperand struct{ left, right operand }
+
+type arithmeticOperand struct {
+	op          string
+	left, right operand
+}
+
+func (o ifNotExistsOperand) value(item map[string]types.AttributeValue) (types.AttributeValue, error) {
+	if v := resolvePath(item, o.path); v != nil {
+		return v, nil
+	}
+	return o.fallback.value(item)
+}
+
+func (o listAppendOperand) value(item map[string]types.AttributeValue) (types.AttributeValue, error) {
+	var lists [2][]types.AttributeValue
+	for i, side := range []operand{o.left, o.right} {
+		v, err := side.value(item)
+		if err != nil {
+			return nil, err
+		}
+		l, ok := v.(*types.AttributeValueMemberL)
+		if !ok {
+			return nil, fmt.Errorf("list_append requires two lists")
+		}
+		lists[i] = l.Value
+	}
+	return &types.AttributeValueMemberL{Value: append(append([]types.AttributeValue{}, lists[0]...), lists[1]...)}, nil
+}
+
+func (o arithmeticOperand) value(item map[string]types.AttributeValue) (types.AttributeValue, error) {
+	left, err := o.left.value(item)
+	if err != nil {
+		return nil, err
+	}
+	right, err := o.right.value(item)
+	if err != nil {
+		return nil, err
+	}
+	l, okl := left.(*types.AttributeValueMemberN)
+	r, okr := right.(*types.AttributeValueMemberN)
+	if !okl || !okr {
+		return nil, fmt.Errorf("arithmetic requires two numbers")
+	}
+	return addNumbers(l.Value, r.Value, o.op == "-")
+}
+
+func addNumbers(a, b string, subtract bool) (types.AttributeValue, error) {
+	x, okx := parseNumber(a)
+	y, oky := parseNumber(b)
+	if !okx || !oky {
+		return nil, fmt.Errorf("invalid number")
+	}
+	if subtract {
+		y.Neg(y)
+	}
+	return &types.AttributeValueMemberN{Value: x.Add(x, y).Text('f', -1)}, nil
+}
+
+type updateAction struct {
+	kind  string // SET, REMOVE, ADD or DELETE
+	path  attrPath
+	value operand
+}
+
+// parses a complete update expression into its actions
+func parseUpdate(expr string, names map[string]string, values map[string]types.AttributeValue) ([]updateAction, error) {
+	p, err := newExprParser(expr, names, values)
+	if err != nil {
+		return nil, err
+	}
+
+	var actions []updateAction
+	for p.peek().kind != tokEOF {
+		clause := strings.ToUpper(p.next().text)
+		switch clause {
+		case "SET", "REMOVE", "ADD", "DELETE":
+		default:
+			return nil, fmt.Errorf("expected SET, REMOVE, ADD or DELETE, found %q", clause)
+		}
+
+		for {
+			path, err := p.parsePath()
+			if err != nil {
+				return nil, err
+			}
+			action := updateAction{kind: clause, path: path}
+
+			switch clause {
+			case "SET":
+				if err := p.expectPunct("="); err != nil {
+					return nil, err
+				}
+				if action.value, err = p.parseSetValue(); err != nil {
+					return nil, err
+				}
+			case "ADD", "DELETE":
+				if action.value, err = p.parseOperand(); err != nil {
+					return nil, err
+				}
+			}
+			actions = append(actions, action)
+
+			if !p.acceptPunct(",") {
+				break
+			}
+		}
+	}
+
+	if len(actions) == 0 {
+		return nil, fmt.Errorf("empty update expression")
+	}
+	return actions, nil
+}
+
+func (p *exprParser) parseSetValue() (operand, error) {
+	left, err := p.parseSetOperand()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"+", "-"} {
+		if p.acceptPunct(op) {
+			right, err := p.parseSetOperand()
+			if err != nil {
+				return nil, err
+			}
+			return arithmeticOperand{op, left, right}, nil
+		}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseSetOperand() (operand, error) {
+	t := p.peek()
+	if t.kind != tokIdent || p.tokens[p.pos+1].text != "(" {
+		return p.parseOperand()
+	}
+
+	switch t.text {
+	case "if_not_exists":
+		p.next()
+		p.next()
+		path, err := p.parsePath()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(","); err != nil {
+			return nil, err
+		}
+		fallback, err := p.parseSetValue()
+		if err != nil {
+			return nil, err
+		}
+		return ifNotExistsOperand{path, fallback}, p.expectPunct(")")
+	case "list_append":
+		p.next()
+		p.next()
+		left, err := p.parseSetValue()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(","); err != nil {
+			return nil, err
+		}
+		right, err := p.parseSetValue()
+		if err != nil {
+			return nil, err
+		}
+		return listAppendOperand{left, right}, p.expectPunct(")")
+	}
+	return p.parseOperand()
+}
+
+// applies update actions to a copy of item, evaluating every value against the original
+func applyUpdate(item map[string]types.AttributeValue, actions []updateAction) (map[string]types.AttributeValue, error) {
+	values := make([]types.AttributeValue, len(actions))
+	for i, action := range actions {
+		if action.path[0].name == partitionKeyAttr || action.path[0].name == sortKeyAttr {
+			return nil, fmt.Errorf("cannot update key attribute %s", action.path[0].name)
+		}
+		if action.value == nil {
+			continue
+		}
+		v, err := action.value.value(item)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			return nil, fmt.Errorf("value for %s refers to a missing attribute", action.path)
+		}
+		values[i] = v
+	}
+
+	updated := cloneItem(item)
+	for i, action := range actions {
+		var err error
+		switch action.kind {
+		case "SET":
+			err = setPath(updated, action.path, cloneAV(values[i]))
+		case "REMOVE":
+			removePath(updated, action.path)
+		case "ADD":
+			err = addToPath(updated, action.path, values[i])
+		case "DELETE":
+			err = deleteFromPath(updated, action.path, values[i])
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return updated, nil
+}
+
+// resolves the container holding the last element of path
+func parentOf(item map[string]types.AttributeValue, path attrPath) types.AttributeValue {
+	if len(path) == 1 {
+		return &types.AttributeValueMemberM{Value: item}
+	}
+	return resolvePath(item, path[:len(path)-1])
+}
+
+func setPath(item map[string]types.AttributeValue, path attrPath, v types.AttributeValue) error {
+	last := path[len(path)-1]
+	switch parent := parentOf(item, path).(type) {
+	case *types.AttributeValueMemberM:
+		if !last.isIndex {
+			parent.Value[last.name] = v
+			return nil
+		}
+	case *types.AttributeValueMemberL:
+		if last.isIndex {
+			if last.index < len(parent.Value) {
+				parent.Value[last.index] = v
+			} else {
+				parent.Value = append(parent.Value, v)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("document path %s is invalid for update", path)
+}
+
+func removePath(item map[string]types.AttributeValue, path attrPath) {
+	last := path[len(path)-1]
+	switch parent := parentOf(item, path).(type) {
+	case *types.AttributeValueMemberM:
+		delete(parent.Value, last.name)
+	case *types.AttributeValueMemberL:
+		if last.isIndex && last.index < len(parent.Value) {
+			parent.Value = append(parent.Value[:last.index], parent.Value[last.index+1:]...)
+		}
+	}
+}
+
+func addToPath(item map[string]types.AttributeValue, path attrPath, v types.AttributeValue) error {
+	current := resolvePath(item, path)
+	if current == nil {
+		return setPath(item, path, cloneAV(v))
+	}
+
+	switch c := current.(type) {
+	case *types.AttributeValueMemberN:
+		add, ok := v.(*types.AttributeValueMemberN)
+		if !ok {
+			return fmt.Errorf("ADD requires a number for %s", path)
+		}
+		sum, err := addNumbers(c.Value, add.Value, false)
+		if err != nil {
+			return err
+		}
+		return setPath(item, path, sum)
+	case *types.AttributeValueMemberSS:
+		add, ok := v.(*types.AttributeValueMemberSS)
+		if !ok {
+			return fmt.Errorf("ADD requires a string set for %s", path)
+		}
+		for _, s := range add.Value {
+			if !slices.Contains(c.Value, s) {
+				c.Value = append(c.Value, s)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("ADD is not supported for %s", path)
+}
+
+func deleteFromPath(item map[string]types.AttributeValue, path attrPath, v types.AttributeValue) error {
+	c, ok := resolvePath(item, path).(*types.AttributeValueMemberSS)
+	if !ok {
+		return nil
+	}
+	remove, ok := v.(*types.AttributeValueMemberSS)
+	if !ok {
+		return fmt.Errorf("DELETE requires a string set for %s", path)
+	}
+
+	kept := c.Value[:0]
+	for _, s := range c.Value {
+		if !slices.Contains(remove.Value, s) {
+			kept = append(kept, s)
+		}
+	}
+	if len(kept) == 0 {
+		removePath(item, path)
+		return nil
+	}
+	c.Value = kept
+	return nil
+}
@@ -0,0 +1,417 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+)
+
+const testTable = "tasork"
+
+func str(value string) types.AttributeValue {
+	return &types.AttributeValueMemberS{Value: value}
+}
+
+func testItem(pk, sk string, attrs ...string) map[string]types.AttributeValue {
+	item := keyAttributes(pk, sk)
+	for i := 0; i+1 < len(attrs); i += 2 {
+		item[attrs[i]] = str(attrs[i+1])
+	}
+	return item
+}
+
+func putTestItems(t *testing.T, db *MemoryDB, items ...map[string]types.AttributeValue) {
+	t.Helper()
+	for _, item := range items {
+		if _, err := db.PutItem(context.Background(), &dynamodb.PutItemInput{TableName: aws.String(testTable), Item: item}); err != nil {
+			t.Fatalf("put %v: %v", item, err)
+		}
+	}
+}
+
+func sortKeys(items []map[string]types.AttributeValue) []string {
+	keys := make([]string, 0, len(items))
+	for _, item := range items {
+		_, sk, _ := keyOf(item)
+		keys = append(keys, sk)
+	}
+	return keys
+}
+
+func isValidationError(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "ValidationException"
+}
+
+func TestMemoryDBQueryKeyConditions(t *testing.T) {
+	db := NewMemoryDB()
+	putTestItems(t, db,
+		testItem("P", "A#1"), testItem("P", "A#2"), testItem("P", "B#1"), testItem("P", "C"),
+		testItem("Q", "A#1"),
+	)
+
+	tests := []struct {
+		name   string
+		expr   string
+		values map[string]types.AttributeValue
+		back   bool
+		want   []string
+	}{
+		{"partition only", "PartitionKey = :pk", map[string]types.AttributeValue{":pk": str("P")}, false, []string{"A#1", "A#2", "B#1", "C"}},
+		{"equal", "PartitionKey = :pk AND SortKey = :sk", map[string]types.AttributeValue{":pk": str("P"), ":sk": str("B#1")}, false, []string{"B#1"}},
+		{"less than", "PartitionKey = :pk AND SortKey < :sk", map[string]types.AttributeValue{":pk": str("P"), ":sk": str("B")}, false, []string{"A#1", "A#2"}},
+		{"at most", "PartitionKey = :pk AND SortKey <= :sk", map[string]types.AttributeValue{":pk": str("P"), ":sk": str("B#1")}, false, []string{"A#1", "A#2", "B#1"}},
+		{"greater than", "PartitionKey = :pk AND SortKey > :sk", map[string]types.AttributeValue{":pk": str("P"), ":sk": str("B#1")}, false, []string{"C"}},
+		{"at least", "PartitionKey = :pk AND SortKey >= :sk", map[string]types.AttributeValue{":pk": str("P"), ":sk": str("B#1")}, false, []string{"B#1", "C"}},
+		{"between", "PartitionKey = :pk AND SortKey BETWEEN :lo AND :hi", map[string]types.AttributeValue{":pk": str("P"), ":lo": str("A#2"), ":hi": str("B#1")}, false, []string{"A#2", "B#1"}},
+		{"begins with", "PartitionKey = :pk AND begins_with(SortKey, :prefix)", map[string]types.AttributeValue{":pk": str("P"), ":prefix": str("A#")}, false, []string{"A#1", "A#2"}},
+		{"begins with backwards", "PartitionKey = :pk AND begins_with(SortKey, :prefix)", map[string]types.AttributeValue{":pk": str("P"), ":prefix": str("A#")}, true, []string{"A#2", "A#1"}},
+		{"begins with nothing", "PartitionKey = :pk AND begins_with(SortKey, :prefix)", map[string]types.AttributeValue{":pk": str("P"), ":prefix": str("Z")}, false, []string{}},
+		{"other partition", "PartitionKey = :pk", map[string]types.AttributeValue{":pk": str("Q")}, false, []string{"A#1"}},
+		{"aliased names", "#pk = :pk AND begins_with(#sk, :prefix)", map[string]types.AttributeValue{":pk": str("P"), ":prefix": str("B")}, false, []string{"B#1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := db.Query(context.Background(), &dynamodb.QueryInput{
+				TableName:                 aws.String(testTable),
+				KeyConditionExpression:    aws.String(tt.expr),
+				ExpressionAttributeNames:  map[string]string{"#pk": "PartitionKey", "#sk": "SortKey"},
+				ExpressionAttributeValues: tt.values,
+				ScanIndexForward:          aws.Bool(!tt.back),
+			})
+			if err != nil {
+				t.Fatalf("query: %v", err)
+			}
+			if got := sortKeys(output.Items); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryDBQueryPagesWithLimit(t *testing.T) {
+	db := NewMemoryDB()
+	for i := range 5 {
+		putTestItems(t, db, testItem("P", fmt.Sprintf("A#%d", i)))
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(testTable),
+		KeyConditionExpression:    aws.String("PartitionKey = :pk AND begins_with(SortKey, :prefix)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":pk": str("P"), ":prefix": str("A#")},
+		Limit:                     aws.Int32(2),
+	}
+	var got []string
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("query never ran out of pages")
+		}
+		output, err := db.Query(context.Background(), input)
+		if err != nil {
+			t.Fatalf("query: %v", err)
+		}
+		got = append(got, sortKeys(output.Items)...)
+		if output.LastEvaluatedKey == nil {
+			break
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+	if want := []string{"A#0", "A#1", "A#2", "A#3", "A#4"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestMemoryDBScanPagesWithFilter(t *testing.T) {
+	db := NewMemoryDB()
+	for _, pk := range []string{"B", "A", "C"} {
+		for i := range 3 {
+			putTestItems(t, db, testItem(pk, fmt.Sprintf("S#%d", i), "kind", fmt.Sprint(i%2)))
+		}
+	}
+
+	input := &dynamodb.ScanInput{
+		TableName:                 aws.String(testTable),
+		FilterExpression:          aws.String("kind = :kind"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":kind": str("0")},
+		Limit:                     aws.Int32(2),
+	}
+	var got []string
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("scan never ran out of pages")
+		}
+		output, err := db.Scan(context.Background(), input)
+		if err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		for _, item := range output.Items {
+			pk, sk, _ := keyOf(item)
+			got = append(got, pk+"/"+sk)
+		}
+		if output.LastEvaluatedKey == nil {
+			break
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+	if want := []string{"A/S#0", "A/S#2", "B/S#0", "B/S#2", "C/S#0", "C/S#2"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestMemoryDBQueryRequiresPartitionKey(t *testing.T) {
+	_, err := NewMemoryDB().Query(context.Background(), &dynamodb.QueryInput{
+		TableName:                 aws.String(testTable),
+		KeyConditionExpression:    aws.String("begins_with(SortKey, :prefix)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":prefix": str("A")},
+	})
+	if !isValidationError(err) {
+		t.Errorf("got %v, want a validation error", err)
+	}
+}
+
+func TestMemoryDBBatchWriteLimit(t *testing.T) {
+	requests := func(n int) []types.WriteRequest {
+		var writes []types.WriteRequest
+		for i := range n {
+			writes = append(writes, types.WriteRequest{PutRequest: &types.PutRequest{Item: testItem("P", fmt.Sprintf("%03d", i))}})
+		}
+		return writes
+	}
+
+	tests := []struct {
+		name    string
+		writes  []types.WriteRequest
+		wantErr bool
+	}{
+		{"none", nil, true},
+		{"one", requests(1), false},
+		{"at the limit", requests(maxBatchWriteItems), false},
+		{"over the limit", requests(maxBatchWriteItems + 1), true},
+		{"duplicate keys", append(requests(1), requests(1)...), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewMemoryDB()
+			_, err := db.BatchWriteItem(context.Background(), &dynamodb.BatchWriteItemInput{
+				RequestItems: map[string][]types.WriteRequest{testTable: tt.writes},
+			})
+			if tt.wantErr {
+				if !isValidationError(err) {
+					t.Fatalf("got %v, want a validation error", err)
+				}
+				// a rejected batch writes nothing
+				if len(db.tables) != 0 && len(db.tables[testTable].partitions) != 0 {
+					t.Errorf("rejected batch wrote items")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("batch write: %v", err)
+			}
+			if got := len(db.tables[testTable].partitions["P"]); got != len(tt.writes) {
+				t.Errorf("wrote %d items, want %d", got, len(tt.writes))
+			}
+		})
+	}
+}
+
+func TestMemoryDBConditionalDelete(t *testing.T) {
+	tests := []struct {
+		name      string
+		existing  bool
+		condition string
+		wantErr   bool
+		wantGone  bool
+	}{
+		{"unconditional", true, "", false, true},
+		{"unconditional on missing item", false, "", false, true},
+		{"exists", true, "attribute_exists(PartitionKey)", false, true},
+		{"exists on missing item", false, "attribute_exists(PartitionKey)", true, true},
+		{"matching value", true, "#status = :status", false, true},
+		{"other value", true, "#status = :other", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewMemoryDB()
+			if tt.existing {
+				putTestItems(t, db, testItem("P", "S", "status", "open"))
+			}
+
+			input := &dynamodb.DeleteItemInput{TableName: aws.String(testTable), Key: keyAttributes("P", "S")}
+			if tt.condition != "" {
+				input.ConditionExpression = aws.String(tt.condition)
+				if tt.condition != "attribute_exists(PartitionKey)" {
+					input.ExpressionAttributeNames = map[string]string{"#status": "status"}
+					input.ExpressionAttributeValues = map[string]types.AttributeValue{":status": str("open"), ":other": str("closed")}
+				}
+			}
+			_, err := db.DeleteItem(context.Background(), input)
+			if tt.wantErr != isConditionFailure(err) || (!tt.wantErr && err != nil) {
+				t.Fatalf("got %v, want condition failure %v", err, tt.wantErr)
+			}
+
+			output, _ := db.GetItem(context.Background(), &dynamodb.GetItemInput{TableName: aws.String(testTable), Key: keyAttributes("P", "S")})
+			if gone := output.Item == nil; gone != tt.wantGone {
+				t.Errorf("item gone %v, want %v", gone, tt.wantGone)
+			}
+		})
+	}
+}
+
+func TestMemoryDBTransactWriteItems(t *testing.T) {
+	put := func(sk string, condition string) types.TransactWriteItem {
+		action := types.TransactWriteItem{Put: &types.Put{TableName: aws.String(testTable), Item: testItem("P", sk, "v", "new")}}
+		if condition != "" {
+			action.Put.ConditionExpression = aws.String(condition)
+		}
+		return action
+	}
+	update := types.TransactWriteItem{Update: &types.Update{
+		TableName:                 aws.String(testTable),
+		Key:                       keyAttributes("P", "existing"),
+		UpdateExpression:          aws.String("SET v = :v"),
+		ConditionExpression:       aws.String("attribute_exists(PartitionKey)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":v": str("updated")},
+	}}
+	remove := types.TransactWriteItem{Delete: &types.Delete{TableName: aws.String(testTable), Key: keyAttributes("P", "existing")}}
+	check := func(condition string) types.TransactWriteItem {
+		return types.TransactWriteItem{ConditionCheck: &types.ConditionCheck{
+			TableName:           aws.String(testTable),
+			Key:                 keyAttributes("P", "existing"),
+			ConditionExpression: aws.String(condition),
+		}}
+	}
+
+	tests := []struct {
+		name       string
+		actions    []types.TransactWriteItem
+		wantFailed []int // indexes whose condition fails, cancelling the transaction
+		wantErr    bool  // rejected as invalid
+		wantItems  map[string]string
+	}{
+		{
+			name:      "applies every action",
+			actions:   []types.TransactWriteItem{put("a", "attribute_not_exists(PartitionKey)"), update},
+			wantItems: map[string]string{"a": "new", "existing": "updated"},
+		},
+		{
+			name:      "delete",
+			actions:   []types.TransactWriteItem{put("a", ""), remove},
+			wantItems: map[string]string{"a": "new"},
+		},
+		{
+			name:       "one failed condition cancels all",
+			actions:    []types.TransactWriteItem{put("a", ""), put("b", "attribute_exists(PartitionKey)"), update},
+			wantFailed: []int{1},
+			wantItems:  map[string]string{"existing": "old"},
+		},
+		{
+			name:       "condition check",
+			actions:    []types.TransactWriteItem{put("a", ""), check("attribute_not_exists(PartitionKey)")},
+			wantFailed: []int{1},
+			wantItems:  map[string]string{"existing": "old"},
+		},
+		{
+			name:      "passing condition check",
+			actions:   []types.TransactWriteItem{put("a", ""), check("attribute_exists(PartitionKey)")},
+			wantItems: map[string]string{"a": "new", "existing": "old"},
+		},
+		{
+			name:      "two actions on one item",
+			actions:   []types.TransactWriteItem{update, remove},
+			wantErr:   true,
+			wantItems: map[string]string{"existing": "old"},
+		},
+		{
+			name:      "no actions",
+			wantErr:   true,
+			wantItems: map[string]string{"existing": "old"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewMemoryDB()
+			putTestItems(t, db, testItem("P", "existing", "v", "old"))
+
+			_, err := db.TransactWriteItems(context.Background(), &dynamodb.TransactWriteItemsInput{TransactItems: tt.actions})
+			switch {
+			case tt.wantErr:
+				if !isValidationError(err) {
+					t.Fatalf("got %v, want a validation error", err)
+				}
+			case tt.wantFailed != nil:
+				for i := range tt.actions {
+					if want := slices.Contains(tt.wantFailed, i); conditionFailedAt(err, i) != want {
+						t.Errorf("action %d failed %v, want %v (%v)", i, !want, want, err)
+					}
+				}
+			case err != nil:
+				t.Fatalf("transaction: %v", err)
+			}
+
+			output, err := db.Query(context.Background(), &dynamodb.QueryInput{
+				TableName:                 aws.String(testTable),
+				KeyConditionExpression:    aws.String("PartitionKey = :pk"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{":pk": str("P")},
+			})
+			if err != nil {
+				t.Fatalf("query: %v", err)
+			}
+			got := map[string]string{}
+			for _, item := range output.Items {
+				_, sk, _ := keyOf(item)
+				got[sk] = item["v"].(*types.AttributeValueMemberS).Value
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.wantItems) {
+				t.Errorf("items %v, want %v", got, tt.wantItems)
+			}
+		})
+	}
+}
+
+func TestMemoryDBRejectsReservedWords(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		names   map[string]string
+		wantErr bool
+	}{
+		{"plain name", "SET lastSentAt = :v", nil, false},
+		{"reserved name", "SET timezone = :v", nil, true},
+		{"reserved in any case", "SET Status = :v", nil, true},
+		{"reserved nested name", "SET settings.zone = :v", nil, true},
+		{"aliased reserved name", "SET #timezone = :v", map[string]string{"#timezone": "timezone"}, false},
+		{"reserved inside a function", "SET readAt = if_not_exists(comment, :v)", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewMemoryDB().UpdateItem(context.Background(), &dynamodb.UpdateItemInput{
+				TableName:                 aws.String(testTable),
+				Key:                       keyAttributes("P", "S"),
+				UpdateExpression:          aws.String(tt.expr),
+				ExpressionAttributeNames:  tt.names,
+				ExpressionAttributeValues: map[string]types.AttributeValue{":v": str("x")},
+			})
+			if tt.wantErr != isValidationError(err) || (!tt.wantErr && err != nil) {
+				t.Errorf("got %v, want a validation error %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	_, err := NewMemoryDB().Query(context.Background(), &dynamodb.QueryInput{
+		TableName:                 aws.String(testTable),
+		KeyConditionExpression:    aws.String("PartitionKey = :pk"),
+		FilterExpression:          aws.String("status = :v"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":pk": str("P"), ":v": str("x")},
+	})
+	if !isValidationError(err) {
+		t.Errorf("filter on a reserved name: got %v, want a validation error", err)
+	}
+}
@@ -0,0 +1,341 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Ghaby-X/tasork/internal/env"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Notification is a message to a user. ReadAt is empty while it is unread,
+// and a read notification may carry ExpiresAt, the unix time dynamodb's ttl
+// deletes it at. An archived one is read, kept out of listings and never
+// expires
+type Notification struct {
+	UserID         string
+	NotificationID string
+	Message        string
+	Time           string
+	ReadAt         string
+	ArchivedAt     string
+	ExpiresAt      int64
+}
+
+// NotificationQuery narrows a notification listing to the unread ones, or
+// to the archived ones in place of the others
+type NotificationQuery struct {
+	UnreadOnly bool
+	Archived   bool
+}
+
+type notificationItem struct {
+	PartitionKey string `dynamodbav:"PartitionKey"`
+	SortKey      string `dynamodbav:"SortKey"`
+	Message      string `dynamodbav:"message"`
+	Time         string `dynamodbav:"time"`
+	ReadAt       string `dynamodbav:"readAt,omitempty"`
+	ArchivedAt   string `dynamodbav:"archivedAt,omitempty"`
+	ExpiresAt    int64  `dynamodbav:"expiresAt,omitempty"`
+}
+
+func newNotificationItem(n Notification) notificationItem {
+	return notificationItem{
+		PartitionKey: UserKey(n.UserID),
+		SortKey:      notificationPrefix + n.NotificationID,
+		Message:      n.Message,
+		Time:         n.Time,
+		ReadAt:       n.ReadAt,
+		ArchivedAt:   n.ArchivedAt,
+		ExpiresAt:    n.ExpiresAt,
+	}
+}
+
+func (item notificationItem) notification() Notification {
+	return Notification{
+		UserID:         strings.TrimPrefix(item.PartitionKey, userPrefix),
+		NotificationID: strings.TrimPrefix(item.SortKey, notificationPrefix),
+		Message:        item.Message,
+		Time:           item.Time,
+		ReadAt:         item.ReadAt,
+		ArchivedAt:     item.ArchivedAt,
+		ExpiresAt:      item.ExpiresAt,
+	}
+}
+
+type NotificationsStore interface {
+	PutNotifications(notifications []Notification) error
+	// lists one page of a user's notifications, newest first, leaving out
+	// the ones that expired before now
+	ListNotifications(userID string, query NotificationQuery, now int64, page PageRequest) ([]Notification, string, error)
+	// lists up to limit of a user's notifications made after the one with
+	// the given id, oldest first
+	ListNotificationsAfter(userID, notificationID string, limit int) ([]Notification, error)
+	CountUnread(userID string) (int, error)
+	// marks a notification read, ErrNotFound when the user has no such
+	// notification. One that is already read keeps its first read time
+	MarkRead(userID, notificationID, readAt string, expiresAt int64) error
+	// marks every unread notification of a user read, returning how many it marked
+	MarkAllRead(userID, readAt string, expiresAt int64) (int, error)
+	// archives a notification, marking it read if it was not, ErrNotFound
+	// when the user has no such notification
+	ArchiveNotification(userID, notificationID, archivedAt string) error
+	// returns ErrNotFound when the user has no such notification
+	DeleteNotification(userID, notificationID string) error
+	// returns ErrNotFound for a user who never saved any
+	GetPreferences(userID string) (*Preferences, error)
+	// saves preferences along with the user's entry in the digest index
+	PutPreferences(preferences Preferences) error
+	// lists every user whose digest is on
+	ListDigests() ([]DigestEntry, error)
+	// records that the digest of a user goes out at sentAt, ErrVersionConflict
+	// when another run claimed it since the entry was read. Claiming an entry
+	// whose LastSentAt is sentAt back for its old time gives the digest back
+	ClaimDigest(entry DigestEntry, sentAt string) error
+	// holds an email back until its SendAt
+	DeferMail(mail DeferredMail) error
+	// lists the held back emails due before the given time, soonest first
+	ListDeferredMail(before string) ([]DeferredMail, error)
+	// takes a held back email out before it is sent, ErrNotFound when
+	// another run took it first
+	TakeDeferredMail(mail DeferredMail) error
+}
+
+type notificationsStore struct {
+	db        DynamoDBAPI
+	tableName string
+	cursors   *cursorCodec
+}
+
+func newNotificationsStore(db DynamoDBAPI, cursors *cursorCodec) *notificationsStore {
+	return &notificationsStore{db, env.GetString("DYNAMODB_TABLE_NAME", "tasork"), cursors}
+}
+
+func (s *notificationsStore) PutNotifications(notifications []Notification) error {
+	actions := make([]types.TransactWriteItem, 0, len(notifications))
+	for _, notification := range notifications {
+		action, err := putAction(s.tableName, newNotificationItem(notification))
+		if err != nil {
+			return err
+		}
+		actions = append(actions, action)
+	}
+	if len(actions) == 0 {
+		return nil
+	}
+	return transactWrite(s.db, actions)
+}
+
+var unreadFilter = &queryFilter{expr: "attribute_not_exists(readAt)"}
+
+func (s *notificationsStore) ListNotifications(userID string, query NotificationQuery, now int64, page PageRequest) ([]Notification, string, error) {
+	// the ttl deletes expired items up to a few days late, so they are hidden
+	// until then. Archived ones never expire
+	filter := &queryFilter{
+		expr:   "attribute_not_exists(archivedAt) AND (attribute_not_exists(expiresAt) OR expiresAt > :now)",
+		values: map[string]types.AttributeValue{":now": &types.AttributeValueMemberN{Value: strconv.FormatInt(now, 10)}},
+	}
+	if query.Archived {
+		filter = &queryFilter{expr: "attribute_exists(archivedAt)"}
+	}
+	if query.UnreadOnly {
+		filter.expr = fmt.Sprintf("(%s) AND %s", filter.expr, unreadFilter.expr)
+	}
+
+	// ids sort by time, so reading backwards lists the newest first
+	rows, next, err := queryPageBackward(s.db, s.cursors, s.tableName, UserKey(userID), notificationPrefix, filter, page)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var items []notificationItem
+	if err := attributevalue.UnmarshalListOfMaps(rows, &items); err != nil {
+		return nil, "", fmt.Errorf("failed to unmarshal notifications: %w", err)
+	}
+
+	notifications := make([]Notification, 0, len(items))
+	for _, item := range items {
+		notifications = append(notifications, item.notification())
+	}
+	return notifications, next, nil
+}
+
+func (s *notificationsStore) ListNotificationsAfter(userID, notificationID string, limit int) ([]Notification, error) {
+	// the key condition has no greater than with a prefix, so the range ends
+	// at the first key past the prefix, '$' following '#'
+	output, err := s.db.Query(context.Background(), &dynamodb.QueryInput{
+		TableName:              aws.String(s.tableName),
+		KeyConditionExpression: aws.String("PartitionKey = :pkey AND SortKey BETWEEN :after AND :end"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pkey":  &types.AttributeValueMemberS{Value: UserKey(userID)},
+			":after": &types.AttributeValueMemberS{Value: notificationPrefix + notificationID},
+			":end":   &types.AttributeValueMemberS{Value: strings.TrimSuffix(notificationPrefix, "#") + "$"},
+		},
+		// one more, as the range includes the notification it starts at
+		Limit: aws.Int32(int32(limit) + 1),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query notifications: %w", err)
+	}
+
+	var items []notificationItem
+	if err := attributevalue.UnmarshalListOfMaps(output.Items, &items); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal notifications: %w", err)
+	}
+
+	notifications := make([]Notification, 0, len(items))
+	for _, item := range items {
+		notification := item.notification()
+		if notification.NotificationID == notificationID || len(notifications) == limit {
+			continue
+		}
+		notifications = append(notifications, notification)
+	}
+	return notifications, nil
+}
+
+func (s *notificationsStore) unread(userID string) ([]notificationItem, error) {
+	rows, err := queryAll(s.db, s.tableName, UserKey(userID), notificationPrefix, unreadFilter)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []notificationItem
+	if err := attributevalue.UnmarshalListOfMaps(rows, &items); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal notifications: %w", err)
+	}
+	return items, nil
+}
+
+func (s *notificationsStore) CountUnread(userID string) (int, error) {
+	items, err := s.unread(userID)
+	return len(items), err
+}
+
+// only a notification that is still unread is updated, so one that is read
+// already keeps its read time and expiry, and an archived one never expires
+func (s *notificationsStore) MarkRead(userID, notificationID, readAt string, expiresAt int64) error {
+	update := s.markReadAction(notificationItem{PartitionKey: UserKey(userID), SortKey: notificationPrefix + notificationID}, readAt, expiresAt).Update
+	_, err := s.db.UpdateItem(context.Background(), &dynamodb.UpdateItemInput{
+		TableName:                 update.TableName,
+		Key:                       update.Key,
+		ConditionExpression:       update.ConditionExpression,
+		UpdateExpression:          update.UpdateExpression,
+		ExpressionAttributeValues: update.ExpressionAttributeValues,
+	})
+	if !isConditionFailure(err) {
+		return err
+	}
+
+	// read already, or not there at all
+	output, err := s.db.GetItem(context.Background(), &dynamodb.GetItemInput{TableName: update.TableName, Key: update.Key})
+	if err != nil {
+		return err
+	}
+	if output.Item == nil {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *notificationsStore) ArchiveNotification(userID, notificationID, archivedAt string) error {
+	_, err := s.db.UpdateItem(context.Background(), &dynamodb.UpdateItemInput{
+		TableName:           aws.String(s.tableName),
+		Key:                 keyAttributes(UserKey(userID), notificationPrefix+notificationID),
+		ConditionExpression: aws.String("attribute_exists(PartitionKey)"),
+		UpdateExpression:    aws.String("SET archivedAt = if_not_exists(archivedAt, :at), readAt = if_not_exists(readAt, :at) REMOVE expiresAt"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":at": &types.AttributeValueMemberS{Value: archivedAt},
+		},
+	})
+	if isConditionFailure(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// the update marking a notification read, which must still be there and unread
+func (s *notificationsStore) markReadAction(item notificationItem, readAt string, expiresAt int64) types.TransactWriteItem {
+	update := &types.Update{
+		TableName:           aws.String(s.tableName),
+		Key:                 keyAttributes(item.PartitionKey, item.SortKey),
+		ConditionExpression: aws.String("attribute_exists(PartitionKey) AND attribute_not_exists(readAt)"),
+		UpdateExpression:    aws.String("SET readAt = :readAt"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":readAt": &types.AttributeValueMemberS{Value: readAt},
+		},
+	}
+	if expiresAt > 0 {
+		update.UpdateExpression = aws.String(*update.UpdateExpression + ", expiresAt = :expiresAt")
+		update.ExpressionAttributeValues[":expiresAt"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(expiresAt, 10)}
+	}
+	return types.TransactWriteItem{Update: update}
+}
+
+// marks the unread notifications a page at a time, each page in one
+// transaction, so a user with many never has them all in memory
+func (s *notificationsStore) MarkAllRead(userID, readAt string, expiresAt int64) (int, error) {
+	marked := 0
+	page := PageRequest{Limit: MaxPageLimit}
+	for {
+		rows, next, err := queryPage(s.db, s.cursors, s.tableName, UserKey(userID), notificationPrefix, unreadFilter, page)
+		if err != nil {
+			return marked, err
+		}
+		var items []notificationItem
+		if err := attributevalue.UnmarshalListOfMaps(rows, &items); err != nil {
+			return marked, fmt.Errorf("failed to unmarshal notifications: %w", err)
+		}
+
+		n, err := s.markPageRead(items, readAt, expiresAt)
+		marked += n
+		if err != nil || next == "" {
+			return marked, err
+		}
+		page.Cursor = next
+	}
+}
+
+// marks a page of notifications read, leaving out the ones deleted or read
+// since the page was listed and trying again without them
+func (s *notificationsStore) markPageRead(items []notificationItem, readAt string, expiresAt int64) (int, error) {
+	for attempt := 1; len(items) > 0; attempt++ {
+		actions := make([]types.TransactWriteItem, 0, len(items))
+		for _, item := range items {
+			actions = append(actions, s.markReadAction(item, readAt, expiresAt))
+		}
+
+		err := transactWrite(s.db, actions)
+		if err == nil {
+			return len(items), nil
+		}
+		if !isConditionFailure(err) || attempt >= batchMaxAttempts {
+			return 0, err
+		}
+		remaining := items[:0]
+		for i, item := range items {
+			if !conditionFailedAt(err, i) {
+				remaining = append(remaining, item)
+			}
+		}
+		items = remaining
+	}
+	return 0, nil
+}
+
+func (s *notificationsStore) DeleteNotification(userID, notificationID string) error {
+	_, err := s.db.DeleteItem(context.Background(), &dynamodb.DeleteItemInput{
+		TableName:           aws.String(s.tableName),
+		Key:                 keyAttributes(UserKey(userID), notificationPrefix+notificationID),
+		ConditionExpression: aws.String("attribute_exists(PartitionKey)"),
+	})
+	if isConditionFailure(err) {
+		return ErrNotFound
+	}
+	return err
+}
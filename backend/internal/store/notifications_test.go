@@ -0,0 +1,128 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// a MemoryDB recording the size of each transaction
+type transactionsDB struct {
+	*MemoryDB
+	sizes []int
+}
+
+func (db *transactionsDB) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	db.sizes = append(db.sizes, len(params.TransactItems))
+	return db.MemoryDB.TransactWriteItems(ctx, params, optFns...)
+}
+
+func putTestNotifications(t *testing.T, notifications *notificationsStore, n int) {
+	t.Helper()
+	var batch []Notification
+	for i := range n {
+		batch = append(batch, Notification{UserID: "ann", NotificationID: fmt.Sprintf("N%03d", i), Message: "hi", Time: "2024-05-01T12:00:00Z"})
+	}
+	if err := notifications.PutNotifications(batch); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMarkAllReadByPage(t *testing.T) {
+	db := &transactionsDB{MemoryDB: NewMemoryDB()}
+	notifications := newNotificationsStore(db, newCursorCodec())
+	putTestNotifications(t, notifications, 250)
+	for _, id := range []string{"N000", "N120", "N249"} {
+		if err := notifications.MarkRead("ann", id, "2024-05-01T13:00:00Z", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db.sizes = nil
+	marked, err := notifications.MarkAllRead("ann", "2024-05-01T14:00:00Z", 1e10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if marked != 247 {
+		t.Errorf("marked %d read, want 247", marked)
+	}
+	// the pages hold 100 unread notifications but the last
+	if len(db.sizes) != 3 || db.sizes[0] != 100 || db.sizes[1] != 100 || db.sizes[2] != 47 {
+		t.Errorf("wrote transactions of %v notifications, want 100, 100 and 47", db.sizes)
+	}
+	if unread, err := notifications.CountUnread("ann"); err != nil || unread != 0 {
+		t.Errorf("%d unread after marking all read, %v", unread, err)
+	}
+
+	// the ones read before keep their read time and never expire
+	rows, err := queryAll(db, testTable, UserKey("ann"), notificationPrefix, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var items []notificationItem
+	if err := attributevalue.UnmarshalListOfMaps(rows, &items); err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range items {
+		n := item.notification()
+		want, expires := "2024-05-01T14:00:00Z", n.ExpiresAt != 0
+		if n.NotificationID == "N000" || n.NotificationID == "N120" || n.NotificationID == "N249" {
+			want, expires = "2024-05-01T13:00:00Z", n.ExpiresAt == 0
+		}
+		if n.ReadAt != want || !expires {
+			t.Errorf("%s read at %s expiring at %d, want read at %s", n.NotificationID, n.ReadAt, n.ExpiresAt, want)
+		}
+	}
+}
+
+func TestArchiveNotification(t *testing.T) {
+	notifications := newNotificationsStore(NewMemoryDB(), newCursorCodec())
+	putTestNotifications(t, notifications, 4)
+	if err := notifications.MarkRead("ann", "N001", "2024-05-01T13:00:00Z", 100); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"N001", "N002"} {
+		if err := notifications.ArchiveNotification("ann", id, "2024-05-01T14:00:00Z"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := notifications.ArchiveNotification("ann", "N009", "2024-05-01T14:00:00Z"); err != ErrNotFound {
+		t.Errorf("archiving a missing notification = %v, want ErrNotFound", err)
+	}
+	// read already, so this leaves its expiry off
+	if err := notifications.MarkRead("ann", "N002", "2024-05-01T15:00:00Z", 100); err != nil {
+		t.Fatal(err)
+	}
+
+	list := func(query NotificationQuery) []string {
+		t.Helper()
+		// far past the expiry of N001 had it kept one
+		page, _, err := notifications.ListNotifications("ann", query, 1000, PageRequest{Limit: MaxPageLimit})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, n := range page {
+			ids = append(ids, n.NotificationID)
+			if query.Archived && (n.ArchivedAt == "" || n.ReadAt == "" || n.ExpiresAt != 0) {
+				t.Errorf("archived %+v, want it read without an expiry", n)
+			}
+		}
+		return ids
+	}
+	if got := fmt.Sprint(list(NotificationQuery{})); got != "[N003 N000]" {
+		t.Errorf("listed %s, want N003 and N000", got)
+	}
+	if got := fmt.Sprint(list(NotificationQuery{Archived: true})); got != "[N002 N001]" {
+		t.Errorf("listed %s archived, want N002 and N001", got)
+	}
+	if got := fmt.Sprint(list(NotificationQuery{UnreadOnly: true})); got != "[N003 N000]" {
+		t.Errorf("listed %s unread, want N003 and N000", got)
+	}
+	if unread, err := notifications.CountUnread("ann"); err != nil || unread != 2 {
+		t.Errorf("%d unread, want 2: %v", unread, err)
+	}
+}
@@ -0,0 +1,116 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// partition holding emails that wait to be sent, such as ones that came in
+// during the quiet hours of their recipient. Only read by the outbox worker
+const outboxPartition = "OUTBOX"
+
+// DeferredMail is an email held back until SendAt, in UTC
+type DeferredMail struct {
+	MailID  string
+	UserID  string
+	To      string
+	Subject string
+	HTML    string
+	SendAt  string
+}
+
+// OUTBOX/<send at>#<id> holds an email until it is due, so the due ones are
+// read with one range query
+type deferredMailItem struct {
+	PartitionKey string `dynamodbav:"PartitionKey"`
+	SortKey      string `dynamodbav:"SortKey"`
+	MailID       string `dynamodbav:"mailId"`
+	UserID       string `dynamodbav:"userId"`
+	To           string `dynamodbav:"to"`
+	Subject      string `dynamodbav:"subject"`
+	HTML         string `dynamodbav:"html"`
+	SendAt       string `dynamodbav:"sendAt"`
+}
+
+func outboxKey(mail DeferredMail) string {
+	return mail.SendAt + "#" + mail.MailID
+}
+
+func (s *notificationsStore) DeferMail(mail DeferredMail) error {
+	item, err := attributevalue.MarshalMap(deferredMailItem{
+		PartitionKey: outboxPartition,
+		SortKey:      outboxKey(mail),
+		MailID:       mail.MailID,
+		UserID:       mail.UserID,
+		To:           mail.To,
+		Subject:      mail.Subject,
+		HTML:         mail.HTML,
+		SendAt:       mail.SendAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.PutItem(context.Background(), &dynamodb.PutItemInput{
+		TableName: aws.String(s.tableName),
+		Item:      item,
+	})
+	return err
+}
+
+func (s *notificationsStore) ListDeferredMail(before string) ([]DeferredMail, error) {
+	// a mail due at before sorts after it, as its key goes on past the time
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(s.tableName),
+		KeyConditionExpression: aws.String("PartitionKey = :pkey AND SortKey < :before"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pkey":   &types.AttributeValueMemberS{Value: outboxPartition},
+			":before": &types.AttributeValueMemberS{Value: before},
+		},
+	}
+
+	var mails []DeferredMail
+	for {
+		output, err := s.db.Query(context.Background(), input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query outbox: %w", err)
+		}
+
+		var items []deferredMailItem
+		if err := attributevalue.UnmarshalListOfMaps(output.Items, &items); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal outbox: %w", err)
+		}
+		for _, item := range items {
+			mails = append(mails, DeferredMail{
+				MailID:  item.MailID,
+				UserID:  item.UserID,
+				To:      item.To,
+				Subject: item.Subject,
+				HTML:    item.HTML,
+				SendAt:  item.SendAt,
+			})
+		}
+
+		if output.LastEvaluatedKey == nil {
+			return mails, nil
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+}
+
+func (s *notificationsStore) TakeDeferredMail(mail DeferredMail) error {
+	_, err := s.db.DeleteItem(context.Background(), &dynamodb.DeleteItemInput{
+		TableName:           aws.String(s.tableName),
+		Key:                 keyAttributes(outboxPartition, outboxKey(mail)),
+		ConditionExpression: aws.String("attribute_exists(PartitionKey)"),
+	})
+	if isConditionFailure(err) {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,232 @@
+package store
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"maps"
+	"strings"
+
+	"github.com/Ghaby-X/tasork/internal/env"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// page sizes when the caller asks for none, and the most it may ask for
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 100
+)
+
+// returned for a cursor that was tampered with or issued for another listing
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// PageRequest asks for one page of a listing, an empty Cursor starts at the beginning
+type PageRequest struct {
+	Limit  int
+	Cursor string
+}
+
+func (p PageRequest) limit() int32 {
+	if p.Limit <= 0 {
+		return DefaultPageLimit
+	}
+	return int32(min(p.Limit, MaxPageLimit))
+}
+
+// what a cursor carries, the listing it belongs to and where the last page
+// stopped. Listings sorted on an attribute also carry the order and the
+// attribute value of the last item
+type cursorPayload struct {
+	PartitionKey string `json:"pk"`
+	Prefix       string `json:"prefix"`
+	SortKey      string `json:"sk"`
+	Order        string `json:"order,omitempty"`
+	After        string `json:"after,omitempty"`
+}
+
+// signs cursors so clients can hand them back but not forge them
+type cursorCodec struct {
+	secret []byte
+}
+
+// uses CURSOR_SECRET, or a random secret when unset, in which case cursors do
+// not survive a restart and are not shared between instances
+func newCursorCodec() *cursorCodec {
+	secret := []byte(env.GetString("CURSOR_SECRET", ""))
+	if len(secret) == 0 {
+		log.Printf("CURSOR_SECRET is not set, pagination cursors will only be valid for this process")
+		secret = make([]byte, 32)
+		rand.Read(secret)
+	}
+	return &cursorCodec{secret}
+}
+
+func (c *cursorCodec) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// encodes the last evaluated key of a listing, empty when there are no more pages
+func (c *cursorCodec) encode(prefix string, lastKey map[string]types.AttributeValue) (string, error) {
+	if lastKey == nil {
+		return "", nil
+	}
+
+	pk, sk, err := keyOf(lastKey)
+	if err != nil {
+		return "", err
+	}
+	return c.encodePayload(cursorPayload{PartitionKey: pk, Prefix: prefix, SortKey: sk})
+}
+
+func (c *cursorCodec) encodePayload(p cursorPayload) (string, error) {
+	payload, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(c.sign(payload)), nil
+}
+
+// checks a cursor and returns the key to resume the listing of pk/prefix from
+func (c *cursorCodec) decode(cursor, pk, prefix string) (map[string]types.AttributeValue, error) {
+	p, err := c.decodePayload(cursor, pk, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if p.Order != "" {
+		return nil, ErrInvalidCursor
+	}
+	return keyAttributes(p.PartitionKey, p.SortKey), nil
+}
+
+// checks a cursor was issued for the listing of pk/prefix and returns what it carries
+func (c *cursorCodec) decodePayload(cursor, pk, prefix string) (cursorPayload, error) {
+	var p cursorPayload
+	encPayload, encSig, ok := strings.Cut(cursor, ".")
+	if !ok {
+		return p, ErrInvalidCursor
+	}
+
+	enc := base64.RawURLEncoding
+	payload, err := enc.DecodeString(encPayload)
+	if err != nil {
+		return p, ErrInvalidCursor
+	}
+	sig, err := enc.DecodeString(encSig)
+	if err != nil || !hmac.Equal(sig, c.sign(payload)) {
+		return p, ErrInvalidCursor
+	}
+
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return p, ErrInvalidCursor
+	}
+	if p.PartitionKey != pk || p.Prefix != prefix || !strings.HasPrefix(p.SortKey, prefix) {
+		return p, ErrInvalidCursor
+	}
+	return p, nil
+}
+
+// a FilterExpression with its placeholders, applied after the key condition
+type queryFilter struct {
+	expr   string
+	names  map[string]string
+	values map[string]types.AttributeValue
+}
+
+func prefixQuery(tableName, pk, prefix string, filter *queryFilter) *dynamodb.QueryInput {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		KeyConditionExpression: aws.String("PartitionKey = :pkey AND begins_with(SortKey, :skprefix)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pkey":     &types.AttributeValueMemberS{Value: pk},
+			":skprefix": &types.AttributeValueMemberS{Value: prefix},
+		},
+	}
+
+	if filter != nil && filter.expr != "" {
+		input.FilterExpression = aws.String(filter.expr)
+		if len(filter.names) > 0 {
+			input.ExpressionAttributeNames = filter.names
+		}
+		maps.Copy(input.ExpressionAttributeValues, filter.values)
+	}
+	return input
+}
+
+// reads one page of the items of pk whose sort key starts with prefix and that
+// pass filter, along with the cursor of the next page. Since dynamodb applies
+// the limit before the filter, it keeps querying until the page is full
+func queryPage(db DynamoDBAPI, cursors *cursorCodec, tableName, pk, prefix string, filter *queryFilter, page PageRequest) ([]map[string]types.AttributeValue, string, error) {
+	return readPage(db, cursors, prefixQuery(tableName, pk, prefix, filter), pk, prefix, page)
+}
+
+// reads one page of the items of pk whose sort key starts with prefix and that
+// pass filter, from the highest sort key down
+func queryPageBackward(db DynamoDBAPI, cursors *cursorCodec, tableName, pk, prefix string, filter *queryFilter, page PageRequest) ([]map[string]types.AttributeValue, string, error) {
+	input := prefixQuery(tableName, pk, prefix, filter)
+	input.ScanIndexForward = aws.Bool(false)
+	return readPage(db, cursors, input, pk, prefix, page)
+}
+
+func readPage(db DynamoDBAPI, cursors *cursorCodec, input *dynamodb.QueryInput, pk, prefix string, page PageRequest) ([]map[string]types.AttributeValue, string, error) {
+	if page.Cursor != "" {
+		startKey, err := cursors.decode(page.Cursor, pk, prefix)
+		if err != nil {
+			return nil, "", err
+		}
+		input.ExclusiveStartKey = startKey
+	}
+
+	limit := page.limit()
+	var items []map[string]types.AttributeValue
+	for {
+		input.Limit = aws.Int32(limit - int32(len(items)))
+
+		output, err := db.Query(context.Background(), input)
+		if err != nil {
+			log.Printf("failed to query input\n %v", err)
+			return nil, "", err
+		}
+
+		items = append(items, output.Items...)
+		if output.LastEvaluatedKey == nil || int32(len(items)) >= limit {
+			next, err := cursors.encode(prefix, output.LastEvaluatedKey)
+			if err != nil {
+				return nil, "", err
+			}
+			return items, next, nil
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+}
+
+// reads every item of pk whose sort key starts with prefix and that passes
+// filter, following LastEvaluatedKey past the 1MB a single query returns
+func queryAll(db DynamoDBAPI, tableName, pk, prefix string, filter *queryFilter) ([]map[string]types.AttributeValue, error) {
+	input := prefixQuery(tableName, pk, prefix, filter)
+
+	var items []map[string]types.AttributeValue
+	for {
+		output, err := db.Query(context.Background(), input)
+		if err != nil {
+			log.Printf("failed to query input\n %v", err)
+			return nil, err
+		}
+
+		items = append(items, output.Items...)
+		if output.LastEvaluatedKey == nil {
+			return items, nil
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+}
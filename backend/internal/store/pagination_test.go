@@ -0,0 +1,105 @@
+package store
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestQueryPageFollowsCursors(t *testing.T) {
+	db := NewMemoryDB()
+	cursors := newCursorCodec()
+	var want []string
+	for i := range 130 {
+		sk := fmt.Sprintf("S#%03d", i)
+		flag := "odd"
+		if i%2 == 0 {
+			flag = "even"
+			want = append(want, sk)
+		}
+		putTestItems(t, db, testItem("P", sk, "flag", flag))
+	}
+	putTestItems(t, db, testItem("P", "T#000", "flag", "even"))
+
+	// dynamodb limits before it filters, so the pages are still full
+	filter := &queryFilter{expr: "flag = :flag", values: map[string]types.AttributeValue{":flag": str("even")}}
+	var got []string
+	var sizes []int
+	page := PageRequest{Limit: 30}
+	for {
+		items, next, err := queryPage(db, cursors, testTable, "P", "S#", filter, page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, sortKeys(items)...)
+		sizes = append(sizes, len(items))
+		if next == "" {
+			break
+		}
+		page.Cursor = next
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("read %v, want the 65 even items in order", got)
+	}
+	if !slices.Equal(sizes, []int{30, 30, 5}) {
+		t.Errorf("read pages of %v items, want 30, 30 and 5", sizes)
+	}
+
+	if items, _, err := queryPage(db, cursors, testTable, "P", "S#", nil, PageRequest{Limit: 1000}); err != nil || len(items) != MaxPageLimit {
+		t.Errorf("a limit of 1000 read %d items with %v, want %d", len(items), err, MaxPageLimit)
+	}
+}
+
+func TestCursorsAreSignedForOneListing(t *testing.T) {
+	cursors := newCursorCodec()
+	cursor, err := cursors.encode("S#", keyAttributes("P", "S#010"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, err := cursors.decode(cursor, "P", "S#"); err != nil || fmt.Sprint(sortKeys([]map[string]types.AttributeValue{key})) != "[S#010]" {
+		t.Fatalf("decoded %v with %v, want S#010", key, err)
+	}
+
+	payload, sig, _ := strings.Cut(cursor, ".")
+	enc := base64.RawURLEncoding
+	forged := enc.EncodeToString([]byte(`{"pk":"Q","prefix":"S#","sk":"S#010"}`)) + "." + sig
+	flipped := []byte(sig)
+	flipped[0] ^= 1
+
+	other := &cursorCodec{secret: []byte("another secret")}
+	fromOther, err := other.encode("S#", keyAttributes("P", "S#010"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, cursor, pk, prefix string
+	}{
+		{"another partition", cursor, "Q", "S#"},
+		{"another prefix", cursor, "P", "T#"},
+		{"a forged payload", forged, "Q", "S#"},
+		{"a changed signature", payload + "." + string(flipped), "P", "S#"},
+		{"another secret", fromOther, "P", "S#"},
+		{"no signature", payload, "P", "S#"},
+		{"not base64", "!!.!!", "P", "S#"},
+	}
+	for _, tt := range tests {
+		if _, err := cursors.decode(tt.cursor, tt.pk, tt.prefix); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("%s = %v, want ErrInvalidCursor", tt.name, err)
+		}
+	}
+
+	// the cursor of a sorted listing only continues that listing
+	sorted, err := cursors.encodePayload(cursorPayload{PartitionKey: "P", Prefix: "S#", SortKey: "S#010", Order: "deadline", After: "2024-05-01"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cursors.decode(sorted, "P", "S#"); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("sorted cursor on a key ordered listing = %v, want ErrInvalidCursor", err)
+	}
+}
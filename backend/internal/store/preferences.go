@@ -0,0 +1,102 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const preferencesKey = "PREFS"
+
+// Preferences are how a user wants to be notified. Digest is "off", "daily"
+// or "weekly", Muted lists the notification types they do not want at all,
+// and no email is sent between QuietStart and QuietEnd, "HH:MM" times in
+// Timezone, unless both are empty. TenantID is the tenant the digest is
+// about, set whenever the preferences are saved
+type Preferences struct {
+	UserID     string
+	TenantID   string
+	InApp      bool
+	Email      bool
+	Digest     string
+	Muted      []string
+	QuietStart string
+	QuietEnd   string
+	Timezone   string
+	UpdatedAt  string
+}
+
+// USER#<id>/PREFS
+type preferencesItem struct {
+	PartitionKey string   `dynamodbav:"PartitionKey"`
+	SortKey      string   `dynamodbav:"SortKey"`
+	TenantID     string   `dynamodbav:"tenantId,omitempty"`
+	InApp        bool     `dynamodbav:"inApp"`
+	Email        bool     `dynamodbav:"email"`
+	Digest       string   `dynamodbav:"digest"`
+	Muted        []string `dynamodbav:"muted,omitempty"`
+	QuietStart   string   `dynamodbav:"quietStart,omitempty"`
+	QuietEnd     string   `dynamodbav:"quietEnd,omitempty"`
+	Timezone     string   `dynamodbav:"timezone,omitempty"`
+	UpdatedAt    string   `dynamodbav:"updatedAt"`
+}
+
+func (s *notificationsStore) GetPreferences(userID string) (*Preferences, error) {
+	output, err := s.db.GetItem(context.Background(), &dynamodb.GetItemInput{
+		TableName: aws.String(s.tableName),
+		Key:       keyAttributes(UserKey(userID), preferencesKey),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if output.Item == nil {
+		return nil, ErrNotFound
+	}
+
+	var item preferencesItem
+	if err := attributevalue.UnmarshalMap(output.Item, &item); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal preferences: %w", err)
+	}
+	return &Preferences{
+		UserID:     strings.TrimPrefix(item.PartitionKey, userPrefix),
+		TenantID:   item.TenantID,
+		InApp:      item.InApp,
+		Email:      item.Email,
+		Digest:     item.Digest,
+		Muted:      item.Muted,
+		QuietStart: item.QuietStart,
+		QuietEnd:   item.QuietEnd,
+		Timezone:   item.Timezone,
+		UpdatedAt:  item.UpdatedAt,
+	}, nil
+}
+
+func (s *notificationsStore) PutPreferences(preferences Preferences) error {
+	item, err := attributevalue.MarshalMap(preferencesItem{
+		PartitionKey: UserKey(preferences.UserID),
+		SortKey:      preferencesKey,
+		TenantID:     preferences.TenantID,
+		InApp:        preferences.InApp,
+		Email:        preferences.Email,
+		Digest:       preferences.Digest,
+		Muted:        preferences.Muted,
+		QuietStart:   preferences.QuietStart,
+		QuietEnd:     preferences.QuietEnd,
+		Timezone:     preferences.Timezone,
+		UpdatedAt:    preferences.UpdatedAt,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal preferences: %w", err)
+	}
+
+	// the digest index follows the preferences in the same transaction
+	return transactWrite(s.db, []types.TransactWriteItem{
+		{Put: &types.Put{TableName: aws.String(s.tableName), Item: item}},
+		s.digestAction(preferences),
+	})
+}
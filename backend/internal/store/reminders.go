@@ -0,0 +1,224 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/Ghaby-X/tasork/internal/utils"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const reminderPrefix = "REMINDER#"
+
+// partition indexing the tasks of every tenant by deadline, only read by the
+// reminder worker
+const deadlinePartition = "DEADLINE"
+
+// DeadlineEntry points at a task with a deadline, Deadline in UTC
+type DeadlineEntry struct {
+	TenantID string
+	TaskID   string
+	Deadline string
+}
+
+// Reminder is a reminder of a task's deadline that was due At, sent once
+type Reminder struct {
+	TaskID   string
+	Deadline string
+	At       string
+	SentAt   string
+}
+
+// DEADLINE/<deadline>#<tenant>#TASK#<id> points at a task by its deadline, so
+// the tasks due soon are read with one range query
+type deadlineItem struct {
+	PartitionKey string `dynamodbav:"PartitionKey"`
+	SortKey      string `dynamodbav:"SortKey"`
+	TenantID     string `dynamodbav:"tenantId"`
+	TaskID       string `dynamodbav:"taskId"`
+	Deadline     string `dynamodbav:"deadline"`
+}
+
+type reminderItem struct {
+	PartitionKey string `dynamodbav:"PartitionKey"`
+	SortKey      string `dynamodbav:"SortKey"`
+	SentAt       string `dynamodbav:"sentAt"`
+}
+
+// the deadline of a task in the sortable form the index uses, false for
+// deadlines that are not dates, which are not indexed
+func indexedDeadline(deadline string) (string, bool) {
+	if deadline == "" {
+		return "", false
+	}
+	at, err := utils.ParseDateToISOString(deadline)
+	return at, err == nil
+}
+
+func deadlineKey(tenantID, taskID, deadline string) string {
+	return deadline + "#" + tenantID + "#" + TaskKey(taskID)
+}
+
+func newDeadlineItem(task Task) (deadlineItem, bool) {
+	deadline, ok := indexedDeadline(task.Deadline)
+	return deadlineItem{
+		PartitionKey: deadlinePartition,
+		SortKey:      deadlineKey(task.TenantID, task.TaskID, deadline),
+		TenantID:     task.TenantID,
+		TaskID:       strings.TrimPrefix(task.TaskID, taskPrefix),
+		Deadline:     deadline,
+	}, ok
+}
+
+// actions moving a task's entry in the deadline index from its current
+// deadline to deadline, either of which may be empty
+func (s *tasksStore) deadlineActions(task Task, deadline string) ([]types.TransactWriteItem, error) {
+	moved := task
+	moved.Deadline = deadline
+	from, hadEntry := newDeadlineItem(task)
+	to, hasEntry := newDeadlineItem(moved)
+	if hadEntry && hasEntry && from.SortKey == to.SortKey {
+		return nil, nil
+	}
+
+	var actions []types.TransactWriteItem
+	if hadEntry {
+		actions = append(actions, s.deleteAction(from.PartitionKey, from.SortKey))
+	}
+	if hasEntry {
+		action, err := s.putAction(to)
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, action)
+	}
+	return actions, nil
+}
+
+func (s *tasksStore) ListDeadlinesBefore(before string) ([]DeadlineEntry, error) {
+	// an entry whose deadline equals before sorts after it, as its key goes on past the time
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(s.tableName),
+		KeyConditionExpression: aws.String("PartitionKey = :pkey AND SortKey < :before"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pkey":   &types.AttributeValueMemberS{Value: deadlinePartition},
+			":before": &types.AttributeValueMemberS{Value: before},
+		},
+	}
+
+	var entries []DeadlineEntry
+	for {
+		output, err := s.db.Query(context.Background(), input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query deadlines: %w", err)
+		}
+
+		var items []deadlineItem
+		if err := attributevalue.UnmarshalListOfMaps(output.Items, &items); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal deadlines: %w", err)
+		}
+		for _, item := range items {
+			entries = append(entries, DeadlineEntry{TenantID: item.TenantID, TaskID: item.TaskID, Deadline: item.Deadline})
+		}
+
+		if output.LastEvaluatedKey == nil {
+			return entries, nil
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+}
+
+func (s *tasksStore) DropDeadline(entry DeadlineEntry) error {
+	_, err := s.db.DeleteItem(context.Background(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.tableName),
+		Key:       keyAttributes(deadlinePartition, deadlineKey(entry.TenantID, entry.TaskID, entry.Deadline)),
+	})
+	return err
+}
+
+func (s *tasksStore) BackfillDeadlines() (int, error) {
+	// tenant rows only, the copies under each assignee have the same deadline
+	input := &dynamodb.ScanInput{
+		TableName:        aws.String(s.tableName),
+		FilterExpression: aws.String("begins_with(PartitionKey, :tenant) AND begins_with(SortKey, :task) AND #deadline <> :nodeadline"),
+		ExpressionAttributeNames: map[string]string{
+			"#deadline": "deadline",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":tenant":     &types.AttributeValueMemberS{Value: tenantPrefix},
+			":task":       &types.AttributeValueMemberS{Value: taskPrefix},
+			":nodeadline": &types.AttributeValueMemberS{Value: ""},
+		},
+	}
+
+	added := 0
+	for {
+		output, err := s.db.Scan(context.Background(), input)
+		if err != nil {
+			return added, fmt.Errorf("failed to scan tasks: %w", err)
+		}
+
+		var items []taskItem
+		if err := attributevalue.UnmarshalListOfMaps(output.Items, &items); err != nil {
+			return added, fmt.Errorf("failed to unmarshal tasks: %w", err)
+		}
+		for _, item := range items {
+			entry, ok := newDeadlineItem(Task{TenantID: item.PartitionKey, TaskID: strings.TrimPrefix(item.SortKey, taskPrefix), Deadline: item.Deadline})
+			if !ok {
+				continue
+			}
+			av, err := attributevalue.MarshalMap(entry)
+			if err != nil {
+				return added, err
+			}
+			_, err = s.db.PutItem(context.Background(), &dynamodb.PutItemInput{
+				TableName:           aws.String(s.tableName),
+				Item:                av,
+				ConditionExpression: aws.String("attribute_not_exists(PartitionKey)"),
+			})
+			if isConditionFailure(err) {
+				continue
+			}
+			if err != nil {
+				return added, fmt.Errorf("failed to index deadline of task %s: %w", entry.TaskID, err)
+			}
+			added++
+		}
+
+		if output.LastEvaluatedKey == nil {
+			return added, nil
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+}
+
+func (s *tasksStore) PutReminder(reminder Reminder) error {
+	item, err := attributevalue.MarshalMap(reminderItem{
+		PartitionKey: TaskKey(reminder.TaskID),
+		SortKey:      reminderPrefix + reminder.Deadline + "#" + reminder.At,
+		SentAt:       reminder.SentAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.PutItem(context.Background(), &dynamodb.PutItemInput{
+		TableName:           aws.String(s.tableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(PartitionKey)"),
+	})
+	if isConditionFailure(err) {
+		return ErrAlreadyExists
+	}
+	if err != nil {
+		log.Printf("failed to put reminder, %v", err)
+		return errors.New("could not write reminder")
+	}
+	return nil
+}
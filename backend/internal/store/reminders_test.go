@@ -0,0 +1,50 @@
+package store
+
+import "testing"
+
+func TestBackfillDeadlines(t *testing.T) {
+	db := NewMemoryDB()
+	tasks := newTasksStore(db, newCursorCodec())
+
+	// rows as they were saved before the deadline index was kept
+	putTestItems(t, db,
+		testItem("TENANT#a", "TASK#1", "deadline", "2030-01-02T09:00:00+02:00"),
+		testItem("TENANT#a", "TASK#2", "deadline", "2030-01-01"),
+		testItem("TENANT#a", "TASK#3", "deadline", ""),
+		testItem("TENANT#a", "TASK#4", "deadline", "soon"),
+		testItem("TENANT#b", "TASK#5", "deadline", "2030-01-03T00:00:00Z"),
+		// the copy of task 1 under its assignee is not indexed twice
+		testItem("USER#u", "TASK#1", "deadline", "2030-01-02T09:00:00+02:00", "tenantId", "TENANT#a"),
+	)
+
+	added, err := tasks.BackfillDeadlines()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 3 {
+		t.Errorf("indexed %d deadlines, want 3", added)
+	}
+
+	entries, err := tasks.ListDeadlinesBefore("2031")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []DeadlineEntry{
+		{TenantID: "TENANT#a", TaskID: "2", Deadline: "2030-01-01T00:00:00Z"},
+		{TenantID: "TENANT#a", TaskID: "1", Deadline: "2030-01-02T07:00:00Z"},
+		{TenantID: "TENANT#b", TaskID: "5", Deadline: "2030-01-03T00:00:00Z"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("indexed %v, want %v", entries, want)
+	}
+	for i := range want {
+		if entries[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, entries[i], want[i])
+		}
+	}
+
+	// running it again adds nothing
+	if added, err := tasks.BackfillDeadlines(); err != nil || added != 0 {
+		t.Errorf("second backfill indexed %d, %v, want 0", added, err)
+	}
+}
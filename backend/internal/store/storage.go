@@ -1,17 +1,57 @@
 package store
 
-import "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// DynamoDBAPI is the subset of the dynamodb client used by the stores,
+// satisfied by both *dynamodb.Client and MemoryDB
+type DynamoDBAPI interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+	BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error)
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	// only for one-off jobs like backfills, the api never scans the table
+	Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+	BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error)
+	TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error)
+}
 
 type Storage struct {
-	Tasks *TasksStore
-	Users *UsersStore
-	Auth  *AuthStore
+	Tasks         TasksStore
+	Users         UsersStore
+	Auth          AuthStore
+	Workflows     WorkflowStore
+	Comments      CommentsStore
+	Attachments   AttachmentsStore
+	Templates     TemplatesStore
+	Notifications NotificationsStore
+	Blobs         BlobStore
 }
 
-func NewStorage(db *dynamodb.Client) *Storage {
+func NewStorage(db DynamoDBAPI, blobs BlobStore) *Storage {
+	cursors := newCursorCodec()
 	return &Storage{
-		Tasks: &TasksStore{db},
-		Users: &UsersStore{db},
-		Auth:  &AuthStore{db},
+		Tasks:         newTasksStore(db, cursors),
+		Users:         newUsersStore(db, cursors),
+		Auth:          &authStore{db},
+		Workflows:     newWorkflowStore(db),
+		Comments:      newCommentsStore(db, cursors),
+		Attachments:   newAttachmentsStore(db, cursors),
+		Templates:     newTemplatesStore(db, cursors),
+		Notifications: newNotificationsStore(db, cursors),
+		Blobs:         blobs,
 	}
 }
+
+// storage backed by an in-memory table and blobs under the temporary
+// directory, for tests and offline development
+func NewMemoryStorage() *Storage {
+	return NewStorage(NewMemoryDB(), NewLocalBlobStore(filepath.Join(os.TempDir(), "tasork-blobs")))
+}
@@ -0,0 +1,145 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// links a subtask under its parent, TASK#<parent>/SUBTASK#<child>, so the
+// subtasks of a task are read with one query
+type subtaskItem struct {
+	PartitionKey string `dynamodbav:"PartitionKey"`
+	SortKey      string `dynamodbav:"SortKey"`
+	TenantID     string `dynamodbav:"tenantId"`
+}
+
+func subtaskKey(taskID string) string {
+	return subtaskPrefix + strings.TrimPrefix(taskID, taskPrefix)
+}
+
+func newSubtaskItem(task Task) subtaskItem {
+	return subtaskItem{
+		PartitionKey: TaskKey(task.ParentID),
+		SortKey:      subtaskKey(task.TaskID),
+		TenantID:     task.TenantID,
+	}
+}
+
+// checks the parent of a new subtask exists under the same tenant
+func (s *tasksStore) parentCheck(task Task) types.TransactWriteItem {
+	return types.TransactWriteItem{ConditionCheck: &types.ConditionCheck{
+		TableName:           aws.String(s.tableName),
+		Key:                 keyAttributes(task.TenantID, TaskKey(task.ParentID)),
+		ConditionExpression: aws.String("attribute_exists(PartitionKey)"),
+	}}
+}
+
+// actions that turn the subtasks of a deleted task into tasks of their own,
+// dropping their links and the parent id on their tenant rows
+func (s *tasksStore) detachSubtasks(tenantID, taskID string) ([]types.TransactWriteItem, error) {
+	var links []subtaskItem
+	if err := s.queryPrefix(TaskKey(taskID), subtaskPrefix, &links); err != nil {
+		return nil, fmt.Errorf("failed to query subtasks: %w", err)
+	}
+
+	var actions []types.TransactWriteItem
+	for _, link := range links {
+		actions = append(actions, s.deleteAction(link.PartitionKey, link.SortKey))
+		if link.TenantID != tenantID {
+			continue
+		}
+		actions = append(actions, types.TransactWriteItem{Update: &types.Update{
+			TableName:                aws.String(s.tableName),
+			Key:                      keyAttributes(tenantID, TaskKey(strings.TrimPrefix(link.SortKey, subtaskPrefix))),
+			UpdateExpression:         aws.String("REMOVE #parentId"),
+			ConditionExpression:      aws.String("attribute_exists(PartitionKey)"),
+			ExpressionAttributeNames: map[string]string{"#parentId": "parentId"},
+		}})
+	}
+	return actions, nil
+}
+
+func (s *tasksStore) UpdateChecklist(task Task, checklist []ChecklistItem, history *HistoryEntry) error {
+	av, err := attributevalue.Marshal(checklist)
+	if err != nil {
+		return fmt.Errorf("failed to marshal checklist: %w", err)
+	}
+
+	actions := []types.TransactWriteItem{s.versionedUpdate(task, "SET #checklist = :checklist",
+		map[string]string{"#checklist": "checklist"},
+		map[string]types.AttributeValue{":checklist": av},
+	)}
+	if history != nil {
+		action, err := s.putAction(newHistoryItem(*history))
+		if err != nil {
+			return err
+		}
+		actions = append(actions, action)
+	}
+
+	err = transactWrite(s.db, actions)
+	if isConditionFailure(err) {
+		return s.staleOrMissing(task)
+	}
+	if err != nil {
+		log.Printf("failed to update checklist, %v", err)
+		return errors.New("could not update checklist")
+	}
+	return nil
+}
+
+// reads the tenant rows the subtask links of a task point at, in link order.
+// Links of another tenant, or to tasks that are gone, are skipped
+func (s *tasksStore) ListSubtasks(tenantID, taskID string) ([]Task, error) {
+	var links []subtaskItem
+	if err := s.queryPrefix(TaskKey(taskID), subtaskPrefix, &links); err != nil {
+		return nil, fmt.Errorf("failed to query subtasks: %w", err)
+	}
+
+	ids := make([]string, 0, len(links))
+	for _, link := range links {
+		if link.TenantID == tenantID {
+			ids = append(ids, strings.TrimPrefix(link.SortKey, subtaskPrefix))
+		}
+	}
+	return s.GetTasks(tenantID, ids)
+}
+
+func (s *tasksStore) GetTasks(tenantID string, taskIDs []string) ([]Task, error) {
+	keys := make([]map[string]types.AttributeValue, 0, len(taskIDs))
+	seen := map[string]bool{}
+	for _, taskID := range taskIDs {
+		if !seen[TaskKey(taskID)] {
+			seen[TaskKey(taskID)] = true
+			keys = append(keys, keyAttributes(tenantID, TaskKey(taskID)))
+		}
+	}
+	rows, err := batchGet(s.db, s.tableName, keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tasks: %w", err)
+	}
+
+	var items []taskItem
+	if err := attributevalue.UnmarshalListOfMaps(rows, &items); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal tasks: %w", err)
+	}
+	byKey := map[string]taskItem{}
+	for _, item := range items {
+		byKey[item.SortKey] = item
+	}
+
+	var found []taskItem
+	for _, taskID := range taskIDs {
+		if item, ok := byKey[TaskKey(taskID)]; ok {
+			found = append(found, item)
+			delete(byKey, TaskKey(taskID))
+		}
+	}
+	return s.tasksFromItems(tenantID, found)
+}
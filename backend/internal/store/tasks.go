@@ -1,77 +1,1198 @@
 package store
 
 import (
+	"cmp"
 	"context"
 	"errors"
+	"fmt"
 	"log"
+	"maps"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Ghaby-X/tasork/internal/env"
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
 )
 
-type TasksStore struct {
-	db *dynamodb.Client
+// key prefixes of the single table, only ever built through the helpers below
+const (
+	taskPrefix         = "TASK#"
+	userPrefix         = "USER#"
+	historyPrefix      = "HISTORY#"
+	notificationPrefix = "NOTIFICATION#"
+	commentPrefix      = "COMMENT#"
+	subtaskPrefix      = "SUBTASK#"
+	blockedByPrefix    = "BLOCKEDBY#"
+	blocksPrefix       = "BLOCKS#"
+	usernamePrefix     = "USERNAME#"
+	// <tenant>/DEPENDENCIES counts the dependencies added to a tenant's tasks
+	dependenciesKey = "DEPENDENCIES"
+	// tenant ids carry it already, so it is only used to tell tenant partitions apart
+	tenantPrefix = "TENANT#"
+)
+
+var (
+	// returned when a requested item does not exist
+	ErrNotFound = errors.New("item not found")
+	// returned when creating an item that is already there
+	ErrAlreadyExists = errors.New("item already exists")
+	// returned when a task changed since the version a write was based on
+	ErrVersionConflict = errors.New("item was modified")
+	// returned when a dependency was added to a tenant since its dependencies
+	// were checked for cycles
+	ErrDependenciesChanged = errors.New("dependencies were modified")
+)
+
+// TaskKey returns the key of a task, accepting either a bare or prefixed id
+func TaskKey(taskID string) string {
+	return taskPrefix + strings.TrimPrefix(taskID, taskPrefix)
+}
+
+// UserKey returns the key of a user, accepting either a bare or prefixed id
+func UserKey(userID string) string {
+	return userPrefix + strings.TrimPrefix(userID, userPrefix)
 }
 
+// Task is a task as stored under its tenant, ids are kept without prefixes.
+// Version counts the writes to the task, tasks saved before versioning read as 0.
+// Assignees is filled on reads and ignored on writes, which take them separately.
+// ParentID is the task this one is a subtask of, set only when it is created.
+// BlockedBy lists the ids of the tasks it depends on, written by AddDependency
+// and RemoveDependency only
 type Task struct {
-	ID          int64  `json:"id"`
-	Title       string `json:"title"`
-	Description string `json:"description"`
-	CreatedAt   string `json:"created_at"`
-	UpdatedAt   string `json:"updated_at"`
+	TenantID        string
+	TaskID          string
+	ParentID        string
+	Title           string
+	Description     string
+	Status          string
+	Deadline        string
+	CreatedAt       string
+	CreatedBy       string
+	Version         int64
+	Assignees       []Assignee
+	Checklist       []ChecklistItem
+	BlockOnSubtasks bool
+	BlockedBy       []string
+}
+
+// ChecklistItem is one step of a task's checklist, kept in order on the task
+type ChecklistItem struct {
+	ID     string `dynamodbav:"id"`
+	Text   string `dynamodbav:"text"`
+	Done   bool   `dynamodbav:"done"`
+	DoneBy string `dynamodbav:"doneBy,omitempty"`
+	DoneAt string `dynamodbav:"doneAt,omitempty"`
+}
+
+type Assignee struct {
+	UserID   string
+	Username string
+	Email    string
+}
+
+// actions recorded in a task's history
+const (
+	HistoryCreated       = "created"
+	HistoryStatusChanged = "status_changed"
+	HistoryReassigned    = "reassigned"
+	HistoryEdited        = "edited"
+	HistoryCommented     = "commented"
+	HistoryAttached      = "attached"
+)
+
+// the layout of the time at the start of time ordered ids, fixed width so ids sort by time
+const timeIDLayout = "20060102T150405.000000000Z"
+
+// HistoryEntry is one change to a task. Status is the task status after the
+// change and Changes the fields it touched, entries written before actions
+// were recorded have neither an Action nor Changes
+type HistoryEntry struct {
+	TaskID            string
+	HistoryID         string
+	Action            string
+	Status            string
+	UpdatedBy         string
+	UpdatedAt         string
+	UpdateDescription string
+	Changes           []FieldChange
+}
+
+// FieldChange is the value of a task field before and after a change, an
+// empty Before is a value that was set and an empty After one that was cleared
+type FieldChange struct {
+	Field  string `dynamodbav:"field"`
+	Before string `dynamodbav:"before"`
+	After  string `dynamodbav:"after"`
+}
+
+// NewHistoryID returns a history id that sorts after the ids made before at.
+// Ids written before this were random and sort among the others arbitrarily
+func NewHistoryID(at time.Time) string {
+	return timeOrderedID(at)
 }
 
-func (s *TasksStore) Create(ctx context.Context, task *Task) (*dynamodb.PutItemOutput, error) {
-	tableName := env.GetString("DYNAMODB_TABLE_NAME", "tasork")
-	item := map[string]types.AttributeValue{
-		"PartitionKey": &types.AttributeValueMemberS{Value: "Task1"},
-		"SortKey":      &types.AttributeValueMemberS{Value: "taskSort"},
-		"taskId":       &types.AttributeValueMemberN{Value: "123"},
-		"taskTitle":    &types.AttributeValueMemberS{Value: "Get your hair done"},
-		"age":          &types.AttributeValueMemberN{Value: "30"},
+func timeOrderedID(at time.Time) string {
+	return at.UTC().Format(timeIDLayout) + "-" + uuid.NewString()
+}
+
+// TaskWrite is everything saved together, atomically, when a task is written
+type TaskWrite struct {
+	Task      Task
+	Assignees []Assignee
+	History   *HistoryEntry
+}
+
+// TaskChanges holds the task fields to change, nil fields are left untouched
+type TaskChanges struct {
+	Title           *string
+	Description     *string
+	Status          *string
+	Deadline        *string
+	BlockOnSubtasks *bool // tenant row only
+}
+
+// attributes a task listing can be sorted on
+const (
+	SortByDeadline  = "deadline"
+	SortByCreatedAt = "createdAt"
+	SortByStatus    = "status"
+)
+
+// TaskQuery narrows and orders a task listing. Empty fields match every task
+// and an empty SortBy keeps key order. Deadlines compare as strings, so the
+// bounds should be in the same ISO 8601 form the deadlines are saved in
+type TaskQuery struct {
+	Status         string
+	AssigneeID     string
+	CreatedBy      string
+	DeadlineAfter  string // inclusive
+	DeadlineBefore string // exclusive
+	TitleContains  string // case sensitive
+	SortBy         string
+	Descending     bool
+}
+
+// builds the FilterExpression for everything but the assignee, which picks
+// the partition instead. Mirror rows are scoped to the tenant by their
+// tenantId, so mirrors written before they carried one never match
+func (q TaskQuery) filter(tenantID string) *queryFilter {
+	var conds []string
+	names := map[string]string{}
+	values := map[string]types.AttributeValue{}
+
+	add := func(cond, attr, placeholder, value string) {
+		conds = append(conds, cond)
+		names["#"+attr] = attr
+		values[placeholder] = &types.AttributeValueMemberS{Value: value}
 	}
 
-	input := &dynamodb.PutItemInput{
-		TableName: aws.String(tableName),
-		Item:      item,
+	if q.AssigneeID != "" {
+		add("#tenantId = :tenantid", "tenantId", ":tenantid", tenantID)
+	}
+	if q.Status != "" {
+		add("#status = :status", "status", ":status", q.Status)
+	}
+	if q.CreatedBy != "" {
+		add("#createdby = :createdby", "createdby", ":createdby", UserKey(q.CreatedBy))
+	}
+	if q.DeadlineAfter != "" {
+		add("#deadline >= :deadlineafter", "deadline", ":deadlineafter", q.DeadlineAfter)
+	}
+	if q.DeadlineBefore != "" {
+		add("#deadline < :deadlinebefore AND #deadline <> :nodeadline", "deadline", ":deadlinebefore", q.DeadlineBefore)
+		values[":nodeadline"] = &types.AttributeValueMemberS{Value: ""}
+	}
+	if q.TitleContains != "" {
+		add("contains(#tasktitle, :title)", "tasktitle", ":title", q.TitleContains)
+	}
+
+	if len(conds) == 0 {
+		return nil
+	}
+	return &queryFilter{strings.Join(conds, " AND "), names, values}
+}
+
+// identifies the order of a sorted listing inside its cursors
+func (q TaskQuery) order() string {
+	if q.Descending {
+		return q.SortBy + ":desc"
+	}
+	return q.SortBy + ":asc"
+}
+
+// orders two rows on the sort attribute then the sort key, tasks without a
+// value for the attribute go last whichever the direction
+func (q TaskQuery) compare(aValue, aKey, bValue, bKey string) int {
+	if (aValue == "") != (bValue == "") {
+		if aValue == "" {
+			return 1
+		}
+		return -1
+	}
+
+	c := cmp.Compare(aValue, bValue)
+	if c == 0 {
+		c = cmp.Compare(aKey, bKey)
+	}
+	if q.Descending {
+		return -c
 	}
+	return c
+}
+
+type TasksStore interface {
+	PutTask(write TaskWrite) error
+	UpdateTask(task Task, changes TaskChanges, history *HistoryEntry) error
+	GetTask(tenantID, taskID string) (*Task, error)
+	AddAssignee(task Task, assignee Assignee, history HistoryEntry) error
+	RemoveAssignee(task Task, userID string, history HistoryEntry) error
+	// applies field changes and sets the assignees of a task in one
+	// transaction guarded by task.Version, writing every history entry
+	ReplaceTask(task Task, changes TaskChanges, assignees []Assignee, history []HistoryEntry) error
+	DeleteTask(tenantID, taskID string) error
+	ListTasksByTenant(tenantID string, query TaskQuery, page PageRequest) ([]Task, string, error)
+	ListTasksByAssignee(userID string, page PageRequest) ([]Task, string, error)
+	ListAssignees(taskID string) ([]Assignee, error)
+	// replaces the checklist of a task, guarded by task.Version
+	UpdateChecklist(task Task, checklist []ChecklistItem, history *HistoryEntry) error
+	// lists the tasks created as subtasks of a task, of the same tenant
+	ListSubtasks(tenantID, taskID string) ([]Task, error)
+	// reads tasks of a tenant by id, in the order given, leaving out missing ones
+	GetTasks(tenantID string, taskIDs []string) ([]Task, error)
+	// counts the dependencies ever added to the tasks of a tenant, to be read
+	// before checking a new one for cycles
+	DependenciesVersion(tenantID string) (int64, error)
+	// ErrAlreadyExists when the dependency is already there, and
+	// ErrDependenciesChanged when the tenant is no longer at dependenciesVersion
+	AddDependency(task Task, blocker Task, dependency Dependency, dependenciesVersion int64, history HistoryEntry) error
+	// ErrNotFound when the task is not blocked by blockerID
+	RemoveDependency(task Task, blockerID string, history HistoryEntry) error
+	// lists the ids of the tasks blocked by a task
+	ListDependents(taskID string) ([]string, error)
+	// lists the tasks of every tenant with a deadline before the given time,
+	// soonest first
+	ListDeadlinesBefore(before string) ([]DeadlineEntry, error)
+	// takes a task off the deadline index, for one that needs no more reminders
+	DropDeadline(entry DeadlineEntry) error
+	// indexes the deadlines of tasks saved before the deadline index was kept,
+	// scanning the whole table, and returns how many it added
+	BackfillDeadlines() (int, error)
+	// records a reminder before it is sent, ErrAlreadyExists when it was
+	// recorded before
+	PutReminder(reminder Reminder) error
+	AppendHistory(entry HistoryEntry) error
+	// lists one page of a task's history, newest first
+	ListHistory(taskID string, page PageRequest) ([]HistoryEntry, string, error)
+}
+
+// item layouts, attribute names match what is already in the table
+type taskItem struct {
+	PartitionKey string `dynamodbav:"PartitionKey"`
+	SortKey      string `dynamodbav:"SortKey"`
+	TenantID     string `dynamodbav:"tenantId,omitempty"`
+	Title        string `dynamodbav:"tasktitle"`
+	Description  string `dynamodbav:"description"`
+	Status       string `dynamodbav:"status"`
+	Deadline     string `dynamodbav:"deadline"`
+	CreatedAt    string `dynamodbav:"createdAt"`
+	CreatedBy    string `dynamodbav:"createdby"`
+	UserName     string `dynamodbav:"userName,omitempty"`
+	Email        string `dynamodbav:"email,omitempty"`
+	Version      int64  `dynamodbav:"version,omitempty"` // tenant row only
+
+	// tenant row only
+	ParentID        string          `dynamodbav:"parentId,omitempty"`
+	Checklist       []ChecklistItem `dynamodbav:"checklist,omitempty"`
+	BlockOnSubtasks bool            `dynamodbav:"blockOnSubtasks,omitempty"`
+	BlockedBy       []string        `dynamodbav:"blockedBy,omitempty"`
+
+	// tenant row only, so listings need no query per task. nil on rows written
+	// before it was kept, whose assignees are read from the assignment rows
+	Assignees *[]assigneeSummary `dynamodbav:"assignees,omitempty"`
+}
+
+type assigneeSummary struct {
+	UserID   string `dynamodbav:"userId"`
+	Username string `dynamodbav:"userName"`
+	Email    string `dynamodbav:"email"`
+}
 
-	result, err := s.db.PutItem(ctx, input)
+func newAssigneeSummaries(assignees []Assignee) *[]assigneeSummary {
+	summaries := make([]assigneeSummary, 0, len(assignees))
+	for _, assignee := range assignees {
+		summaries = append(summaries, assigneeSummary{strings.TrimPrefix(assignee.UserID, userPrefix), assignee.Username, assignee.Email})
+	}
+	return &summaries
+}
+
+type historyItem struct {
+	PartitionKey      string        `dynamodbav:"PartitionKey"`
+	SortKey           string        `dynamodbav:"SortKey"`
+	Action            string        `dynamodbav:"action,omitempty"`
+	Status            string        `dynamodbav:"status"`
+	UpdatedBy         string        `dynamodbav:"updatedby"`
+	UpdatedAt         string        `dynamodbav:"updatedAt"`
+	UpdateDescription string        `dynamodbav:"updateDescription"`
+	Changes           []FieldChange `dynamodbav:"changes,omitempty"`
+}
+
+func newTaskItem(pk, sk string, task Task) taskItem {
+	return taskItem{
+		PartitionKey: pk,
+		SortKey:      sk,
+		Title:        task.Title,
+		Description:  task.Description,
+		Status:       task.Status,
+		Deadline:     task.Deadline,
+		CreatedAt:    task.CreatedAt,
+		CreatedBy:    UserKey(task.CreatedBy),
+	}
+}
+
+func (i taskItem) sortValue(field string) string {
+	switch field {
+	case SortByDeadline:
+		return i.Deadline
+	case SortByCreatedAt:
+		return i.CreatedAt
+	case SortByStatus:
+		return i.Status
+	}
+	return ""
+}
+
+func (i taskItem) task() Task {
+	var assignees []Assignee
+	if i.Assignees != nil {
+		assignees = make([]Assignee, 0, len(*i.Assignees))
+		for _, summary := range *i.Assignees {
+			assignees = append(assignees, Assignee{summary.UserID, summary.Username, summary.Email})
+		}
+	}
+
+	return Task{
+		TenantID:        i.TenantID,
+		TaskID:          strings.TrimPrefix(i.SortKey, taskPrefix),
+		ParentID:        strings.TrimPrefix(i.ParentID, taskPrefix),
+		Title:           i.Title,
+		Description:     i.Description,
+		Status:          i.Status,
+		Deadline:        i.Deadline,
+		CreatedAt:       i.CreatedAt,
+		CreatedBy:       strings.TrimPrefix(i.CreatedBy, userPrefix),
+		Version:         i.Version,
+		Assignees:       assignees,
+		Checklist:       i.Checklist,
+		BlockOnSubtasks: i.BlockOnSubtasks,
+		BlockedBy:       i.BlockedBy,
+	}
+}
+
+// turns tenant rows into tasks, reading the assignees of rows that predate
+// the assignee summary from their assignment rows
+func (s *tasksStore) tasksFromItems(tenantID string, items []taskItem) ([]Task, error) {
+	tasks := make([]Task, 0, len(items))
+	for _, item := range items {
+		task := item.task()
+		task.TenantID = tenantID
+
+		if item.Assignees == nil {
+			assignees, err := s.ListAssignees(task.TaskID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get users for task %s: %w", task.TaskID, err)
+			}
+			task.Assignees = assignees
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+type tasksStore struct {
+	db        DynamoDBAPI
+	tableName string
+	cursors   *cursorCodec
+}
+
+func newTasksStore(db DynamoDBAPI, cursors *cursorCodec) *tasksStore {
+	return &tasksStore{db, env.GetString("DYNAMODB_TABLE_NAME", "tasork"), cursors}
+}
+
+func (s *tasksStore) putAction(item any) (types.TransactWriteItem, error) {
+	return putAction(s.tableName, item)
+}
+
+func putAction(tableName string, item any) (types.TransactWriteItem, error) {
+	av, err := attributevalue.MarshalMap(item)
 	if err != nil {
-		return nil, err
+		return types.TransactWriteItem{}, fmt.Errorf("failed to marshal item: %w", err)
 	}
+	return types.TransactWriteItem{Put: &types.Put{TableName: aws.String(tableName), Item: av}}, nil
+}
 
-	return result, nil
+func (s *tasksStore) deleteAction(pk, sk string) types.TransactWriteItem {
+	return types.TransactWriteItem{Delete: &types.Delete{TableName: aws.String(s.tableName), Key: keyAttributes(pk, sk)}}
 }
 
-func (s *TasksStore) BatchWriteData(item *dynamodb.BatchWriteItemInput) (*dynamodb.BatchWriteItemOutput, error) {
-	batchWriteOutput, err := s.db.BatchWriteItem(context.Background(), item)
+func newHistoryItem(entry HistoryEntry) historyItem {
+	return historyItem{
+		PartitionKey:      TaskKey(entry.TaskID),
+		SortKey:           historyPrefix + entry.HistoryID,
+		Action:            entry.Action,
+		Status:            entry.Status,
+		UpdatedBy:         UserKey(entry.UpdatedBy),
+		UpdatedAt:         entry.UpdatedAt,
+		UpdateDescription: entry.UpdateDescription,
+		Changes:           entry.Changes,
+	}
+}
+
+// writes the tenant task row, both assignment rows per assignee, notifications
+// and history in one transaction. A subtask is also linked under its parent,
+// which must still exist
+func (s *tasksStore) PutTask(write TaskWrite) error {
+	task := write.Task
+	taskKey := TaskKey(task.TaskID)
+
+	var items []any
+	if task.ParentID != "" {
+		items = append(items, newSubtaskItem(task))
+	}
+	for _, assignee := range write.Assignees {
+		userKey := UserKey(assignee.UserID)
+
+		// TASK#/USER# lists who is on a task, USER#/TASK# mirrors it per user
+		assignment := newTaskItem(taskKey, userKey, task)
+		assignment.TenantID = task.TenantID
+		assignment.UserName = assignee.Username
+		assignment.Email = assignee.Email
+
+		mirror := assignment
+		mirror.PartitionKey, mirror.SortKey = userKey, taskKey
+
+		items = append(items, assignment, mirror)
+	}
+
+	if write.History != nil {
+		items = append(items, newHistoryItem(*write.History))
+	}
+	if entry, ok := newDeadlineItem(task); ok {
+		items = append(items, entry)
+	}
+
+	// the tenant row goes last so a chunked write only shows the task once complete
+	tenantItem := newTaskItem(task.TenantID, taskKey, task)
+	tenantItem.TenantID = task.TenantID
+	tenantItem.Version = 1
+	tenantItem.Assignees = newAssigneeSummaries(write.Assignees)
+	tenantItem.Checklist = task.Checklist
+	tenantItem.BlockOnSubtasks = task.BlockOnSubtasks
+	if task.ParentID != "" {
+		tenantItem.ParentID = TaskKey(task.ParentID)
+	}
+	items = append(items, tenantItem)
+
+	var actions []types.TransactWriteItem
+	if task.ParentID != "" {
+		actions = append(actions, s.parentCheck(task))
+	}
+	for _, item := range items {
+		action, err := s.putAction(item)
+		if err != nil {
+			return err
+		}
+		actions = append(actions, action)
+	}
+	actions[len(actions)-1].Put.ConditionExpression = aws.String("attribute_not_exists(PartitionKey)")
+
+	err := transactWrite(s.db, actions)
+	if task.ParentID != "" && conditionFailedAt(err, 0) {
+		return ErrNotFound
+	}
+	if isConditionFailure(err) {
+		return ErrAlreadyExists
+	}
 	if err != nil {
 		log.Printf("failed to write items, %v", err)
-		return nil, errors.New("could not create task")
+		return errors.New("could not write task")
+	}
+	return nil
+}
+
+// condition that the tenant row exists and is still at the given version
+func versionCondition(version int64) (string, map[string]string, map[string]types.AttributeValue) {
+	names := map[string]string{"#version": "version"}
+	if version == 0 {
+		return "attribute_exists(PartitionKey) AND attribute_not_exists(#version)", names, nil
+	}
+	return "attribute_exists(PartitionKey) AND #version = :expectedversion", names, map[string]types.AttributeValue{
+		":expectedversion": &types.AttributeValueMemberN{Value: strconv.FormatInt(version, 10)},
+	}
+}
+
+// tenant row update applying expr, bumping the version and guarded by the
+// version the write was based on. An empty expr only bumps the version
+func (s *tasksStore) versionedUpdate(task Task, expr string, names map[string]string, values map[string]types.AttributeValue) types.TransactWriteItem {
+	cond, condNames, condValues := versionCondition(task.Version)
+
+	bump := "#version = if_not_exists(#version, :zeroversion) + :oneversion"
+	if expr == "" {
+		expr = "SET " + bump
+	} else {
+		expr += ", " + bump
+	}
+
+	allNames := map[string]string{}
+	maps.Copy(allNames, names)
+	maps.Copy(allNames, condNames)
+	allValues := map[string]types.AttributeValue{
+		":zeroversion": &types.AttributeValueMemberN{Value: "0"},
+		":oneversion":  &types.AttributeValueMemberN{Value: "1"},
+	}
+	maps.Copy(allValues, values)
+	maps.Copy(allValues, condValues)
+
+	return types.TransactWriteItem{Update: &types.Update{
+		TableName:                 aws.String(s.tableName),
+		Key:                       keyAttributes(task.TenantID, TaskKey(task.TaskID)),
+		UpdateExpression:          aws.String(expr),
+		ConditionExpression:       aws.String(cond),
+		ExpressionAttributeNames:  allNames,
+		ExpressionAttributeValues: allValues,
+	}}
+}
+
+// tells apart a missing task from one that moved past task.Version after a
+// versioned write failed its condition
+func (s *tasksStore) staleOrMissing(task Task) error {
+	current, err := s.GetTask(task.TenantID, task.TaskID)
+	if err != nil {
+		return err
+	}
+	if current.Version != task.Version {
+		return ErrVersionConflict
+	}
+	// the task itself is unchanged, so another row of the write went missing
+	return ErrNotFound
+}
+
+// builds the SET expression for the changed fields, attribute names match
+// taskItem. Fields kept on the tenant row only are left out unless tenantRow
+func (c TaskChanges) updateExpression(tenantRow bool) (string, map[string]string, map[string]types.AttributeValue) {
+	var sets []string
+	names := map[string]string{}
+	values := map[string]types.AttributeValue{}
+
+	for attr, value := range map[string]*string{
+		"tasktitle":   c.Title,
+		"description": c.Description,
+		"status":      c.Status,
+		"deadline":    c.Deadline,
+	} {
+		if value == nil {
+			continue
+		}
+		sets = append(sets, fmt.Sprintf("#%s = :%s", attr, attr))
+		names["#"+attr] = attr
+		values[":"+attr] = &types.AttributeValueMemberS{Value: *value}
+	}
+	if tenantRow && c.BlockOnSubtasks != nil {
+		sets = append(sets, "#blockOnSubtasks = :blockOnSubtasks")
+		names["#blockOnSubtasks"] = "blockOnSubtasks"
+		values[":blockOnSubtasks"] = &types.AttributeValueMemberBOOL{Value: *c.BlockOnSubtasks}
+	}
+	sort.Strings(sets)
+
+	if len(sets) == 0 {
+		return "", nil, nil
+	}
+	return "SET " + strings.Join(sets, ", "), names, values
+}
+
+func (s *tasksStore) updateAction(pk, sk, expr string, names map[string]string, values map[string]types.AttributeValue) types.TransactWriteItem {
+	return types.TransactWriteItem{Update: &types.Update{
+		TableName:                 aws.String(s.tableName),
+		Key:                       keyAttributes(pk, sk),
+		UpdateExpression:          aws.String(expr),
+		ConditionExpression:       aws.String("attribute_exists(PartitionKey)"),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	}}
+}
+
+// applies field changes to the tenant row and every assignee's copies in one
+// transaction, leaving other attributes as they are
+func (s *tasksStore) UpdateTask(task Task, changes TaskChanges, history *HistoryEntry) error {
+	expr, names, values := changes.updateExpression(false)
+	tenantExpr, tenantNames, tenantValues := changes.updateExpression(true)
+	if tenantExpr == "" && history == nil {
+		return nil
+	}
+
+	taskKey := TaskKey(task.TaskID)
+	var actions []types.TransactWriteItem
+
+	if expr != "" {
+		assignees, err := s.ListAssignees(task.TaskID)
+		if err != nil {
+			return fmt.Errorf("failed to query user assignments: %w", err)
+		}
+
+		for _, assignee := range assignees {
+			userKey := UserKey(assignee.UserID)
+			actions = append(actions,
+				s.updateAction(taskKey, userKey, expr, names, values),
+				s.updateAction(userKey, taskKey, expr, names, values),
+			)
+		}
+	}
+
+	if history != nil {
+		action, err := s.putAction(newHistoryItem(*history))
+		if err != nil {
+			return err
+		}
+		actions = append(actions, action)
+	}
+	if changes.Deadline != nil {
+		deadline, err := s.deadlineActions(task, *changes.Deadline)
+		if err != nil {
+			return err
+		}
+		actions = append(actions, deadline...)
+	}
+
+	// the tenant row goes last, and must be unchanged for any of the update to apply
+	actions = append(actions, s.versionedUpdate(task, tenantExpr, tenantNames, tenantValues))
+
+	err := transactWrite(s.db, actions)
+	if isConditionFailure(err) {
+		return s.staleOrMissing(task)
+	}
+	if err != nil {
+		log.Printf("failed to update items, %v", err)
+		return errors.New("could not update task")
+	}
+	return nil
+}
+
+// versioned update of the tenant row replacing its assignee summary
+func (s *tasksStore) assigneesUpdate(task Task, assignees []Assignee) (types.TransactWriteItem, error) {
+	av, err := attributevalue.Marshal(newAssigneeSummaries(assignees))
+	if err != nil {
+		return types.TransactWriteItem{}, fmt.Errorf("failed to marshal assignees: %w", err)
+	}
+
+	return s.versionedUpdate(task, "SET #assignees = :assignees",
+		map[string]string{"#assignees": "assignees"},
+		map[string]types.AttributeValue{":assignees": av},
+	), nil
+}
+
+// writes a single assignment pair along with its history
+func (s *tasksStore) AddAssignee(task Task, assignee Assignee, history HistoryEntry) error {
+	taskKey, userKey := TaskKey(task.TaskID), UserKey(assignee.UserID)
+
+	assignment := newTaskItem(taskKey, userKey, task)
+	assignment.TenantID = task.TenantID
+	assignment.UserName = assignee.Username
+	assignment.Email = assignee.Email
+
+	mirror := assignment
+	mirror.PartitionKey, mirror.SortKey = userKey, taskKey
+
+	// the summary is rewritten whole, the version guard keeps it from racing
+	summary, err := s.assigneesUpdate(task, append(slices.Clone(task.Assignees), assignee))
+	if err != nil {
+		return err
+	}
+
+	actions := []types.TransactWriteItem{summary}
+	for _, item := range []any{assignment, mirror, newHistoryItem(history)} {
+		action, err := s.putAction(item)
+		if err != nil {
+			return err
+		}
+		actions = append(actions, action)
+	}
+
+	// never overwrite an existing assignment
+	for _, action := range actions[1:3] {
+		action.Put.ConditionExpression = aws.String("attribute_not_exists(PartitionKey)")
+	}
+
+	err = transactWrite(s.db, actions)
+	switch {
+	case conditionFailedAt(err, 0):
+		return s.staleOrMissing(task)
+	case isConditionFailure(err):
+		return ErrAlreadyExists
+	case err != nil:
+		log.Printf("failed to add assignee, %v", err)
+		return errors.New("could not add assignee")
+	}
+	return nil
+}
+
+// deletes a single assignment pair along with writing its history
+func (s *tasksStore) RemoveAssignee(task Task, userID string, history HistoryEntry) error {
+	taskKey, userKey := TaskKey(task.TaskID), UserKey(userID)
+
+	remaining := slices.DeleteFunc(slices.Clone(task.Assignees), func(a Assignee) bool {
+		return UserKey(a.UserID) == userKey
+	})
+	summary, err := s.assigneesUpdate(task, remaining)
+	if err != nil {
+		return err
+	}
+
+	actions := []types.TransactWriteItem{
+		summary,
+		s.deleteAction(taskKey, userKey),
+		s.deleteAction(userKey, taskKey),
+	}
+	actions[1].Delete.ConditionExpression = aws.String("attribute_exists(PartitionKey)")
+
+	action, err := s.putAction(newHistoryItem(history))
+	if err != nil {
+		return err
+	}
+	actions = append(actions, action)
+
+	err = transactWrite(s.db, actions)
+	if conditionFailedAt(err, 0) {
+		return s.staleOrMissing(task)
+	}
+	if isConditionFailure(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		log.Printf("failed to remove assignee, %v", err)
+		return errors.New("could not remove assignee")
+	}
+	return nil
+}
+
+// applies field changes to the tenant row and the copies of the assignees who
+// stay, and writes or deletes the assignment pairs of the ones added or removed,
+// all in one transaction
+func (s *tasksStore) ReplaceTask(task Task, changes TaskChanges, assignees []Assignee, history []HistoryEntry) error {
+	taskKey := TaskKey(task.TaskID)
+	current, err := s.ListAssignees(task.TaskID)
+	if err != nil {
+		return fmt.Errorf("failed to query user assignments: %w", err)
+	}
+
+	wanted := map[string]bool{}
+	for _, assignee := range assignees {
+		wanted[UserKey(assignee.UserID)] = true
+	}
+	existing := map[string]bool{}
+
+	// the copies of the assignees who stay are updated in place
+	expr, names, values := changes.updateExpression(false)
+	var actions []types.TransactWriteItem
+	for _, assignee := range current {
+		userKey := UserKey(assignee.UserID)
+		existing[userKey] = true
+		switch {
+		case !wanted[userKey]:
+			actions = append(actions, s.deleteAction(taskKey, userKey), s.deleteAction(userKey, taskKey))
+		case expr != "":
+			actions = append(actions,
+				s.updateAction(taskKey, userKey, expr, names, values),
+				s.updateAction(userKey, taskKey, expr, names, values),
+			)
+		}
+	}
+
+	// the pairs of new assignees are written as the task is after the changes
+	updated := task
+	for field, value := range map[*string]*string{
+		&updated.Title:       changes.Title,
+		&updated.Description: changes.Description,
+		&updated.Status:      changes.Status,
+		&updated.Deadline:    changes.Deadline,
+	} {
+		if value != nil {
+			*field = *value
+		}
+	}
+	for _, assignee := range assignees {
+		userKey := UserKey(assignee.UserID)
+		if existing[userKey] {
+			continue
+		}
+
+		assignment := newTaskItem(taskKey, userKey, updated)
+		assignment.TenantID = task.TenantID
+		assignment.UserName = assignee.Username
+		assignment.Email = assignee.Email
+
+		mirror := assignment
+		mirror.PartitionKey, mirror.SortKey = userKey, taskKey
+
+		for _, item := range []any{assignment, mirror} {
+			action, err := s.putAction(item)
+			if err != nil {
+				return err
+			}
+			action.Put.ConditionExpression = aws.String("attribute_not_exists(PartitionKey)")
+			actions = append(actions, action)
+		}
+	}
+
+	for _, entry := range history {
+		action, err := s.putAction(newHistoryItem(entry))
+		if err != nil {
+			return err
+		}
+		actions = append(actions, action)
+	}
+	if changes.Deadline != nil {
+		deadline, err := s.deadlineActions(task, *changes.Deadline)
+		if err != nil {
+			return err
+		}
+		actions = append(actions, deadline...)
+	}
+
+	// the tenant row goes last with the new summary, and must be unchanged for
+	// any of the replace to apply
+	summary, err := attributevalue.Marshal(newAssigneeSummaries(assignees))
+	if err != nil {
+		return fmt.Errorf("failed to marshal assignees: %w", err)
+	}
+	tenantExpr, tenantNames, tenantValues := changes.updateExpression(true)
+	if tenantExpr == "" {
+		tenantExpr = "SET #assignees = :assignees"
+	} else {
+		tenantExpr += ", #assignees = :assignees"
+	}
+	if tenantNames == nil {
+		tenantNames, tenantValues = map[string]string{}, map[string]types.AttributeValue{}
+	}
+	tenantNames["#assignees"] = "assignees"
+	tenantValues[":assignees"] = summary
+	actions = append(actions, s.versionedUpdate(task, tenantExpr, tenantNames, tenantValues))
+
+	err = transactWrite(s.db, actions)
+	if isConditionFailure(err) {
+		return s.staleOrMissing(task)
+	}
+	if err != nil {
+		log.Printf("failed to replace task, %v", err)
+		return errors.New("could not replace task")
 	}
-	return batchWriteOutput, nil
+	return nil
 }
 
-// function to get tasks from queryInput
-func (s *TasksStore) QueryTask(queryInput dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
-	result, err := s.db.Query(context.Background(), &queryInput)
+func (s *tasksStore) GetTask(tenantID, taskID string) (*Task, error) {
+	output, err := s.db.GetItem(context.Background(), &dynamodb.GetItemInput{
+		TableName: aws.String(s.tableName),
+		Key:       keyAttributes(tenantID, TaskKey(taskID)),
+	})
 	if err != nil {
-		log.Printf("failed to query input\n %v", err)
 		return nil, err
 	}
+	if output.Item == nil {
+		return nil, ErrNotFound
+	}
+
+	var item taskItem
+	if err := attributevalue.UnmarshalMap(output.Item, &item); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal task: %w", err)
+	}
 
-	return result, nil
+	tasks, err := s.tasksFromItems(tenantID, []taskItem{item})
+	if err != nil {
+		return nil, err
+	}
+	return &tasks[0], nil
 }
 
-// function to batch delete item
-func (s *TasksStore) DeleteItem(deleteInput *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
-	result, err := s.db.DeleteItem(context.Background(), deleteInput)
+// deletes the tenant task row and every assignment pair. A subtask is
+// unlinked from its parent, and the subtasks of a task are kept as tasks of
+// their own. Its dependencies either way are dropped, which fails with
+// ErrVersionConflict if a task it blocked changes at the same time
+func (s *tasksStore) DeleteTask(tenantID, taskID string) error {
+	taskKey := TaskKey(taskID)
+
+	task, err := s.GetTask(tenantID, taskID)
+	if err != nil {
+		return err
+	}
+	assignees, err := s.ListAssignees(taskID)
 	if err != nil {
+		return fmt.Errorf("failed to query user assignments: %w", err)
+	}
+	detach, err := s.detachSubtasks(tenantID, taskID)
+	if err != nil {
+		return err
+	}
+	dependencies, err := s.dropDependencies(*task)
+	if err != nil {
+		return err
+	}
+	deadline, err := s.deadlineActions(*task, "")
+	if err != nil {
+		return err
+	}
+
+	// the tenant row goes first so a chunked delete hides the task straight away,
+	// and must exist so a task id of another tenant deletes nothing
+	actions := []types.TransactWriteItem{s.deleteAction(tenantID, taskKey)}
+	actions[0].Delete.ConditionExpression = aws.String("attribute_exists(PartitionKey)")
+	for _, assignee := range assignees {
+		userKey := UserKey(assignee.UserID)
+		actions = append(actions,
+			s.deleteAction(taskKey, userKey),
+			s.deleteAction(userKey, taskKey),
+		)
+	}
+	if task.ParentID != "" {
+		actions = append(actions, s.deleteAction(TaskKey(task.ParentID), subtaskKey(taskID)))
+	}
+	actions = append(actions, detach...)
+	actions = append(actions, dependencies...)
+	actions = append(actions, deadline...)
+
+	err = transactWrite(s.db, actions)
+	if conditionFailedAt(err, 0) {
+		return ErrNotFound
+	}
+	if isConditionFailure(err) {
+		return ErrVersionConflict
+	}
+	if err != nil {
+		log.Printf("failed to delete items, %v", err)
+		return errors.New("could not delete task")
+	}
+	return nil
+}
+
+// lists one page of a tenant's tasks matching query, returning the cursor of
+// the next page. Filtering on an assignee reads that user's mirror rows
+func (s *tasksStore) ListTasksByTenant(tenantID string, query TaskQuery, page PageRequest) ([]Task, string, error) {
+	pk := tenantID
+	if query.AssigneeID != "" {
+		pk = UserKey(query.AssigneeID)
+	}
+
+	var items []taskItem
+	var next string
+	var err error
+	if query.SortBy == "" {
+		next, err = s.queryPage(pk, taskPrefix, query.filter(tenantID), page, &items)
+	} else {
+		items, next, err = s.sortedPage(pk, query.filter(tenantID), query, page)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	var tasks []Task
+	if query.AssigneeID != "" {
+		tasks, err = s.tasksFromMirrors(items)
+	} else {
+		tasks, err = s.tasksFromItems(tenantID, items)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return tasks, next, nil
+}
+
+// reads one page of rows ordered on query.SortBy. The table has no index on
+// task attributes, so every matching row is read and the page cut out of the
+// sorted rows. The cursor holds the last row returned
+func (s *tasksStore) sortedPage(pk string, filter *queryFilter, query TaskQuery, page PageRequest) ([]taskItem, string, error) {
+	rows, err := queryAll(s.db, s.tableName, pk, taskPrefix, filter)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var items []taskItem
+	if err := attributevalue.UnmarshalListOfMaps(rows, &items); err != nil {
+		return nil, "", fmt.Errorf("failed to unmarshal items: %w", err)
+	}
+	slices.SortFunc(items, func(a, b taskItem) int {
+		return query.compare(a.sortValue(query.SortBy), a.SortKey, b.sortValue(query.SortBy), b.SortKey)
+	})
+
+	start := 0
+	if page.Cursor != "" {
+		cursor, err := s.cursors.decodePayload(page.Cursor, pk, taskPrefix)
+		if err != nil || cursor.Order != query.order() {
+			return nil, "", ErrInvalidCursor
+		}
+		start = sort.Search(len(items), func(i int) bool {
+			return query.compare(items[i].sortValue(query.SortBy), items[i].SortKey, cursor.After, cursor.SortKey) > 0
+		})
+	}
+
+	end := min(start+int(page.limit()), len(items))
+	next := ""
+	if end < len(items) {
+		last := items[end-1]
+		next, err = s.cursors.encodePayload(cursorPayload{
+			PartitionKey: pk,
+			Prefix:       taskPrefix,
+			SortKey:      last.SortKey,
+			Order:        query.order(),
+			After:        last.sortValue(query.SortBy),
+		})
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	return items[start:end], next, nil
+}
+
+// lists one page of a user's tasks, paging through their mirror rows
+func (s *tasksStore) ListTasksByAssignee(userID string, page PageRequest) ([]Task, string, error) {
+	var mirrors []taskItem
+	next, err := s.queryPage(UserKey(userID), taskPrefix, nil, page, &mirrors)
+	if err != nil {
+		return nil, "", err
+	}
+
+	tasks, err := s.tasksFromMirrors(mirrors)
+	if err != nil {
+		return nil, "", err
+	}
+	return tasks, next, nil
+}
+
+// reads the tenant rows a user's mirror rows point at in batches
+func (s *tasksStore) tasksFromMirrors(mirrors []taskItem) ([]Task, error) {
+	keys := make([]map[string]types.AttributeValue, 0, len(mirrors))
+	for _, mirror := range mirrors {
+		if mirror.TenantID != "" {
+			keys = append(keys, keyAttributes(mirror.TenantID, mirror.SortKey))
+		}
+	}
+	rows, err := batchGet(s.db, s.tableName, keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tasks: %w", err)
+	}
+
+	var items []taskItem
+	if err := attributevalue.UnmarshalListOfMaps(rows, &items); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal tasks: %w", err)
+	}
+	byKey := map[[2]string]taskItem{}
+	for _, item := range items {
+		byKey[[2]string{item.PartitionKey, item.SortKey}] = item
+	}
+
+	// keep the mirror order, skipping mirrors whose task is gone. Mirrors
+	// written before they carried a tenant id stand in for their task
+	tasks := make([]Task, 0, len(mirrors))
+	for _, mirror := range mirrors {
+		item, ok := byKey[[2]string{mirror.TenantID, mirror.SortKey}]
+		if mirror.TenantID == "" {
+			item, ok = mirror, true
+		}
+		if !ok {
+			continue
+		}
+		found, err := s.tasksFromItems(mirror.TenantID, []taskItem{item})
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, found[0])
+	}
+	return tasks, nil
+}
+
+func (s *tasksStore) ListAssignees(taskID string) ([]Assignee, error) {
+	var items []taskItem
+	if err := s.queryPrefix(TaskKey(taskID), userPrefix, &items); err != nil {
 		return nil, err
 	}
 
-	return result, err
+	assignees := make([]Assignee, 0, len(items))
+	for _, item := range items {
+		assignees = append(assignees, Assignee{
+			UserID:   strings.TrimPrefix(item.SortKey, userPrefix),
+			Username: item.UserName,
+			Email:    item.Email,
+		})
+	}
+	return assignees, nil
+}
+
+func (s *tasksStore) AppendHistory(entry HistoryEntry) error {
+	item, err := attributevalue.MarshalMap(newHistoryItem(entry))
+	if err != nil {
+		return fmt.Errorf("failed to marshal history: %w", err)
+	}
+
+	_, err = s.db.PutItem(context.Background(), &dynamodb.PutItemInput{
+		TableName: aws.String(s.tableName),
+		Item:      item,
+	})
+	return err
+}
+
+func (s *tasksStore) ListHistory(taskID string, page PageRequest) ([]HistoryEntry, string, error) {
+	rows, next, err := queryPageBackward(s.db, s.cursors, s.tableName, TaskKey(taskID), historyPrefix, nil, page)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var items []historyItem
+	if err := attributevalue.UnmarshalListOfMaps(rows, &items); err != nil {
+		return nil, "", fmt.Errorf("failed to unmarshal history: %w", err)
+	}
+
+	entries := make([]HistoryEntry, 0, len(items))
+	for _, item := range items {
+		entries = append(entries, HistoryEntry{
+			TaskID:            strings.TrimPrefix(item.PartitionKey, taskPrefix),
+			HistoryID:         strings.TrimPrefix(item.SortKey, historyPrefix),
+			Action:            item.Action,
+			Status:            item.Status,
+			UpdatedBy:         strings.TrimPrefix(item.UpdatedBy, userPrefix),
+			UpdatedAt:         item.UpdatedAt,
+			UpdateDescription: item.UpdateDescription,
+			Changes:           item.Changes,
+		})
+	}
+	return entries, next, nil
+}
+
+// queries every item of a partition whose sort key starts with prefix
+func (s *tasksStore) queryPrefix(pk, prefix string, out any) error {
+	items, err := queryAll(s.db, s.tableName, pk, prefix, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := attributevalue.UnmarshalListOfMaps(items, out); err != nil {
+		return fmt.Errorf("failed to unmarshal items: %w", err)
+	}
+	return nil
+}
+
+// queries one page of a partition whose sort key starts with prefix
+func (s *tasksStore) queryPage(pk, prefix string, filter *queryFilter, page PageRequest, out any) (string, error) {
+	items, next, err := queryPage(s.db, s.cursors, s.tableName, pk, prefix, filter, page)
+	if err != nil {
+		return "", err
+	}
+
+	if err := attributevalue.UnmarshalListOfMaps(items, out); err != nil {
+		return "", fmt.Errorf("failed to unmarshal items: %w", err)
+	}
+	return next, nil
 }
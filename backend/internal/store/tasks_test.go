@@ -0,0 +1,104 @@
+package store
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func taskIDs(tasks []Task) []string {
+	var ids []string
+	for _, task := range tasks {
+		ids = append(ids, task.TaskID)
+	}
+	return ids
+}
+
+func TestTaskRoundTrip(t *testing.T) {
+	tasks := newTasksStore(NewMemoryDB(), newCursorCodec())
+	write := TaskWrite{
+		Task: Task{
+			TenantID:    "TENANT#a",
+			TaskID:      "fence",
+			Title:       "mend the fence",
+			Description: "north side",
+			Status:      "todo",
+			Deadline:    "2024-05-03T12:00:00Z",
+			CreatedAt:   "2024-05-01T12:00:00Z",
+			CreatedBy:   "ann",
+			Checklist:   []ChecklistItem{{ID: "1", Text: "posts"}, {ID: "2", Text: "rails", Done: true}},
+		},
+		Assignees: []Assignee{{UserID: "bob", Username: "bob", Email: "bob@example.com"}, {UserID: "cat", Username: "cat", Email: "cat@example.com"}},
+		History:   &HistoryEntry{TaskID: "fence", HistoryID: NewHistoryID(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)), Action: HistoryCreated, Status: "todo", UpdatedBy: "ann", UpdatedAt: "2024-05-01T12:00:00Z"},
+	}
+	if err := tasks.PutTask(write); err != nil {
+		t.Fatal(err)
+	}
+	if err := tasks.PutTask(TaskWrite{Task: Task{TenantID: "TENANT#b", TaskID: "gate", Title: "gate"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	task, err := tasks.GetTask("TENANT#a", "TASK#fence")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := write.Task
+	want.Assignees = write.Assignees
+	if task.Title != want.Title || task.Description != want.Description || task.Status != want.Status ||
+		task.Deadline != want.Deadline || task.CreatedAt != want.CreatedAt || task.CreatedBy != want.CreatedBy ||
+		!slices.Equal(task.Checklist, want.Checklist) || !slices.Equal(task.Assignees, want.Assignees) {
+		t.Errorf("read %+v, want %+v", *task, want)
+	}
+	history, _, err := tasks.ListHistory("fence", PageRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].Action != HistoryCreated || history[0].UpdatedBy != "ann" {
+		t.Errorf("history = %+v, want the created entry", history)
+	}
+	if _, err := tasks.GetTask("TENANT#b", "fence"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("task of another tenant = %v, want ErrNotFound", err)
+	}
+
+	listed, _, err := tasks.ListTasksByTenant("TENANT#a", TaskQuery{}, PageRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := taskIDs(listed); !slices.Equal(ids, []string{"fence"}) {
+		t.Errorf("tenant lists %v, want fence only", ids)
+	}
+	for _, userID := range []string{"bob", "USER#cat"} {
+		assigned, _, err := tasks.ListTasksByAssignee(userID, PageRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(assigned) != 1 || assigned[0].Title != "mend the fence" || len(assigned[0].Assignees) != 2 {
+			t.Errorf("tasks of %s = %+v, want fence with both assignees", userID, assigned)
+		}
+	}
+	assignees, err := tasks.ListAssignees("fence")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(assignees, write.Assignees) {
+		t.Errorf("assignees = %+v, want %+v", assignees, write.Assignees)
+	}
+
+	// writes are guarded by the version the task was read at
+	title := "mend the north fence"
+	if err := tasks.UpdateTask(*task, TaskChanges{Title: &title}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := tasks.UpdateTask(*task, TaskChanges{Title: &title}, nil); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("update at a stale version = %v, want ErrVersionConflict", err)
+	}
+	// and the assignees' copies follow the task
+	assigned, _, err := tasks.ListTasksByAssignee("bob", PageRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(assigned) != 1 || assigned[0].Title != title {
+		t.Errorf("bob's copy = %+v, want the new title", assigned)
+	}
+}
@@ -0,0 +1,342 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/Ghaby-X/tasork/internal/env"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const templatePrefix = "TEMPLATE#"
+
+// partition indexing the templates of every tenant by their next run, only
+// read by the scheduler
+const schedulePartition = "SCHEDULE"
+
+// Template is a task that is created again each time its recurrence rule
+// comes round. NextRun is the first occurrence that has no task yet, in UTC,
+// and is empty while the template is paused or once its rule has ended
+type Template struct {
+	TenantID    string
+	TemplateID  string
+	Title       string
+	Description string
+	Assignees   []Assignee
+	Checklist   []string
+	Start       string
+	Rule        string
+	Timezone    string
+	DueAfter    string
+	Paused      bool
+	NextRun     string
+	// how many occurrences of the rule came before NextRun, so the rule can
+	// be walked on from NextRun without losing count of COUNT
+	RunCount  int
+	Version   int64
+	CreatedBy string
+	CreatedAt string
+	UpdatedAt string
+}
+
+type TemplatesStore interface {
+	// returns ErrAlreadyExists when the id is taken
+	PutTemplate(template Template) error
+	// returns ErrNotFound when the tenant has no such template
+	GetTemplate(tenantID, templateID string) (*Template, error)
+	// replaces current with updated, ErrVersionConflict when current is
+	// no longer the saved version and ErrNotFound when it is gone
+	UpdateTemplate(current, updated Template) error
+	DeleteTemplate(template Template) error
+	// lists one page of a tenant's templates
+	ListTemplates(tenantID string, page PageRequest) ([]Template, string, error)
+	// lists the templates of every tenant whose next run is before the given time
+	ListDueTemplates(before string) ([]Template, error)
+}
+
+type templateItem struct {
+	PartitionKey string            `dynamodbav:"PartitionKey"`
+	SortKey      string            `dynamodbav:"SortKey"`
+	Title        string            `dynamodbav:"tasktitle"`
+	Description  string            `dynamodbav:"description"`
+	Assignees    []assigneeSummary `dynamodbav:"assignees"`
+	Checklist    []string          `dynamodbav:"checklist,omitempty"`
+	Start        string            `dynamodbav:"start"`
+	Rule         string            `dynamodbav:"rrule"`
+	Timezone     string            `dynamodbav:"timezone,omitempty"`
+	DueAfter     string            `dynamodbav:"dueAfter,omitempty"`
+	Paused       bool              `dynamodbav:"paused,omitempty"`
+	NextRun      string            `dynamodbav:"nextRun,omitempty"`
+	RunCount     int               `dynamodbav:"runCount,omitempty"`
+	Version      int64             `dynamodbav:"version"`
+	CreatedBy    string            `dynamodbav:"createdby"`
+	CreatedAt    string            `dynamodbav:"createdAt"`
+	UpdatedAt    string            `dynamodbav:"updatedAt,omitempty"`
+}
+
+func newTemplateItem(template Template) templateItem {
+	return templateItem{
+		PartitionKey: template.TenantID,
+		SortKey:      templatePrefix + template.TemplateID,
+		Title:        template.Title,
+		Description:  template.Description,
+		Assignees:    *newAssigneeSummaries(template.Assignees),
+		Checklist:    template.Checklist,
+		Start:        template.Start,
+		Rule:         template.Rule,
+		Timezone:     template.Timezone,
+		DueAfter:     template.DueAfter,
+		Paused:       template.Paused,
+		NextRun:      template.NextRun,
+		RunCount:     template.RunCount,
+		Version:      template.Version,
+		CreatedBy:    UserKey(template.CreatedBy),
+		CreatedAt:    template.CreatedAt,
+		UpdatedAt:    template.UpdatedAt,
+	}
+}
+
+func (item templateItem) template() Template {
+	template := Template{
+		TenantID:    item.PartitionKey,
+		TemplateID:  strings.TrimPrefix(item.SortKey, templatePrefix),
+		Title:       item.Title,
+		Description: item.Description,
+		Assignees:   make([]Assignee, 0, len(item.Assignees)),
+		Checklist:   item.Checklist,
+		Start:       item.Start,
+		Rule:        item.Rule,
+		Timezone:    item.Timezone,
+		DueAfter:    item.DueAfter,
+		Paused:      item.Paused,
+		NextRun:     item.NextRun,
+		RunCount:    item.RunCount,
+		Version:     item.Version,
+		CreatedBy:   strings.TrimPrefix(item.CreatedBy, userPrefix),
+		CreatedAt:   item.CreatedAt,
+		UpdatedAt:   item.UpdatedAt,
+	}
+	for _, assignee := range item.Assignees {
+		template.Assignees = append(template.Assignees, Assignee{UserID: assignee.UserID, Username: assignee.Username, Email: assignee.Email})
+	}
+	return template
+}
+
+// SCHEDULE/<next run>#<tenant>#TEMPLATE#<id> points at a template that is
+// due at its next run, so the due ones are read with one range query
+type scheduleItem struct {
+	PartitionKey string `dynamodbav:"PartitionKey"`
+	SortKey      string `dynamodbav:"SortKey"`
+	TenantID     string `dynamodbav:"tenantId"`
+	TemplateID   string `dynamodbav:"templateId"`
+}
+
+func scheduleKey(template Template) string {
+	return template.NextRun + "#" + template.TenantID + "#" + templatePrefix + template.TemplateID
+}
+
+type templatesStore struct {
+	db        DynamoDBAPI
+	tableName string
+	cursors   *cursorCodec
+}
+
+func newTemplatesStore(db DynamoDBAPI, cursors *cursorCodec) *templatesStore {
+	return &templatesStore{db, env.GetString("DYNAMODB_TABLE_NAME", "tasork"), cursors}
+}
+
+// the schedule entry of a template, none when it has no next run
+func (s *templatesStore) scheduleAction(template Template) ([]types.TransactWriteItem, error) {
+	if template.NextRun == "" {
+		return nil, nil
+	}
+	action, err := putAction(s.tableName, scheduleItem{
+		PartitionKey: schedulePartition,
+		SortKey:      scheduleKey(template),
+		TenantID:     template.TenantID,
+		TemplateID:   template.TemplateID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []types.TransactWriteItem{action}, nil
+}
+
+func (s *templatesStore) unscheduleAction(template Template) []types.TransactWriteItem {
+	if template.NextRun == "" {
+		return nil
+	}
+	return []types.TransactWriteItem{{Delete: &types.Delete{
+		TableName: aws.String(s.tableName),
+		Key:       keyAttributes(schedulePartition, scheduleKey(template)),
+	}}}
+}
+
+func (s *templatesStore) PutTemplate(template Template) error {
+	template.Version = 1
+	action, err := putAction(s.tableName, newTemplateItem(template))
+	if err != nil {
+		return err
+	}
+	action.Put.ConditionExpression = aws.String("attribute_not_exists(PartitionKey)")
+
+	schedule, err := s.scheduleAction(template)
+	if err != nil {
+		return err
+	}
+
+	err = transactWrite(s.db, append([]types.TransactWriteItem{action}, schedule...))
+	if isConditionFailure(err) {
+		return ErrAlreadyExists
+	}
+	if err != nil {
+		log.Printf("failed to put template, %v", err)
+		return errors.New("could not create template")
+	}
+	return nil
+}
+
+func (s *templatesStore) GetTemplate(tenantID, templateID string) (*Template, error) {
+	output, err := s.db.GetItem(context.Background(), &dynamodb.GetItemInput{
+		TableName: aws.String(s.tableName),
+		Key:       keyAttributes(tenantID, templatePrefix+templateID),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if output.Item == nil {
+		return nil, ErrNotFound
+	}
+
+	var item templateItem
+	if err := attributevalue.UnmarshalMap(output.Item, &item); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal template: %w", err)
+	}
+	template := item.template()
+	return &template, nil
+}
+
+// a write of the template row guarded by the version current was read at,
+// moving its schedule entry along with it
+func (s *templatesStore) write(current Template, row types.TransactWriteItem, updated *Template) error {
+	cond, names, values := versionCondition(current.Version)
+	if row.Put != nil {
+		row.Put.ConditionExpression, row.Put.ExpressionAttributeNames, row.Put.ExpressionAttributeValues = aws.String(cond), names, values
+	} else {
+		row.Delete.ConditionExpression, row.Delete.ExpressionAttributeNames, row.Delete.ExpressionAttributeValues = aws.String(cond), names, values
+	}
+	actions := []types.TransactWriteItem{row}
+
+	// a transaction cannot touch the same item twice, so an entry that stays put is left alone
+	if updated == nil || scheduleKey(current) != scheduleKey(*updated) {
+		actions = append(actions, s.unscheduleAction(current)...)
+		if updated != nil {
+			schedule, err := s.scheduleAction(*updated)
+			if err != nil {
+				return err
+			}
+			actions = append(actions, schedule...)
+		}
+	}
+
+	err := transactWrite(s.db, actions)
+	if conditionFailedAt(err, 0) {
+		if _, err := s.GetTemplate(current.TenantID, current.TemplateID); errors.Is(err, ErrNotFound) {
+			return ErrNotFound
+		}
+		return ErrVersionConflict
+	}
+	if err != nil {
+		log.Printf("failed to write template, %v", err)
+		return errors.New("could not write template")
+	}
+	return nil
+}
+
+func (s *templatesStore) UpdateTemplate(current, updated Template) error {
+	updated.Version = current.Version + 1
+	action, err := putAction(s.tableName, newTemplateItem(updated))
+	if err != nil {
+		return err
+	}
+	return s.write(current, action, &updated)
+}
+
+func (s *templatesStore) DeleteTemplate(template Template) error {
+	return s.write(template, types.TransactWriteItem{Delete: &types.Delete{
+		TableName: aws.String(s.tableName),
+		Key:       keyAttributes(template.TenantID, templatePrefix+template.TemplateID),
+	}}, nil)
+}
+
+func (s *templatesStore) ListTemplates(tenantID string, page PageRequest) ([]Template, string, error) {
+	rows, next, err := queryPage(s.db, s.cursors, s.tableName, tenantID, templatePrefix, nil, page)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var items []templateItem
+	if err := attributevalue.UnmarshalListOfMaps(rows, &items); err != nil {
+		return nil, "", fmt.Errorf("failed to unmarshal templates: %w", err)
+	}
+
+	templates := make([]Template, 0, len(items))
+	for _, item := range items {
+		templates = append(templates, item.template())
+	}
+	return templates, next, nil
+}
+
+func (s *templatesStore) ListDueTemplates(before string) ([]Template, error) {
+	// an entry whose time equals before sorts after it, as its key goes on past the time
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(s.tableName),
+		KeyConditionExpression: aws.String("PartitionKey = :pkey AND SortKey < :before"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pkey":   &types.AttributeValueMemberS{Value: schedulePartition},
+			":before": &types.AttributeValueMemberS{Value: before},
+		},
+	}
+
+	var keys []map[string]types.AttributeValue
+	for {
+		output, err := s.db.Query(context.Background(), input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query schedule: %w", err)
+		}
+
+		var entries []scheduleItem
+		if err := attributevalue.UnmarshalListOfMaps(output.Items, &entries); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal schedule: %w", err)
+		}
+		for _, entry := range entries {
+			keys = append(keys, keyAttributes(entry.TenantID, templatePrefix+entry.TemplateID))
+		}
+
+		if output.LastEvaluatedKey == nil {
+			break
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+
+	rows, err := batchGet(s.db, s.tableName, keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read templates: %w", err)
+	}
+	var items []templateItem
+	if err := attributevalue.UnmarshalListOfMaps(rows, &items); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal templates: %w", err)
+	}
+
+	templates := make([]Template, 0, len(items))
+	for _, item := range items {
+		templates = append(templates, item.template())
+	}
+	return templates, nil
+}
@@ -0,0 +1,144 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// dynamodb allows at most 100 actions in one TransactWriteItems call
+const maxTransactItems = 100
+
+// reports whether a write was rejected because a condition did not hold
+func isConditionFailure(err error) bool {
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return true
+	}
+
+	var cancelled *types.TransactionCanceledException
+	if errors.As(err, &cancelled) {
+		for _, reason := range cancelled.CancellationReasons {
+			if aws.ToString(reason.Code) == "ConditionalCheckFailed" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// reports whether action i of a transactWrite failed its condition
+func conditionFailedAt(err error, i int) bool {
+	var cancelled *types.TransactionCanceledException
+	if !errors.As(err, &cancelled) || i >= len(cancelled.CancellationReasons) {
+		return false
+	}
+	return aws.ToString(cancelled.CancellationReasons[i].Code) == "ConditionalCheckFailed"
+}
+
+// where and what a transaction action touches
+func transactTarget(item types.TransactWriteItem) (*string, map[string]types.AttributeValue) {
+	switch {
+	case item.Put != nil:
+		pk, sk, _ := keyOf(item.Put.Item)
+		return item.Put.TableName, keyAttributes(pk, sk)
+	case item.Delete != nil:
+		return item.Delete.TableName, item.Delete.Key
+	case item.Update != nil:
+		return item.Update.TableName, item.Update.Key
+	}
+	return nil, nil
+}
+
+// writes items all-or-nothing. Anything over one transaction is split into
+// chunks committed in order, so callers should put the item that makes a write
+// visible last. If a later chunk fails, earlier chunks are rolled back to the
+// item images read before the write started
+func transactWrite(db DynamoDBAPI, items []types.TransactWriteItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+	if len(items) <= maxTransactItems {
+		_, err := db.TransactWriteItems(context.Background(), &dynamodb.TransactWriteItemsInput{TransactItems: items})
+		return err
+	}
+
+	// snapshot every item a chunk other than the last could change
+	lastChunk := (len(items) - 1) / maxTransactItems * maxTransactItems
+	snapshot := make([]types.TransactWriteItem, 0, lastChunk)
+	for _, item := range items[:lastChunk] {
+		tableName, key := transactTarget(item)
+		if key == nil {
+			continue
+		}
+
+		output, err := db.GetItem(context.Background(), &dynamodb.GetItemInput{
+			TableName:      tableName,
+			Key:            key,
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read item before write: %w", err)
+		}
+
+		if output.Item == nil {
+			snapshot = append(snapshot, types.TransactWriteItem{Delete: &types.Delete{TableName: tableName, Key: key}})
+		} else {
+			snapshot = append(snapshot, types.TransactWriteItem{Put: &types.Put{TableName: tableName, Item: output.Item}})
+		}
+	}
+
+	for start := 0; start < len(items); start += maxTransactItems {
+		end := min(start+maxTransactItems, len(items))
+		_, err := db.TransactWriteItems(context.Background(), &dynamodb.TransactWriteItemsInput{TransactItems: items[start:end]})
+		if err != nil {
+			rollback(db, items[:start], snapshot)
+			return offsetReasons(err, start, len(items))
+		}
+	}
+
+	return nil
+}
+
+// moves the cancellation reasons of the chunk from start to the indexes of
+// the whole write, so conditionFailedAt reads them like an unchunked one
+func offsetReasons(err error, start, total int) error {
+	var cancelled *types.TransactionCanceledException
+	if !errors.As(err, &cancelled) {
+		return err
+	}
+
+	reasons := make([]types.CancellationReason, total)
+	for i := range reasons {
+		reasons[i].Code = aws.String("None")
+	}
+	copy(reasons[start:], cancelled.CancellationReasons)
+
+	offset := *cancelled
+	offset.CancellationReasons = reasons
+	return &offset
+}
+
+// restores the images of the committed items captured before a chunked write, best effort
+func rollback(db DynamoDBAPI, committed []types.TransactWriteItem, snapshot []types.TransactWriteItem) {
+	touched := 0
+	for _, item := range committed {
+		if _, key := transactTarget(item); key != nil {
+			touched++
+		}
+	}
+	snapshot = snapshot[:touched]
+
+	for start := 0; start < len(snapshot); start += maxTransactItems {
+		end := min(start+maxTransactItems, len(snapshot))
+		_, err := db.TransactWriteItems(context.Background(), &dynamodb.TransactWriteItemsInput{TransactItems: snapshot[start:end]})
+		if err != nil {
+			log.Printf("failed to roll back chunked write, %d items may be inconsistent: %v", end-start, err)
+		}
+	}
+}
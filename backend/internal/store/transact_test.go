@@ -0,0 +1,91 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// a write over two transactions that fails in its last chunk leaves the
+// items of the earlier chunks as they were before it started
+func TestTransactWriteRollsBackEarlierChunks(t *testing.T) {
+	db := NewMemoryDB()
+	for i := range 10 {
+		putTestItems(t, db, testItem("P", fmt.Sprintf("K#%03d", i), "v", "old"))
+	}
+
+	actions := []types.TransactWriteItem{
+		{Delete: &types.Delete{TableName: aws.String(testTable), Key: keyAttributes("P", "K#000")}},
+	}
+	for i := 1; i < 250; i++ {
+		actions = append(actions, types.TransactWriteItem{Put: &types.Put{
+			TableName: aws.String(testTable),
+			Item:      testItem("P", fmt.Sprintf("K#%03d", i), "v", "new"),
+		}})
+	}
+	// K#249 does not exist, so the third chunk fails
+	actions[249].Put.ConditionExpression = aws.String("attribute_exists(PartitionKey)")
+
+	err := transactWrite(db, actions)
+	if !isConditionFailure(err) {
+		t.Fatalf("got %v, want a condition failure", err)
+	}
+	for _, i := range []int{0, 49, 200, 248} {
+		if conditionFailedAt(err, i) {
+			t.Errorf("action %d reported as failed", i)
+		}
+	}
+	if !conditionFailedAt(err, 249) {
+		t.Errorf("action 249 not reported as failed: %v", err)
+	}
+
+	output, err := db.Query(context.Background(), &dynamodb.QueryInput{
+		TableName:                 aws.String(testTable),
+		KeyConditionExpression:    aws.String("PartitionKey = :pk"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":pk": str("P")},
+	})
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if len(output.Items) != 10 {
+		t.Errorf("%d items after the rollback, want the 10 from before", len(output.Items))
+	}
+	for _, item := range output.Items {
+		if v := item["v"].(*types.AttributeValueMemberS).Value; v != "old" {
+			_, sk, _ := keyOf(item)
+			t.Errorf("%s = %q after the rollback, want %q", sk, v, "old")
+		}
+	}
+}
+
+// a subtask with 49 assignees is 101 actions, the tenant row alone in the
+// second chunk. Writing it again is told apart from a missing parent
+func TestPutTaskChunkedReportsExistingTask(t *testing.T) {
+	db := NewMemoryDB()
+	tasks := newTasksStore(db, newCursorCodec())
+
+	if err := tasks.PutTask(TaskWrite{Task: Task{TenantID: "TENANT#a", TaskID: "parent", Title: "parent"}}); err != nil {
+		t.Fatal(err)
+	}
+	write := TaskWrite{Task: Task{TenantID: "TENANT#a", TaskID: "child", ParentID: "parent", Title: "child"}}
+	for i := range 49 {
+		write.Assignees = append(write.Assignees, Assignee{UserID: fmt.Sprintf("user-%02d", i)})
+	}
+
+	if err := tasks.PutTask(write); err != nil {
+		t.Fatalf("first write: %v", err)
+	}
+	if err := tasks.PutTask(write); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("second write = %v, want ErrAlreadyExists", err)
+	}
+
+	write.Task.TaskID, write.Task.ParentID = "orphan", "missing"
+	if err := tasks.PutTask(write); !errors.Is(err, ErrNotFound) {
+		t.Errorf("write under a missing parent = %v, want ErrNotFound", err)
+	}
+}
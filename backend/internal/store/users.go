@@ -2,28 +2,93 @@ package store
 
 import (
 	"context"
-	"log"
+	"fmt"
+	"strings"
 
+	"github.com/Ghaby-X/tasork/internal/env"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
-type UsersStore struct {
-	db *dynamodb.Client
+type UsersStore interface {
+	QueryPage(pk, skPrefix string, page PageRequest) ([]map[string]types.AttributeValue, string, error)
+	CreateItem(item *dynamodb.PutItemInput) error
+	// returns ErrNotFound when the user is not a member of the tenant
+	GetUser(tenantID, userID string) (map[string]types.AttributeValue, error)
+	// reads the users of a tenant by username, ignoring case, in the order
+	// given and leaving out unknown names
+	GetUsersByName(tenantID string, usernames []string) ([]Member, error)
+	// indexes the usernames of users saved before usernames were indexed,
+	// scanning the whole table, and returns how many it added
+	BackfillUsernames() (int, error)
 }
 
-// get all users from database
-func (s *UsersStore) QueryDB(queryInput dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
-	result, err := s.db.Query(context.Background(), &queryInput)
+// Member is a user of a tenant as found by their username
+type Member struct {
+	UserID   string
+	Username string
+	Email    string
+}
+
+// <tenant>/USERNAME#<lowercased name> finds a user by the name others @mention
+// them with. A name taken twice in a tenant finds the user indexed last
+type usernameItem struct {
+	PartitionKey string `dynamodbav:"PartitionKey"`
+	SortKey      string `dynamodbav:"SortKey"`
+	UserID       string `dynamodbav:"userId"`
+	Username     string `dynamodbav:"userName"`
+	Email        string `dynamodbav:"email"`
+}
+
+func usernameKey(username string) string {
+	return usernamePrefix + strings.ToLower(username)
+}
+
+// UsernameItem is the item indexing a user of a tenant by username, written
+// along with the user row
+func UsernameItem(tenantID string, member Member) map[string]types.AttributeValue {
+	item := keyAttributes(tenantID, usernameKey(member.Username))
+	item["userId"] = &types.AttributeValueMemberS{Value: strings.TrimPrefix(member.UserID, userPrefix)}
+	item["userName"] = &types.AttributeValueMemberS{Value: member.Username}
+	item["email"] = &types.AttributeValueMemberS{Value: member.Email}
+	return item
+}
+
+type usersStore struct {
+	db        DynamoDBAPI
+	tableName string
+	cursors   *cursorCodec
+}
+
+func newUsersStore(db DynamoDBAPI, cursors *cursorCodec) *usersStore {
+	return &usersStore{db, env.GetString("DYNAMODB_TABLE_NAME", "tasork"), cursors}
+}
+
+// get one page of the items of a partition whose sort key starts with skPrefix,
+// along with the cursor of the next page
+func (s *usersStore) QueryPage(pk, skPrefix string, page PageRequest) ([]map[string]types.AttributeValue, string, error) {
+	return queryPage(s.db, s.cursors, s.tableName, pk, skPrefix, nil, page)
+}
+
+// get a user of a tenant, accepting either a bare or prefixed user id
+func (s *usersStore) GetUser(tenantID, userID string) (map[string]types.AttributeValue, error) {
+	output, err := s.db.GetItem(context.Background(), &dynamodb.GetItemInput{
+		TableName: aws.String(s.tableName),
+		Key:       keyAttributes(tenantID, UserKey(userID)),
+	})
 	if err != nil {
-		log.Printf("failed to query input\n %v", err)
 		return nil, err
 	}
-
-	return result, nil
+	if output.Item == nil {
+		return nil, ErrNotFound
+	}
+	return output.Item, nil
 }
 
 // queries dynamodb based on query input
-func (s *UsersStore) CreateItem(item *dynamodb.PutItemInput) error {
+func (s *usersStore) CreateItem(item *dynamodb.PutItemInput) error {
 	_, err := s.db.PutItem(context.Background(), item)
 	if err != nil {
 		return err
@@ -31,3 +96,87 @@ func (s *UsersStore) CreateItem(item *dynamodb.PutItemInput) error {
 
 	return nil
 }
+
+func (s *usersStore) GetUsersByName(tenantID string, usernames []string) ([]Member, error) {
+	var keys []map[string]types.AttributeValue
+	seen := map[string]bool{}
+	for _, username := range usernames {
+		key := usernameKey(username)
+		if username == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		keys = append(keys, keyAttributes(tenantID, key))
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	rows, err := batchGet(s.db, s.tableName, keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read usernames: %w", err)
+	}
+	var items []usernameItem
+	if err := attributevalue.UnmarshalListOfMaps(rows, &items); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal usernames: %w", err)
+	}
+
+	found := map[string]usernameItem{}
+	for _, item := range items {
+		found[item.SortKey] = item
+	}
+	var members []Member
+	for _, key := range keys {
+		sk, _ := key[sortKeyAttr].(*types.AttributeValueMemberS)
+		if item, ok := found[sk.Value]; ok {
+			members = append(members, Member{UserID: item.UserID, Username: item.Username, Email: item.Email})
+		}
+	}
+	return members, nil
+}
+
+func (s *usersStore) BackfillUsernames() (int, error) {
+	input := &dynamodb.ScanInput{
+		TableName:        aws.String(s.tableName),
+		FilterExpression: aws.String("begins_with(PartitionKey, :tenant) AND begins_with(SortKey, :user)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":tenant": &types.AttributeValueMemberS{Value: tenantPrefix},
+			":user":   &types.AttributeValueMemberS{Value: userPrefix},
+		},
+	}
+
+	added := 0
+	for {
+		output, err := s.db.Scan(context.Background(), input)
+		if err != nil {
+			return added, fmt.Errorf("failed to scan users: %w", err)
+		}
+
+		var users []usernameItem
+		if err := attributevalue.UnmarshalListOfMaps(output.Items, &users); err != nil {
+			return added, fmt.Errorf("failed to unmarshal users: %w", err)
+		}
+		for _, user := range users {
+			if user.Username == "" {
+				continue
+			}
+			_, err := s.db.PutItem(context.Background(), &dynamodb.PutItemInput{
+				TableName:           aws.String(s.tableName),
+				Item:                UsernameItem(user.PartitionKey, Member{UserID: user.SortKey, Username: user.Username, Email: user.Email}),
+				ConditionExpression: aws.String("attribute_not_exists(PartitionKey)"),
+			})
+			if isConditionFailure(err) {
+				continue
+			}
+			if err != nil {
+				return added, fmt.Errorf("failed to index username of %s: %w", user.SortKey, err)
+			}
+			added++
+		}
+
+		if output.LastEvaluatedKey == nil {
+			return added, nil
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+}
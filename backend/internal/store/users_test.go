@@ -0,0 +1,44 @@
+package store
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestGetUsersByName(t *testing.T) {
+	db := NewMemoryDB()
+	users := newUsersStore(db, newCursorCodec())
+	putTestItems(t, db,
+		testItem("TENANT#a", UserKey("ann"), "userName", "Ann", "email", "ann@example.com"),
+		testItem("TENANT#a", UserKey("bob"), "userName", "bob", "email", "bob@example.com"),
+		testItem("TENANT#b", UserKey("cat"), "userName", "cat", "email", "cat@example.com"),
+		// indexed already, so the backfill leaves it be
+		UsernameItem("TENANT#a", Member{UserID: "bob", Username: "bob", Email: "bob@example.com"}),
+	)
+
+	added, err := users.BackfillUsernames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 2 {
+		t.Errorf("backfill added %d usernames, want 2", added)
+	}
+	if added, err := users.BackfillUsernames(); err != nil || added != 0 {
+		t.Errorf("second backfill added %d usernames with %v, want none", added, err)
+	}
+
+	members, err := users.GetUsersByName("TENANT#a", []string{"BOB", "cat", "ann", "bob", "nobody"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, member := range members {
+		ids = append(ids, member.UserID)
+	}
+	if want := []string{"bob", "ann"}; !slices.Equal(ids, want) {
+		t.Errorf("got users %v, want %v", ids, want)
+	}
+	if members[1].Username != "Ann" || members[1].Email != "ann@example.com" {
+		t.Errorf("ann read as %+v", members[1])
+	}
+}
@@ -0,0 +1,114 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Ghaby-X/tasork/internal/env"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// sort key of the workflow item under its tenant
+const workflowSortKey = "WORKFLOW"
+
+// Workflow is the set of statuses a tenant's tasks can be in and the moves
+// allowed between them
+type Workflow struct {
+	TenantID     string
+	InitialState string
+	States       []WorkflowState
+	Transitions  []Transition
+	UpdatedBy    string
+	UpdatedAt    string
+}
+
+// a terminal state allows no transitions out of it
+type WorkflowState struct {
+	Name     string `dynamodbav:"name"`
+	Terminal bool   `dynamodbav:"terminal"`
+}
+
+// Transition allows moving a task from one state to another, to the given
+// roles only, or to every role when Roles is empty
+type Transition struct {
+	From  string   `dynamodbav:"from"`
+	To    string   `dynamodbav:"to"`
+	Roles []string `dynamodbav:"roles"`
+}
+
+type WorkflowStore interface {
+	// returns ErrNotFound when the tenant has not saved a workflow
+	GetWorkflow(tenantID string) (*Workflow, error)
+	PutWorkflow(workflow Workflow) error
+}
+
+type workflowItem struct {
+	PartitionKey string          `dynamodbav:"PartitionKey"`
+	SortKey      string          `dynamodbav:"SortKey"`
+	InitialState string          `dynamodbav:"initialState"`
+	States       []WorkflowState `dynamodbav:"states"`
+	Transitions  []Transition    `dynamodbav:"transitions"`
+	UpdatedBy    string          `dynamodbav:"updatedby"`
+	UpdatedAt    string          `dynamodbav:"updatedAt"`
+}
+
+type workflowStore struct {
+	db        DynamoDBAPI
+	tableName string
+}
+
+func newWorkflowStore(db DynamoDBAPI) *workflowStore {
+	return &workflowStore{db, env.GetString("DYNAMODB_TABLE_NAME", "tasork")}
+}
+
+func (s *workflowStore) GetWorkflow(tenantID string) (*Workflow, error) {
+	output, err := s.db.GetItem(context.Background(), &dynamodb.GetItemInput{
+		TableName: aws.String(s.tableName),
+		Key:       keyAttributes(tenantID, workflowSortKey),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if output.Item == nil {
+		return nil, ErrNotFound
+	}
+
+	var item workflowItem
+	if err := attributevalue.UnmarshalMap(output.Item, &item); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal workflow: %w", err)
+	}
+
+	return &Workflow{
+		TenantID:     tenantID,
+		InitialState: item.InitialState,
+		States:       item.States,
+		Transitions:  item.Transitions,
+		UpdatedBy:    strings.TrimPrefix(item.UpdatedBy, userPrefix),
+		UpdatedAt:    item.UpdatedAt,
+	}, nil
+}
+
+// saves a tenant's workflow, replacing the one before it
+func (s *workflowStore) PutWorkflow(workflow Workflow) error {
+	item, err := attributevalue.MarshalMap(workflowItem{
+		PartitionKey: workflow.TenantID,
+		SortKey:      workflowSortKey,
+		InitialState: workflow.InitialState,
+		States:       workflow.States,
+		Transitions:  workflow.Transitions,
+		UpdatedBy:    UserKey(workflow.UpdatedBy),
+		UpdatedAt:    workflow.UpdatedAt,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal workflow: %w", err)
+	}
+
+	_, err = s.db.PutItem(context.Background(), &dynamodb.PutItemInput{
+		TableName: aws.String(s.tableName),
+		Item:      item,
+	})
+	return err
+}
@@ -0,0 +1,27 @@
+package types
+
+// DTO for asking for a presigned upload url
+type PresignAttachmentDTO struct {
+	FileName    string `json:"fileName"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+}
+
+// a file attached to a task, pending until a presigned upload is completed
+type AttachmentOutput struct {
+	AttachmentId string `json:"attachmentId"`
+	TaskId       string `json:"taskId"`
+	FileName     string `json:"fileName"`
+	ContentType  string `json:"contentType"`
+	Size         int64  `json:"size"`
+	UploadedBy   string `json:"uploadedBy"`
+	UploadedAt   string `json:"uploadedAt"`
+	Pending      bool   `json:"pending,omitempty"`
+}
+
+// a pending attachment and the url to PUT its contents to before expiresAt
+type PresignedUploadOutput struct {
+	Attachment AttachmentOutput `json:"attachment"`
+	UploadURL  string           `json:"uploadUrl"`
+	ExpiresAt  string           `json:"expiresAt"`
+}
@@ -0,0 +1,25 @@
+package types
+
+// DTO for creating a comment, parentId is set on replies
+type CreateCommentDTO struct {
+	Body     string `json:"body"`
+	ParentId string `json:"parentId"`
+}
+
+// DTO for editing a comment
+type UpdateCommentDTO struct {
+	Body string `json:"body"`
+}
+
+// a comment on a task, deleted comments keep their place in the thread without a body
+type CommentOutput struct {
+	CommentId string   `json:"commentId"`
+	ParentId  string   `json:"parentId,omitempty"`
+	TaskId    string   `json:"taskId"`
+	AuthorId  string   `json:"authorId"`
+	Body      string   `json:"body"`
+	Mentions  []string `json:"mentions"`
+	CreatedAt string   `json:"createdAt"`
+	UpdatedAt string   `json:"updatedAt,omitempty"`
+	Deleted   bool     `json:"deleted,omitempty"`
+}
@@ -0,0 +1,13 @@
+package types
+
+// limit and cursor query parameters of a listing
+type PageQuery struct {
+	Limit  int
+	Cursor string
+}
+
+// one page of a listing, NextCursor is left out on the last page
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
@@ -5,9 +5,50 @@ type CreateTaskDTO struct {
 	TaskDescription string     `json:"description"`
 	Status          string     `json:"status"`
 	Deadline        string     `json:"deadline"`
-	CreatedAt       string     `json:"createdAt"`
 	CreatedBy       string     `json:"createdBy"`
 	Assignees       []Assignee `json:"assignee"`
+	BlockOnSubtasks bool       `json:"blockOnSubtasks"`
+	// only read when the task is created
+	ParentId  string             `json:"parentId"`
+	Checklist []ChecklistItemDTO `json:"checklist"`
+}
+
+// one checklist step, id is empty for new items and done defaults to false
+type ChecklistItemDTO struct {
+	Id   string `json:"id"`
+	Text string `json:"text"`
+	Done bool   `json:"done"`
+}
+
+// DTO replacing the whole checklist of a task, in order
+type UpdateChecklistDTO struct {
+	Items []ChecklistItemDTO `json:"items"`
+}
+
+// DTO for ticking or unticking one checklist item
+type CheckItemDTO struct {
+	Done *bool `json:"done"`
+}
+
+// DTO for partial task updates, nil fields are left unchanged
+type PatchTaskDTO struct {
+	Tasktitle       *string `json:"taskTitle"`
+	TaskDescription *string `json:"description"`
+	Status          *string `json:"status"`
+	Deadline        *string `json:"deadline"`
+	BlockOnSubtasks *bool   `json:"blockOnSubtasks"`
+}
+
+// filters and sort order of a task listing, empty fields match every task
+type TaskListQuery struct {
+	Status         string
+	Assignee       string
+	CreatedBy      string
+	DeadlineAfter  string
+	DeadlineBefore string
+	Title          string
+	SortBy         string
+	Descending     bool
 }
 
 type Assignee struct {
@@ -44,7 +85,28 @@ type QueryTasksOutput struct {
 	// Role         string `json:"role"`
 	Status    string `json:"status"`
 	Tasktitle string `json:"tasktitle"`
+	Version   int64  `json:"version,omitempty"`
 	// UserName  string `json:"userName"`
+	ParentId        string                `json:"parentId,omitempty"`
+	Checklist       []ChecklistItemOutput `json:"checklist"`
+	BlockOnSubtasks bool                  `json:"blockOnSubtasks,omitempty"`
+	BlockedBy       []string              `json:"blockedBy,omitempty"`
+}
+
+type ChecklistItemOutput struct {
+	Id     string `json:"id"`
+	Text   string `json:"text"`
+	Done   bool   `json:"done"`
+	DoneBy string `json:"doneBy,omitempty"`
+	DoneAt string `json:"doneAt,omitempty"`
+}
+
+// a task as listed on another one, as its subtask or dependency
+type TaskSummary struct {
+	TaskId    string `json:"taskId"`
+	Tasktitle string `json:"tasktitle"`
+	Status    string `json:"status"`
+	Complete  bool   `json:"complete"`
 }
 
 type TaskAssignee struct {
@@ -53,12 +115,47 @@ type TaskAssignee struct {
 	SortKey  string `json:"userId"`
 }
 
+// Blocked is set while a task it depends on is not complete, Overdue while it
+// is past its deadline and not complete. Subtasks and Completion are only
+// filled when a single task is read
 type GetTasksOutput struct {
-	Task     QueryTasksOutput `json:"task"`
-	Assignee []TaskAssignee   `json:"assignee"`
+	Task       QueryTasksOutput `json:"task"`
+	Assignee   []TaskAssignee   `json:"assignee"`
+	Blocked    bool             `json:"blocked"`
+	Overdue    bool             `json:"overdue"`
+	Subtasks   []TaskSummary    `json:"subtasks,omitempty"`
+	Completion *int             `json:"completion,omitempty"`
 }
 
+// DTO marking a task as blocked by another one
+type DependencyDTO struct {
+	TaskId string `json:"taskId"`
+}
+
+// the tasks a task waits for and the tasks waiting for it
+type DependenciesOutput struct {
+	TaskId    string        `json:"taskId"`
+	Blocked   bool          `json:"blocked"`
+	BlockedBy []TaskSummary `json:"blockedBy"`
+	Blocks    []TaskSummary `json:"blocks"`
+}
+
+// one entry of a task's history, action is empty on entries older than actions
 type GetTaskHistory struct {
+	HistoryId         string          `json:"historyId"`
+	Action            string          `json:"action"`
+	Status            string          `json:"status"`
+	UpdatedBy         string          `json:"updatedBy"`
+	UpdatedAt         string          `json:"updatedAt"`
+	UpdateDescription string          `json:"updateDescription"`
+	Changes           []HistoryChange `json:"changes"`
+}
+
+// the value of a task field before and after a change
+type HistoryChange struct {
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
 }
 
 type UpdateTask struct {
@@ -0,0 +1,36 @@
+package types
+
+// DTO for creating or replacing a recurring task template. Rrule is an
+// RFC 5545 style rule such as "FREQ=WEEKLY;BYDAY=MO;COUNT=10", read in
+// timezone from start on. DueAfter is how long after each occurrence its
+// task is due, like "48h", and no deadline is set when it is empty
+type TemplateDTO struct {
+	Tasktitle       string     `json:"taskTitle"`
+	TaskDescription string     `json:"description"`
+	Assignees       []Assignee `json:"assignee"`
+	Checklist       []string   `json:"checklist"`
+	Start           string     `json:"start"`
+	Rrule           string     `json:"rrule"`
+	Timezone        string     `json:"timezone"`
+	DueAfter        string     `json:"dueAfter"`
+	Paused          bool       `json:"paused"`
+}
+
+// a template as returned by the api, nextRun is empty while it is paused or
+// once its rule has ended
+type TemplateOutput struct {
+	TemplateId      string         `json:"templateId"`
+	Tasktitle       string         `json:"taskTitle"`
+	TaskDescription string         `json:"description"`
+	Assignee        []TaskAssignee `json:"assignee"`
+	Checklist       []string       `json:"checklist"`
+	Start           string         `json:"start"`
+	Rrule           string         `json:"rrule"`
+	Timezone        string         `json:"timezone"`
+	DueAfter        string         `json:"dueAfter,omitempty"`
+	Paused          bool           `json:"paused"`
+	NextRun         string         `json:"nextRun,omitempty"`
+	CreatedBy       string         `json:"createdBy"`
+	CreatedAt       string         `json:"createdAt"`
+	UpdatedAt       string         `json:"updatedAt,omitempty"`
+}
@@ -35,4 +35,39 @@ type NotificationDTO struct {
 	SortKey      string `json:"notificationId"`
 	Message      string `json:"message"`
 	Time         string `json:"time"`
+	Read         bool   `json:"read"`
+	ReadAt       string `json:"readAt,omitempty"`
+	Archived     bool   `json:"archived,omitempty"`
+}
+
+// number of notifications counted or changed by a request
+type NotificationCountDTO struct {
+	Count int `json:"count"`
+}
+
+// quiet hours as "HH:MM" times, both empty for none
+type QuietHoursDTO struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// how a user wants to be notified
+type PreferencesOutput struct {
+	InApp      bool          `json:"inApp"`
+	Email      bool          `json:"email"`
+	Digest     string        `json:"digest"`
+	Muted      []string      `json:"muted"`
+	QuietHours QuietHoursDTO `json:"quietHours"`
+	Timezone   string        `json:"timezone"`
+	UpdatedAt  string        `json:"updatedAt,omitempty"`
+}
+
+// DTO changing notification preferences, nil fields are left untouched
+type PatchPreferencesDTO struct {
+	InApp      *bool          `json:"inApp"`
+	Email      *bool          `json:"email"`
+	Digest     *string        `json:"digest"`
+	Muted      *[]string      `json:"muted"`
+	QuietHours *QuietHoursDTO `json:"quietHours"`
+	Timezone   *string        `json:"timezone"`
 }
@@ -0,0 +1,22 @@
+package types
+
+// DTO for a tenant's task workflow
+type WorkflowDTO struct {
+	InitialState string                  `json:"initialState"`
+	States       []WorkflowStateDTO      `json:"states"`
+	Transitions  []WorkflowTransitionDTO `json:"transitions"`
+	UpdatedBy    string                  `json:"updatedBy,omitempty"`
+	UpdatedAt    string                  `json:"updatedAt,omitempty"`
+}
+
+type WorkflowStateDTO struct {
+	Name     string `json:"name"`
+	Terminal bool   `json:"terminal"`
+}
+
+// an empty roles list lets every role make the transition
+type WorkflowTransitionDTO struct {
+	From  string   `json:"from"`
+	To    string   `json:"to"`
+	Roles []string `json:"roles"`
+}
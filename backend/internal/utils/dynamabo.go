@@ -8,7 +8,12 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
-func InsertIntoDB(database *dynamodb.Client, tableName string, Item map[string]types.AttributeValue) (*dynamodb.PutItemOutput, error) {
+// any client able to put items, e.g *dynamodb.Client
+type PutItemAPI interface {
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+}
+
+func InsertIntoDB(database PutItemAPI, tableName string, Item map[string]types.AttributeValue) (*dynamodb.PutItemOutput, error) {
 	input := &dynamodb.PutItemInput{
 		TableName: aws.String(tableName),
 		Item:      Item,
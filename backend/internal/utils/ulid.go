@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"crypto/rand"
+	"sync"
+	"time"
+)
+
+// crockford's base32, whose order matches the byte order of the ids
+const ulidAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+var ulids struct {
+	sync.Mutex
+	ms      uint64
+	entropy [10]byte
+}
+
+// NewULID returns a 26 character ULID for the given time. Ids sort by time,
+// and ids made within the same millisecond by this process still sort in the
+// order they were made
+func NewULID(at time.Time) string {
+	ms := uint64(at.UnixMilli())
+
+	ulids.Lock()
+	if ms != ulids.ms {
+		ulids.ms = ms
+		rand.Read(ulids.entropy[:])
+	} else {
+		// the same millisecond carries on from the last id
+		for i := len(ulids.entropy) - 1; i >= 0; i-- {
+			ulids.entropy[i]++
+			if ulids.entropy[i] != 0 {
+				break
+			}
+		}
+	}
+	var id [16]byte
+	for i := 0; i < 6; i++ {
+		id[i] = byte(ms >> (40 - 8*i))
+	}
+	copy(id[6:], ulids.entropy[:])
+	ulids.Unlock()
+
+	return encodeULID(id)
+}
+
+// 128 bits in 26 characters of 5 bits, the first character taking only 3
+func encodeULID(id [16]byte) string {
+	out := make([]byte, 26)
+	for i := 25; i >= 0; i-- {
+		out[i] = ulidAlphabet[id[15]&31]
+		// shift the whole id right by 5 bits
+		for j := 15; j > 0; j-- {
+			id[j] = id[j]>>5 | id[j-1]<<3
+		}
+		id[0] >>= 5
+	}
+	return string(out)
+}
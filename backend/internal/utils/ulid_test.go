@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// reads a ULID back into its 16 bytes
+func decodeULID(t *testing.T, ulid string) [16]byte {
+	t.Helper()
+	if len(ulid) != 26 {
+		t.Fatalf("%q is %d characters, want 26", ulid, len(ulid))
+	}
+	var id [16]byte
+	for _, c := range []byte(ulid) {
+		v := strings.IndexByte(ulidAlphabet, c)
+		if v < 0 {
+			t.Fatalf("%q has %q, which is not in crockford's base32", ulid, c)
+		}
+		// shift the whole id left by 5 bits
+		for j := 0; j < 15; j++ {
+			id[j] = id[j]<<5 | id[j+1]>>3
+		}
+		id[15] = id[15]<<5 | byte(v)
+	}
+	return id
+}
+
+func ulidTime(id [16]byte) int64 {
+	var ms int64
+	for _, b := range id[:6] {
+		ms = ms<<8 | int64(b)
+	}
+	return ms
+}
+
+func TestULIDSortsByTime(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var last string
+	for i := range 100 {
+		ulid := NewULID(at.Add(time.Duration(i*37) * time.Millisecond))
+		if ulid <= last {
+			t.Fatalf("%s made after %s sorts before it", ulid, last)
+		}
+		last = ulid
+	}
+	// a later millisecond sorts later whatever the random part of the earlier one
+	ulids.Lock()
+	ulids.ms, ulids.entropy = 0, [10]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xfe}
+	ulids.Unlock()
+	if first, second := NewULID(at), NewULID(at.Add(time.Millisecond)); first >= second {
+		t.Errorf("%s of the next millisecond does not sort after %s", second, first)
+	}
+}
+
+func TestULIDWithinAMillisecond(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	last := NewULID(at)
+	for range 1000 {
+		ulid := NewULID(at)
+		if ulid <= last {
+			t.Fatalf("%s made after %s in the same millisecond sorts before it", ulid, last)
+		}
+		// each id is the one before plus one
+		prev, next := decodeULID(t, last), decodeULID(t, ulid)
+		for i := 15; i >= 6; i-- {
+			prev[i]++
+			if prev[i] != 0 {
+				break
+			}
+		}
+		if prev != next {
+			t.Fatalf("%s does not follow %s by one", ulid, last)
+		}
+		last = ulid
+	}
+
+	// the increment carries across the bytes of the random part
+	ulids.Lock()
+	ulids.entropy = [10]byte{0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff}
+	ulids.Unlock()
+	id := decodeULID(t, NewULID(at))
+	if want := [10]byte{0, 0, 0, 0, 0, 0, 0, 1, 0, 0}; [10]byte(id[6:]) != want {
+		t.Errorf("random part after a carry = %x, want %x", id[6:], want)
+	}
+}
+
+func TestULIDCrockfordRoundTrip(t *testing.T) {
+	// an empty want only checks the id decodes back to itself
+	tests := []struct {
+		id   [16]byte
+		want string
+	}{
+		{[16]byte{}, "00000000000000000000000000"},
+		{[16]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, "7ZZZZZZZZZZZZZZZZZZZZZZZZZ"},
+		{[16]byte{15: 1}, "00000000000000000000000001"},
+		{[16]byte{0x01, 0x8f, 0x33, 0x7c, 0x9b, 0x00, 0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0, 0x11, 0x22}, ""},
+	}
+	for _, tt := range tests {
+		got := encodeULID(tt.id)
+		if tt.want != "" && got != tt.want {
+			t.Errorf("encodeULID(%x) = %s, want %s", tt.id, got, tt.want)
+		}
+		if back := decodeULID(t, got); back != tt.id {
+			t.Errorf("%s decodes to %x, want %x", got, back, tt.id)
+		}
+	}
+
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if ms := ulidTime(decodeULID(t, NewULID(at))); ms != at.UnixMilli() {
+		t.Errorf("time of a ULID made at %d = %d", at.UnixMilli(), ms)
+	}
+}
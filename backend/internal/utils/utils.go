@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	internal_types "github.com/Ghaby-X/tasork/internal/types"
@@ -29,6 +30,20 @@ func WriteError(w http.ResponseWriter, status int, err error) {
 	WriteJSON(w, status, map[string]string{"error": err.Error()})
 }
 
+// read the limit and cursor query parameters of a listing
+func ParsePageQuery(r *http.Request) (internal_types.PageQuery, error) {
+	query := internal_types.PageQuery{Cursor: r.URL.Query().Get("cursor")}
+
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return query, fmt.Errorf("limit must be a positive number")
+		}
+		query.Limit = n
+	}
+	return query, nil
+}
+
 // extract user from request context only works after authorization middleware
 func GetUserFromRequest(r *http.Request) internal_types.TokenClaims {
 	ctxkey := internal_types.ContextKey("user")
@@ -14,6 +14,12 @@ resource "aws_dynamodb_table" "tasork" {
     type = "S"
   }
 
+  # read notifications are deleted once their expiresAt has passed
+  ttl {
+    attribute_name = "expiresAt"
+    enabled        = true
+  }
+
   tags = {
     Name        = "tasork"
   }